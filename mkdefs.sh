@@ -7,6 +7,10 @@ case $GOOS in
         go tool cgo -godefs=true types_linux.go >ztypes_linux.go
         rm -rf _obj
         ;;
+    darwin)
+        go tool cgo -godefs=true types_darwin.go >ztypes_darwin.go
+        rm -rf _obj
+        ;;
     *)
         echo "Don't know how to compile types for $GOOS"
         exit 1
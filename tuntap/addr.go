@@ -0,0 +1,59 @@
+package tuntap
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// Src returns the source address of the header, or the zero Addr if
+// it's not an IP header. Packets carry no zone, see Interface.WithZone.
+func (h IPHeader) Src() netip.Addr {
+	a, _ := netip.AddrFromSlice(h.SourceAddr())
+	return a
+}
+
+// Dst returns the destination address of the header, like Src.
+func (h IPHeader) Dst() netip.Addr {
+	a, _ := netip.AddrFromSlice(h.DestAddr())
+	return a
+}
+
+// SetSrc overwrites the source address in place, like SetSourceAddr.
+// The zone of a is dropped, and IPv4-mapped IPv6 addresses are accepted
+// in IPv4 headers.
+func (h IPHeader) SetSrc(a netip.Addr) error {
+	b, err := h.addrBytes(a)
+	if err != nil {
+		return err
+	}
+	return h.SetSourceAddr(b)
+}
+
+// SetDst overwrites the destination address in place, like SetSrc.
+func (h IPHeader) SetDst(a netip.Addr) error {
+	b, err := h.addrBytes(a)
+	if err != nil {
+		return err
+	}
+	return h.SetDestAddr(b)
+}
+
+func (h IPHeader) addrBytes(a netip.Addr) ([]byte, error) {
+	if !a.IsValid() {
+		return nil, errors.New("Invalid address")
+	}
+	if len(h.Data) > 0 && h.version() == 4 {
+		a = a.Unmap()
+	}
+	return a.WithZone("").AsSlice(), nil
+}
+
+// WithZone returns a with the interface as its zone if it's a
+// link-local address, which needs one to be used with the net package.
+// Other addresses are returned unchanged.
+func (t *Interface) WithZone(a netip.Addr) netip.Addr {
+	if a.Is6() && (a.IsLinkLocalUnicast() || a.IsLinkLocalMulticast() || a.IsInterfaceLocalMulticast()) {
+		return a.WithZone(t.Name())
+	}
+	return a
+}
@@ -0,0 +1,276 @@
+// Package arp answers ARP requests and resolves IPv4 addresses on DevTap
+// interfaces, for the hosts emulated behind them. A Responder answers
+// the requests for the addresses added to it, proxy ARP style, and
+// learns the addresses of the neighbors from the packets it sees:
+//
+//	r, err := arp.NewResponder(t, arp.Options{})
+//	err = r.Add(netip.MustParseAddr("10.0.0.2"), mac)
+//	for {
+//		f, err := t.ReadFrame()
+//		...
+//		if ok, err := r.HandleFrame(f); ok {
+//			continue
+//		}
+//		// Not an ARP frame.
+//	}
+package arp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// DefaultCacheTimeout is the time learned neighbors are kept without
+// hearing from them, by default.
+const DefaultCacheTimeout = time.Minute
+
+// How often Resolve repeats its request.
+const requestInterval = time.Second
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// Options configure a Responder.
+type Options struct {
+	// DefaultCacheTimeout if zero.
+	CacheTimeout time.Duration
+}
+
+// Responder answers ARP requests on a DevTap interface and keeps a
+// neighbor cache. It's safe for concurrent use.
+type Responder struct {
+	t       *tuntap.Interface
+	timeout time.Duration
+
+	mu sync.Mutex
+	// The addresses answered for.
+	local     map[netip.Addr]net.HardwareAddr
+	neighbors map[netip.Addr]neighbor
+	// Closed when the address of a neighbor is learned.
+	waiters   map[netip.Addr]chan struct{}
+	lastSweep time.Time
+}
+
+type neighbor struct {
+	mac     net.HardwareAddr
+	expires time.Time
+}
+
+// NewResponder returns a Responder for the DevTap interface t, answering
+// for no address yet.
+func NewResponder(t *tuntap.Interface, opts Options) (*Responder, error) {
+	if a, ok := t.LocalAddr().(*tuntap.Addr); !ok || a.Kind != tuntap.DevTap {
+		return nil, errors.New("ARP needs a DevTap interface")
+	}
+	r := &Responder{
+		t:         t,
+		timeout:   opts.CacheTimeout,
+		local:     make(map[netip.Addr]net.HardwareAddr),
+		neighbors: make(map[netip.Addr]neighbor),
+		waiters:   make(map[netip.Addr]chan struct{}),
+	}
+	if r.timeout <= 0 {
+		r.timeout = DefaultCacheTimeout
+	}
+	return r, nil
+}
+
+// Add makes the responder answer the requests for ip with mac,
+// replacing any previous mapping of ip.
+func (r *Responder) Add(ip netip.Addr, mac net.HardwareAddr) error {
+	ip = ip.Unmap()
+	if !ip.Is4() || ip.IsUnspecified() || ip.IsMulticast() {
+		return errors.New("ARP answers for unicast IPv4 addresses")
+	}
+	if len(mac) != 6 {
+		return errors.New("ARP needs 6-byte MAC addresses")
+	}
+	r.mu.Lock()
+	r.local[ip] = append(net.HardwareAddr(nil), mac...)
+	r.mu.Unlock()
+	return nil
+}
+
+// Remove stops answering the requests for ip.
+func (r *Responder) Remove(ip netip.Addr) {
+	r.mu.Lock()
+	delete(r.local, ip.Unmap())
+	r.mu.Unlock()
+}
+
+// HandleFrame handles f if it's an ARP frame, learning the address of
+// its sender and answering it if it's a request for an address added to
+// the responder. It returns false for other frames, and the error of
+// writing the reply.
+func (r *Responder) HandleFrame(f *tuntap.EthernetFrame) (bool, error) {
+	if f.EtherType != parser.EtherTypeARP {
+		return false, nil
+	}
+	var a parser.ARPPacket
+	if err := a.Unmarshal(f.Payload); err != nil {
+		return true, nil
+	}
+
+	r.mu.Lock()
+	_, spoofed := r.local[a.SenderIP]
+	if !spoofed && !a.SenderIP.IsUnspecified() {
+		r.learn(a.SenderIP, a.SenderMAC)
+	}
+	mac, ok := r.local[a.TargetIP]
+	r.mu.Unlock()
+	if a.Operation != parser.ARPRequest || !ok || a.SenderIP == a.TargetIP {
+		return true, nil
+	}
+
+	reply := parser.ARPPacket{
+		Operation: parser.ARPReply,
+		SenderMAC: mac,
+		SenderIP:  a.TargetIP,
+		TargetMAC: a.SenderMAC,
+		TargetIP:  a.SenderIP,
+	}
+	return true, r.send(&reply, f.SrcMAC, f.VLAN)
+}
+
+// learn records that ip is at mac, waking up the resolutions of ip.
+func (r *Responder) learn(ip netip.Addr, mac net.HardwareAddr) {
+	now := time.Now()
+	if n, ok := r.neighbors[ip]; ok && bytes.Equal(n.mac, mac) {
+		n.expires = now.Add(r.timeout)
+		r.neighbors[ip] = n
+	} else {
+		r.neighbors[ip] = neighbor{mac: append(net.HardwareAddr(nil), mac...), expires: now.Add(r.timeout)}
+	}
+	if ch, ok := r.waiters[ip]; ok {
+		close(ch)
+		delete(r.waiters, ip)
+	}
+	if now.Sub(r.lastSweep) >= r.timeout {
+		r.lastSweep = now
+		for ip, n := range r.neighbors {
+			if now.After(n.expires) {
+				delete(r.neighbors, ip)
+			}
+		}
+	}
+}
+
+func (r *Responder) send(a *parser.ARPPacket, dst net.HardwareAddr, vlan *parser.VLANTag) error {
+	b, err := a.Marshal()
+	if err != nil {
+		return err
+	}
+	return r.t.WriteFrame(&tuntap.EthernetFrame{
+		DstMAC:    dst,
+		SrcMAC:    a.SenderMAC,
+		VLAN:      vlan,
+		EtherType: parser.EtherTypeARP,
+		Payload:   b,
+	})
+}
+
+// Lookup returns the MAC address of the neighbor ip from the cache.
+func (r *Responder) Lookup(ip netip.Addr) (net.HardwareAddr, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, ok := r.neighbors[ip.Unmap()]
+	if !ok || time.Now().After(n.expires) {
+		return nil, false
+	}
+	return n.mac, true
+}
+
+// Neighbors returns the neighbors in the cache.
+func (r *Responder) Neighbors() map[netip.Addr]net.HardwareAddr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	m := make(map[netip.Addr]net.HardwareAddr, len(r.neighbors))
+	for ip, n := range r.neighbors {
+		if !now.After(n.expires) {
+			m[ip] = n.mac
+		}
+	}
+	return m
+}
+
+// Resolve returns the MAC address of the neighbor ip, from the cache or
+// by broadcasting requests every second until answered or ctx is done.
+// The requests are sent from the added address sharing the longest
+// prefix with ip, and the replies must be read and given to HandleFrame
+// by another goroutine.
+func (r *Responder) Resolve(ctx context.Context, ip netip.Addr) (net.HardwareAddr, error) {
+	ip = ip.Unmap()
+	if !ip.Is4() {
+		return nil, errors.New("ARP resolves IPv4 addresses")
+	}
+	ticker := time.NewTicker(requestInterval)
+	defer ticker.Stop()
+	for {
+		r.mu.Lock()
+		if n, ok := r.neighbors[ip]; ok && !time.Now().After(n.expires) {
+			r.mu.Unlock()
+			return n.mac, nil
+		}
+		ch, ok := r.waiters[ip]
+		if !ok {
+			ch = make(chan struct{})
+			r.waiters[ip] = ch
+		}
+		src, mac := r.source(ip)
+		r.mu.Unlock()
+		if !src.IsValid() {
+			return nil, errors.New("No address to send ARP requests from")
+		}
+
+		req := parser.ARPPacket{Operation: parser.ARPRequest, SenderMAC: mac, SenderIP: src, TargetIP: ip}
+		if err := r.send(&req, broadcastMAC, nil); err != nil {
+			return nil, err
+		}
+		select {
+		case <-ch:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// source returns the added address sharing the longest prefix with ip,
+// the lowest one among equals.
+func (r *Responder) source(ip netip.Addr) (netip.Addr, net.HardwareAddr) {
+	var best netip.Addr
+	bestLen := -1
+	target := ip.As4()
+	for a := range r.local {
+		b := a.As4()
+		n := bits.LeadingZeros32(binary.BigEndian.Uint32(b[:]) ^ binary.BigEndian.Uint32(target[:]))
+		if n > bestLen || n == bestLen && a.Less(best) {
+			best, bestLen = a, n
+		}
+	}
+	return best, r.local[best]
+}
+
+// Announce broadcasts a gratuitous ARP request for the added address
+// ip, so that the neighbors update their caches.
+func (r *Responder) Announce(ip netip.Addr) error {
+	ip = ip.Unmap()
+	r.mu.Lock()
+	mac, ok := r.local[ip]
+	r.mu.Unlock()
+	if !ok {
+		return errors.New("Address " + ip.String() + " not added")
+	}
+	a := parser.ARPPacket{Operation: parser.ARPRequest, SenderMAC: mac, SenderIP: ip, TargetIP: ip}
+	return r.send(&a, broadcastMAC, nil)
+}
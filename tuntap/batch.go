@@ -0,0 +1,196 @@
+package tuntap
+
+import (
+	"sync/atomic"
+)
+
+// batchReader is implemented by Devices that can read several packets
+// with fewer system calls than one Read each.
+type batchReader interface {
+	// readBatch blocks until at least one packet is available, then
+	// reads as many queued packets as fit in bufs without blocking
+	// again. It returns the number of packets and their sizes.
+	readBatch(bufs [][]byte, sizes []int) (int, error)
+}
+
+// ReadPackets reads up to len(pkts) packets into pkts. It blocks until
+// at least one packet is available, then returns every packet already
+// queued on the device that fits, without waiting for more.
+//
+// Packets that fail to parse are dropped; the error of the first of
+// them is only returned if no packet could be read at all. When every
+// packet read is dropped by the hook or a filter, or answered by the
+// echo responder, ReadPackets reads again.
+func (t *Interface) ReadPackets(pkts []*IPPacket) (int, error) {
+	if len(pkts) == 0 {
+		return 0, nil
+	}
+
+	bufs := make([][]byte, len(pkts))
+	ptrs := make([]*[]byte, len(pkts))
+	for i := range bufs {
+		if t.pooled {
+			ptrs[i] = t.getBuffer()
+			bufs[i] = *ptrs[i]
+		} else {
+			bufs[i] = make([]byte, t.bufferSize())
+		}
+	}
+	defer func() {
+		for _, p := range ptrs {
+			if p != nil {
+				bufferPool.Put(p)
+			}
+		}
+	}()
+
+	sizes := make([]int, len(pkts))
+	for {
+		if atomic.LoadInt32(&t.closed) != 0 {
+			return 0, ErrClosed
+		}
+		count, err := t.readPacketsOnce(pkts, bufs, ptrs, sizes)
+		// Read again when every packet was dropped, answered or
+		// filtered out.
+		if count > 0 || err != nil {
+			return count, err
+		}
+	}
+}
+
+// readPacketsOnce is one read of ReadPackets. It returns 0 and no
+// error when all the packets read were dropped. The pooled buffers of
+// the packets returned are moved from ptrs to them.
+func (t *Interface) readPacketsOnce(pkts []*IPPacket, bufs [][]byte, ptrs []*[]byte, sizes []int) (int, error) {
+	start := t.stats.start()
+	var n int
+	if r, ok := t.dev.(batchReader); ok {
+		var err error
+		n, err = retryIO(func() (int, error) { return r.readBatch(bufs, sizes) })
+		if err != nil {
+			err = t.ioError("read", err)
+			t.stats.received(start, 0, err)
+			return 0, err
+		}
+	} else {
+		size, err := retryIO(func() (int, error) { return t.dev.Read(bufs[0]) })
+		if err != nil {
+			err = t.ioError("read", err)
+			t.stats.received(start, 0, err)
+			return 0, err
+		}
+		sizes[0] = size
+		n = 1
+	}
+
+	count := 0
+	var firstErr error
+	for i := 0; i < n; i++ {
+		t.stats.received(start, sizes[i], nil)
+		data, info, err := t.stripHeader(bufs[i][:sizes[i]], t.filled(sizes[i], len(bufs[i])))
+		var pkt *IPPacket
+		if err == nil {
+			if data = t.softFiltered(data, &info); data == nil {
+				continue
+			}
+			t.obs.observe(false, data)
+			pkt, err = t.decodePacket(data, info)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			t.stats.rxDropped.Add(1)
+			continue
+		}
+		if pkt = t.hooked(pkt, false); pkt == nil {
+			continue
+		}
+		if t.pooled {
+			pkt.buf = ptrs[i]
+			ptrs[i] = nil
+		}
+		pkts[count] = pkt
+		count++
+	}
+	if count == 0 {
+		return 0, firstErr
+	}
+	return count, nil
+}
+
+// batchWriter is implemented by Devices that can write several packets
+// with fewer system calls than one Write each.
+type batchWriter interface {
+	// writeBatch writes the packets in order, each given in pieces
+	// like to writev. It returns the number of packets written and the
+	// error that stopped it, if any.
+	writeBatch(pkts [][][]byte) (int, error)
+}
+
+// WritePackets writes pkts in order. It returns the number of packets
+// written, or dropped by the PacketHook, and the error that stopped it,
+// if any.
+func (t *Interface) WritePackets(pkts []*IPPacket) (int, error) {
+	if w, ok := t.dev.(batchWriter); ok && len(pkts) > 1 {
+		return t.writeBatch(w, pkts)
+	}
+	for i, pkt := range pkts {
+		if err := t.WritePacket(pkt); err != nil {
+			return i, err
+		}
+	}
+	return len(pkts), nil
+}
+
+// writeBatch is WritePackets on a batchWriter Device. The packets
+// before one that can't be sent are still written.
+func (t *Interface) writeBatch(w batchWriter, pkts []*IPPacket) (int, error) {
+	if atomic.LoadInt32(&t.closed) != 0 {
+		return 0, ErrClosed
+	}
+	vecs := make([][][]byte, 0, len(pkts))
+	// Index in pkts of the packet of each vector, and number of packets
+	// handled, written or dropped by the hook, if all vectors are
+	// written.
+	index := make([]int, 0, len(pkts))
+	handled := len(pkts)
+	var perr error
+	for i, pkt := range pkts {
+		if pkt = t.hooked(pkt, true); pkt == nil {
+			continue
+		}
+		proto, parts, err := t.packetParts(pkt)
+		if err != nil {
+			handled, perr = i, err
+			break
+		}
+		vecs = append(vecs, append([][]byte{t.header(proto, pkt.VnetHdr)}, parts...))
+		index = append(index, i)
+	}
+
+	start := t.stats.start()
+	n, err := 0, error(nil)
+	if len(vecs) > 0 {
+		n, err = retryIO(func() (int, error) { return w.writeBatch(vecs) })
+	}
+	for _, bufs := range vecs[:n] {
+		size := 0
+		for _, b := range bufs {
+			size += len(b)
+		}
+		t.stats.sent(start, size, nil)
+		if t.obs.active() {
+			t.obs.observe(true, concat(bufs[1:]))
+		}
+	}
+	if err != nil {
+		err = t.ioError("write", err)
+		t.stats.sent(start, 0, err)
+		if n < len(index) {
+			return index[n], err
+		}
+		return handled, err
+	}
+	return handled, perr
+}
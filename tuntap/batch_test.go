@@ -0,0 +1,41 @@
+package tuntap
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadWritePackets(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		a, b := NewPipe(DevTun)
+		b.SetPooled(pooled)
+		want := []*IPPacket{
+			udpPacket(t, testSrc4, testDst4, 53, []byte("first")),
+			udpPacket(t, testSrc6, testDst6, 53, []byte("second")),
+			udpPacket(t, testSrc4, testDst4, 123, nil),
+		}
+		if n, err := a.WritePackets(want); n != len(want) || err != nil {
+			t.Fatalf("WritePackets: %d, %v", n, err)
+		}
+
+		got := make([]*IPPacket, 8)
+		n, err := b.ReadPackets(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(want) {
+			t.Fatalf("pooled %v: read %d packets, want %d", pooled, n, len(want))
+		}
+		for i, pkt := range got[:n] {
+			if !bytes.Equal(packetBytes(pkt), packetBytes(want[i])) {
+				t.Errorf("pooled %v: packet %d is %x, want %x", pooled, i, packetBytes(pkt), packetBytes(want[i]))
+			}
+			pkt.Release()
+		}
+		if s := b.Stats(); s.RxPackets != uint64(len(want)) {
+			t.Errorf("pooled %v: counted %d packets read, want %d", pooled, s.RxPackets, len(want))
+		}
+		a.Close()
+		b.Close()
+	}
+}
@@ -0,0 +1,63 @@
+// +build linux darwin freebsd openbsd
+
+package tuntap
+
+import (
+	"os"
+	"syscall"
+)
+
+// readBatch waits for the first packet through the runtime poller, then
+// drains whatever else is queued with non-blocking reads. Descriptors in
+// blocking mode only ever read one packet.
+func (d *osDevice) readBatch(bufs [][]byte, sizes []int) (int, error) {
+	f, ok := d.ReadWriteCloser.(*os.File)
+	if !ok {
+		n, err := d.Read(bufs[0])
+		if err != nil {
+			return 0, err
+		}
+		sizes[0] = n
+		return 1, nil
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var rerr error
+	err = rc.Read(func(fd uintptr) bool {
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+		nonblock := errno == 0 && flags&syscall.O_NONBLOCK != 0
+		for count < len(bufs) {
+			n, err := syscall.Read(int(fd), bufs[count])
+			if err == syscall.EAGAIN {
+				// Wait for the poller if nothing was read yet.
+				return count > 0
+			}
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				if count == 0 {
+					rerr = err
+				}
+				return true
+			}
+			sizes[count] = n
+			count++
+			if !nonblock {
+				return true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if rerr != nil {
+		return 0, rerr
+	}
+	return count, nil
+}
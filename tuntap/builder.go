@@ -0,0 +1,85 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// Hop limit of the packets built by NewIPv6Packet and NewIPv4Packet.
+const DefaultHopLimit = 64
+
+// NewIPv6Packet builds an IPv6 packet from src to dst carrying payload,
+// a message of the nextHeader protocol. The header gets the payload
+// length and DefaultHopLimit, and TCP, UDP and ICMPv6 payloads get their
+// checksum (and UDP its length) filled in. payload is copied.
+func NewIPv6Packet(src, dst netip.Addr, nextHeader IPProtocol, payload []byte) (*IPPacket, error) {
+	if !src.Is6() || !dst.Is6() || src.Is4In6() || dst.Is4In6() {
+		return nil, errors.New("IPv6 packets need IPv6 addresses")
+	}
+	if len(payload) > 0xffff {
+		return nil, errors.New("IPv6 payload too long")
+	}
+
+	buf := make([]byte, ipHeaderLength+len(payload))
+	buf[0] = 6 << 4
+	binary.BigEndian.PutUint16(buf[4:6], uint16(len(payload)))
+	buf[6] = uint8(nextHeader)
+	buf[7] = DefaultHopLimit
+	s, d := src.As16(), dst.As16()
+	copy(buf[8:24], s[:])
+	copy(buf[24:40], d[:])
+	copy(buf[ipHeaderLength:], payload)
+	fillChecksum(src, dst, nextHeader, buf[ipHeaderLength:])
+
+	return &IPPacket{
+		Protocol: etherTypeIPv6,
+		Header:   IPHeader{Data: buf[:ipHeaderLength]},
+		Payload:  buf[ipHeaderLength:],
+	}, nil
+}
+
+// NewIPv4Packet builds an IPv4 packet from src to dst carrying payload,
+// like NewIPv6Packet. The header gets the total length, the don't
+// fragment flag, DefaultHopLimit as TTL and its checksum.
+func NewIPv4Packet(src, dst netip.Addr, protocol IPProtocol, payload []byte) (*IPPacket, error) {
+	h := parser.IPv4Header{
+		TotalLength: 20 + len(payload),
+		Flags:       parser.IPv4DontFragment,
+		TTL:         DefaultHopLimit,
+		Protocol:    uint8(protocol),
+		Src:         src.Unmap(),
+		Dst:         dst.Unmap(),
+	}
+	hdr, err := h.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := append(hdr, payload...)
+	fillChecksum(h.Src, h.Dst, protocol, buf[len(hdr):])
+
+	return &IPPacket{
+		Protocol: etherTypeIPv4,
+		Header:   IPHeader{Data: buf[:len(hdr)]},
+		Payload:  buf[len(hdr):],
+	}, nil
+}
+
+// fillChecksum fills in the checksum of the upper-layer message b, if
+// its protocol has one this package knows about.
+func fillChecksum(src, dst netip.Addr, proto IPProtocol, b []byte) {
+	switch {
+	case proto == ProtoTCP && len(b) >= 20:
+		binary.BigEndian.PutUint16(b[16:18], parser.TCPChecksum(src, dst, b))
+	case proto == ProtoUDP && len(b) >= 8:
+		binary.BigEndian.PutUint16(b[4:6], uint16(len(b)))
+		binary.BigEndian.PutUint16(b[6:8], parser.UDPChecksum(src, dst, b))
+	case proto == ProtoICMPv6 && src.Is6() && len(b) >= 4:
+		binary.BigEndian.PutUint16(b[2:4], parser.ICMPv6Checksum(src, dst, b))
+	case proto == ProtoICMP && src.Is4() && len(b) >= 4:
+		binary.BigEndian.PutUint16(b[2:4], parser.ICMPv4Checksum(b))
+	}
+}
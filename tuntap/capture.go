@@ -0,0 +1,168 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// Block types.
+	pcapngSectionHeader  = 0x0a0d0d0a
+	pcapngInterfaceDesc  = 0x00000001
+	pcapngEnhancedPacket = 0x00000006
+	pcapngByteOrderMagic = 0x1a2b3c4d
+
+	// Options.
+	pcapngOptEnd       = 0
+	pcapngOptIfName    = 2
+	pcapngOptIfTsresol = 9
+	pcapngOptEPBFlags  = 2
+
+	// Direction in epb_flags.
+	pcapngFlagInbound  = 1
+	pcapngFlagOutbound = 2
+
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+
+	pcapngDefaultSnaplen = 262144
+)
+
+// CaptureOptions configure Capture.
+type CaptureOptions struct {
+	// Packets longer than Snaplen are truncated in the capture. 0
+	// means 262144 bytes.
+	Snaplen int
+}
+
+// Capture writes a copy of every packet read from or written to the
+// interface to w as a pcapng stream, which Wireshark and tcpdump can
+// read, until the returned stop function is called. Packets are
+// captured as IP packets on DevTun (LINKTYPE_RAW) and Ethernet frames on
+// DevTap (LINKTYPE_ETHERNET), with nanosecond timestamps. Their
+// direction is the one seen from the network stack: packets read are
+// outbound and packets written inbound. I/O through Raw is not
+// captured.
+//
+// w is written to by the goroutines doing I/O on the interface, so a
+// slow w slows the interface down; wrap it in a bufio.Writer and flush
+// it after stop if needed. Capture stops by itself on the first error
+// writing to w, which stop then returns.
+func (t *Interface) Capture(w io.Writer, opts CaptureOptions) (stop func() error, err error) {
+	snaplen := opts.Snaplen
+	if snaplen <= 0 {
+		snaplen = pcapngDefaultSnaplen
+	}
+	linkType := linkTypeRaw
+	if t.kind == DevTap {
+		linkType = linkTypeEthernet
+	}
+
+	c := &capture{w: w, snaplen: snaplen}
+	if err := c.writeHeader(t.Name(), linkType); err != nil {
+		return nil, err
+	}
+	c.remove = t.addObserver(c.packet)
+
+	return func() error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.stop()
+		return c.err
+	}, nil
+}
+
+type capture struct {
+	mu      sync.Mutex
+	w       io.Writer
+	snaplen int
+	remove  func()
+	stopped bool
+	err     error
+}
+
+func (c *capture) stop() {
+	if !c.stopped {
+		c.stopped = true
+		// Fine to call from an observer: observe works on a
+		// snapshot of the observers.
+		c.remove()
+	}
+}
+
+// block appends a pcapng block of the given type and body.
+func block(b []byte, typ uint32, body []byte) []byte {
+	n := 12 + len(body)
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	b = binary.LittleEndian.AppendUint32(b, uint32(n))
+	b = append(b, body...)
+	return binary.LittleEndian.AppendUint32(b, uint32(n))
+}
+
+// option appends a pcapng option, padded to 32 bits.
+func option(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func (c *capture) writeHeader(name string, linkType int) error {
+	shb := binary.LittleEndian.AppendUint32(nil, pcapngByteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	// Unknown section length.
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+
+	idb := binary.LittleEndian.AppendUint16(nil, uint16(linkType))
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, uint32(c.snaplen))
+	idb = option(idb, pcapngOptIfName, []byte(name))
+	// Nanosecond timestamps.
+	idb = option(idb, pcapngOptIfTsresol, []byte{9})
+	idb = option(idb, pcapngOptEnd, nil)
+
+	_, err := c.w.Write(block(block(nil, pcapngSectionHeader, shb), pcapngInterfaceDesc, idb))
+	return err
+}
+
+func (c *capture) packet(write bool, data []byte) {
+	ts := uint64(time.Now().UnixNano())
+	captured := data
+	if len(captured) > c.snaplen {
+		captured = captured[:c.snaplen]
+	}
+	flags := uint32(pcapngFlagOutbound)
+	if write {
+		flags = pcapngFlagInbound
+	}
+
+	epb := make([]byte, 0, 20+len(captured)+3+12)
+	// Interface 0.
+	epb = binary.LittleEndian.AppendUint32(epb, 0)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(captured)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(data)))
+	epb = append(epb, captured...)
+	for len(epb)%4 != 0 {
+		epb = append(epb, 0)
+	}
+	epb = option(epb, pcapngOptEPBFlags, binary.LittleEndian.AppendUint32(nil, flags))
+	epb = option(epb, pcapngOptEnd, nil)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+	if _, err := c.w.Write(block(nil, pcapngEnhancedPacket, epb)); err != nil {
+		c.err = err
+		c.stop()
+	}
+}
@@ -0,0 +1,134 @@
+package tuntap
+
+import (
+	"sync"
+)
+
+// DropPolicy tells what the reader goroutine behind Packets does with a
+// packet when the channel buffer is full.
+type DropPolicy int
+
+const (
+	// Wait for the consumer, leaving packets queued in the kernel.
+	DropNone DropPolicy = iota
+	// Discard the packet just read.
+	DropNewest
+	// Discard the oldest buffered packet to make room.
+	DropOldest
+)
+
+// channels is the state behind Packets and Out.
+type channels struct {
+	mu     sync.Mutex
+	buffer int
+	policy DropPolicy
+	in     chan *IPPacket
+	out    chan *IPPacket
+	err    error
+}
+
+// SetChannelOptions configures the channels returned by Packets and
+// Out: their buffer size and the drop policy of Packets. It must be
+// called before the first call to either.
+func (t *Interface) SetChannelOptions(buffer int, policy DropPolicy) {
+	t.ch.mu.Lock()
+	defer t.ch.mu.Unlock()
+	t.ch.buffer = buffer
+	t.ch.policy = policy
+}
+
+// Packets returns a channel delivering the packets read from the
+// interface by a goroutine started on the first call. Packets that
+// can't be parsed are skipped. The channel is closed when reading fails,
+// for example after Close; ChannelErr then tells why.
+func (t *Interface) Packets() <-chan *IPPacket {
+	t.ch.mu.Lock()
+	defer t.ch.mu.Unlock()
+	if t.ch.in == nil {
+		t.ch.in = make(chan *IPPacket, t.ch.buffer)
+		go t.readLoop(t.ch.in, t.ch.policy)
+	}
+	return t.ch.in
+}
+
+// Out returns a channel whose packets are written to the interface by a
+// goroutine started on the first call. Closing the channel stops the
+// goroutine. Write errors are dropped silently.
+func (t *Interface) Out() chan<- *IPPacket {
+	t.ch.mu.Lock()
+	defer t.ch.mu.Unlock()
+	if t.ch.out == nil {
+		t.ch.out = make(chan *IPPacket, t.ch.buffer)
+		go t.writeLoop(t.ch.out)
+	}
+	return t.ch.out
+}
+
+// ChannelLen returns the number of packets buffered in the channels
+// returned by Packets and Out, waiting to be received by the consumer
+// and to be written respectively.
+func (t *Interface) ChannelLen() (in, out int) {
+	t.ch.mu.Lock()
+	defer t.ch.mu.Unlock()
+	return len(t.ch.in), len(t.ch.out)
+}
+
+// ChannelErr returns the error that closed the Packets channel, or nil
+// while it's open.
+func (t *Interface) ChannelErr() error {
+	t.ch.mu.Lock()
+	defer t.ch.mu.Unlock()
+	return t.ch.err
+}
+
+func (t *Interface) readLoop(in chan *IPPacket, policy DropPolicy) {
+	for {
+		buf := make([]byte, t.bufferSize())
+		data, info, err := t.readRaw(buf)
+		if err != nil {
+			t.ch.mu.Lock()
+			t.ch.err = err
+			t.ch.mu.Unlock()
+			close(in)
+			return
+		}
+		pkt, err := t.decodePacket(data, info)
+		if err != nil {
+			t.stats.rxDropped.Add(1)
+			continue
+		}
+		if pkt = t.hooked(pkt, false); pkt == nil {
+			continue
+		}
+
+		switch policy {
+		case DropNewest:
+			select {
+			case in <- pkt:
+			default:
+				t.stats.rxDropped.Add(1)
+			}
+		case DropOldest:
+			for sent := false; !sent; {
+				select {
+				case in <- pkt:
+					sent = true
+				default:
+					select {
+					case <-in:
+						t.stats.rxDropped.Add(1)
+					default:
+					}
+				}
+			}
+		default:
+			in <- pkt
+		}
+	}
+}
+
+func (t *Interface) writeLoop(out chan *IPPacket) {
+	for pkt := range out {
+		t.WritePacket(pkt)
+	}
+}
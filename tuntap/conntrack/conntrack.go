@@ -0,0 +1,499 @@
+// Package conntrack tracks the connections of the packets read from and
+// written to tuntap Interfaces, keyed by their FlowKey, for NATs,
+// firewalls and proxies to keep state about:
+//
+//	ct := conntrack.NewTable(conntrack.Options{})
+//	...
+//	p, err := t.ReadPacket()
+//	...
+//	c, dir, err := ct.Track(p)
+//	if err == nil && (dir == conntrack.Reply || c.State == conntrack.Established) {
+//		// Part of a connection being answered.
+//	}
+package conntrack
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// ErrTableFull is returned by Track for the packets of new connections
+// while the table holds Options.MaxConns.
+var ErrTableFull = errors.New("Connection tracking table full")
+
+// State is the state of a tracked connection.
+type State int
+
+const (
+	// Packets were only seen in the original direction.
+	New State = iota
+	// Packets were seen both ways; for TCP, the handshake completed.
+	Established
+	// TCP states: SYN sent, answered with a SYN-ACK, FIN sent by one
+	// end, by both, and connection reset.
+	SynSent
+	SynReceived
+	FinWait
+	TimeWait
+	Closed
+)
+
+var stateNames = []string{"new", "established", "syn-sent", "syn-received", "fin-wait", "time-wait", "closed"}
+
+func (s State) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "unknown"
+}
+
+// Direction tells how a packet relates to its connection.
+type Direction int
+
+const (
+	// From the end that sent the first packet.
+	Original Direction = iota
+	// From the other end.
+	Reply
+	// An ICMP error about a packet of the connection.
+	Related
+)
+
+// Timeouts are the times a connection is kept without packets,
+// depending on its state. Zero values stand for the defaults, those of
+// Linux.
+type Timeouts struct {
+	TCPSynSent     time.Duration
+	TCPSynReceived time.Duration
+	TCPEstablished time.Duration
+	TCPFinWait     time.Duration
+	TCPTimeWait    time.Duration
+	TCPClosed      time.Duration
+	// UDP flows, with and without replies.
+	UDP        time.Duration
+	UDPReplied time.Duration
+	ICMP       time.Duration
+	// Any other protocol.
+	Other time.Duration
+}
+
+// DefaultTimeouts are the Timeouts used by zero fields.
+var DefaultTimeouts = Timeouts{
+	TCPSynSent:     2 * time.Minute,
+	TCPSynReceived: time.Minute,
+	TCPEstablished: 5 * 24 * time.Hour,
+	TCPFinWait:     2 * time.Minute,
+	TCPTimeWait:    2 * time.Minute,
+	TCPClosed:      10 * time.Second,
+	UDP:            30 * time.Second,
+	UDPReplied:     2 * time.Minute,
+	ICMP:           30 * time.Second,
+	Other:          10 * time.Minute,
+}
+
+// EvictReason tells why a connection left the table.
+type EvictReason int
+
+const (
+	// No packet was seen for the timeout of its state.
+	Expired EvictReason = iota
+	// Removed by Delete.
+	Deleted
+)
+
+// Options configure a Table.
+type Options struct {
+	Timeouts Timeouts
+	// Maximum number of connections, unlimited if zero.
+	MaxConns int
+	// OnEvict, if set, is called with each connection leaving the
+	// table, from the goroutine calling into the Table, without holding
+	// its lock.
+	OnEvict func(c Conn, reason EvictReason)
+}
+
+// Conn is a snapshot of a tracked connection.
+type Conn struct {
+	// Key of the packets in the original direction.
+	Key     tuntap.FlowKey
+	State   State
+	Created time.Time
+	// Time of the last packet.
+	LastSeen time.Time
+	// Packets and bytes seen in the original and reply directions.
+	OrigPackets, OrigBytes   uint64
+	ReplyPackets, ReplyBytes uint64
+}
+
+// Stats are counters of a Table.
+type Stats struct {
+	// Connections tracked.
+	Conns int
+	// Connections added, expired and deleted.
+	Created uint64
+	Expired uint64
+	Deleted uint64
+	// Packets of new connections refused while the table was full.
+	Dropped uint64
+}
+
+// How often expired connections are looked for.
+const sweepInterval = time.Second
+
+// Table is a connection tracking table. It's safe for concurrent use.
+type Table struct {
+	timeouts Timeouts
+	maxConns int
+	onEvict  func(Conn, EvictReason)
+
+	mu sync.Mutex
+	// Both keys of each connection.
+	conns     map[tuntap.FlowKey]*conn
+	n         int
+	stats     Stats
+	lastSweep time.Time
+}
+
+type conn struct {
+	Conn
+	expires time.Time
+	// FINs seen in the original and reply directions.
+	finOrig, finReply bool
+}
+
+// NewTable returns an empty Table.
+func NewTable(opts Options) *Table {
+	t := &Table{
+		timeouts: opts.Timeouts,
+		maxConns: opts.MaxConns,
+		onEvict:  opts.OnEvict,
+		conns:    make(map[tuntap.FlowKey]*conn),
+	}
+	for _, f := range []struct{ v, def *time.Duration }{
+		{&t.timeouts.TCPSynSent, &DefaultTimeouts.TCPSynSent},
+		{&t.timeouts.TCPSynReceived, &DefaultTimeouts.TCPSynReceived},
+		{&t.timeouts.TCPEstablished, &DefaultTimeouts.TCPEstablished},
+		{&t.timeouts.TCPFinWait, &DefaultTimeouts.TCPFinWait},
+		{&t.timeouts.TCPTimeWait, &DefaultTimeouts.TCPTimeWait},
+		{&t.timeouts.TCPClosed, &DefaultTimeouts.TCPClosed},
+		{&t.timeouts.UDP, &DefaultTimeouts.UDP},
+		{&t.timeouts.UDPReplied, &DefaultTimeouts.UDPReplied},
+		{&t.timeouts.ICMP, &DefaultTimeouts.ICMP},
+		{&t.timeouts.Other, &DefaultTimeouts.Other},
+	} {
+		if *f.v <= 0 {
+			*f.v = *f.def
+		}
+	}
+	return t
+}
+
+// Track records the packet p in the table, adding its connection if
+// it's new, and returns the updated connection and how p relates to it.
+// ICMP errors are matched with the connection of the packet they quote,
+// and only tracked as connections of their own if there's none.
+func (t *Table) Track(p *tuntap.IPPacket) (Conn, Direction, error) {
+	k, err := p.FlowKey()
+	if err != nil {
+		return Conn{}, Original, err
+	}
+	proto, data, _ := p.UpperLayer()
+	if q, ok := quotedKey(proto, data); ok {
+		if c, _, ok := t.Lookup(q); ok {
+			return c, Related, nil
+		}
+	}
+	var flags uint8
+	if proto == tuntap.ProtoTCP && len(data) >= 14 {
+		flags = data[13]
+	}
+	return t.TrackKey(k, flags, len(p.Header.Data)+len(p.Payload))
+}
+
+// TrackKey records a packet of size bytes with key k, and the TCP flags
+// of TCP segments, like Track. It lets flows seen other than as
+// IPPackets be tracked.
+func (t *Table) TrackKey(k tuntap.FlowKey, flags uint8, size int) (Conn, Direction, error) {
+	now := time.Now()
+	t.mu.Lock()
+	evicted := t.sweep(now, false)
+	c, dir, err := t.track(k, flags, size, now)
+	var snapshot Conn
+	if c != nil {
+		snapshot = c.Conn
+	}
+	t.mu.Unlock()
+	t.evicted(evicted, Expired)
+	return snapshot, dir, err
+}
+
+func (t *Table) track(k tuntap.FlowKey, flags uint8, size int, now time.Time) (*conn, Direction, error) {
+	c := t.conns[k]
+	if c != nil && now.After(c.expires) {
+		// Expired but not swept yet: a new connection.
+		t.remove(c)
+		t.stats.Expired++
+		c = nil
+	}
+	dir := Original
+	if c == nil {
+		if t.maxConns > 0 && t.n >= t.maxConns {
+			t.stats.Dropped++
+			return nil, Original, ErrTableFull
+		}
+		c = &conn{Conn: Conn{Key: k, State: New, Created: now}}
+		t.conns[k] = c
+		t.conns[k.Reverse()] = c
+		t.n++
+		t.stats.Created++
+	} else if k != c.Key {
+		dir = Reply
+	}
+
+	c.LastSeen = now
+	if dir == Original {
+		c.OrigPackets++
+		c.OrigBytes += uint64(size)
+	} else {
+		c.ReplyPackets++
+		c.ReplyBytes += uint64(size)
+	}
+	switch k.Proto {
+	case tuntap.ProtoTCP:
+		c.trackTCP(flags, dir)
+	default:
+		if dir == Reply {
+			c.State = Established
+		}
+	}
+	c.expires = now.Add(t.timeout(c))
+	return c, dir, nil
+}
+
+// trackTCP follows the TCP state of c through a segment with flags.
+func (c *conn) trackTCP(flags uint8, dir Direction) {
+	syn, ack := flags&parser.TCPFlagSYN != 0, flags&parser.TCPFlagACK != 0
+	switch {
+	case flags&parser.TCPFlagRST != 0:
+		c.State = Closed
+		return
+	case syn && !ack && dir == Original && (c.State == New || c.State == Closed || c.State == TimeWait):
+		// Reopened.
+		c.State, c.finOrig, c.finReply = SynSent, false, false
+	case syn && ack && dir == Reply && c.State == SynSent:
+		c.State = SynReceived
+	case ack && dir == Original && c.State == SynReceived:
+		c.State = Established
+	case c.State == New && dir == Reply:
+		// Picked up in the middle.
+		c.State = Established
+	}
+	if flags&parser.TCPFlagFIN != 0 {
+		if dir == Original {
+			c.finOrig = true
+		} else {
+			c.finReply = true
+		}
+		switch {
+		case c.finOrig && c.finReply:
+			c.State = TimeWait
+		case c.State == Established || c.State == SynReceived:
+			c.State = FinWait
+		}
+	}
+}
+
+// timeout returns the timeout of c in its state.
+func (t *Table) timeout(c *conn) time.Duration {
+	switch c.Key.Proto {
+	case tuntap.ProtoTCP:
+		switch c.State {
+		case SynSent:
+			return t.timeouts.TCPSynSent
+		case SynReceived:
+			return t.timeouts.TCPSynReceived
+		case FinWait:
+			return t.timeouts.TCPFinWait
+		case TimeWait:
+			return t.timeouts.TCPTimeWait
+		case Closed:
+			return t.timeouts.TCPClosed
+		case New:
+			// Picking up a connection in the middle.
+			return t.timeouts.TCPSynSent
+		}
+		return t.timeouts.TCPEstablished
+	case tuntap.ProtoUDP:
+		if c.State == Established {
+			return t.timeouts.UDPReplied
+		}
+		return t.timeouts.UDP
+	case tuntap.ProtoICMP, tuntap.ProtoICMPv6:
+		return t.timeouts.ICMP
+	}
+	return t.timeouts.Other
+}
+
+// Lookup returns the connection of the packets with key k, and the
+// direction of such packets.
+func (t *Table) Lookup(k tuntap.FlowKey) (Conn, Direction, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.conns[k]
+	if c == nil || time.Now().After(c.expires) {
+		return Conn{}, Original, false
+	}
+	if k != c.Key {
+		return c.Conn, Reply, true
+	}
+	return c.Conn, Original, true
+}
+
+// Delete removes the connection of the packets with key k, in either
+// direction. It returns false if there's none.
+func (t *Table) Delete(k tuntap.FlowKey) bool {
+	t.mu.Lock()
+	c := t.conns[k]
+	if c != nil {
+		t.remove(c)
+		t.stats.Deleted++
+	}
+	t.mu.Unlock()
+	if c == nil {
+		return false
+	}
+	t.evicted([]Conn{c.Conn}, Deleted)
+	return true
+}
+
+// Conns returns a snapshot of the connections tracked.
+func (t *Table) Conns() []Conn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conns := make([]Conn, 0, t.n)
+	for k, c := range t.conns {
+		if k == c.Key {
+			conns = append(conns, c.Conn)
+		}
+	}
+	return conns
+}
+
+// Expire removes the connections that timed out. Track does so every
+// second, so calling it is only needed while no packets are tracked.
+func (t *Table) Expire() {
+	t.mu.Lock()
+	evicted := t.sweep(time.Now(), true)
+	t.mu.Unlock()
+	t.evicted(evicted, Expired)
+}
+
+// Stats returns the counters of the table.
+func (t *Table) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stats
+	s.Conns = t.n
+	return s
+}
+
+func (t *Table) remove(c *conn) {
+	delete(t.conns, c.Key)
+	delete(t.conns, c.Key.Reverse())
+	t.n--
+}
+
+// sweep removes the expired connections, at most every sweepInterval
+// unless forced, and returns them for OnEvict.
+func (t *Table) sweep(now time.Time, force bool) []Conn {
+	if !force && now.Sub(t.lastSweep) < sweepInterval {
+		return nil
+	}
+	t.lastSweep = now
+	var evicted []Conn
+	for k, c := range t.conns {
+		if k == c.Key && now.After(c.expires) {
+			t.remove(c)
+			t.stats.Expired++
+			if t.onEvict != nil {
+				evicted = append(evicted, c.Conn)
+			}
+		}
+	}
+	return evicted
+}
+
+func (t *Table) evicted(conns []Conn, reason EvictReason) {
+	if t.onEvict == nil {
+		return
+	}
+	for _, c := range conns {
+		t.onEvict(c, reason)
+	}
+}
+
+// quotedKey returns the key of the packet quoted by the ICMP or ICMPv6
+// error msg, false if msg isn't one.
+func quotedKey(proto tuntap.IPProtocol, msg []byte) (tuntap.FlowKey, bool) {
+	if len(msg) < 8 {
+		return tuntap.FlowKey{}, false
+	}
+	switch {
+	case proto == tuntap.ProtoICMP:
+		switch msg[0] {
+		case parser.ICMPv4DestUnreachable, parser.ICMPv4TimeExceeded, parser.ICMPv4ParamProblem:
+		default:
+			return tuntap.FlowKey{}, false
+		}
+	case proto == tuntap.ProtoICMPv6 && msg[0] < 128:
+	default:
+		return tuntap.FlowKey{}, false
+	}
+
+	b := msg[8:]
+	var k tuntap.FlowKey
+	var l4 []byte
+	switch {
+	case len(b) >= 20 && b[0]>>4 == 4:
+		hlen := int(b[0]&0x0f) * 4
+		if hlen < 20 || hlen > len(b) {
+			return tuntap.FlowKey{}, false
+		}
+		k.Src = netip.AddrFrom4([4]byte(b[12:16]))
+		k.Dst = netip.AddrFrom4([4]byte(b[16:20]))
+		k.Proto = tuntap.IPProtocol(b[9])
+		l4 = b[hlen:]
+	case len(b) >= 40 && b[0]>>4 == 6:
+		it := parser.NewExtensionHeaders(b[6], b[40:])
+		for it.Next() {
+		}
+		if it.Err() != nil {
+			return tuntap.FlowKey{}, false
+		}
+		k.Src = netip.AddrFrom16([16]byte(b[8:24]))
+		k.Dst = netip.AddrFrom16([16]byte(b[24:40]))
+		k.Proto = tuntap.IPProtocol(it.Protocol())
+		l4 = b[40+it.Offset():]
+	default:
+		return tuntap.FlowKey{}, false
+	}
+	switch k.Proto {
+	case tuntap.ProtoTCP, tuntap.ProtoUDP, tuntap.ProtoSCTP:
+		if len(l4) >= 4 {
+			k.SrcPort = binary.BigEndian.Uint16(l4[0:2])
+			k.DstPort = binary.BigEndian.Uint16(l4[2:4])
+		}
+	case tuntap.ProtoICMP, tuntap.ProtoICMPv6:
+		if len(l4) >= 6 {
+			k.SrcPort = binary.BigEndian.Uint16(l4[4:6])
+			k.DstPort = k.SrcPort
+		}
+	}
+	return k, true
+}
@@ -0,0 +1,92 @@
+package tuntap
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past, to wake up blocked I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// ReadPacketContext is like ReadPacket, but gives up and returns
+// ctx.Err() when ctx is done before a packet arrives.
+//
+// Cancellation works through the read deadline of the device; the one
+// set with SetReadDeadline, if any, still applies and is restored
+// afterwards. Devices without deadline support are read through the
+// Packets channel instead, so that a cancelled read loses no packet;
+// ReadPacketContext shouldn't be mixed with the other read methods on
+// them.
+func (t *Interface) ReadPacketContext(ctx context.Context) (*IPPacket, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	user := loadDeadline(&t.readDeadline)
+	if t.setDevReadDeadline(user) != nil {
+		select {
+		case pkt, ok := <-t.Packets():
+			if !ok {
+				return nil, t.ChannelErr()
+			}
+			return pkt, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	var pkt *IPPacket
+	err := withContext(ctx, t.setDevReadDeadline, user, func() error {
+		var err error
+		pkt, err = t.ReadPacket()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// WritePacketContext is like WritePacket, but gives up and returns
+// ctx.Err() when ctx is done before the packet could be written, the
+// way ReadPacketContext does with the write deadline. On devices
+// without deadline support, ctx is only checked before writing.
+func (t *Interface) WritePacketContext(ctx context.Context, packet *IPPacket) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	user := loadDeadline(&t.writeDeadline)
+	if t.setDevWriteDeadline(user) != nil {
+		return t.WritePacket(packet)
+	}
+	return withContext(ctx, t.setDevWriteDeadline, user, func() error {
+		return t.WritePacket(packet)
+	})
+}
+
+// loadDeadline returns the deadline stored in v, zero if none.
+func loadDeadline(v *atomic.Value) time.Time {
+	tm, _ := v.Load().(time.Time)
+	return tm
+}
+
+// withContext runs op, interrupting it by moving the deadline with
+// setDeadline when ctx is done, and then putting back user.
+func withContext(ctx context.Context, setDeadline func(time.Time) error, user time.Time, op func() error) error {
+	moved := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		setDeadline(aLongTimeAgo)
+		close(moved)
+	})
+	err := op()
+	if !stop() {
+		// ctx was done: wait for the deadline to be moved, so it
+		// can't happen after it's put back.
+		<-moved
+		setDeadline(user)
+		if err != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package tuntap
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadPacketContextCancel(t *testing.T) {
+	a, b := NewPipe(DevTun)
+	defer a.Close()
+	defer b.Close()
+
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		if i%2 == 0 {
+			go cancel()
+		} else {
+			cancel()
+		}
+		if _, err := b.ReadPacketContext(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got error %v, want context.Canceled", err)
+		}
+
+		// The deadline moved to cancel the read is put back.
+		if err := a.WritePacket(udpPacket(t, testSrc4, testDst4, 53, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.ReadPacket(); err != nil {
+			t.Fatalf("read after cancellation: %v", err)
+		}
+	}
+}
+
+func TestReadPacketContextUserDeadline(t *testing.T) {
+	a, b := NewPipe(DevTun)
+	defer a.Close()
+	defer b.Close()
+
+	// A cancelled read doesn't clear the deadline set before.
+	b.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.ReadPacketContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := b.ReadPacketContext(ctx); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got error %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	b.SetReadDeadline(time.Time{})
+	if err := a.WritePacket(udpPacket(t, testSrc4, testDst4, 53, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadPacketContext(ctx); err != nil {
+		t.Errorf("read after clearing the deadline: %v", err)
+	}
+}
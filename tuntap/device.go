@@ -0,0 +1,210 @@
+package tuntap
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap/internal/mtu"
+)
+
+// Device is the OS specific layer under an Interface. Read and Write
+// transfer exactly one packet (or frame) per call, including any
+// packet information or address family header the device adds. Read
+// and Write are called concurrently from the goroutines using the
+// Interface, and Close while they are pending, which they must support.
+type Device interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	Close() error
+	// Name of the network interface.
+	Name() string
+	// MTU of the network interface.
+	MTU() (int, error)
+}
+
+// A Backend creates Devices. It gets the arguments given to
+// OpenBackend.
+type Backend func(ifPattern string, kind DevKind, meta bool) (Device, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[string]Backend)
+)
+
+// RegisterBackend makes a Backend available to OpenBackend under name.
+// Registering the same name twice replaces the previous Backend.
+func RegisterBackend(name string, b Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = b
+}
+
+// OpenBackend opens an Interface on a Device created by the Backend
+// registered under name.
+func OpenBackend(name string, ifPattern string, kind DevKind, meta bool) (*Interface, error) {
+	backendsMu.Lock()
+	b, ok := backends[name]
+	backendsMu.Unlock()
+	if !ok {
+		return nil, errors.New("Unknown backend " + name)
+	}
+
+	dev, err := b(ifPattern, kind, meta)
+	if err != nil {
+		return nil, err
+	}
+	return NewInterface(dev, kind, meta), nil
+}
+
+// NewInterface wraps a Device in an Interface. meta tells whether the
+// device delivers the tun/tap packet information header.
+func NewInterface(dev Device, kind DevKind, meta bool) *Interface {
+	return &Interface{dev: dev, kind: kind, meta: meta}
+}
+
+// osDevice is a Device opened by the platform code, the kernel tun/tap
+// driver on most systems.
+type osDevice struct {
+	io.ReadWriteCloser
+	name string
+}
+
+func (d *osDevice) Name() string {
+	return d.name
+}
+
+func (d *osDevice) MTU() (int, error) {
+	return mtu.Get(d.name)
+}
+
+// wrappedDevice is implemented by Devices layered over another one,
+// like the io_uring engine.
+type wrappedDevice interface {
+	unwrap() Device
+}
+
+// file returns the descriptor under an Interface's Device, if it's an
+// OS device backed by one.
+func (t *Interface) file() (*os.File, error) {
+	dev := t.dev
+	if w, ok := dev.(wrappedDevice); ok {
+		dev = w.unwrap()
+	}
+	if d, ok := dev.(*osDevice); ok {
+		if f, ok := d.ReadWriteCloser.(*os.File); ok {
+			return f, nil
+		}
+	}
+	return nil, errors.New("Device has no file descriptor")
+}
+
+// SyscallConn returns a raw connection to the descriptor of the
+// interface, to issue ioctls or other system calls on it that this
+// package doesn't wrap.
+func (t *Interface) SyscallConn() (syscall.RawConn, error) {
+	file, err := t.file()
+	if err != nil {
+		return nil, err
+	}
+	return file.SyscallConn()
+}
+
+// Fd returns the descriptor of the interface, or ^uintptr(0) if its
+// Device has none. Like os.File.Fd, it switches the descriptor to
+// blocking mode, which disables deadlines and keeps Close from
+// unblocking pending I/O: prefer SyscallConn unless the descriptor is
+// passed to another process.
+func (t *Interface) Fd() uintptr {
+	file, err := t.file()
+	if err != nil {
+		return ^uintptr(0)
+	}
+	return file.Fd()
+}
+
+// control runs f on the descriptor of file. Unlike File.Fd, it leaves
+// the descriptor in non-blocking mode and registered with the runtime
+// poller, which deadlines and unblocking Close rely on.
+func control(file *os.File, f func(fd uintptr) error) error {
+	rc, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err := rc.Control(func(fd uintptr) { ferr = f(fd) }); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// deadliner is implemented by Devices supporting I/O deadlines.
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+func (d *osDevice) SetReadDeadline(t time.Time) error {
+	if f, ok := d.ReadWriteCloser.(deadliner); ok {
+		return f.SetReadDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+func (d *osDevice) SetWriteDeadline(t time.Time) error {
+	if f, ok := d.ReadWriteCloser.(deadliner); ok {
+		return f.SetWriteDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+// SetReadDeadline sets the deadline for ReadPacket and the other read
+// methods, like net.Conn. Reads blocked past the deadline fail with an
+// error wrapping os.ErrDeadlineExceeded. A zero t means no deadline.
+//
+// It returns os.ErrNoDeadline if the device doesn't support deadlines.
+func (t *Interface) SetReadDeadline(tm time.Time) error {
+	if err := t.setDevReadDeadline(tm); err != nil {
+		return err
+	}
+	t.readDeadline.Store(tm)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for WritePacket and the other
+// write methods, like SetReadDeadline does for reads.
+func (t *Interface) SetWriteDeadline(tm time.Time) error {
+	if err := t.setDevWriteDeadline(tm); err != nil {
+		return err
+	}
+	t.writeDeadline.Store(tm)
+	return nil
+}
+
+// setDevReadDeadline sets the read deadline of the device without
+// recording it as the one of SetReadDeadline.
+func (t *Interface) setDevReadDeadline(tm time.Time) error {
+	if d, ok := t.dev.(deadliner); ok {
+		return d.SetReadDeadline(tm)
+	}
+	return os.ErrNoDeadline
+}
+
+// setDevWriteDeadline is setDevReadDeadline for writes.
+func (t *Interface) setDevWriteDeadline(tm time.Time) error {
+	if d, ok := t.dev.(deadliner); ok {
+		return d.SetWriteDeadline(tm)
+	}
+	return os.ErrNoDeadline
+}
+
+// SetDeadline sets both the read and write deadlines.
+func (t *Interface) SetDeadline(tm time.Time) error {
+	if err := t.SetReadDeadline(tm); err != nil {
+		return err
+	}
+	return t.SetWriteDeadline(tm)
+}
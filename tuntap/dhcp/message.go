@@ -0,0 +1,198 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// UDP ports of DHCP servers and clients.
+const (
+	ServerPort = 67
+	ClientPort = 68
+)
+
+// DHCP message types, the values of option 53.
+const (
+	Discover = 1
+	Offer    = 2
+	Request  = 3
+	Decline  = 4
+	Ack      = 5
+	Nak      = 6
+	Release  = 7
+	Inform   = 8
+)
+
+// Options used by the server (RFC 2132).
+const (
+	optPad          = 0
+	optSubnetMask   = 1
+	optRouter       = 3
+	optDNS          = 6
+	optHostName     = 12
+	optDomainName   = 15
+	optMTU          = 26
+	optBroadcast    = 28
+	optRequestedIP  = 50
+	optLeaseTime    = 51
+	optMessageType  = 53
+	optServerID     = 54
+	optMessage      = 56
+	optRenewalTime  = 58
+	optRebindTime   = 59
+	optEnd          = 255
+	maxOptionLength = 255
+)
+
+const (
+	// Values of op.
+	bootRequest = 1
+	bootReply   = 2
+
+	htypeEthernet = 1
+	// Length of the fixed fields, followed by the magic cookie and the
+	// options.
+	headerLength  = 236
+	magicCookie   = 0x63825363
+	flagBroadcast = 0x8000
+	// BOOTP messages are at least as long.
+	minMessageSize = 300
+)
+
+// message is a DHCP message.
+type message struct {
+	op     uint8
+	xid    uint32
+	flags  uint16
+	ciaddr netip.Addr
+	yiaddr netip.Addr
+	siaddr netip.Addr
+	giaddr netip.Addr
+	chaddr net.HardwareAddr
+	// Option values by code, those split in several options joined
+	// (RFC 3396).
+	options map[uint8][]byte
+	// Codes of the options to encode, in order.
+	order []uint8
+}
+
+// unmarshal decodes the DHCP message in b, with Ethernet addresses.
+// The values reference b.
+func (m *message) unmarshal(b []byte) error {
+	if len(b) < headerLength+4 || binary.BigEndian.Uint32(b[headerLength:]) != magicCookie {
+		return errors.New("Not a DHCP message")
+	}
+	if b[1] != htypeEthernet || b[2] != 6 {
+		return errors.New("DHCP message without an Ethernet address")
+	}
+	*m = message{
+		op:      b[0],
+		xid:     binary.BigEndian.Uint32(b[4:8]),
+		flags:   binary.BigEndian.Uint16(b[10:12]),
+		ciaddr:  netip.AddrFrom4([4]byte(b[12:16])),
+		yiaddr:  netip.AddrFrom4([4]byte(b[16:20])),
+		siaddr:  netip.AddrFrom4([4]byte(b[20:24])),
+		giaddr:  netip.AddrFrom4([4]byte(b[24:28])),
+		chaddr:  net.HardwareAddr(b[28:34]),
+		options: make(map[uint8][]byte),
+	}
+	for opts := b[headerLength+4:]; len(opts) > 0; {
+		code := opts[0]
+		if code == optEnd {
+			break
+		}
+		if code == optPad {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return errors.New("Truncated DHCP option")
+		}
+		m.options[code] = append(m.options[code], opts[2:2+opts[1]]...)
+		opts = opts[2+opts[1]:]
+	}
+	return nil
+}
+
+// messageType returns the value of option 53, 0 for BOOTP messages.
+func (m *message) messageType() uint8 {
+	if v := m.options[optMessageType]; len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+// addrOption returns the IPv4 address of the option code, the zero Addr
+// if missing.
+func (m *message) addrOption(code uint8) netip.Addr {
+	if v := m.options[code]; len(v) == 4 {
+		return netip.AddrFrom4([4]byte(v))
+	}
+	return netip.Addr{}
+}
+
+// set sets the option code to v.
+func (m *message) set(code uint8, v []byte) {
+	if m.options == nil {
+		m.options = make(map[uint8][]byte)
+	}
+	if _, ok := m.options[code]; !ok {
+		m.order = append(m.order, code)
+	}
+	m.options[code] = v
+}
+
+func (m *message) setAddrs(code uint8, addrs ...netip.Addr) {
+	v := make([]byte, 0, 4*len(addrs))
+	for _, a := range addrs {
+		a4 := a.As4()
+		v = append(v, a4[:]...)
+	}
+	m.set(code, v)
+}
+
+func (m *message) setUint32(code uint8, n uint32) {
+	m.set(code, binary.BigEndian.AppendUint32(nil, n))
+}
+
+// marshal encodes m, padded to the minimum BOOTP message size.
+func (m *message) marshal() []byte {
+	b := make([]byte, headerLength+4, minMessageSize)
+	b[0] = m.op
+	b[1] = htypeEthernet
+	b[2] = 6
+	binary.BigEndian.PutUint32(b[4:8], m.xid)
+	binary.BigEndian.PutUint16(b[10:12], m.flags)
+	for i, a := range []netip.Addr{m.ciaddr, m.yiaddr, m.siaddr, m.giaddr} {
+		if a.IsValid() {
+			a4 := a.As4()
+			copy(b[12+4*i:], a4[:])
+		}
+	}
+	copy(b[28:44], m.chaddr)
+	binary.BigEndian.PutUint32(b[headerLength:], magicCookie)
+	for _, code := range m.order {
+		v, ok := m.options[code]
+		if !ok {
+			continue
+		}
+		for {
+			n := len(v)
+			if n > maxOptionLength {
+				n = maxOptionLength
+			}
+			b = append(b, code, uint8(n))
+			b = append(b, v[:n]...)
+			if v = v[n:]; len(v) == 0 {
+				break
+			}
+		}
+	}
+	b = append(b, optEnd)
+	for len(b) < minMessageSize {
+		b = append(b, optPad)
+	}
+	return b
+}
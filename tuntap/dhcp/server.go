@@ -0,0 +1,389 @@
+// Package dhcp is a minimal DHCPv4 server (RFC 2131) for the Ethernet
+// segments behind DevTap interfaces, handing out the addresses of a
+// single pool to the virtual machines and containers attached to them:
+//
+//	s, err := dhcp.NewServer(t, dhcp.Config{
+//		ServerIP:  netip.MustParseAddr("10.0.0.1"),
+//		ServerMAC: mac,
+//		Pool:      netip.MustParsePrefix("10.0.0.0/24"),
+//		Router:    netip.MustParseAddr("10.0.0.1"),
+//	})
+//	...
+//	for {
+//		f, err := t.ReadFrame()
+//		...
+//		if ok, err := s.HandleFrame(f); ok {
+//			continue
+//		}
+//		// Not a DHCP frame.
+//	}
+package dhcp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// DefaultLeaseTime is the lease time of the addresses handed out, by
+// default.
+const DefaultLeaseTime = time.Hour
+
+// How long an offered address is reserved for the client it's offered
+// to, and a declined one kept out of the pool.
+const (
+	offerTimeout   = time.Minute
+	declineTimeout = 10 * time.Minute
+)
+
+var (
+	broadcastMAC  = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	broadcastAddr = netip.AddrFrom4([4]byte{255, 255, 255, 255})
+)
+
+// Config configures a Server.
+type Config struct {
+	// Address of the server, the source of its messages, and its MAC
+	// address.
+	ServerIP  netip.Addr
+	ServerMAC net.HardwareAddr
+	// The subnet of the segment. All its addresses are handed out, but
+	// the network and broadcast ones, ServerIP and Router.
+	Pool netip.Prefix
+	// Options given to the clients, when set.
+	Router     netip.Addr
+	DNS        []netip.Addr
+	DomainName string
+	MTU        int
+	// DefaultLeaseTime if zero.
+	LeaseTime time.Duration
+}
+
+// Lease is an address handed out.
+type Lease struct {
+	MAC      net.HardwareAddr
+	IP       netip.Addr
+	HostName string
+	Expires  time.Time
+}
+
+type lease struct {
+	Lease
+	// Not yet requested by the client.
+	offered bool
+	// Declined by the client, in use by another host.
+	declined bool
+}
+
+// Server is a DHCP server on a DevTap interface. It's safe for
+// concurrent use.
+type Server struct {
+	t     *tuntap.Interface
+	cfg   Config
+	first uint32
+	last  uint32
+
+	mu sync.Mutex
+	// Leases by address, and address by MAC address.
+	leases map[netip.Addr]*lease
+	byMAC  map[string]netip.Addr
+}
+
+// NewServer returns a Server handing out the addresses of cfg.Pool on
+// the DevTap interface t.
+func NewServer(t *tuntap.Interface, cfg Config) (*Server, error) {
+	if a, ok := t.LocalAddr().(*tuntap.Addr); !ok || a.Kind != tuntap.DevTap {
+		return nil, errors.New("DHCP needs a DevTap interface")
+	}
+	cfg.Pool = cfg.Pool.Masked()
+	if !cfg.Pool.Addr().Is4() || cfg.Pool.Bits() > 30 {
+		return nil, errors.New("DHCP pool must be an IPv4 prefix of at most 30 bits")
+	}
+	if !cfg.Pool.Contains(cfg.ServerIP) {
+		return nil, errors.New("DHCP server address must be in the pool")
+	}
+	if len(cfg.ServerMAC) != 6 {
+		return nil, errors.New("DHCP needs a 6-byte server MAC address")
+	}
+	if cfg.Router.IsValid() && !cfg.Router.Is4() {
+		return nil, errors.New("DHCP router must be an IPv4 address")
+	}
+	for _, a := range cfg.DNS {
+		if !a.Is4() {
+			return nil, errors.New("DHCP DNS servers must be IPv4 addresses")
+		}
+	}
+	if cfg.MTU != 0 && (cfg.MTU < 68 || cfg.MTU > 0xffff) {
+		return nil, errors.New("Invalid DHCP MTU")
+	}
+	if len(cfg.DomainName) > maxOptionLength {
+		return nil, errors.New("DHCP domain name too long")
+	}
+	if cfg.LeaseTime <= 0 {
+		cfg.LeaseTime = DefaultLeaseTime
+	}
+	network := addrUint32(cfg.Pool.Addr())
+	broadcast := network | (1<<(32-cfg.Pool.Bits()) - 1)
+	return &Server{
+		t:      t,
+		cfg:    cfg,
+		first:  network + 1,
+		last:   broadcast - 1,
+		leases: make(map[netip.Addr]*lease),
+		byMAC:  make(map[string]netip.Addr),
+	}, nil
+}
+
+func addrUint32(a netip.Addr) uint32 {
+	b := a.As4()
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+func uint32Addr(n uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+}
+
+// HandleFrame handles f if it's a DHCP message to a server, answering
+// it. It returns false for other frames, and the error of writing the
+// answer.
+func (s *Server) HandleFrame(f *tuntap.EthernetFrame) (bool, error) {
+	if f.EtherType != parser.EtherTypeIPv4 {
+		return false, nil
+	}
+	ip, err := parser.ParseIPv4(f.Payload)
+	if err != nil {
+		return false, nil
+	}
+	p := &tuntap.IPPacket{Protocol: parser.EtherTypeIPv4, Header: tuntap.IPHeader{Data: ip.Header}, Payload: ip.Payload}
+	udp, data, err := p.UDP()
+	if err != nil || udp.DstPort != ServerPort {
+		return false, nil
+	}
+	var m message
+	if err := m.unmarshal(data); err != nil || m.op != bootRequest || m.giaddr.IsValid() && !m.giaddr.IsUnspecified() {
+		// Relayed messages aren't supported.
+		return true, nil
+	}
+	if id := m.addrOption(optServerID); id.IsValid() && id != s.cfg.ServerIP && m.messageType() != Request {
+		return true, nil
+	}
+
+	s.mu.Lock()
+	reply := s.handle(&m, time.Now())
+	s.mu.Unlock()
+	if reply == nil {
+		return true, nil
+	}
+	return true, s.send(&m, reply)
+}
+
+// handle returns the reply to m, nil for none.
+func (s *Server) handle(m *message, now time.Time) *message {
+	mac := string(m.chaddr)
+	switch m.messageType() {
+	case Discover:
+		ip := s.allocate(m.chaddr, m.addrOption(optRequestedIP), now)
+		if !ip.IsValid() {
+			return nil
+		}
+		if l, ok := s.leases[ip]; !ok || l.offered || !bytes.Equal(l.MAC, m.chaddr) || now.After(l.Expires) {
+			// Reserved until requested, unless already leased.
+			l = s.bind(m, ip, now)
+			l.offered = true
+			l.Expires = now.Add(offerTimeout)
+		}
+		return s.reply(m, Offer, ip)
+
+	case Request:
+		requested := m.addrOption(optRequestedIP)
+		if id := m.addrOption(optServerID); id.IsValid() {
+			// Selecting: the client chose a server.
+			if id != s.cfg.ServerIP {
+				if ip, ok := s.byMAC[mac]; ok && s.leases[ip].offered {
+					s.remove(ip)
+				}
+				return nil
+			}
+		} else if !requested.IsValid() {
+			// Renewing or rebinding.
+			requested = m.ciaddr
+		}
+		if !requested.IsValid() || !s.available(requested, m.chaddr, now) {
+			return s.nak(m)
+		}
+		s.bind(m, requested, now)
+		return s.reply(m, Ack, requested)
+
+	case Decline:
+		ip := m.addrOption(optRequestedIP)
+		if l, ok := s.leases[ip]; ok && bytes.Equal(l.MAC, m.chaddr) {
+			s.remove(ip)
+			s.leases[ip] = &lease{Lease: Lease{IP: ip, Expires: now.Add(declineTimeout)}, declined: true}
+		}
+		return nil
+
+	case Release:
+		if l, ok := s.leases[m.ciaddr]; ok && bytes.Equal(l.MAC, m.chaddr) {
+			s.remove(m.ciaddr)
+		}
+		return nil
+
+	case Inform:
+		// Only the options.
+		r := s.reply(m, Ack, netip.Addr{})
+		r.ciaddr = m.ciaddr
+		delete(r.options, optLeaseTime)
+		delete(r.options, optRenewalTime)
+		delete(r.options, optRebindTime)
+		return r
+	}
+	return nil
+}
+
+// available tells whether ip can be leased to the client mac.
+func (s *Server) available(ip netip.Addr, mac net.HardwareAddr, now time.Time) bool {
+	n := addrUint32(ip)
+	if !ip.Is4() || n < s.first || n > s.last || ip == s.cfg.ServerIP || ip == s.cfg.Router {
+		return false
+	}
+	l, ok := s.leases[ip]
+	return !ok || now.After(l.Expires) || bytes.Equal(l.MAC, mac) && !l.declined
+}
+
+// allocate returns the address to offer to mac: its current one, the
+// requested one, or the lowest one available. It returns the zero Addr
+// if the pool is exhausted.
+func (s *Server) allocate(mac net.HardwareAddr, requested netip.Addr, now time.Time) netip.Addr {
+	if ip, ok := s.byMAC[string(mac)]; ok && s.available(ip, mac, now) {
+		return ip
+	}
+	if requested.IsValid() && s.available(requested, mac, now) {
+		return requested
+	}
+	for n := s.first; n <= s.last; n++ {
+		if ip := uint32Addr(n); s.available(ip, mac, now) {
+			return ip
+		}
+	}
+	return netip.Addr{}
+}
+
+// bind leases ip to the client of m and returns the lease.
+func (s *Server) bind(m *message, ip netip.Addr, now time.Time) *lease {
+	if old, ok := s.byMAC[string(m.chaddr)]; ok && old != ip {
+		s.remove(old)
+	}
+	hostName := string(m.options[optHostName])
+	if l, ok := s.leases[ip]; ok && !bytes.Equal(l.MAC, m.chaddr) {
+		s.remove(ip)
+	} else if ok && hostName == "" {
+		// Renewals may not repeat it.
+		hostName = l.HostName
+	}
+	l := &lease{Lease: Lease{
+		MAC:      append(net.HardwareAddr(nil), m.chaddr...),
+		IP:       ip,
+		HostName: hostName,
+		Expires:  now.Add(s.cfg.LeaseTime),
+	}}
+	s.leases[ip] = l
+	s.byMAC[string(l.MAC)] = ip
+	return l
+}
+
+func (s *Server) remove(ip netip.Addr) {
+	if l, ok := s.leases[ip]; ok {
+		if s.byMAC[string(l.MAC)] == ip {
+			delete(s.byMAC, string(l.MAC))
+		}
+		delete(s.leases, ip)
+	}
+}
+
+// reply returns the reply of type typ to m, giving yiaddr.
+func (s *Server) reply(m *message, typ uint8, yiaddr netip.Addr) *message {
+	r := &message{
+		op:     bootReply,
+		xid:    m.xid,
+		flags:  m.flags,
+		yiaddr: yiaddr,
+		chaddr: m.chaddr,
+	}
+	r.set(optMessageType, []byte{typ})
+	r.setAddrs(optServerID, s.cfg.ServerIP)
+	lease := uint32(s.cfg.LeaseTime / time.Second)
+	r.setUint32(optLeaseTime, lease)
+	r.setUint32(optRenewalTime, lease/2)
+	r.setUint32(optRebindTime, lease/8*7)
+	mask := net.CIDRMask(s.cfg.Pool.Bits(), 32)
+	r.set(optSubnetMask, mask)
+	r.setAddrs(optBroadcast, uint32Addr(s.last+1))
+	if s.cfg.Router.IsValid() {
+		r.setAddrs(optRouter, s.cfg.Router)
+	}
+	if len(s.cfg.DNS) > 0 {
+		r.setAddrs(optDNS, s.cfg.DNS...)
+	}
+	if s.cfg.DomainName != "" {
+		r.set(optDomainName, []byte(s.cfg.DomainName))
+	}
+	if s.cfg.MTU != 0 {
+		r.set(optMTU, []byte{byte(s.cfg.MTU >> 8), byte(s.cfg.MTU)})
+	}
+	return r
+}
+
+func (s *Server) nak(m *message) *message {
+	r := &message{op: bootReply, xid: m.xid, flags: m.flags, chaddr: m.chaddr}
+	r.set(optMessageType, []byte{Nak})
+	r.setAddrs(optServerID, s.cfg.ServerIP)
+	r.set(optMessage, []byte("Address not available"))
+	return r
+}
+
+// send sends the reply r to the client of m, where RFC 2131 section
+// 4.1 says: unicast to a configured client, else broadcast if it asks
+// or for NAKs, else unicast to the address handed out.
+func (s *Server) send(m *message, r *message) error {
+	dst, mac := broadcastAddr, broadcastMAC
+	switch {
+	case r.messageType() == Nak:
+	case m.ciaddr.IsValid() && !m.ciaddr.IsUnspecified():
+		dst, mac = m.ciaddr, m.chaddr
+	case m.flags&flagBroadcast == 0 && r.yiaddr.IsValid():
+		dst, mac = r.yiaddr, m.chaddr
+	}
+	data := r.marshal()
+	udp := make([]byte, 8+len(data))
+	udp[0], udp[1] = 0, ServerPort
+	udp[2], udp[3] = 0, ClientPort
+	copy(udp[8:], data)
+	p, err := tuntap.NewIPv4Packet(s.cfg.ServerIP, dst, tuntap.ProtoUDP, udp)
+	if err != nil {
+		return err
+	}
+	p.Frame = &tuntap.EthernetFrame{DstMAC: mac, SrcMAC: s.cfg.ServerMAC}
+	return s.t.WritePacket(p)
+}
+
+// Leases returns the addresses leased, by address.
+func (s *Server) Leases() []Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var leases []Lease
+	for _, l := range s.leases {
+		if !l.offered && !l.declined && !now.After(l.Expires) {
+			leases = append(leases, l.Lease)
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].IP.Less(leases[j].IP) })
+	return leases
+}
@@ -0,0 +1,99 @@
+package tuntap
+
+import (
+	"net/netip"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// echoAddrs are the addresses ICMP and ICMPv6 echo requests are
+// answered for.
+type echoAddrs map[netip.Addr]bool
+
+func newEchoAddrs(addrs []netip.Addr) echoAddrs {
+	m := make(echoAddrs, len(addrs))
+	for _, a := range addrs {
+		m[a.Unmap().WithZone("")] = true
+	}
+	return m
+}
+
+// WithEchoResponder makes the interface answer the ICMP and ICMPv6 echo
+// requests read from it for the given addresses, instead of returning
+// them from the read methods, which keep waiting for another packet
+// when all they read was answered. Answering comes before the
+// PacketHook, and the replies are written like by WritePacket.
+func WithEchoResponder(addrs ...netip.Addr) Option {
+	return func(o *openOptions) { o.echo = append(o.echo, addrs...) }
+}
+
+// EchoResponder returns the Middleware answering the ICMP and ICMPv6
+// echo requests for the given addresses, writing the replies to w,
+// typically the Interface served. Other packets are passed on.
+func EchoResponder(w PacketWriter, addrs ...netip.Addr) Middleware {
+	m := newEchoAddrs(addrs)
+	return func(p *IPPacket, next Handler) error {
+		reply := m.reply(p)
+		if reply == nil {
+			return next(p)
+		}
+		return w.WritePacket(reply)
+	}
+}
+
+// answerEcho writes the reply to p if it's an echo request for the
+// addresses of the echo responder, and tells whether it was one.
+func (t *Interface) answerEcho(p *IPPacket) bool {
+	if len(t.echo) == 0 {
+		return false
+	}
+	reply := t.echo.reply(p)
+	if reply == nil {
+		return false
+	}
+	// Best effort, like the kernel's.
+	t.WritePacket(reply)
+	return true
+}
+
+// reply returns the echo reply to p, nil if p isn't a complete echo
+// request for one of the addresses.
+func (m echoAddrs) reply(p *IPPacket) *IPPacket {
+	if p.Truncated || p.Malformed || !m[p.Header.Dst()] {
+		return nil
+	}
+	if f, err := parseFragment(p); err != nil || f != nil && (f.off != 0 || f.more) {
+		return nil
+	}
+	proto, data, err := p.UpperLayer()
+	if err != nil || len(data) < 8 {
+		return nil
+	}
+	src, dst := p.Header.Src(), p.Header.Dst()
+	if !src.IsValid() || src.IsUnspecified() || src.IsMulticast() {
+		return nil
+	}
+
+	msg := append([]byte(nil), data...)
+	var reply *IPPacket
+	switch {
+	case proto == ProtoICMP && src.Is4() && data[0] == parser.ICMPv4EchoRequest && data[1] == 0:
+		msg[0] = parser.ICMPv4EchoReply
+		reply, err = NewIPv4Packet(dst, src, ProtoICMP, msg)
+	case proto == ProtoICMPv6 && src.Is6() && data[0] == parser.ICMPv6EchoRequest && data[1] == 0:
+		msg[0] = parser.ICMPv6EchoReply
+		reply, err = NewIPv6Packet(dst, src, ProtoICMPv6, msg)
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	if p.Frame != nil {
+		frame := *p.Frame
+		frame.SrcMAC, frame.DstMAC = p.Frame.DstMAC, p.Frame.SrcMAC
+		frame.Payload = nil
+		reply.Frame = &frame
+	}
+	return reply
+}
@@ -0,0 +1,71 @@
+package tuntap
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// Errors matched with errors.Is by the errors of this package, in
+// addition to ErrClosed.
+var (
+	// A packet isn't an IP packet, or not of the expected IP version.
+	ErrNotIP = parser.ErrNotIP
+	// A length field of a packet doesn't match its size.
+	ErrLengthMismatch = parser.ErrLengthMismatch
+	// A packet ends before a header it should contain.
+	ErrTruncated = parser.ErrTruncated
+	// The network interface was removed, or its device detached, while
+	// the Interface was open. No further I/O will succeed.
+	ErrDeviceGone = errors.New("Device is gone")
+)
+
+// Error is the error returned for a failed system call on an
+// Interface. It wraps the system error, and also matches ErrDeviceGone
+// if the error means that the interface was removed.
+type Error struct {
+	// The failed operation, such as "read" or "write".
+	Op string
+	// Name of the interface.
+	Name string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Op + " " + e.Name + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == ErrDeviceGone && deviceGone(e.Err)
+}
+
+// PermissionError is the error returned when opening an interface fails
+// for lack of privileges. It wraps the system error, so it matches
+// os.ErrPermission, and tells what's missing and how to fix it.
+type PermissionError struct {
+	// The failed operation, such as "open" or "TUNSETIFF".
+	Op string
+	// The device node or interface the operation was on.
+	Path string
+	// The capability the operation needs, if any, such as
+	// "CAP_NET_ADMIN".
+	Capability string
+	// Effective user ID of the process, -1 if the system has none.
+	UID int
+	// What's missing and how to fix it.
+	Hint string
+	Err  error
+}
+
+func (e *PermissionError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error() + " (uid " + strconv.Itoa(e.UID) + "): " + e.Hint
+}
+
+func (e *PermissionError) Unwrap() error {
+	return e.Err
+}
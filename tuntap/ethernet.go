@@ -0,0 +1,20 @@
+package tuntap
+
+import (
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+const (
+	ethHeaderLength  = 14
+	dot1QTagLength   = 4
+	etherTypeIPv4    = parser.EtherTypeIPv4
+	etherTypeIPv6    = parser.EtherTypeIPv6
+	etherTypeMinimum = parser.EtherTypeMinimum
+)
+
+// VLANTag is an IEEE 802.1Q tag.
+type VLANTag = parser.VLANTag
+
+// EthernetFrame is an Ethernet II frame as exchanged with a DevTap
+// interface.
+type EthernetFrame = parser.EthernetFrame
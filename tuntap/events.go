@@ -0,0 +1,104 @@
+package tuntap
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// EventType is the kind of an Event.
+type EventType int
+
+const (
+	// The interface was brought up (IFF_UP set).
+	EventLinkUp EventType = iota
+	// The interface was brought down (IFF_UP cleared).
+	EventLinkDown
+	// The MTU of the interface changed.
+	EventMTU
+	// An address was added to the interface.
+	EventAddrAdded
+	// An address was removed from the interface.
+	EventAddrRemoved
+	// The network interface was deleted. It's the last event.
+	EventDeleted
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventLinkUp:
+		return "link up"
+	case EventLinkDown:
+		return "link down"
+	case EventMTU:
+		return "MTU changed"
+	case EventAddrAdded:
+		return "address added"
+	case EventAddrRemoved:
+		return "address removed"
+	case EventDeleted:
+		return "deleted"
+	}
+	return "unknown event"
+}
+
+// An Event is a change of the network interface made by the system or
+// an administrator, see Events.
+type Event struct {
+	Type EventType
+	// The new MTU, EventMTU only.
+	MTU int
+	// The address and its prefix length, EventAddrAdded and
+	// EventAddrRemoved only.
+	Addr netip.Prefix
+}
+
+// eventBuffer is the buffer size of the channels returned by Events.
+const eventBuffer = 16
+
+// watchers stops the goroutines behind Events when the Interface is
+// closed.
+type watchers struct {
+	mu     sync.Mutex
+	closed bool
+	stops  []func()
+}
+
+// add registers stop, or calls it at once if the Interface is closed.
+func (w *watchers) add(stop func()) {
+	w.mu.Lock()
+	if !w.closed {
+		w.stops = append(w.stops, stop)
+		stop = nil
+	}
+	w.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+func (w *watchers) close() {
+	w.mu.Lock()
+	stops := w.stops
+	w.stops = nil
+	w.closed = true
+	w.mu.Unlock()
+	for _, stop := range stops {
+		stop()
+	}
+}
+
+// Events returns a channel delivering the changes of the network
+// interface: link up and down, MTU and address changes, and its
+// deletion, so that a daemon can react when an administrator
+// reconfigures its interface. Each call starts a watcher of its own.
+//
+// The channel is closed after EventDeleted, or once the Interface is
+// closed. Events that happen while the channel is full are held back
+// until the kernel buffer overflows, then lost. Linux only.
+func (t *Interface) Events() (<-chan Event, error) {
+	if atomic.LoadInt32(&t.closed) != 0 {
+		return nil, ErrClosed
+	}
+	return t.watchEvents()
+}
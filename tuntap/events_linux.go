@@ -0,0 +1,207 @@
+package tuntap
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// linkState is what the watcher behind Events knows of the interface,
+// to only report changes.
+type linkState struct {
+	up    bool
+	mtu   int
+	addrs map[netip.Prefix]bool
+}
+
+func (t *Interface) watchEvents() (<-chan Event, error) {
+	index, err := t.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	// Subscribe before reading the current state, so no change is
+	// missed in between.
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK | unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	file := os.NewFile(uintptr(fd), "rtnetlink")
+
+	ifi, err := net.InterfaceByIndex(index)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	state := linkState{up: ifi.Flags&net.FlagUp != 0, mtu: ifi.MTU, addrs: make(map[netip.Prefix]bool)}
+	if addrs, err := ifi.Addrs(); err == nil {
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok {
+				if p, ok := ipNetPrefix(ipn); ok {
+					state.addrs[p] = true
+				}
+			}
+		}
+	}
+
+	ch := make(chan Event, eventBuffer)
+	done := make(chan struct{})
+	t.watch.add(func() {
+		close(done)
+		file.Close()
+	})
+	go watchLink(file, index, state, ch, done)
+	return ch, nil
+}
+
+// ipNetPrefix converts an interface address to a Prefix.
+func ipNetPrefix(ipn *net.IPNet) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(ipn.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones, _ := ipn.Mask.Size()
+	return netip.PrefixFrom(addr.Unmap(), ones), true
+}
+
+// watchLink sends the changes of the interface index reported on the
+// netlink socket file to ch, until done is closed or the interface is
+// deleted.
+func watchLink(file *os.File, index int, state linkState, ch chan<- Event, done <-chan struct{}) {
+	defer close(ch)
+	send := func(e Event) bool {
+		select {
+		case ch <- e:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	rc, err := file.SyscallConn()
+	if err != nil {
+		return
+	}
+	buf := make([]byte, os.Getpagesize()*4)
+	for {
+		var n int
+		var rerr error
+		err := rc.Read(func(fd uintptr) bool {
+			n, _, rerr = syscall.Recvfrom(int(fd), buf, 0)
+			return rerr != syscall.EAGAIN
+		})
+		if err != nil {
+			return
+		}
+		if rerr == syscall.ENOBUFS || rerr == syscall.EINTR {
+			// Events were lost, the next ones still come.
+			continue
+		}
+		if rerr != nil {
+			return
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, m := range msgs {
+			for _, e := range state.update(index, &m) {
+				if !send(e) || e.Type == EventDeleted {
+					return
+				}
+			}
+		}
+	}
+}
+
+// update applies m to the state and returns the events it makes for the
+// interface index.
+func (s *linkState) update(index int, m *syscall.NetlinkMessage) []Event {
+	switch m.Header.Type {
+	case syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+		if len(m.Data) < syscall.SizeofIfInfomsg {
+			return nil
+		}
+		ifi := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+		if int(ifi.Index) != index {
+			return nil
+		}
+		if m.Header.Type == syscall.RTM_DELLINK {
+			return []Event{{Type: EventDeleted}}
+		}
+
+		var events []Event
+		if up := ifi.Flags&syscall.IFF_UP != 0; up != s.up {
+			s.up = up
+			if up {
+				events = append(events, Event{Type: EventLinkUp})
+			} else {
+				events = append(events, Event{Type: EventLinkDown})
+			}
+		}
+		attrs, _ := syscall.ParseNetlinkRouteAttr(m)
+		for _, a := range attrs {
+			if a.Attr.Type == syscall.IFLA_MTU && len(a.Value) >= 4 {
+				if mtu := int(nativeEndian.Uint32(a.Value)); mtu != s.mtu {
+					s.mtu = mtu
+					events = append(events, Event{Type: EventMTU, MTU: mtu})
+				}
+			}
+		}
+		return events
+
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(m.Data) < syscall.SizeofIfAddrmsg {
+			return nil
+		}
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		if int(ifa.Index) != index {
+			return nil
+		}
+		attrs, _ := syscall.ParseNetlinkRouteAttr(m)
+		var addr netip.Addr
+		for _, a := range attrs {
+			// IFA_LOCAL is the address of the interface, IFA_ADDRESS
+			// the peer on point-to-point links; IPv6 only has the
+			// latter.
+			switch a.Attr.Type {
+			case syscall.IFA_LOCAL:
+				addr, _ = netip.AddrFromSlice(a.Value)
+			case syscall.IFA_ADDRESS:
+				if !addr.IsValid() {
+					addr, _ = netip.AddrFromSlice(a.Value)
+				}
+			}
+		}
+		if !addr.IsValid() {
+			return nil
+		}
+		p := netip.PrefixFrom(addr, int(ifa.Prefixlen))
+		if m.Header.Type == syscall.RTM_DELADDR {
+			if !s.addrs[p] {
+				return nil
+			}
+			delete(s.addrs, p)
+			return []Event{{Type: EventAddrRemoved, Addr: p}}
+		}
+		// Updates of an address, like its lifetimes, are not reported.
+		if s.addrs[p] {
+			return nil
+		}
+		s.addrs[p] = true
+		return []Event{{Type: EventAddrAdded, Addr: p}}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package tuntap
+
+import (
+	"errors"
+)
+
+func (t *Interface) watchEvents() (<-chan Event, error) {
+	return nil, errors.New("Interface events are not supported on this platform")
+}
@@ -0,0 +1,145 @@
+package filter
+
+import (
+	"context"
+	"errors"
+
+	"github.com/izqui/tuntap/tuntap"
+)
+
+// ErrRejected is returned by the WritePacket of an attached Interface
+// for the packets rejected by its Filter.
+var ErrRejected = errors.New("Packet rejected by filter")
+
+// Interface is a tuntap Interface whose packets go through a Filter,
+// see Attach. The methods other than those reading and writing
+// IPPackets bypass the filter.
+type Interface struct {
+	*tuntap.Interface
+	filter *Filter
+}
+
+// Attach returns t with the packets read from it going through f in
+// the In direction, and those written to it in the Out direction.
+// Packets read and dropped or rejected are skipped, rejected ones
+// getting their answer written back to t. Packets written and dropped
+// are discarded silently, rejected ones failing with ErrRejected.
+func (f *Filter) Attach(t *tuntap.Interface) *Interface {
+	return &Interface{Interface: t, filter: f}
+}
+
+// Filter returns the filter of t.
+func (t *Interface) Filter() *Filter {
+	return t.filter
+}
+
+// ReadPacket reads the next packet accepted by the filter.
+func (t *Interface) ReadPacket() (*tuntap.IPPacket, error) {
+	for {
+		p, err := t.Interface.ReadPacket()
+		if err != nil || t.accept(p) {
+			return p, err
+		}
+	}
+}
+
+// ReadPacketContext is ReadPacket, until ctx is done.
+func (t *Interface) ReadPacketContext(ctx context.Context) (*tuntap.IPPacket, error) {
+	for {
+		p, err := t.Interface.ReadPacketContext(ctx)
+		if err != nil || t.accept(p) {
+			return p, err
+		}
+	}
+}
+
+// ReadPackets reads up to len(pkts) packets accepted by the filter,
+// like the ReadPackets of tuntap Interfaces.
+func (t *Interface) ReadPackets(pkts []*tuntap.IPPacket) (int, error) {
+	for {
+		n, err := t.Interface.ReadPackets(pkts)
+		if err != nil {
+			return 0, err
+		}
+		count := 0
+		for _, p := range pkts[:n] {
+			if t.accept(p) {
+				pkts[count] = p
+				count++
+			}
+		}
+		if count > 0 {
+			return count, nil
+		}
+	}
+}
+
+// accept applies the filter to p, read from the interface, and tells
+// whether it's accepted. Other packets are released.
+func (t *Interface) accept(p *tuntap.IPPacket) bool {
+	action := t.filter.Check(p, In)
+	if action == Accept {
+		return true
+	}
+	if action == Reject {
+		answer(t.Interface, p)
+	}
+	p.Release()
+	return false
+}
+
+// WritePacket writes packet if accepted by the filter.
+func (t *Interface) WritePacket(packet *tuntap.IPPacket) error {
+	switch t.filter.Check(packet, Out) {
+	case Drop:
+		return nil
+	case Reject:
+		return ErrRejected
+	}
+	return t.Interface.WritePacket(packet)
+}
+
+// WritePacketContext is WritePacket, until ctx is done.
+func (t *Interface) WritePacketContext(ctx context.Context, packet *tuntap.IPPacket) error {
+	switch t.filter.Check(packet, Out) {
+	case Drop:
+		return nil
+	case Reject:
+		return ErrRejected
+	}
+	return t.Interface.WritePacketContext(ctx, packet)
+}
+
+// WritePackets writes pkts in order, like WritePacket. It returns the
+// number of packets handled, written or dropped, and the error that
+// stopped it, if any.
+func (t *Interface) WritePackets(pkts []*tuntap.IPPacket) (int, error) {
+	accepted := make([]*tuntap.IPPacket, 0, len(pkts))
+	// Index in pkts of each accepted packet.
+	index := make([]int, 0, len(pkts))
+	var rejected error
+	for i, p := range pkts {
+		action := t.filter.Check(p, Out)
+		if action == Reject {
+			pkts, rejected = pkts[:i], ErrRejected
+			break
+		}
+		if action == Accept {
+			accepted = append(accepted, p)
+			index = append(index, i)
+		}
+	}
+	n, err := t.Interface.WritePackets(accepted)
+	if err != nil {
+		return index[n], err
+	}
+	return len(pkts), rejected
+}
+
+// answer writes the answer to the rejected packet p to w.
+func answer(w tuntap.PacketWriter, p *tuntap.IPPacket) {
+	// The answer is best effort, like an ICMP error.
+	if resp, err := RejectPacket(p); err == nil && resp != nil {
+		w.WritePacket(resp)
+	}
+}
@@ -0,0 +1,253 @@
+// Package filter enforces simple firewall policies on the packets read
+// from and written to tuntap Interfaces, with ordered rules matching
+// their addresses, protocol, ports and direction:
+//
+//	f, err := filter.New(filter.Drop,
+//		filter.Rule{Direction: filter.In, Proto: tuntap.ProtoTCP, DstPorts: filter.Port(443), Action: filter.Accept},
+//		filter.Rule{Direction: filter.In, Proto: tuntap.ProtoUDP, DstPorts: filter.Port(53), Action: filter.Reject},
+//	)
+//	...
+//	t := f.Attach(iface)
+//	p, err := t.ReadPacket() // Only HTTPS packets.
+//
+// Firewall turns a Filter into a stage of the pipelines served by
+// Interfaces.
+package filter
+
+import (
+	"errors"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/izqui/tuntap/tuntap"
+)
+
+// Direction is the direction of a packet through an Interface.
+type Direction int
+
+const (
+	// Both directions, in rules.
+	Any Direction = iota
+	// Packets read from the interface, sent by the host.
+	In
+	// Packets written to the interface, sent to the host.
+	Out
+)
+
+// Action is what happens to the packets matching a rule.
+type Action int
+
+const (
+	// The packet goes through.
+	Accept Action = iota
+	// The packet is discarded silently.
+	Drop
+	// The packet is discarded, and its sender told so: with a TCP reset
+	// for TCP segments, else an ICMP administratively prohibited error.
+	Reject
+)
+
+var actionNames = []string{"accept", "drop", "reject"}
+
+func (a Action) String() string {
+	if a >= 0 && int(a) < len(actionNames) {
+		return actionNames[a]
+	}
+	return "action " + strconv.Itoa(int(a))
+}
+
+// PortRange is an inclusive range of TCP, UDP or SCTP ports. The zero
+// PortRange matches any port.
+type PortRange struct {
+	Low, High uint16
+}
+
+// Port returns the PortRange of the single port p.
+func Port(p uint16) PortRange {
+	return PortRange{p, p}
+}
+
+func (r PortRange) any() bool {
+	return r.Low == 0 && r.High == 0
+}
+
+func (r PortRange) contains(p uint16) bool {
+	return r.Low <= p && p <= r.High
+}
+
+// Rule matches packets by their header fields, the zero ones matching
+// anything.
+type Rule struct {
+	Direction Direction
+	// Source and destination addresses.
+	Src, Dst netip.Prefix
+	// Upper-layer protocol, after any IPv6 extension headers.
+	Proto tuntap.IPProtocol
+	// Source and destination ports; only TCP, UDP and SCTP packets have
+	// ports. IP fragments other than the first never match ports.
+	SrcPorts, DstPorts PortRange
+	Action             Action
+}
+
+func (r *Rule) validate() error {
+	for _, p := range []netip.Prefix{r.Src, r.Dst} {
+		if p != (netip.Prefix{}) && !p.IsValid() {
+			return errors.New("Invalid rule prefix " + p.String())
+		}
+	}
+	if r.Src.IsValid() && r.Dst.IsValid() && r.Src.Addr().Unmap().Is4() != r.Dst.Addr().Unmap().Is4() {
+		return errors.New("Rule prefixes must be of the same IP version")
+	}
+	for _, pr := range []PortRange{r.SrcPorts, r.DstPorts} {
+		if pr.Low > pr.High {
+			return errors.New("Invalid rule port range " + strconv.Itoa(int(pr.Low)) + "-" + strconv.Itoa(int(pr.High)))
+		}
+	}
+	if !r.SrcPorts.any() || !r.DstPorts.any() {
+		switch r.Proto {
+		case tuntap.ProtoTCP, tuntap.ProtoUDP, tuntap.ProtoSCTP:
+		default:
+			return errors.New("Rules with ports must be for TCP, UDP or SCTP")
+		}
+	}
+	if r.Direction < Any || r.Direction > Out {
+		return errors.New("Invalid rule direction")
+	}
+	if r.Action < Accept || r.Action > Reject {
+		return errors.New("Invalid rule action")
+	}
+	r.Src, r.Dst = unmapPrefix(r.Src), unmapPrefix(r.Dst)
+	return nil
+}
+
+func (r *Rule) match(k *tuntap.FlowKey, dir Direction) bool {
+	if r.Direction != Any && r.Direction != dir {
+		return false
+	}
+	if r.Src.IsValid() && !r.Src.Contains(k.Src) || r.Dst.IsValid() && !r.Dst.Contains(k.Dst) {
+		return false
+	}
+	if r.Proto != 0 && r.Proto != k.Proto {
+		return false
+	}
+	if !r.SrcPorts.any() && (k.SrcPort == 0 || !r.SrcPorts.contains(k.SrcPort)) {
+		return false
+	}
+	if !r.DstPorts.any() && (k.DstPort == 0 || !r.DstPorts.contains(k.DstPort)) {
+		return false
+	}
+	return true
+}
+
+// unmapPrefix turns prefixes of IPv4-mapped IPv6 addresses into IPv4
+// ones, as the addresses of IPv4 packets are.
+func unmapPrefix(p netip.Prefix) netip.Prefix {
+	if !p.IsValid() || !p.Addr().Is4In6() {
+		return p
+	}
+	bits := p.Bits() - 96
+	if bits < 0 {
+		bits = 0
+	}
+	return netip.PrefixFrom(p.Addr().Unmap(), bits).Masked()
+}
+
+// Counters count the packets matching a rule.
+type Counters struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+type counters struct {
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+func (c *counters) add(n int) {
+	c.packets.Add(1)
+	c.bytes.Add(uint64(n))
+}
+
+func (c *counters) get() Counters {
+	return Counters{Packets: c.packets.Load(), Bytes: c.bytes.Load()}
+}
+
+// ruleSet is an immutable set of rules and their counters.
+type ruleSet struct {
+	rules    []Rule
+	counters []counters
+	def      Action
+	defCount counters
+}
+
+// Filter is an ordered list of rules: packets get the action of the
+// first rule they match, or the default action. It's safe for
+// concurrent use.
+type Filter struct {
+	set atomic.Pointer[ruleSet]
+}
+
+// New returns a Filter with the given default action and rules.
+func New(def Action, rules ...Rule) (*Filter, error) {
+	f := &Filter{}
+	if err := f.SetRules(def, rules...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetRules replaces the rules and default action of the filter,
+// resetting the counters.
+func (f *Filter) SetRules(def Action, rules ...Rule) error {
+	if def < Accept || def > Reject {
+		return errors.New("Invalid default action")
+	}
+	set := &ruleSet{rules: make([]Rule, len(rules)), counters: make([]counters, len(rules)), def: def}
+	copy(set.rules, rules)
+	for i := range set.rules {
+		if err := set.rules[i].validate(); err != nil {
+			return errors.New("Rule " + strconv.Itoa(i) + ": " + err.Error())
+		}
+	}
+	f.set.Store(set)
+	return nil
+}
+
+// Rules returns the rules of the filter and its default action.
+func (f *Filter) Rules() ([]Rule, Action) {
+	set := f.set.Load()
+	return append([]Rule(nil), set.rules...), set.def
+}
+
+// Check returns the action for the packet p going in direction dir,
+// counting it. Packets without a 5-tuple, such as malformed ones, only
+// match rules without protocol and ports.
+func (f *Filter) Check(p *tuntap.IPPacket, dir Direction) Action {
+	set := f.set.Load()
+	k, err := p.FlowKey()
+	if err != nil {
+		k = tuntap.FlowKey{Src: p.Header.Src(), Dst: p.Header.Dst()}
+	}
+	size := len(p.Header.Data) + len(p.Payload)
+	for i := range set.rules {
+		if set.rules[i].match(&k, dir) {
+			set.counters[i].add(size)
+			return set.rules[i].Action
+		}
+	}
+	set.defCount.add(size)
+	return set.def
+}
+
+// Counters returns the counters of each rule, in order, followed by
+// the counters of the packets that got the default action.
+func (f *Filter) Counters() []Counters {
+	set := f.set.Load()
+	c := make([]Counters, len(set.rules)+1)
+	for i := range set.rules {
+		c[i] = set.counters[i].get()
+	}
+	c[len(set.rules)] = set.defCount.get()
+	return c
+}
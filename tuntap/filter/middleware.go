@@ -0,0 +1,23 @@
+package filter
+
+import (
+	"github.com/izqui/tuntap/tuntap"
+)
+
+// Firewall returns the tuntap Middleware passing on the packets f
+// accepts, in the In direction, for pipelines served by an Interface.
+// Rejected packets get their answer written to reply, the Interface the
+// packets are read from, unless it's nil.
+func Firewall(f *Filter, reply tuntap.PacketWriter) tuntap.Middleware {
+	return func(p *tuntap.IPPacket, next tuntap.Handler) error {
+		switch f.Check(p, In) {
+		case Accept:
+			return next(p)
+		case Reject:
+			if reply != nil {
+				answer(reply, p)
+			}
+		}
+		return nil
+	}
+}
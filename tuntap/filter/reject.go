@@ -0,0 +1,123 @@
+package filter
+
+import (
+	"errors"
+	"net/netip"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// RejectPacket returns the packet telling the sender of p that it was
+// rejected: a TCP reset for TCP segments, else an ICMP or ICMPv6
+// administratively prohibited error. It returns nil for the packets
+// that must not be answered: resets, ICMP errors, fragments other than
+// the first, and packets from or to multicast and unspecified
+// addresses.
+func RejectPacket(p *tuntap.IPPacket) (*tuntap.IPPacket, error) {
+	proto, data, err := p.UpperLayer()
+	if err != nil {
+		return nil, err
+	}
+	src, dst := p.Header.Src(), p.Header.Dst()
+	if !src.IsValid() || src.IsUnspecified() || src.IsMulticast() || dst.IsMulticast() || src == broadcast || dst == broadcast {
+		return nil, nil
+	}
+	// The upper layer data of later fragments is no header, and errors
+	// about them aren't sent (RFC 1812 section 4.3.2.7).
+	ip := parser.Packet{Version: int(p.Header.Data[0] >> 4), Header: p.Header.Data, Payload: p.Payload}
+	if !ip.FirstFragment() {
+		return nil, nil
+	}
+
+	var resp *tuntap.IPPacket
+	switch {
+	case proto == tuntap.ProtoTCP:
+		payload, err := tcpReset(data)
+		if payload == nil || err != nil {
+			return nil, err
+		}
+		resp, err = newPacket(dst, src, tuntap.ProtoTCP, payload)
+		if err != nil {
+			return nil, err
+		}
+	case src.Is4():
+		if proto == tuntap.ProtoICMP && (len(data) < 1 || !icmpv4Informational(data[0])) {
+			return nil, nil
+		}
+		invoking := append(append([]byte(nil), p.Header.Data...), p.Payload...)
+		msg := parser.NewICMPv4Error(parser.ICMPv4DestUnreachable, parser.ICMPv4AdminProhibited, 0, invoking)
+		resp, err = tuntap.NewIPv4Packet(dst, src, tuntap.ProtoICMP, msg.Marshal())
+		if err != nil {
+			return nil, err
+		}
+	default:
+		if proto == tuntap.ProtoICMPv6 && (len(data) < 1 || data[0] < 128) {
+			return nil, nil
+		}
+		invoking := append(append([]byte(nil), p.Header.Data...), p.Payload...)
+		msg := parser.NewICMPv6Error(parser.ICMPv6DestUnreachable, parser.ICMPv6AdminProhibited, 0, invoking)
+		resp, err = tuntap.NewIPv6Packet(dst, src, tuntap.ProtoICMPv6, msg.Marshal(dst, src))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.Frame != nil {
+		frame := *p.Frame
+		frame.SrcMAC, frame.DstMAC = p.Frame.DstMAC, p.Frame.SrcMAC
+		frame.EtherType = resp.Protocol
+		frame.Payload = nil
+		resp.Frame = &frame
+	}
+	return resp, nil
+}
+
+var broadcast = netip.AddrFrom4([4]byte{255, 255, 255, 255})
+
+// tcpReset returns the TCP reset answering the segment b, without its
+// checksum, or nil if b is itself a reset (RFC 9293 section 3.10.7.1).
+func tcpReset(b []byte) ([]byte, error) {
+	var h parser.TCPHeader
+	if err := h.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	if h.Flags&parser.TCPFlagRST != 0 {
+		return nil, nil
+	}
+	rst := parser.TCPHeader{SrcPort: h.DstPort, DstPort: h.SrcPort, Flags: parser.TCPFlagRST}
+	if h.Flags&parser.TCPFlagACK != 0 {
+		rst.Seq = h.Ack
+	} else {
+		// Acknowledge everything the segment occupies.
+		n := uint32(len(b) - h.DataOffset*4)
+		if h.Flags&parser.TCPFlagSYN != 0 {
+			n++
+		}
+		if h.Flags&parser.TCPFlagFIN != 0 {
+			n++
+		}
+		rst.Ack = h.Seq + n
+		rst.Flags |= parser.TCPFlagACK
+	}
+	return rst.Marshal()
+}
+
+// newPacket builds a packet of the IP version of src, which fills in
+// its checksum.
+func newPacket(src, dst netip.Addr, proto tuntap.IPProtocol, payload []byte) (*tuntap.IPPacket, error) {
+	if src.Is4() {
+		return tuntap.NewIPv4Packet(src, dst, proto, payload)
+	}
+	if src.Is6() {
+		return tuntap.NewIPv6Packet(src, dst, proto, payload)
+	}
+	return nil, errors.New("Invalid address " + src.String())
+}
+
+func icmpv4Informational(typ uint8) bool {
+	switch typ {
+	case parser.ICMPv4DestUnreachable, parser.ICMPv4Redirect, parser.ICMPv4TimeExceeded, parser.ICMPv4ParamProblem:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package tuntap
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+)
+
+var errFilterNotTap = errors.New("Filters are only supported on DevTap interfaces")
+
+// AttachFilter attaches a classic BPF socket filter to a DevTap
+// interface (TUNATTACHFILTER), so the kernel drops the frames it
+// rejects before they're queued for reading. The filter sees whole
+// Ethernet frames. It replaces any filter attached before.
+//
+// The kernel doesn't filter DevTun interfaces, so their filter is run
+// in userspace by the golang.org/x/net/bpf VM instead, on the IP
+// packets read, with the same semantics: the reads skip the packets it
+// rejects and keep waiting for one it accepts.
+//
+// prog is typically built with bpf.Assemble.
+func (t *Interface) AttachFilter(prog []bpf.RawInstruction) error {
+	if t.kind != DevTap {
+		vm, err := newFilterVM(prog)
+		if err != nil {
+			return err
+		}
+		t.setSoftFilter(vm)
+		return nil
+	}
+	if len(prog) == 0 {
+		return errors.New("Empty filter program")
+	}
+	filter := make([]syscall.SockFilter, len(prog))
+	for i, ins := range prog {
+		filter[i] = syscall.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	fprog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	return t.ioctl(syscall.TUNATTACHFILTER, unsafe.Pointer(&fprog))
+}
+
+// DetachFilter removes the filter attached with AttachFilter.
+func (t *Interface) DetachFilter() error {
+	if t.kind != DevTap {
+		t.setSoftFilter(nil)
+		return nil
+	}
+	var fprog syscall.SockFprog
+	return t.ioctl(syscall.TUNDETACHFILTER, unsafe.Pointer(&fprog))
+}
+
+// SetSteeringEBPF attaches the eBPF program progFd
+// (BPF_PROG_TYPE_SOCKET_FILTER) to steer the packets sent through a
+// multiqueue interface (TUNSETSTEERINGEBPF): its return value, modulo
+// the number of queues, picks the queue each packet is delivered to.
+// A program hashing the flow keeps each flow on one queue, and so in
+// order when queues are served by different goroutines.
+//
+// The interface keeps its own reference to the program, progFd can be
+// closed afterwards.
+func (t *Interface) SetSteeringEBPF(progFd int) error {
+	fd := int32(progFd)
+	return t.ioctl(tunSetSteeringEBPF, unsafe.Pointer(&fd))
+}
+
+// ClearSteeringEBPF detaches the steering program, restoring the
+// default flow hash based steering.
+func (t *Interface) ClearSteeringEBPF() error {
+	return t.SetSteeringEBPF(-1)
+}
+
+// tunFltAllMulti is TUN_FLT_ALLMULTI from <linux/if_tun.h>.
+const tunFltAllMulti = 0x0001
+
+// SetTxFilter restricts the frames a DevTap interface delivers for
+// reading to the ones sent to addrs (TUNSETTXFILTER). Frames to other
+// unicast or multicast addresses are dropped in the kernel, and so are
+// broadcasts unless the broadcast address is in addrs. allMulti passes
+// all multicast frames through.
+//
+// An empty addrs removes the filter, so every frame is delivered again.
+func (t *Interface) SetTxFilter(addrs []net.HardwareAddr, allMulti bool) error {
+	if t.kind != DevTap {
+		return errFilterNotTap
+	}
+	// struct tun_filter, followed by the addresses.
+	b := make([]byte, 4, 4+6*len(addrs))
+	if allMulti {
+		*(*uint16)(unsafe.Pointer(&b[0])) = tunFltAllMulti
+	}
+	*(*uint16)(unsafe.Pointer(&b[2])) = uint16(len(addrs))
+	for _, addr := range addrs {
+		if len(addr) != 6 {
+			return errors.New("MAC addresses must be 6 bytes long")
+		}
+		b = append(b, addr...)
+	}
+	return t.ioctl(syscall.TUNSETTXFILTER, unsafe.Pointer(&b[0]))
+}
@@ -0,0 +1,32 @@
+// +build !linux
+
+package tuntap
+
+import (
+	"golang.org/x/net/bpf"
+)
+
+// AttachFilter attaches a classic BPF filter to the interface, dropping
+// the packets it rejects before they're returned by the reads. There's
+// no kernel support for it on this platform, so the filter is run in
+// userspace by the golang.org/x/net/bpf VM, with the same semantics as
+// on Linux: it sees whole Ethernet frames on DevTap interfaces, and IP
+// packets on DevTun ones, a return value shorter than the packet
+// truncates it, and the reads keep waiting while it rejects every
+// packet. It replaces any filter attached before.
+//
+// prog is typically built with bpf.Assemble.
+func (t *Interface) AttachFilter(prog []bpf.RawInstruction) error {
+	vm, err := newFilterVM(prog)
+	if err != nil {
+		return err
+	}
+	t.setSoftFilter(vm)
+	return nil
+}
+
+// DetachFilter removes the filter attached with AttachFilter.
+func (t *Interface) DetachFilter() error {
+	t.setSoftFilter(nil)
+	return nil
+}
@@ -0,0 +1,65 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// FlowKey is the 5-tuple identifying the flow of a packet. It's
+// comparable, so it can be used as a map key.
+type FlowKey struct {
+	Src     netip.Addr
+	Dst     netip.Addr
+	Proto   IPProtocol
+	SrcPort uint16
+	DstPort uint16
+}
+
+// Reverse returns the key of the packets flowing the other way.
+func (k FlowKey) Reverse() FlowKey {
+	return FlowKey{Src: k.Dst, Dst: k.Src, Proto: k.Proto, SrcPort: k.DstPort, DstPort: k.SrcPort}
+}
+
+// FlowLabel returns the IPv6 flow label of the flow, see FlowLabelFor.
+func (k FlowKey) FlowLabel() uint32 {
+	return FlowLabelFor(k.Src, k.Dst, uint8(k.Proto), k.SrcPort, k.DstPort)
+}
+
+// FlowKey returns the 5-tuple of the packet, skipping IPv6 extension
+// headers. Ports are those of TCP, UDP and SCTP; for ICMP and ICMPv6
+// echo messages both hold the echo identifier. They are zero for other
+// protocols and in fragments other than the first.
+func (p *IPPacket) FlowKey() (FlowKey, error) {
+	proto, data, err := p.UpperLayer()
+	if err != nil {
+		return FlowKey{}, err
+	}
+	k := FlowKey{Src: p.Header.Src(), Dst: p.Header.Dst(), Proto: proto}
+
+	ip := parser.Packet{Version: p.Header.version(), Header: p.Header.Data, Payload: p.Payload}
+	if !ip.FirstFragment() {
+		return k, nil
+	}
+	switch proto {
+	case ProtoTCP, ProtoUDP, ProtoSCTP:
+		if len(data) >= 4 {
+			k.SrcPort = binary.BigEndian.Uint16(data[0:2])
+			k.DstPort = binary.BigEndian.Uint16(data[2:4])
+		}
+	case ProtoICMP, ProtoICMPv6:
+		if len(data) >= 6 && isEcho(proto, data[0]) {
+			k.SrcPort = binary.BigEndian.Uint16(data[4:6])
+			k.DstPort = k.SrcPort
+		}
+	}
+	return k, nil
+}
+
+func isEcho(proto IPProtocol, typ uint8) bool {
+	if proto == ProtoICMP {
+		return typ == parser.ICMPv4EchoRequest || typ == parser.ICMPv4EchoReply
+	}
+	return typ == parser.ICMPv6EchoRequest || typ == parser.ICMPv6EchoReply
+}
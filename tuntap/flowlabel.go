@@ -0,0 +1,57 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/maphash"
+	"net/netip"
+)
+
+// Seed of the flow label hash, random per process so that labels can't
+// be predicted from outside (RFC 6437 section 3).
+var flowLabelSeed = maphash.MakeSeed()
+
+// FlowLabel returns the 20-bit flow label of an IPv6 header, 0 for
+// unlabeled packets and IPv4 headers.
+func (h IPHeader) FlowLabel() uint32 {
+	if len(h.Data) < 40 || h.version() != 6 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(h.Data[0:4]) & 0xfffff
+}
+
+// SetFlowLabel sets the flow label of an IPv6 header.
+func (h IPHeader) SetFlowLabel(label uint32) error {
+	if len(h.Data) < 40 || h.version() != 6 {
+		return errors.New("IPv6 headers are required")
+	}
+	if label > 0xfffff {
+		return errors.New("Invalid flow label")
+	}
+	v := binary.BigEndian.Uint32(h.Data[0:4])
+	binary.BigEndian.PutUint32(h.Data[0:4], v&^0xfffff|label)
+	return nil
+}
+
+// FlowLabelFor returns a flow label for the packets of a flow, as
+// RFC 6437 recommends for sources: a hash of the 5-tuple, stable for
+// the lifetime of the process and never 0.
+func FlowLabelFor(src, dst netip.Addr, proto uint8, srcPort, dstPort uint16) uint32 {
+	var h maphash.Hash
+	h.SetSeed(flowLabelSeed)
+	s, d := src.As16(), dst.As16()
+	h.Write(s[:])
+	h.Write(d[:])
+	var b [5]byte
+	b[0] = proto
+	binary.BigEndian.PutUint16(b[1:3], srcPort)
+	binary.BigEndian.PutUint16(b[3:5], dstPort)
+	h.Write(b[:])
+
+	sum := h.Sum64()
+	label := uint32(sum^sum>>20^sum>>40) & 0xfffff
+	if label == 0 {
+		label = 1
+	}
+	return label
+}
@@ -0,0 +1,510 @@
+package tuntap
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// ErrPacketTooBig is returned by Fragment for IPv4 packets larger than
+// the MTU with the don't fragment flag set. The source should be sent
+// an ICMP fragmentation needed message instead.
+var ErrPacketTooBig = errors.New("Packet too big")
+
+// Defaults of ReassemblerOptions.
+const (
+	DefaultReassemblyTimeout = 60 * time.Second
+	DefaultReassemblyMemory  = 4 << 20
+)
+
+const (
+	ipv6FragmentHeaderLength = 8
+	// Largest fragment offset plus length.
+	maxFragmentEnd = 0xffff
+)
+
+// ReassemblerOptions configures a Reassembler.
+type ReassemblerOptions struct {
+	// How long the fragments of a packet are kept waiting for the
+	// others, DefaultReassemblyTimeout if zero.
+	Timeout time.Duration
+	// Largest total size of the fragments kept, DefaultReassemblyMemory
+	// if zero. The oldest incomplete packets are dropped to stay under
+	// it.
+	MaxBytes int
+}
+
+// Reassembler reassembles IPv4 and IPv6 fragments into the packets
+// they were split from. Fragments overlapping each other make the whole
+// packet be dropped (RFC 5722). It's safe for concurrent use.
+type Reassembler struct {
+	timeout  time.Duration
+	maxBytes int
+
+	mu      sync.Mutex
+	pending map[fragmentKey]*fragments
+	// The *fragments of the pending packets in the order their first
+	// fragment arrived, which is also the order they expire in.
+	order *list.List
+	bytes int
+}
+
+// NewReassembler returns a Reassembler configured by opts.
+func NewReassembler(opts ReassemblerOptions) *Reassembler {
+	r := &Reassembler{
+		timeout:  opts.Timeout,
+		maxBytes: opts.MaxBytes,
+		pending:  make(map[fragmentKey]*fragments),
+		order:    list.New(),
+	}
+	if r.timeout <= 0 {
+		r.timeout = DefaultReassemblyTimeout
+	}
+	if r.maxBytes <= 0 {
+		r.maxBytes = DefaultReassemblyMemory
+	}
+	return r
+}
+
+type fragmentKey struct {
+	src   netip.Addr
+	dst   netip.Addr
+	id    uint32
+	proto uint8
+}
+
+// fragment is a fragment decoded by parseFragment.
+type fragment struct {
+	key  fragmentKey
+	off  int
+	more bool
+	data []byte
+	// Header of the reassembled packet: the IPv4 header, or the IPv6
+	// header followed by the extension headers preceding the fragment
+	// header.
+	hdr []byte
+	// IPv6 only, offset in hdr of the next header field pointing to the
+	// fragment header, and the protocol following the fragment header.
+	nextOff int
+	next    uint8
+}
+
+// fragments are the fragments of a packet received so far.
+type fragments struct {
+	key      fragmentKey
+	deadline time.Time
+	// The first fragment, once received.
+	first  *fragment
+	pieces []fragmentPiece
+	// Length of the reassembled data, -1 until the last fragment is
+	// received.
+	total int
+	size  int
+	// The element of the fragments in Reassembler.order.
+	elem *list.Element
+}
+
+type fragmentPiece struct {
+	off  int
+	data []byte
+}
+
+// Add adds a packet to the reassembler. It returns pkt unchanged if it
+// isn't a fragment, the reassembled packet if pkt was the last missing
+// fragment of one, and nil otherwise. Fragments are copied.
+func (r *Reassembler) Add(pkt *IPPacket) (*IPPacket, error) {
+	if pkt.Truncated {
+		return nil, ErrTruncated
+	}
+	f, err := parseFragment(pkt)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return pkt, nil
+	}
+	if f.off == 0 && !f.more {
+		// An atomic fragment, reassembled on its own (RFC 6946).
+		return assemble(pkt, f, f.data)
+	}
+
+	f.data = append([]byte(nil), f.data...)
+	if f.off == 0 {
+		f.hdr = append([]byte(nil), f.hdr...)
+	} else {
+		f.hdr = nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.expire(now)
+
+	fs := r.pending[f.key]
+	if fs == nil {
+		fs = &fragments{key: f.key, deadline: now.Add(r.timeout), total: -1}
+		r.pending[f.key] = fs
+		fs.elem = r.order.PushBack(fs)
+	}
+	added, err := fs.add(f)
+	if err != nil {
+		r.drop(fs)
+		return nil, err
+	}
+	if !added {
+		return nil, nil
+	}
+	n := len(f.data) + len(f.hdr)
+	fs.size += n
+	r.bytes += n
+
+	if fs.complete() {
+		data := make([]byte, 0, fs.total)
+		for _, p := range fs.pieces {
+			data = append(data, p.data...)
+		}
+		first := fs.first
+		r.drop(fs)
+		return assemble(pkt, first, data)
+	}
+	for r.bytes > r.maxBytes && r.order.Len() > 0 {
+		r.drop(r.order.Front().Value.(*fragments))
+	}
+	return nil, nil
+}
+
+// expire drops the packets whose fragments timed out.
+func (r *Reassembler) expire(now time.Time) {
+	for e := r.order.Front(); e != nil; e = r.order.Front() {
+		fs := e.Value.(*fragments)
+		if now.Before(fs.deadline) {
+			return
+		}
+		r.drop(fs)
+	}
+}
+
+// drop removes the packet of fs from the Reassembler, releasing its
+// fragments.
+func (r *Reassembler) drop(fs *fragments) {
+	delete(r.pending, fs.key)
+	r.order.Remove(fs.elem)
+	r.bytes -= fs.size
+	fs.pieces = nil
+	fs.first = nil
+}
+
+// add adds f to the fragments of the packet. It returns false if f is a
+// duplicate.
+func (fs *fragments) add(f *fragment) (bool, error) {
+	end := f.off + len(f.data)
+	if f.more && len(f.data)%8 != 0 {
+		return false, errors.New("Invalid fragment length")
+	}
+	if end > maxFragmentEnd {
+		return false, errors.New("Fragment past the largest packet size")
+	}
+	if !f.more {
+		if fs.total >= 0 && fs.total != end {
+			return false, errors.New("Inconsistent last fragment")
+		}
+		if n := len(fs.pieces); n > 0 {
+			last := fs.pieces[n-1]
+			if last.off+len(last.data) > end {
+				return false, errors.New("Fragment past the last fragment")
+			}
+		}
+	} else if fs.total >= 0 && end > fs.total {
+		return false, errors.New("Fragment past the last fragment")
+	}
+
+	i := 0
+	for ; i < len(fs.pieces); i++ {
+		p := fs.pieces[i]
+		if p.off == f.off && len(p.data) == len(f.data) {
+			return false, nil
+		}
+		if f.off < p.off+len(p.data) && p.off < end {
+			return false, errors.New("Overlapping fragments")
+		}
+		if p.off > f.off {
+			break
+		}
+	}
+	fs.pieces = append(fs.pieces, fragmentPiece{})
+	copy(fs.pieces[i+1:], fs.pieces[i:])
+	fs.pieces[i] = fragmentPiece{off: f.off, data: f.data}
+
+	if !f.more {
+		fs.total = end
+	}
+	if f.off == 0 {
+		fs.first = f
+	}
+	return true, nil
+}
+
+func (fs *fragments) complete() bool {
+	if fs.total < 0 || fs.first == nil {
+		return false
+	}
+	next := 0
+	for _, p := range fs.pieces {
+		if p.off != next {
+			return false
+		}
+		next += len(p.data)
+	}
+	return next == fs.total
+}
+
+// parseFragment decodes pkt as a fragment. It returns nil if pkt isn't
+// one.
+func parseFragment(pkt *IPPacket) (*fragment, error) {
+	h := pkt.Header.Data
+	switch {
+	case len(h) >= 20 && h[0]>>4 == 4:
+		v := binary.BigEndian.Uint16(h[6:8])
+		f := &fragment{
+			off:  int(v&0x1fff) * 8,
+			more: v&0x2000 != 0,
+			data: pkt.Payload,
+			hdr:  h,
+		}
+		if f.off == 0 && !f.more {
+			return nil, nil
+		}
+		f.key = fragmentKey{
+			src:   pkt.Header.Src(),
+			dst:   pkt.Header.Dst(),
+			id:    uint32(binary.BigEndian.Uint16(h[4:6])),
+			proto: h[9],
+		}
+		return f, nil
+
+	case len(h) >= ipHeaderLength && h[0]>>4 == 6:
+		it := parser.NewExtensionHeaders(h[6], pkt.Payload)
+		nextOff, start := 6, 0
+		for it.Next() {
+			eh := it.Header()
+			if eh.Type != parser.IPv6Fragment {
+				nextOff, start = ipHeaderLength+it.Offset()-len(eh.Data), it.Offset()
+				continue
+			}
+			off, more := eh.FragmentOffset()
+			hdr := make([]byte, 0, ipHeaderLength+start)
+			hdr = append(append(hdr, h[:ipHeaderLength]...), pkt.Payload[:start]...)
+			return &fragment{
+				key: fragmentKey{
+					src: pkt.Header.Src(),
+					dst: pkt.Header.Dst(),
+					id:  binary.BigEndian.Uint32(eh.Data[4:8]),
+				},
+				off:     off,
+				more:    more,
+				data:    pkt.Payload[it.Offset():],
+				hdr:     hdr,
+				nextOff: nextOff,
+				next:    eh.NextHeader,
+			}, nil
+		}
+		return nil, it.Err()
+	}
+	return nil, ErrNotIP
+}
+
+// assemble builds the packet reassembled from data, using the header of
+// its first fragment first. pkt is the last fragment received.
+func assemble(pkt *IPPacket, first *fragment, data []byte) (*IPPacket, error) {
+	buf := make([]byte, 0, len(first.hdr)+len(data))
+	buf = append(append(buf, first.hdr...), data...)
+
+	hlen := ipHeaderLength
+	if buf[0]>>4 == 4 {
+		hlen = len(first.hdr)
+		if len(buf) > 0xffff {
+			return nil, errors.New("Reassembled packet too long")
+		}
+		binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+		// Keep the don't fragment flag only.
+		buf[6] &= 0x40
+		buf[7] = 0
+		binary.BigEndian.PutUint16(buf[10:12], parser.IPv4Checksum(buf[:hlen]))
+	} else {
+		if len(buf)-ipHeaderLength > 0xffff {
+			return nil, errors.New("Reassembled packet too long")
+		}
+		binary.BigEndian.PutUint16(buf[4:6], uint16(len(buf)-ipHeaderLength))
+		buf[first.nextOff] = first.next
+	}
+
+	return &IPPacket{
+		Protocol: pkt.Protocol,
+		Header:   IPHeader{Data: buf[:hlen]},
+		Payload:  buf[hlen:],
+		Frame:    pkt.Frame,
+	}, nil
+}
+
+// Fragment splits pkt into fragments of at most mtu bytes, IP header
+// included, to be sent in order. It returns pkt alone if it fits.
+//
+// IPv4 packets with the don't fragment flag set aren't split, Fragment
+// fails with ErrPacketTooBig instead. IPv6 packets get a fragment header
+// after their per-fragment extension headers, and can't be split if
+// they already have one.
+func Fragment(pkt *IPPacket, mtu int) ([]*IPPacket, error) {
+	h := pkt.Header.Data
+	if len(h)+len(pkt.Payload) <= mtu {
+		return []*IPPacket{pkt}, nil
+	}
+	switch {
+	case len(h) >= 20 && h[0]>>4 == 4:
+		return fragmentIPv4(pkt, mtu)
+	case len(h) >= ipHeaderLength && h[0]>>4 == 6:
+		return fragmentIPv6(pkt, mtu)
+	}
+	return nil, ErrNotIP
+}
+
+func fragmentIPv4(pkt *IPPacket, mtu int) ([]*IPPacket, error) {
+	h := pkt.Header.Data
+	v := binary.BigEndian.Uint16(h[6:8])
+	if v&0x4000 != 0 {
+		return nil, ErrPacketTooBig
+	}
+	// A fragment may be fragmented again.
+	base := int(v&0x1fff) * 8
+	more := v&0x2000 != 0
+
+	// Only the options with the copied flag set are repeated in the
+	// fragments after the first.
+	rest := append([]byte(nil), h[:20]...)
+	opts := h[20:]
+	for len(opts) > 0 {
+		n := 1
+		if opts[0] > 1 {
+			if len(opts) < 2 || opts[1] < 2 || int(opts[1]) > len(opts) {
+				return nil, errors.New("Invalid IPv4 options")
+			}
+			n = int(opts[1])
+		}
+		if opts[0]&0x80 != 0 {
+			rest = append(rest, opts[:n]...)
+		}
+		if opts[0] == 0 {
+			break
+		}
+		opts = opts[n:]
+	}
+	for len(rest)%4 != 0 {
+		rest = append(rest, 0)
+	}
+	rest[0] = rest[0]&0xf0 | uint8(len(rest)/4)
+
+	var frags []*IPPacket
+	data := pkt.Payload
+	hdr := h
+	for off := 0; len(data) > 0; hdr = rest {
+		room := (mtu - len(hdr)) &^ 7
+		if room < 8 {
+			return nil, errors.New("MTU too small to fragment")
+		}
+		n := room
+		last := n >= len(data)
+		if last {
+			n = len(data)
+		}
+		buf := make([]byte, len(hdr)+n)
+		copy(buf, hdr)
+		copy(buf[len(hdr):], data[:n])
+		binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+		fv := uint16((base + off) / 8)
+		if !last || more {
+			fv |= 0x2000
+		}
+		binary.BigEndian.PutUint16(buf[6:8], fv)
+		binary.BigEndian.PutUint16(buf[10:12], parser.IPv4Checksum(buf[:len(hdr)]))
+
+		frags = append(frags, &IPPacket{
+			Protocol: pkt.Protocol,
+			Header:   IPHeader{Data: buf[:len(hdr)]},
+			Payload:  buf[len(hdr):],
+			Frame:    pkt.Frame,
+		})
+		data = data[n:]
+		off += n
+	}
+	return frags, nil
+}
+
+func fragmentIPv6(pkt *IPPacket, mtu int) ([]*IPPacket, error) {
+	h := pkt.Header.Data[:ipHeaderLength]
+
+	// The hop-by-hop and routing headers, and the destination options
+	// preceding a routing header, are repeated in each fragment.
+	it := parser.NewExtensionHeaders(h[6], pkt.Payload)
+	nextOff, unfrag := 6, 0
+	for it.Next() {
+		switch it.Header().Type {
+		case parser.IPv6Fragment:
+			return nil, errors.New("Packet is already fragmented")
+		case parser.IPv6HopByHop, parser.IPv6Routing:
+			nextOff, unfrag = ipHeaderLength+it.Offset()-len(it.Header().Data), it.Offset()
+		}
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+	next := h[6]
+	if unfrag > 0 {
+		next = pkt.Payload[nextOff-ipHeaderLength]
+	}
+	hdrLen := ipHeaderLength + unfrag + ipv6FragmentHeaderLength
+	room := (mtu - hdrLen) &^ 7
+	if room < 8 {
+		return nil, errors.New("MTU too small to fragment")
+	}
+	id := rand.Uint32()
+
+	var frags []*IPPacket
+	data := pkt.Payload[unfrag:]
+	for off := 0; len(data) > 0; {
+		n := room
+		last := n >= len(data)
+		if last {
+			n = len(data)
+		}
+		buf := make([]byte, hdrLen+n)
+		copy(buf, h)
+		copy(buf[ipHeaderLength:], pkt.Payload[:unfrag])
+		buf[nextOff] = parser.IPv6Fragment
+		binary.BigEndian.PutUint16(buf[4:6], uint16(len(buf)-ipHeaderLength))
+
+		fh := buf[ipHeaderLength+unfrag : hdrLen]
+		fh[0] = next
+		fv := uint16(off)
+		if !last {
+			fv |= 0x1
+		}
+		binary.BigEndian.PutUint16(fh[2:4], fv)
+		binary.BigEndian.PutUint32(fh[4:8], id)
+		copy(buf[hdrLen:], data[:n])
+
+		frags = append(frags, &IPPacket{
+			Protocol: pkt.Protocol,
+			Header:   IPHeader{Data: buf[:ipHeaderLength]},
+			Payload:  buf[ipHeaderLength:],
+			Frame:    pkt.Frame,
+		})
+		data = data[n:]
+		off += n
+	}
+	return frags, nil
+}
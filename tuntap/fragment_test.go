@@ -0,0 +1,183 @@
+package tuntap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// bigPacket returns a UDP packet carrying size bytes, which may be
+// fragmented, with the given IPv4 identification.
+func bigPacket(t *testing.T, src, dst netip.Addr, size int, id uint16) *IPPacket {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	pkt := udpPacket(t, src, dst, 53, data)
+	if h := pkt.Header.Data; src.Is4() {
+		binary.BigEndian.PutUint16(h[4:6], id)
+		h[6] = 0
+		binary.BigEndian.PutUint16(h[10:12], parser.IPv4Checksum(h))
+	}
+	return pkt
+}
+
+// splitPacket splits pkt into fragments of at most mtu bytes.
+func splitPacket(t *testing.T, pkt *IPPacket, mtu int) []*IPPacket {
+	t.Helper()
+	frags, err := Fragment(pkt, mtu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frags) < 3 {
+		t.Fatalf("split into %d fragments only", len(frags))
+	}
+	for _, f := range frags {
+		if n := len(f.Header.Data) + len(f.Payload); n > mtu {
+			t.Fatalf("%d-byte fragment for an MTU of %d", n, mtu)
+		}
+	}
+	return frags
+}
+
+// addAll adds frags in order to r, and returns the last packet it
+// returned.
+func addAll(t *testing.T, r *Reassembler, frags []*IPPacket) *IPPacket {
+	t.Helper()
+	var out *IPPacket
+	for _, f := range frags {
+		pkt, err := r.Add(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt != nil {
+			if out != nil {
+				t.Fatal("reassembled twice")
+			}
+			out = pkt
+		}
+	}
+	return out
+}
+
+func TestReassemble(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst netip.Addr
+		mtu      int
+		reverse  bool
+	}{
+		{"IPv4", testSrc4, testDst4, 1000, false},
+		{"IPv4 out of order", testSrc4, testDst4, 576, true},
+		{"IPv6", testSrc6, testDst6, 1280, false},
+		{"IPv6 out of order", testSrc6, testDst6, 1280, true},
+	}
+	for _, tt := range tests {
+		want := bigPacket(t, tt.src, tt.dst, 3000, 1)
+		frags := splitPacket(t, want, tt.mtu)
+		if tt.reverse {
+			for i, j := 0, len(frags)-1; i < j; i, j = i+1, j-1 {
+				frags[i], frags[j] = frags[j], frags[i]
+			}
+		}
+		r := NewReassembler(ReassemblerOptions{})
+		got := addAll(t, r, frags)
+		if got == nil {
+			t.Fatalf("%s: not reassembled", tt.name)
+		}
+		if !bytes.Equal(packetBytes(got), packetBytes(want)) {
+			t.Errorf("%s: reassembled %d bytes different from the %d sent", tt.name, len(packetBytes(got)), len(packetBytes(want)))
+		}
+		if r.bytes != 0 || r.order.Len() != 0 || len(r.pending) != 0 {
+			t.Errorf("%s: %d bytes kept in %d packets after reassembly", tt.name, r.bytes, r.order.Len())
+		}
+	}
+}
+
+func TestReassembleNotFragment(t *testing.T) {
+	pkt := udpPacket(t, testSrc4, testDst4, 53, nil)
+	got, err := NewReassembler(ReassemblerOptions{}).Add(pkt)
+	if err != nil || got != pkt {
+		t.Errorf("got %v, %v for a packet that isn't a fragment", got, err)
+	}
+	if _, err := Fragment(udpPacket(t, testSrc4, testDst4, 53, make([]byte, 2000)), 1000); !errors.Is(err, ErrPacketTooBig) {
+		t.Errorf("got error %v fragmenting with the don't fragment flag, want ErrPacketTooBig", err)
+	}
+}
+
+func TestReassembleOverlap(t *testing.T) {
+	pkt := bigPacket(t, testSrc4, testDst4, 3000, 1)
+	large, small := splitPacket(t, pkt, 1000), splitPacket(t, pkt, 600)
+	r := NewReassembler(ReassemblerOptions{})
+	if got, err := r.Add(large[0]); got != nil || err != nil {
+		t.Fatalf("got %v, %v for the first fragment", got, err)
+	}
+	if _, err := r.Add(small[1]); err == nil {
+		t.Fatal("overlapping fragment accepted")
+	}
+	if r.bytes != 0 || r.order.Len() != 0 {
+		t.Errorf("%d bytes kept in %d packets after dropping the packet", r.bytes, r.order.Len())
+	}
+	// The packet was dropped, so its other fragments don't complete it.
+	if got := addAll(t, r, large[1:]); got != nil {
+		t.Error("reassembled a packet with overlapping fragments")
+	}
+}
+
+func TestReassembleMaxBytes(t *testing.T) {
+	r := NewReassembler(ReassemblerOptions{MaxBytes: 2500})
+	var first []*IPPacket
+	for id := uint16(1); id <= 4; id++ {
+		frags := splitPacket(t, bigPacket(t, testSrc4, testDst4, 3000, id), 1000)
+		if id == 1 {
+			first = frags
+		}
+		if got := addAll(t, r, frags[:1]); got != nil {
+			t.Fatal("reassembled from one fragment")
+		}
+		if r.bytes > 2500 {
+			t.Errorf("kept %d bytes, more than MaxBytes", r.bytes)
+		}
+	}
+	if r.order.Len() != 2 || len(r.pending) != 2 {
+		t.Errorf("kept %d packets, want the 2 newest", r.order.Len())
+	}
+	// The oldest packet was dropped.
+	if got := addAll(t, r, first[1:]); got != nil {
+		t.Error("reassembled a dropped packet")
+	}
+}
+
+func TestReassembleCompletedReleased(t *testing.T) {
+	r := NewReassembler(ReassemblerOptions{})
+	old := splitPacket(t, bigPacket(t, testSrc4, testDst4, 3000, 1), 1000)
+	addAll(t, r, old[:1])
+	// Packets completed behind a pending one aren't kept.
+	for id := uint16(2); id < 10; id++ {
+		if got := addAll(t, r, splitPacket(t, bigPacket(t, testSrc4, testDst4, 3000, id), 1000)); got == nil {
+			t.Fatal("not reassembled")
+		}
+	}
+	if r.order.Len() != 1 || r.bytes != len(old[0].Header.Data)+len(old[0].Payload) {
+		t.Errorf("kept %d bytes in %d packets, want the pending one only", r.bytes, r.order.Len())
+	}
+}
+
+func TestReassembleTimeout(t *testing.T) {
+	r := NewReassembler(ReassemblerOptions{Timeout: 10 * time.Millisecond})
+	frags := splitPacket(t, bigPacket(t, testSrc6, testDst6, 3000, 0), 1280)
+	addAll(t, r, frags[:1])
+	time.Sleep(20 * time.Millisecond)
+	if got := addAll(t, r, frags[1:]); got != nil {
+		t.Error("reassembled a packet whose first fragment expired")
+	}
+	if r.order.Len() != 1 {
+		t.Errorf("kept %d packets, want the one started after expiry", r.order.Len())
+	}
+}
@@ -0,0 +1,12 @@
+package tuntap
+
+import (
+	"errors"
+	"syscall"
+)
+
+// deviceGone tells whether err is what I/O on a tun/tap descriptor
+// fails with once its interface is deleted.
+func deviceGone(err error) bool {
+	return errors.Is(err, syscall.EBADFD) || errors.Is(err, syscall.ENODEV)
+}
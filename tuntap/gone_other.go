@@ -0,0 +1,14 @@
+// +build !linux,!windows
+
+package tuntap
+
+import (
+	"errors"
+	"syscall"
+)
+
+// deviceGone tells whether err is what I/O on a tun/tap descriptor
+// fails with once its interface is destroyed.
+func deviceGone(err error) bool {
+	return errors.Is(err, syscall.ENXIO) || errors.Is(err, syscall.ENODEV) || errors.Is(err, syscall.EIO)
+}
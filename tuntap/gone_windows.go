@@ -0,0 +1,16 @@
+package tuntap
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ERROR_DEVICE_NOT_CONNECTED, returned by TAP-Windows6 once its adapter
+// is disabled or removed.
+const errorDeviceNotConnected = syscall.Errno(1167)
+
+// deviceGone tells whether err is what I/O on an adapter fails with once
+// it's removed.
+func deviceGone(err error) bool {
+	return errors.Is(err, errorDeviceNotConnected)
+}
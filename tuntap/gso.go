@@ -0,0 +1,293 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+const (
+	protoTCP = parser.ProtoTCP
+
+	tcpMinHeaderLength = 20
+	tcpFlagFIN         = parser.TCPFlagFIN
+	tcpFlagPSH         = parser.TCPFlagPSH
+	tcpFlagACK         = parser.TCPFlagACK
+
+	// ECN bits of the second byte of an IPv6 header.
+	ipv6ECNMask = 0x30
+
+	// Largest IPv6 payload without a jumbogram option, and IPv4
+	// total length.
+	maxIPv6Payload = 0xffff
+	maxIPv4Length  = 0xffff
+)
+
+// Coalescer is a userspace emulation of GRO and GSO for interfaces
+// without IFF_VNET_HDR. It reads packets in batches and merges
+// consecutive in-order TCP segments of the same flow into one large
+// packet, and splits large TCP packets into MSS-sized segments before
+// writing them. Other packets go through unchanged.
+//
+// Only TCP over IPv4 without options and over IPv6 without extension
+// headers is coalesced. Fragments are neither coalesced nor segmented.
+type Coalescer struct {
+	t     *Interface
+	mss   int
+	batch []*IPPacket
+	queue []*IPPacket
+}
+
+// NewCoalescer wraps t. mss is the largest TCP payload written in a
+// single packet, and batch the number of packets read at once.
+func NewCoalescer(t *Interface, mss, batch int) *Coalescer {
+	if batch < 1 {
+		batch = 1
+	}
+	return &Coalescer{t: t, mss: mss, batch: make([]*IPPacket, batch)}
+}
+
+// ReadPacket returns the next, possibly coalesced, packet.
+func (c *Coalescer) ReadPacket() (*IPPacket, error) {
+	for len(c.queue) == 0 {
+		n, err := c.t.ReadPackets(c.batch)
+		if err != nil {
+			return nil, err
+		}
+		c.queue = coalesce(c.batch[:n])
+	}
+	pkt := c.queue[0]
+	c.queue[0] = nil
+	c.queue = c.queue[1:]
+	return pkt, nil
+}
+
+// WritePacket writes packet, in MSS-sized segments if it's a TCP
+// packet carrying more than the MSS.
+func (c *Coalescer) WritePacket(packet *IPPacket) error {
+	segs, err := segment(packet, c.mss)
+	if err != nil {
+		return err
+	}
+	_, err = c.t.WritePackets(segs)
+	return err
+}
+
+// tcpSegment is a TCP packet broken down for coalescing.
+type tcpSegment struct {
+	pkt *IPPacket
+	tcp []byte
+	seq uint32
+	// TCP payload.
+	data []byte
+}
+
+// parseTCPSegment returns the TCP segment in pkt, or false if pkt isn't
+// a plain TCP over IPv6 packet, or over IPv4 without options nor
+// fragmentation.
+func parseTCPSegment(pkt *IPPacket) (tcpSegment, bool) {
+	h := pkt.Header.Data
+	if pkt.Truncated {
+		return tcpSegment{}, false
+	}
+	switch pkt.Header.version() {
+	case 4:
+		if len(h) != 20 || h[9] != protoTCP || h[6]&0x3f != 0 || h[7] != 0 {
+			return tcpSegment{}, false
+		}
+	case 6:
+		if len(h) < ipHeaderLength || h[6] != protoTCP {
+			return tcpSegment{}, false
+		}
+	default:
+		return tcpSegment{}, false
+	}
+	if len(pkt.Payload) < tcpMinHeaderLength {
+		return tcpSegment{}, false
+	}
+	off := int(pkt.Payload[12]>>4) * 4
+	if off < tcpMinHeaderLength || off > len(pkt.Payload) {
+		return tcpSegment{}, false
+	}
+	return tcpSegment{
+		pkt:  pkt,
+		tcp:  pkt.Payload[:off],
+		seq:  binary.BigEndian.Uint32(pkt.Payload[4:8]),
+		data: pkt.Payload[off:],
+	}, true
+}
+
+// sameIPHeaders tells whether next may follow last in a run started by
+// first, as far as their IP headers go.
+func sameIPHeaders(first, last, next tcpSegment) bool {
+	a, b := first.pkt.Header.Data, next.pkt.Header.Data
+	if len(a) != len(b) || a[0] != b[0] {
+		return false
+	}
+	if len(a) == 20 {
+		// Same TOS, DF flag, TTL and addresses, and an ID following
+		// the last one's, or fixed with DF set.
+		if a[1] != b[1] || a[6] != b[6] || a[8] != b[8] || string(a[12:20]) != string(b[12:20]) {
+			return false
+		}
+		id, lastID := binary.BigEndian.Uint16(b[4:6]), binary.BigEndian.Uint16(last.pkt.Header.Data[4:6])
+		return id == lastID+1 || a[6]&0x40 != 0 && id == lastID
+	}
+	// Same version, traffic class but the ECN bits, flow label, hop
+	// limit and addresses, like Linux ipv6_gro_receive.
+	return a[0] == b[0] && a[1]&^ipv6ECNMask == b[1]&^ipv6ECNMask && a[2] == b[2] && a[3] == b[3] &&
+		a[7] == b[7] && string(a[8:40]) == string(b[8:40])
+}
+
+// canCoalesce tells whether next directly continues the run of
+// segments started by first and ending with last, the way Linux GRO
+// decides it.
+func canCoalesce(first, last, next tcpSegment, size int) bool {
+	if !sameIPHeaders(first, last, next) {
+		return false
+	}
+	// Same ports, ack, window and options.
+	if string(first.tcp[0:4]) != string(next.tcp[0:4]) ||
+		string(first.tcp[8:12]) != string(next.tcp[8:12]) ||
+		string(first.tcp[14:16]) != string(next.tcp[14:16]) ||
+		string(first.tcp[20:]) != string(next.tcp[20:]) {
+		return false
+	}
+	// Only plain ACKs, the last one may push.
+	if first.tcp[13]&^tcpFlagACK != 0 || last.tcp[13]&^tcpFlagACK != 0 || next.tcp[13]&^(tcpFlagACK|tcpFlagPSH) != 0 {
+		return false
+	}
+	// Segments after the first are all of the first's size, except
+	// the last one which may be shorter.
+	if len(first.data) == 0 || len(last.data) != len(first.data) || len(next.data) == 0 || len(next.data) > len(first.data) {
+		return false
+	}
+	if next.seq != last.seq+uint32(len(last.data)) {
+		return false
+	}
+	if first.pkt.Header.version() == 4 {
+		return 20+size+len(next.data) <= maxIPv4Length
+	}
+	return size+len(next.data) <= maxIPv6Payload
+}
+
+// coalesce merges runs of consecutive TCP segments in pkts.
+func coalesce(pkts []*IPPacket) []*IPPacket {
+	out := make([]*IPPacket, 0, len(pkts))
+	var run []tcpSegment
+	size := 0
+
+	flush := func() {
+		if len(run) == 1 {
+			out = append(out, run[0].pkt)
+		} else if len(run) > 1 {
+			out = append(out, mergeSegments(run, size))
+		}
+		run = run[:0]
+		size = 0
+	}
+
+	for _, pkt := range pkts {
+		seg, ok := parseTCPSegment(pkt)
+		if !ok {
+			flush()
+			out = append(out, pkt)
+			continue
+		}
+		if len(run) > 0 && !canCoalesce(run[0], run[len(run)-1], seg, size) {
+			flush()
+		}
+		if len(run) == 0 {
+			size = len(seg.pkt.Payload)
+		} else {
+			size += len(seg.data)
+		}
+		run = append(run, seg)
+	}
+	flush()
+	return out
+}
+
+// mergeSegments builds the packet made of run, whose IP payload is size
+// bytes long, and releases the packets of run.
+func mergeSegments(run []tcpSegment, size int) *IPPacket {
+	first := run[0]
+	hlen := len(first.pkt.Header.Data)
+	buf := make([]byte, hlen+size)
+	copy(buf, first.pkt.Header.Data)
+	n := hlen + copy(buf[hlen:], first.tcp)
+	for _, seg := range run {
+		n += copy(buf[n:], seg.data)
+	}
+	pkt := &IPPacket{
+		Protocol: first.pkt.Protocol,
+		Header:   IPHeader{Data: buf[:hlen]},
+		Payload:  buf[hlen:],
+		Frame:    copyFrameHeader(first.pkt.Frame, buf),
+	}
+	pkt.Payload[13] |= run[len(run)-1].tcp[13] & tcpFlagPSH
+	if ip := pkt.Header.Data; pkt.Header.version() == 4 {
+		binary.BigEndian.PutUint16(ip[2:4], uint16(len(buf)))
+		binary.BigEndian.PutUint16(ip[10:12], parser.IPv4Checksum(ip))
+	} else {
+		binary.BigEndian.PutUint16(ip[4:6], uint16(size))
+		// Keep the congestion experienced mark of any segment.
+		for _, seg := range run {
+			if seg.pkt.Header.ECN() == ECNCE {
+				pkt.Header.SetECN(ECNCE)
+			}
+		}
+	}
+	pkt.setChecksum(ProtoTCP, pkt.Payload)
+
+	for _, seg := range run {
+		seg.pkt.Release()
+	}
+	return pkt
+}
+
+// copyFrameHeader returns a copy of the Ethernet header of f carrying
+// payload, so it outlives the buffer of f when it's released, or nil if
+// f is nil.
+func copyFrameHeader(f *EthernetFrame, payload []byte) *EthernetFrame {
+	if f == nil {
+		return nil
+	}
+	c := &EthernetFrame{
+		DstMAC:    append(net.HardwareAddr(nil), f.DstMAC...),
+		SrcMAC:    append(net.HardwareAddr(nil), f.SrcMAC...),
+		EtherType: f.EtherType,
+		Payload:   payload,
+	}
+	if f.VLAN != nil {
+		vlan := *f.VLAN
+		c.VLAN = &vlan
+	}
+	return c
+}
+
+// segment splits a TCP packet carrying more than mss bytes into
+// segments, or returns packet alone.
+func segment(packet *IPPacket, mss int) ([]*IPPacket, error) {
+	alone := []*IPPacket{packet}
+	if packet.Truncated {
+		return alone, nil
+	}
+	proto, l4, err := packet.UpperLayer()
+	if err != nil || proto != ProtoTCP || len(l4) < tcpMinHeaderLength {
+		return alone, nil
+	}
+	if f, err := parseFragment(packet); err != nil || f != nil {
+		return alone, nil
+	}
+	off := int(l4[12]>>4) * 4
+	if off < tcpMinHeaderLength || off > len(l4) || len(l4)-off <= mss {
+		return alone, nil
+	}
+	if mss <= 0 {
+		return nil, errors.New("MSS must be positive")
+	}
+	return packet.splitPayload(proto, l4, off, mss)
+}
@@ -0,0 +1,178 @@
+package tuntap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// tcpSegments returns n in-order TCP segments of size bytes from src
+// to dst, starting at sequence number seq.
+func tcpSegments(t *testing.T, src, dst netip.Addr, seq uint32, n, size int) []*IPPacket {
+	t.Helper()
+	pkts := make([]*IPPacket, n)
+	for i := range pkts {
+		tcp := make([]byte, tcpMinHeaderLength+size)
+		binary.BigEndian.PutUint16(tcp[0:2], 4000)
+		binary.BigEndian.PutUint16(tcp[2:4], 80)
+		binary.BigEndian.PutUint32(tcp[4:8], seq+uint32(i*size))
+		binary.BigEndian.PutUint32(tcp[8:12], 1)
+		tcp[12] = 5 << 4
+		tcp[13] = tcpFlagACK
+		binary.BigEndian.PutUint16(tcp[14:16], 0xffff)
+		for j := range tcp[tcpMinHeaderLength:] {
+			tcp[tcpMinHeaderLength+j] = byte(i*size + j)
+		}
+		var err error
+		if src.Is4() {
+			pkts[i], err = NewIPv4Packet(src, dst, ProtoTCP, tcp)
+		} else {
+			pkts[i], err = NewIPv6Packet(src, dst, ProtoTCP, tcp)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pkts
+}
+
+// checkTCP checks the checksums and lengths of the TCP packet pkt, and
+// returns its sequence number and payload.
+func checkTCP(t *testing.T, pkt *IPPacket) (uint32, []byte) {
+	t.Helper()
+	ip, err := parser.ParseIP(packetBytes(pkt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.Version == 4 && !parser.VerifyIPv4Checksum(ip.Header) {
+		t.Error("wrong IPv4 header checksum")
+	}
+	src, _ := netip.AddrFromSlice(pkt.Header.SourceAddr())
+	dst, _ := netip.AddrFromSlice(pkt.Header.DestAddr())
+	if !parser.VerifyTCPChecksum(src, dst, ip.Payload) {
+		t.Error("wrong TCP checksum")
+	}
+	return binary.BigEndian.Uint32(ip.Payload[4:8]), ip.Payload[tcpMinHeaderLength:]
+}
+
+func TestCoalescer(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst netip.Addr
+		kind     DevKind
+		pooled   bool
+	}{
+		{"IPv4", testSrc4, testDst4, DevTun, false},
+		{"IPv6", testSrc6, testDst6, DevTun, false},
+		{"pooled IPv6", testSrc6, testDst6, DevTun, true},
+		{"pooled TAP", testSrc4, testDst4, DevTap, true},
+	}
+	for _, tt := range tests {
+		a, b := NewPipe(tt.kind)
+		b.SetPooled(tt.pooled)
+		segs := tcpSegments(t, tt.src, tt.dst, 1000, 4, 100)
+		for _, seg := range segs {
+			if tt.kind == DevTap {
+				withFrame(seg)
+			}
+		}
+		// A packet of another flow ends the run.
+		other := udpPacket(t, tt.src, tt.dst, 53, nil)
+		if tt.kind == DevTap {
+			withFrame(other)
+		}
+		if _, err := a.WritePackets(append(segs, other)); err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewCoalescer(b, 100, 8)
+		merged, err := c.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq, data := checkTCP(t, merged)
+		var want []byte
+		for _, seg := range segs {
+			want = append(want, seg.Payload[tcpMinHeaderLength:]...)
+		}
+		if seq != 1000 || !bytes.Equal(data, want) {
+			t.Errorf("%s: coalesced %d bytes at %d, want %d at 1000", tt.name, len(data), seq, len(want))
+		}
+		if pkt, err := c.ReadPacket(); err != nil || pkt.Header.NextHeader() != ProtoUDP {
+			t.Errorf("%s: read %v after the segments, want the UDP packet", tt.name, err)
+		}
+
+		if tt.kind == DevTap {
+			// The buffers of the segments are reused by this read,
+			// which mustn't change the frame of the merged packet.
+			reply := withFrame(udpPacket(t, tt.dst, tt.src, 53, nil))
+			reply.Frame.SrcMAC, reply.Frame.DstMAC = testDstMAC, testSrcMAC
+			for i := 0; i < 8; i++ {
+				a.WritePacket(reply)
+			}
+			pkts := make([]*IPPacket, 8)
+			if _, err := b.ReadPackets(pkts); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(merged.Frame.SrcMAC, testSrcMAC) || !bytes.Equal(merged.Frame.DstMAC, testDstMAC) {
+				t.Errorf("%s: merged frame changed to %v from %v", tt.name, merged.Frame.DstMAC, merged.Frame.SrcMAC)
+			}
+		}
+
+		// Writing the merged packet splits it back.
+		if err := NewCoalescer(b, 100, 8).WritePacket(merged); err != nil {
+			t.Fatal(err)
+		}
+		split := make([]*IPPacket, 8)
+		n, err := a.ReadPackets(split)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(segs) {
+			t.Fatalf("%s: split into %d segments, want %d", tt.name, n, len(segs))
+		}
+		for i, pkt := range split[:n] {
+			seq, data := checkTCP(t, pkt)
+			if want := segs[i].Payload[tcpMinHeaderLength:]; seq != 1000+uint32(i*100) || !bytes.Equal(data, want) {
+				t.Errorf("%s: segment %d has %d bytes at %d", tt.name, i, len(data), seq)
+			}
+		}
+		a.Close()
+		b.Close()
+	}
+}
+
+func TestCoalesceIPv6Headers(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(h IPHeader)
+		merged bool
+	}{
+		{"same", func(h IPHeader) {}, true},
+		{"hop limit", func(h IPHeader) { h.SetHopLimit(1) }, false},
+		{"flow label", func(h IPHeader) { h.SetFlowLabel(0x12345) }, false},
+		{"DSCP", func(h IPHeader) { h.SetDSCP(46) }, false},
+		{"ECN", func(h IPHeader) { h.SetECN(ECNCE) }, true},
+	}
+	for _, tt := range tests {
+		segs := tcpSegments(t, testSrc6, testDst6, 1000, 3, 100)
+		for _, seg := range segs {
+			seg.Header.SetECN(ECNECT0)
+		}
+		tt.change(segs[2].Header)
+		out := coalesce(segs)
+		if merged := len(out) == 1; merged != tt.merged {
+			t.Errorf("%s: coalesced into %d packets", tt.name, len(out))
+			continue
+		}
+		if tt.merged {
+			checkTCP(t, out[0])
+			if want := segs[2].Header.ECN(); out[0].Header.ECN() != want {
+				t.Errorf("%s: got ECN %d, want %d", tt.name, out[0].Header.ECN(), want)
+			}
+		}
+	}
+}
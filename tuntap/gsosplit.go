@@ -0,0 +1,150 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// SplitGSO returns the packets that a packet read from an interface
+// opened with IFF_VNET_HDR stands for. A GSO super-packet, as received
+// once segmentation offloads are enabled with SetOffloads, is split
+// into segments of VnetHdr.GSOSize bytes of payload, each with its own
+// headers and checksums. Other packets are returned alone, with their
+// checksum completed if VnetHdr asks for it.
+//
+// The returned packets have no VnetHdr, so they can be written to an
+// interface without offloads. The segments don't share the buffer of p.
+func (p *IPPacket) SplitGSO() ([]*IPPacket, error) {
+	h := p.VnetHdr
+	if h == nil {
+		return []*IPPacket{p}, nil
+	}
+	gso := h.GSOType &^ VirtioNetHdrGSOECN
+	if gso == VirtioNetHdrGSONone {
+		if h.Flags&VirtioNetHdrFNeedsCsum != 0 {
+			proto, l4, err := p.UpperLayer()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.setChecksum(proto, l4); err != nil {
+				return nil, err
+			}
+		}
+		p.VnetHdr = nil
+		return []*IPPacket{p}, nil
+	}
+
+	proto, l4, err := p.UpperLayer()
+	if err != nil {
+		return nil, err
+	}
+	version := p.Header.version()
+	var l4HdrLen int
+	switch {
+	case gso == VirtioNetHdrGSOTCPv4 && version == 4 && proto == ProtoTCP,
+		gso == VirtioNetHdrGSOTCPv6 && version == 6 && proto == ProtoTCP:
+		if len(l4) < tcpMinHeaderLength {
+			return nil, errors.New("TCP header too short")
+		}
+		l4HdrLen = int(l4[12]>>4) * 4
+	case gso == VirtioNetHdrGSOUDPL4 && proto == ProtoUDP:
+		l4HdrLen = udpHeaderLength
+	default:
+		return nil, errors.New("Unsupported GSO type")
+	}
+	if l4HdrLen > len(l4) {
+		return nil, errors.New("Transport header too short")
+	}
+	mss := int(h.GSOSize)
+	if mss == 0 {
+		return nil, errors.New("GSO packet without a segment size")
+	}
+
+	return p.splitPayload(proto, l4, l4HdrLen, mss)
+}
+
+// splitPayload splits p, carrying the TCP or UDP message l4 whose header
+// is l4HdrLen bytes long, into segments of at most mss bytes of payload.
+// Each segment gets copies of the headers, with its lengths, IPv4 ID,
+// TCP sequence number and flags, and checksums set.
+func (p *IPPacket) splitPayload(proto IPProtocol, l4 []byte, l4HdrLen, mss int) ([]*IPPacket, error) {
+	// The IP header, the IPv6 extension headers, and the transport
+	// header are repeated in each segment.
+	version := p.Header.version()
+	ipHdrLen := len(p.Header.Data)
+	extLen := len(p.Payload) - len(l4)
+	hdrLen := ipHdrLen + extLen + l4HdrLen
+	data := l4[l4HdrLen:]
+	var seq uint32
+	var id uint16
+	if proto == ProtoTCP {
+		seq = binary.BigEndian.Uint32(l4[4:8])
+	}
+	if version == 4 {
+		id = binary.BigEndian.Uint16(p.Header.Data[4:6])
+	}
+
+	var segs []*IPPacket
+	for i, off := 0, 0; off < len(data); i, off = i+1, off+mss {
+		end := off + mss
+		if end > len(data) {
+			end = len(data)
+		}
+		buf := make([]byte, hdrLen+end-off)
+		copy(buf, p.Header.Data)
+		copy(buf[ipHdrLen:], p.Payload[:extLen+l4HdrLen])
+		copy(buf[hdrLen:], data[off:end])
+
+		seg := &IPPacket{
+			Protocol: p.Protocol,
+			Header:   IPHeader{Data: buf[:ipHdrLen]},
+			Payload:  buf[ipHdrLen:],
+			Frame:    p.Frame,
+		}
+		ip := seg.Header.Data
+		if version == 4 {
+			binary.BigEndian.PutUint16(ip[2:4], uint16(len(buf)))
+			binary.BigEndian.PutUint16(ip[4:6], id+uint16(i))
+			binary.BigEndian.PutUint16(ip[10:12], parser.IPv4Checksum(ip))
+		} else {
+			binary.BigEndian.PutUint16(ip[4:6], uint16(len(seg.Payload)))
+		}
+
+		segL4 := seg.Payload[extLen:]
+		if proto == ProtoTCP {
+			binary.BigEndian.PutUint32(segL4[4:8], seq+uint32(off))
+			if end < len(data) {
+				// FIN and PSH only belong on the last segment.
+				segL4[13] &^= tcpFlagFIN | tcpFlagPSH
+			}
+			if i > 0 {
+				// Congestion window reduced is only signaled once.
+				segL4[13] &^= parser.TCPFlagCWR
+			}
+		} else {
+			binary.BigEndian.PutUint16(segL4[4:6], uint16(len(segL4)))
+		}
+		if err := seg.setChecksum(proto, segL4); err != nil {
+			return nil, err
+		}
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
+
+// setChecksum computes the checksum of the TCP or UDP message l4 carried
+// by the packet.
+func (p *IPPacket) setChecksum(proto IPProtocol, l4 []byte) error {
+	src, dst := p.Header.Src(), p.Header.Dst()
+	switch {
+	case proto == ProtoTCP && len(l4) >= tcpMinHeaderLength:
+		binary.BigEndian.PutUint16(l4[16:18], parser.TCPChecksum(src, dst, l4))
+	case proto == ProtoUDP && len(l4) >= udpHeaderLength:
+		binary.BigEndian.PutUint16(l4[6:8], parser.UDPChecksum(src, dst, l4))
+	default:
+		return errors.New("Checksum offload of " + proto.String() + " not supported")
+	}
+	return nil
+}
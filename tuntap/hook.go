@@ -0,0 +1,71 @@
+package tuntap
+
+// Verdict is the decision of a PacketHook about a packet.
+type Verdict struct {
+	drop bool
+	// The replacement of the packet, if any.
+	packet *IPPacket
+}
+
+var (
+	// Accept lets the packet through unchanged, or changed in place.
+	Accept = Verdict{}
+	// Drop discards the packet.
+	Drop = Verdict{drop: true}
+)
+
+// Modified returns the Verdict replacing the packet with p.
+func Modified(p *IPPacket) Verdict {
+	return Verdict{packet: p}
+}
+
+// A PacketHook is called with each IPPacket read from (write false) or
+// written to an Interface, and decides what happens to it. Dropped
+// packets are skipped by reads and silently discarded by writes.
+type PacketHook func(p *IPPacket, write bool) Verdict
+
+// SetHook installs h on all queues of the interface, replacing the
+// previous hook; nil removes it. h sees the packets read by ReadPacket,
+// ReadPacketInto, ReadPackets and Packets, and those written by
+// WritePacket, WritePackets and Out, but not the I/O through Raw,
+// ReadFrom, WriteTo, ReadFrame and WriteFrame, nor the packets that
+// fail to parse.
+//
+// h is called by the goroutines doing the I/O, so it must be safe for
+// concurrent use. The buffers of the packets it drops may be reused
+// once it returns. In pooled mode, the buffer of a packet read moves to
+// its replacement, and is released with it.
+func (t *Interface) SetHook(h PacketHook) {
+	queues := t.queues
+	if queues == nil {
+		queues = []*Interface{t}
+	}
+	for _, q := range queues {
+		q.hook.Store(h)
+	}
+}
+
+// hooked answers p if it's an echo request for the echo responder,
+// else runs the hook, if any, on it, and returns the packet to go on
+// with, nil if answered or dropped. Packets read and dropped by the
+// hook are counted in RxDropped.
+func (t *Interface) hooked(p *IPPacket, write bool) *IPPacket {
+	if !write && t.answerEcho(p) {
+		return nil
+	}
+	h, _ := t.hook.Load().(PacketHook)
+	if h == nil {
+		return p
+	}
+	v := h(p, write)
+	switch {
+	case v.drop:
+		if !write {
+			t.stats.rxDropped.Add(1)
+		}
+		return nil
+	case v.packet != nil:
+		return v.packet
+	}
+	return p
+}
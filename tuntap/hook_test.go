@@ -0,0 +1,91 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// dropPort returns the PacketHook dropping the UDP packets to port.
+func dropPort(port uint16) PacketHook {
+	return func(p *IPPacket, write bool) Verdict {
+		if proto, l4, err := p.UpperLayer(); err == nil && proto == ProtoUDP && binary.BigEndian.Uint16(l4[2:4]) == port {
+			return Drop
+		}
+		return Accept
+	}
+}
+
+func TestHookDropsWholeBatch(t *testing.T) {
+	a, b := NewPipe(DevTun)
+	defer a.Close()
+	defer b.Close()
+	b.SetHook(dropPort(9))
+
+	// The first read gets the two dropped packets only, and must read
+	// again rather than return none.
+	pkts := []*IPPacket{
+		udpPacket(t, testSrc4, testDst4, 9, nil),
+		udpPacket(t, testSrc6, testDst6, 9, nil),
+		udpPacket(t, testSrc4, testDst4, 53, nil),
+	}
+	if _, err := a.WritePackets(pkts); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]*IPPacket, 2)
+	n, err := b.ReadPackets(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("read %d packets, want 1", n)
+	}
+	if _, l4, _ := got[0].UpperLayer(); binary.BigEndian.Uint16(l4[2:4]) != 53 {
+		t.Errorf("read the packet to port %d", binary.BigEndian.Uint16(l4[2:4]))
+	}
+	if s := b.Stats(); s.RxDropped != 2 {
+		t.Errorf("counted %d packets dropped, want 2", s.RxDropped)
+	}
+}
+
+func TestHookDropsCoalescerBatch(t *testing.T) {
+	a, b := NewPipe(DevTun)
+	defer a.Close()
+	defer b.Close()
+	b.SetHook(dropPort(9))
+
+	for _, port := range []uint16{9, 9, 9, 53} {
+		if err := a.WritePacket(udpPacket(t, testSrc4, testDst4, port, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := NewCoalescer(b, 1400, 3)
+	pkt, err := c.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, l4, _ := pkt.UpperLayer(); binary.BigEndian.Uint16(l4[2:4]) != 53 {
+		t.Errorf("read the packet to port %d", binary.BigEndian.Uint16(l4[2:4]))
+	}
+}
+
+func TestHookDropsWrites(t *testing.T) {
+	a, b := NewPipe(DevTun)
+	defer a.Close()
+	defer b.Close()
+	a.SetHook(dropPort(9))
+
+	pkts := []*IPPacket{
+		udpPacket(t, testSrc4, testDst4, 9, nil),
+		udpPacket(t, testSrc4, testDst4, 53, nil),
+	}
+	if n, err := a.WritePackets(pkts); n != 2 || err != nil {
+		t.Fatalf("WritePackets: %d, %v", n, err)
+	}
+	pkt, err := b.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, l4, _ := pkt.UpperLayer(); binary.BigEndian.Uint16(l4[2:4]) != 53 {
+		t.Errorf("read the packet to port %d", binary.BigEndian.Uint16(l4[2:4]))
+	}
+}
@@ -0,0 +1,143 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// ErrHopLimitExceeded is returned by Decrement when the packet must not
+// be forwarded any further.
+var ErrHopLimitExceeded = errors.New("Hop limit exceeded")
+
+// hopLimitOffset returns the offset of the IPv6 hop limit or IPv4 TTL.
+func (h IPHeader) hopLimitOffset() (int, error) {
+	switch {
+	case len(h.Data) >= 40 && h.version() == 6:
+		return 7, nil
+	case len(h.Data) >= 20 && h.version() == 4:
+		return 8, nil
+	}
+	return 0, ErrNotIP
+}
+
+// HopLimit returns the IPv6 hop limit, or the TTL of an IPv4 header.
+func (h IPHeader) HopLimit() int {
+	off, err := h.hopLimitOffset()
+	if err != nil {
+		return 0
+	}
+	return int(h.Data[off])
+}
+
+// SetHopLimit sets the IPv6 hop limit, or the TTL of an IPv4 header,
+// updating the IPv4 header checksum.
+func (h IPHeader) SetHopLimit(n uint8) error {
+	off, err := h.hopLimitOffset()
+	if err != nil {
+		return err
+	}
+	if h.version() == 4 {
+		h.setIPv4Byte(off, n)
+		return nil
+	}
+	h.Data[off] = n
+	return nil
+}
+
+// setIPv4Byte sets byte i of an IPv4 header, updating the header
+// checksum.
+func (h IPHeader) setIPv4Byte(i int, v byte) {
+	w := i &^ 1
+	old := [2]byte{h.Data[w], h.Data[w+1]}
+	h.Data[i] = v
+	csum := binary.BigEndian.Uint16(h.Data[10:12])
+	csum = parser.ChecksumUpdate(csum, old[:], h.Data[w:w+2])
+	binary.BigEndian.PutUint16(h.Data[10:12], csum)
+}
+
+// Decrement decrements the hop limit like a router forwarding the
+// packet. It returns ErrHopLimitExceeded if the hop limit reaches zero,
+// in which case the packet must be dropped, usually with the
+// TimeExceeded message sent back to its source.
+func (h IPHeader) Decrement() error {
+	off, err := h.hopLimitOffset()
+	if err != nil {
+		return err
+	}
+	n := h.Data[off]
+	if n == 0 {
+		return ErrHopLimitExceeded
+	}
+	if err := h.SetHopLimit(n - 1); err != nil {
+		return err
+	}
+	if n == 1 {
+		return ErrHopLimitExceeded
+	}
+	return nil
+}
+
+// TimeExceeded returns the ICMP or ICMPv6 time exceeded message telling
+// the source of p that it was dropped for exceeding its hop limit, sent
+// from the address from of the router, which traceroute shows as the
+// hop. It returns nil if from isn't of the IP version of p, and for the
+// packets that must not be answered: ICMP errors, non-initial IPv4
+// fragments, and packets from unspecified addresses or from or to
+// multicast ones.
+func TimeExceeded(p *IPPacket, from netip.Addr) (*IPPacket, error) {
+	from = from.Unmap()
+	if !from.IsValid() || from.IsUnspecified() || from.IsMulticast() {
+		return nil, errors.New("Invalid source address " + from.String())
+	}
+	if p.Header.version() == 4 {
+		return icmpError(p, from, parser.ICMPv4TimeExceeded, parser.ICMPv4TTLExceeded, 0, false)
+	}
+	return icmpError(p, from, parser.ICMPv6TimeExceeded, parser.ICMPv6HopLimitExceeded, 0, false)
+}
+
+// HopLimiter returns the Middleware decrementing the hop limit of the
+// packets passed on, like a router forwarding them. Those exceeding it
+// are dropped and answered with TimeExceeded, the messages written to w
+// from the first of addrs of their IP version, or not at all if none.
+func HopLimiter(w PacketWriter, addrs ...netip.Addr) Middleware {
+	from4, from6 := routerAddrs(addrs)
+	return func(p *IPPacket, next Handler) error {
+		err := p.Header.Decrement()
+		if err == nil {
+			return next(p)
+		}
+		if err != ErrHopLimitExceeded {
+			return nil
+		}
+		from := from6
+		if p.Header.version() == 4 {
+			from = from4
+		}
+		if !from.IsValid() {
+			return nil
+		}
+		resp, err := TimeExceeded(p, from)
+		if err != nil || resp == nil {
+			return nil
+		}
+		return w.WritePacket(resp)
+	}
+}
+
+// routerAddrs returns the first IPv4 and IPv6 addresses of addrs, if
+// any, the sources of the ICMP errors of the Middlewares.
+func routerAddrs(addrs []netip.Addr) (from4, from6 netip.Addr) {
+	for _, a := range addrs {
+		a = a.Unmap()
+		switch {
+		case a.Is4() && !from4.IsValid():
+			from4 = a
+		case a.Is6() && !from6.IsValid():
+			from6 = a
+		}
+	}
+	return from4, from6
+}
@@ -0,0 +1,20 @@
+package tuntap
+
+import (
+	"errors"
+	"net"
+)
+
+var errNotTap = errors.New("Hardware addresses are only supported on DevTap interfaces")
+
+// HardwareAddr returns the MAC address of a DevTap interface.
+func (t *Interface) HardwareAddr() (net.HardwareAddr, error) {
+	if t.kind != DevTap {
+		return nil, errNotTap
+	}
+	ifi, err := net.InterfaceByName(t.Name())
+	if err != nil {
+		return nil, err
+	}
+	return ifi.HardwareAddr, nil
+}
@@ -0,0 +1,16 @@
+// +build !linux
+
+package tuntap
+
+import (
+	"errors"
+	"net"
+)
+
+// SetHardwareAddr sets the MAC address of a DevTap interface.
+func (t *Interface) SetHardwareAddr(mac net.HardwareAddr) error {
+	if t.kind != DevTap {
+		return errNotTap
+	}
+	return errors.New("Setting the hardware address is not supported on this platform")
+}
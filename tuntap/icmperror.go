@@ -0,0 +1,78 @@
+package tuntap
+
+import (
+	"net/netip"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+var broadcastAddr = netip.AddrFrom4([4]byte{255, 255, 255, 255})
+
+// icmpError returns the ICMP error, or ICMPv6 one for IPv6 packets, of
+// the given type about p sent from the address from, the destination of
+// p if invalid. It returns nil for the packets that must not be
+// answered: ICMP errors, non-initial IPv4 fragments, and packets from
+// unspecified addresses or from or to multicast ones (RFC 1812 section
+// 4.3.2.7 and RFC 4443 section 2.4). With multicastDst, packets to
+// multicast addresses are answered too, if from is valid since their
+// destination can't be the source of the error.
+func icmpError(p *IPPacket, from netip.Addr, typ, code uint8, param uint32, multicastDst bool) (*IPPacket, error) {
+	src, dst := p.Header.Src(), p.Header.Dst()
+	if !src.IsValid() {
+		return nil, ErrNotIP
+	}
+	if src.IsUnspecified() || src.IsMulticast() || src == broadcastAddr || dst == broadcastAddr {
+		return nil, nil
+	}
+	if dst.IsMulticast() && (!multicastDst || !from.IsValid()) {
+		return nil, nil
+	}
+	if !from.IsValid() {
+		from = dst
+	}
+	if from.Is4() != src.Is4() {
+		return nil, nil
+	}
+	h := p.Header.Data
+	if src.Is4() && (h[6]&0x1f != 0 || h[7] != 0) {
+		return nil, nil
+	}
+	proto, data, err := p.UpperLayer()
+	if err != nil {
+		return nil, err
+	}
+	invoking := append(append([]byte(nil), h...), p.Payload...)
+
+	var resp *IPPacket
+	if src.Is4() {
+		if proto == ProtoICMP && (len(data) < 1 || icmpv4Error(data[0])) {
+			return nil, nil
+		}
+		msg := parser.NewICMPv4Error(typ, code, param, invoking)
+		resp, err = NewIPv4Packet(from, src, ProtoICMP, msg.Marshal())
+	} else {
+		if proto == ProtoICMPv6 && (len(data) < 1 || data[0] < 128) {
+			return nil, nil
+		}
+		msg := parser.NewICMPv6Error(typ, code, param, invoking)
+		resp, err = NewIPv6Packet(from, src, ProtoICMPv6, msg.Marshal(from, src))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Frame != nil {
+		frame := *p.Frame
+		frame.SrcMAC, frame.DstMAC = p.Frame.DstMAC, p.Frame.SrcMAC
+		frame.Payload = nil
+		resp.Frame = &frame
+	}
+	return resp, nil
+}
+
+func icmpv4Error(typ uint8) bool {
+	switch typ {
+	case parser.ICMPv4DestUnreachable, parser.ICMPv4Redirect, parser.ICMPv4TimeExceeded, parser.ICMPv4ParamProblem:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package tuntap
+
+import (
+	"errors"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// ICMPv4Message is a decoded ICMPv4 message, see parser.ICMPv4Message.
+type ICMPv4Message = parser.ICMPv4Message
+
+// ICMPv4 decodes the ICMPv4 message carried by the packet. It fails if
+// the upper-layer protocol isn't ICMP.
+func (p *IPPacket) ICMPv4() (*ICMPv4Message, error) {
+	proto, data, err := p.UpperLayer()
+	if err != nil {
+		return nil, err
+	}
+	if proto != parser.ProtoICMP || p.Header.version() != 4 {
+		return nil, errors.New("Not an ICMPv4 packet")
+	}
+	m := new(ICMPv4Message)
+	if err := m.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,27 @@
+package tuntap
+
+import (
+	"errors"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// ICMPv6Message is a decoded ICMPv6 message, see parser.ICMPv6Message.
+type ICMPv6Message = parser.ICMPv6Message
+
+// ICMPv6 decodes the ICMPv6 message carried by the packet. It fails if
+// the upper-layer protocol isn't ICMPv6.
+func (p *IPPacket) ICMPv6() (*ICMPv6Message, error) {
+	proto, data, err := p.UpperLayer()
+	if err != nil {
+		return nil, err
+	}
+	if proto != parser.ProtoICMPv6 || p.Header.version() != 6 {
+		return nil, errors.New("Not an ICMPv6 packet")
+	}
+	m := new(ICMPv6Message)
+	if err := m.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,39 @@
+package tuntap
+
+import (
+	"errors"
+	"net"
+)
+
+// Index returns the index of the network interface, which identifies
+// it in routes and addresses even if it's renamed.
+func (t *Interface) Index() (int, error) {
+	ifi, err := net.InterfaceByName(t.Name())
+	if err != nil {
+		return 0, err
+	}
+	return ifi.Index, nil
+}
+
+// OpenIndex is like Open, but attaches to the existing interface with
+// the given index. It fails if the interface is renamed or replaced
+// while it's being opened, so the Interface is known to be the one the
+// index refers to.
+func OpenIndex(index int, kind DevKind, meta bool) (*Interface, error) {
+	ifi, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	t, err := Open(ifi.Name, kind, meta)
+	if err != nil {
+		return nil, err
+	}
+	if got, err := t.Index(); err != nil || got != index {
+		t.Close()
+		if err == nil {
+			err = errors.New("Interface " + ifi.Name + " changed while opening it")
+		}
+		return nil, err
+	}
+	return t, nil
+}
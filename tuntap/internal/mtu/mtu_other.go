@@ -0,0 +1,22 @@
+// +build !linux,!darwin,!freebsd,!openbsd
+
+// Package mtu gets and sets the MTU of network interfaces, for package
+// tuntap and its subpackages.
+package mtu
+
+import (
+	"errors"
+	"net"
+)
+
+func Get(name string) (int, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, err
+	}
+	return ifi.MTU, nil
+}
+
+func Set(name string, mtu int) error {
+	return errors.New("Setting the MTU is not supported on this platform")
+}
@@ -0,0 +1,48 @@
+// +build linux darwin freebsd openbsd
+
+// Package mtu gets and sets the MTU of network interfaces, for package
+// tuntap and its subpackages.
+package mtu
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// ifreqMTU is struct ifreq with the ifr_mtu member, padded to the
+// largest ifreq size.
+type ifreqMTU struct {
+	Name [syscall.IFNAMSIZ]byte
+	MTU  int32
+	_    [20]byte
+}
+
+// Get queries the MTU of the interface name with SIOCGIFMTU.
+func Get(name string) (int, error) {
+	var req ifreqMTU
+	if err := mtuIoctl(name, syscall.SIOCGIFMTU, &req); err != nil {
+		return 0, err
+	}
+	return int(req.MTU), nil
+}
+
+// Set sets the MTU of the interface name with SIOCSIFMTU.
+func Set(name string, mtu int) error {
+	req := ifreqMTU{MTU: int32(mtu)}
+	return mtuIoctl(name, syscall.SIOCSIFMTU, &req)
+}
+
+func mtuIoctl(name string, op uintptr, req *ifreqMTU) error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	copy(req.Name[:syscall.IFNAMSIZ-1], name)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), op, uintptr(unsafe.Pointer(req)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
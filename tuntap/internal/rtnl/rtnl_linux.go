@@ -0,0 +1,91 @@
+// Package rtnl builds and sends route netlink requests, for package
+// tuntap and its subpackages.
+package rtnl
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Message is the body of a route netlink message being built.
+type Message []byte
+
+// IfInfo appends a struct ifinfomsg.
+func (b *Message) IfInfo(index int, flags, change uint32) {
+	ifi := unix.IfInfomsg{
+		Family: unix.AF_UNSPEC,
+		Index:  int32(index),
+		Flags:  flags,
+		Change: change,
+	}
+	*b = append(*b, (*[unix.SizeofIfInfomsg]byte)(unsafe.Pointer(&ifi))[:]...)
+}
+
+// Attr appends an attribute, padded to the netlink alignment.
+func (b *Message) Attr(typ uint16, data []byte) {
+	a := unix.RtAttr{Len: uint16(unix.SizeofRtAttr + len(data)), Type: typ}
+	*b = append(*b, (*[unix.SizeofRtAttr]byte)(unsafe.Pointer(&a))[:]...)
+	*b = append(*b, data...)
+	for len(*b)%unix.RTA_ALIGNTO != 0 {
+		*b = append(*b, 0)
+	}
+}
+
+// Uint32 returns v in host byte order, as attribute data.
+func Uint32(v uint32) []byte {
+	return (*[4]byte)(unsafe.Pointer(&v))[:]
+}
+
+// Request sends a route netlink request and waits for the kernel to
+// acknowledge it.
+func Request(typ uint16, flags uint16, body Message) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	sa := &unix.SockaddrNetlink{Family: unix.AF_NETLINK}
+	if err := unix.Bind(fd, sa); err != nil {
+		return err
+	}
+
+	const seq = 1
+	hdr := unix.NlMsghdr{
+		Len:   uint32(unix.SizeofNlMsghdr + len(body)),
+		Type:  typ,
+		Flags: unix.NLM_F_REQUEST | unix.NLM_F_ACK | flags,
+		Seq:   seq,
+	}
+	b := append((*[unix.SizeofNlMsghdr]byte)(unsafe.Pointer(&hdr))[:], body...)
+	if err := unix.Sendto(fd, b, 0, sa); err != nil {
+		return err
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		for p := buf[:n]; len(p) >= unix.SizeofNlMsghdr; {
+			h := (*unix.NlMsghdr)(unsafe.Pointer(&p[0]))
+			if h.Len < unix.SizeofNlMsghdr || int(h.Len) > len(p) {
+				break
+			}
+			if h.Seq == seq && h.Type == unix.NLMSG_ERROR && h.Len >= unix.SizeofNlMsghdr+4 {
+				errno := *(*int32)(unsafe.Pointer(&p[unix.SizeofNlMsghdr]))
+				if errno != 0 {
+					return unix.Errno(-errno)
+				}
+				return nil
+			}
+			l := (int(h.Len) + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+			if l > len(p) {
+				break
+			}
+			p = p[l:]
+		}
+	}
+}
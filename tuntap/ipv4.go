@@ -0,0 +1,15 @@
+package tuntap
+
+import (
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// IPv4Header is a decoded IPv4 header, see parser.IPv4Header.
+type IPv4Header = parser.IPv4Header
+
+// IPv4 decodes h as an IPv4 header. It fails if the packet isn't IPv4.
+func (h IPHeader) IPv4() (IPv4Header, error) {
+	var v4 IPv4Header
+	err := v4.Unmarshal(h.Data)
+	return v4, err
+}
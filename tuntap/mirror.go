@@ -0,0 +1,82 @@
+package tuntap
+
+import (
+	"sync/atomic"
+)
+
+// MirrorDirection selects the packets duplicated by Mirror.
+type MirrorDirection int
+
+const (
+	// Packets read and written.
+	MirrorBoth MirrorDirection = iota
+	// Packets read from the interface only.
+	MirrorReads
+	// Packets written to the interface only.
+	MirrorWrites
+)
+
+// MirrorOptions configure Mirror.
+type MirrorOptions struct {
+	Direction MirrorDirection
+	// Mirror one packet every Sample packets. 0 and 1 mirror them all.
+	Sample int
+}
+
+// Mirror is a running packet mirror, see Interface.Mirror.
+type Mirror struct {
+	ch      chan<- *IPPacket
+	dir     MirrorDirection
+	sample  uint64
+	seen    uint64
+	dropped uint64
+	remove  func()
+}
+
+// Mirror sends a copy of the packets read from or written to the
+// interface to ch, until Stop is called. The copies are independent of
+// the packets handed to the callers of ReadPacket and WritePacket.
+//
+// Mirroring never blocks the I/O on the interface: when ch is full, the
+// copy is dropped, and counted in Dropped. Packets that don't parse are
+// not mirrored, and neither is I/O through Raw.
+func (t *Interface) Mirror(ch chan<- *IPPacket, opts MirrorOptions) *Mirror {
+	m := &Mirror{ch: ch, dir: opts.Direction, sample: 1}
+	if opts.Sample > 1 {
+		m.sample = uint64(opts.Sample)
+	}
+	m.remove = t.addObserver(func(write bool, data []byte) {
+		m.packet(t, write, data)
+	})
+	return m
+}
+
+func (m *Mirror) packet(t *Interface, write bool, data []byte) {
+	if write && m.dir == MirrorReads || !write && m.dir == MirrorWrites {
+		return
+	}
+	if (atomic.AddUint64(&m.seen, 1)-1)%m.sample != 0 {
+		return
+	}
+	pkt, err := t.decodePacket(append([]byte(nil), data...), rawInfo{})
+	if err != nil {
+		return
+	}
+	select {
+	case m.ch <- pkt:
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+// Dropped returns the number of copies dropped because the channel was
+// full.
+func (m *Mirror) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
+// Stop stops mirroring. The channel is not closed, since a packet may
+// still be in the middle of being sent to it.
+func (m *Mirror) Stop() {
+	m.remove()
+}
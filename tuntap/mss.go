@@ -0,0 +1,85 @@
+package tuntap
+
+import (
+	"encoding/binary"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// ClampMSS lowers the MSS option of the packet, if it's a TCP SYN
+// advertising one too large for segments to fit in mtu bytes, so that
+// the connection doesn't rely on path MTU discovery through a tunnel of
+// that MTU. The packet is changed in place, its TCP checksum updated
+// incrementally, and ClampMSS tells whether it was changed.
+func (p *IPPacket) ClampMSS(mtu int) (bool, error) {
+	if len(p.Header.Data) == 0 {
+		return false, ErrNotIP
+	}
+	ip := parser.Packet{Version: p.Header.version(), Header: p.Header.Data, Payload: p.Payload}
+	if !ip.FirstFragment() {
+		return false, nil
+	}
+	proto, data, err := ip.UpperLayer()
+	if err != nil || proto != parser.ProtoTCP {
+		return false, err
+	}
+	var h parser.TCPHeader
+	if err := h.Unmarshal(data); err != nil {
+		return false, err
+	}
+	if h.Flags&parser.TCPFlagSYN == 0 {
+		return false, nil
+	}
+	// The MSS leaves out the fixed IP and TCP headers only (RFC 6691).
+	max := mtu - 20 - tcpMinHeaderLength
+	if ip.Version == 6 {
+		max = mtu - ipHeaderLength - tcpMinHeaderLength
+	}
+	if max < 0 {
+		max = 0
+	}
+
+	changed := false
+	b := h.Options
+	for len(b) > 0 && b[0] != parser.TCPOptionEnd {
+		if b[0] == parser.TCPOptionNop {
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || b[1] < 2 || int(b[1]) > len(b) {
+			return false, nil
+		}
+		if b[0] == parser.TCPOptionMSS && b[1] == 4 && int(binary.BigEndian.Uint16(b[2:4])) > max {
+			old := [2]byte{b[2], b[3]}
+			binary.BigEndian.PutUint16(b[2:4], uint16(max))
+			// With checksum offload, the checksum field only holds the
+			// pseudo-header sum until the kernel completes it.
+			if p.VnetHdr == nil || p.VnetHdr.Flags&VirtioNetHdrFNeedsCsum == 0 {
+				// Offset of the MSS value in the TCP header, whose
+				// options b runs to the end of.
+				off := h.DataOffset*4 - len(b) + 2
+				csum := binary.BigEndian.Uint16(data[16:18])
+				if off%2 == 0 {
+					csum = parser.ChecksumUpdate(csum, old[:], b[2:4])
+				} else {
+					// Straddling two 16-bit words.
+					csum = parser.ChecksumUpdate(csum, []byte{0, old[0], old[1], 0}, []byte{0, b[2], b[3], 0})
+				}
+				binary.BigEndian.PutUint16(data[16:18], csum)
+			}
+			changed = true
+		}
+		b = b[b[1]:]
+	}
+	return changed, nil
+}
+
+// MSSClamp returns the Middleware clamping the MSS option of the TCP
+// SYNs passed on to fit in mtu bytes, see ClampMSS. It's typically used
+// in both directions of a tunnel of that MTU.
+func MSSClamp(mtu int) Middleware {
+	return func(p *IPPacket, next Handler) error {
+		p.ClampMSS(mtu)
+		return next(p)
+	}
+}
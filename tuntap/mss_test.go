@@ -0,0 +1,93 @@
+package tuntap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// synPacket returns a TCP SYN with the given options and payload.
+func synPacket(t *testing.T, src, dst netip.Addr, options, data []byte) *IPPacket {
+	t.Helper()
+	tcp := make([]byte, tcpMinHeaderLength, tcpMinHeaderLength+len(options)+len(data))
+	binary.BigEndian.PutUint16(tcp[0:2], 4000)
+	binary.BigEndian.PutUint16(tcp[2:4], 443)
+	tcp[12] = uint8((tcpMinHeaderLength+len(options))/4) << 4
+	tcp[13] = parser.TCPFlagSYN
+	tcp = append(append(tcp, options...), data...)
+	var pkt *IPPacket
+	var err error
+	if src.Is4() {
+		pkt, err = NewIPv4Packet(src, dst, ProtoTCP, tcp)
+	} else {
+		pkt, err = NewIPv6Packet(src, dst, ProtoTCP, tcp)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+func TestClampMSS(t *testing.T) {
+	mss := []byte{parser.TCPOptionMSS, 4, 0x05, 0xb4}
+	nop := []byte{parser.TCPOptionNop}
+	cat := func(bs ...[]byte) []byte { return bytes.Join(bs, nil) }
+
+	tests := []struct {
+		name     string
+		src, dst netip.Addr
+		options  []byte
+		data     []byte
+		mtu      int
+		want     uint16
+		changed  bool
+	}{
+		{"IPv4", testSrc4, testDst4, mss, nil, 1400, 1360, true},
+		{"IPv6", testSrc6, testDst6, mss, nil, 1400, 1340, true},
+		{"small enough", testSrc4, testDst4, mss, nil, 1500, 1460, false},
+		{"odd offset", testSrc4, testDst4, cat(nop, mss, nop, nop, nop), nil, 1400, 1360, true},
+		// TCP Fast Open data after the options.
+		{"even offset, odd payload", testSrc4, testDst4, mss, []byte{1, 2, 3}, 1400, 1360, true},
+		{"odd offset, odd payload", testSrc6, testDst6, cat(nop, mss, nop, nop, nop), []byte{1, 2, 3}, 1280, 1220, true},
+	}
+	for _, tt := range tests {
+		pkt := synPacket(t, tt.src, tt.dst, tt.options, tt.data)
+		changed, err := pkt.ClampMSS(tt.mtu)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if changed != tt.changed {
+			t.Errorf("%s: changed %v, want %v", tt.name, changed, tt.changed)
+		}
+		var h parser.TCPHeader
+		if err := h.Unmarshal(pkt.Payload); err != nil {
+			t.Fatal(err)
+		}
+		opts, err := h.ParseOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range opts {
+			if o.Kind == parser.TCPOptionMSS {
+				if got := binary.BigEndian.Uint16(o.Data); got != tt.want {
+					t.Errorf("%s: got MSS %d, want %d", tt.name, got, tt.want)
+				}
+			}
+		}
+		if !parser.VerifyTCPChecksum(tt.src, tt.dst, pkt.Payload) {
+			t.Errorf("%s: wrong TCP checksum after clamping", tt.name)
+		}
+	}
+}
+
+func TestClampMSSEmpty(t *testing.T) {
+	for _, p := range []*IPPacket{{}, {Payload: []byte{0x45}}} {
+		if changed, err := p.ClampMSS(1400); changed || !errors.Is(err, ErrNotIP) {
+			t.Errorf("got %v, %v for a packet without header, want ErrNotIP", changed, err)
+		}
+	}
+}
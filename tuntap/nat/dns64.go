@@ -0,0 +1,60 @@
+package nat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// DNS64 resolves names for IPv6-only clients, synthesizing IPv6
+// addresses out of the IPv4 ones of the names without IPv6 addresses
+// (RFC 6147), for a NAT64 with the same prefix. Its LookupNetIP can
+// stand in for that of a net.Resolver in the DNS server or proxy
+// answering the clients.
+type DNS64 struct {
+	// Prefix of the NAT64, WellKnownPrefix if unset.
+	Prefix netip.Prefix
+	// Resolver looks up the names, net.DefaultResolver if nil.
+	Resolver *net.Resolver
+}
+
+// LookupNetIP looks up host. For the "ip" and "ip6" networks, it returns
+// its IPv6 addresses, or the synthesized ones if it has only IPv4
+// addresses. The "ip4" network is looked up as is.
+func (d *DNS64) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	r := d.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	switch network {
+	case "ip", "ip6":
+	case "ip4":
+		return r.LookupNetIP(ctx, network, host)
+	default:
+		return nil, errors.New("Unknown network " + network)
+	}
+
+	addrs, err := r.LookupNetIP(ctx, "ip6", host)
+	// A name with only IPv4 addresses has no suitable address.
+	var addrErr *net.AddrError
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil && len(addrs) > 0:
+		return addrs, nil
+	case err != nil && !errors.As(err, &addrErr) && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound):
+		return nil, err
+	}
+	addrs, err = r.LookupNetIP(ctx, "ip4", host)
+	if err != nil {
+		return nil, err
+	}
+	prefix := d.Prefix
+	if !prefix.IsValid() {
+		prefix = WellKnownPrefix
+	}
+	for i, a := range addrs {
+		addrs[i] = netip.AddrFrom16(embed(prefix.Masked(), a.Unmap().As4()))
+	}
+	return addrs, nil
+}
@@ -0,0 +1,524 @@
+package nat
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// Default NAT44Options.
+const (
+	// RFC 5382 REQ-5.
+	DefaultTCPTimeout           = 2*time.Hour + 4*time.Minute
+	DefaultTCPTransitoryTimeout = 4 * time.Minute
+	// RFC 4787 REQ-5 recommends at least 5 minutes.
+	DefaultUDPTimeout = 5 * time.Minute
+	// RFC 5508 REQ-1.
+	DefaultICMPTimeout = time.Minute
+	DefaultMinPort     = 1024
+	DefaultMaxPort     = 65535
+)
+
+// How often expired bindings are looked for.
+const sweepInterval = time.Second
+
+// An Action tells what to do with a packet once translated.
+type Action int
+
+const (
+	// Drop the packet, which can't be translated.
+	Drop Action = iota
+	// Forward the packet on its way: upstream for Outbound, to the
+	// interface for Inbound.
+	Forward
+	// Hairpin the packet of Outbound, from an internal host to the
+	// external address and port of another, back to the interface: both
+	// its addresses were translated.
+	Hairpin
+)
+
+// NAT44Options configure a NAT44. Zero values stand for the defaults.
+type NAT44Options struct {
+	// Range of the external ports and ICMP echo identifiers allocated.
+	MinPort, MaxPort uint16
+	// Time a binding lasts without traffic: TCP connections once
+	// established, TCP connections being opened or closed, UDP flows
+	// and ICMP echo exchanges.
+	TCPTimeout           time.Duration
+	TCPTransitoryTimeout time.Duration
+	UDPTimeout           time.Duration
+	ICMPTimeout          time.Duration
+}
+
+// NAT44 is a source NAT sharing an external IPv4 address between the
+// hosts of an internal network, rewriting their TCP and UDP ports and
+// ICMP echo identifiers (RFC 4787, RFC 5382 and RFC 5508). An internal
+// endpoint keeps its external port for all destinations, which may all
+// reach it through the port while the binding lasts. Fragmented packets
+// are dropped: reassemble them first. It's safe for concurrent use.
+type NAT44 struct {
+	external [4]byte
+	opts     NAT44Options
+
+	mu        sync.Mutex
+	internal  map[natEndpoint]*binding
+	ports     map[natEndpoint]*binding
+	next      uint16
+	lastSweep time.Time
+}
+
+// natEndpoint is a transport endpoint: a TCP or UDP address and port,
+// or an address and ICMP echo identifier.
+type natEndpoint struct {
+	proto uint8
+	addr  [4]byte
+	port  uint16
+}
+
+type binding struct {
+	internal natEndpoint
+	// The external port or identifier.
+	port    uint16
+	expires time.Time
+	// TCP connection state.
+	synIn, synOut bool
+	finIn, finOut bool
+	reset         bool
+}
+
+// NewNAT44 returns a NAT44 translating to the external address.
+func NewNAT44(external netip.Addr, opts NAT44Options) (*NAT44, error) {
+	if !external.Unmap().Is4() {
+		return nil, errors.New("NAT44 needs an IPv4 address")
+	}
+	if opts.MinPort == 0 {
+		opts.MinPort = DefaultMinPort
+	}
+	if opts.MaxPort == 0 {
+		opts.MaxPort = DefaultMaxPort
+	}
+	if opts.MinPort > opts.MaxPort {
+		return nil, errors.New("Invalid NAT44 port range")
+	}
+	if opts.TCPTimeout <= 0 {
+		opts.TCPTimeout = DefaultTCPTimeout
+	}
+	if opts.TCPTransitoryTimeout <= 0 {
+		opts.TCPTransitoryTimeout = DefaultTCPTransitoryTimeout
+	}
+	if opts.UDPTimeout <= 0 {
+		opts.UDPTimeout = DefaultUDPTimeout
+	}
+	if opts.ICMPTimeout <= 0 {
+		opts.ICMPTimeout = DefaultICMPTimeout
+	}
+	return &NAT44{
+		external: external.Unmap().As4(),
+		opts:     opts,
+		internal: make(map[natEndpoint]*binding),
+		ports:    make(map[natEndpoint]*binding),
+		next:     opts.MinPort,
+	}, nil
+}
+
+// Len returns the number of bindings.
+func (n *NAT44) Len() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sweep(time.Now(), true)
+	return len(n.internal)
+}
+
+// Outbound translates p, a packet from the internal network, whose
+// source becomes the external address. IPv6 packets are forwarded
+// unchanged.
+func (n *NAT44) Outbound(p *tuntap.IPPacket) Action {
+	ip, ok := ipv4Packet(p)
+	if !ok {
+		return Forward
+	}
+	if ip == nil {
+		return Drop
+	}
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sweep(now, false)
+
+	hdr, l4 := ip.Header, ip.Payload
+	proto := hdr[9]
+	src := [4]byte(hdr[12:16])
+	dst := [4]byte(hdr[16:20])
+	switch proto {
+	case parser.ProtoTCP, parser.ProtoUDP:
+		if len(l4) < 8 {
+			return Drop
+		}
+		b := n.bind(natEndpoint{proto, src, binary.BigEndian.Uint16(l4[0:2])}, now)
+		if b == nil {
+			return Drop
+		}
+		b.track(l4, false, now, &n.opts)
+		if dst == n.external {
+			// Hairpinning (RFC 4787 REQ-9): from the external endpoint
+			// of b to the internal one of the destination.
+			to := n.lookup(natEndpoint{proto: proto, port: binary.BigEndian.Uint16(l4[2:4])}, now)
+			if to == nil {
+				return Drop
+			}
+			rewriteAddr(ip, 16, to.internal.addr)
+			rewritePort(proto, l4, 2, to.internal.port)
+			rewriteAddr(ip, 12, n.external)
+			rewritePort(proto, l4, 0, b.port)
+			return Hairpin
+		}
+		rewriteAddr(ip, 12, n.external)
+		rewritePort(proto, l4, 0, b.port)
+		return Forward
+
+	case parser.ProtoICMP:
+		if len(l4) < 8 {
+			return Drop
+		}
+		switch l4[0] {
+		case parser.ICMPv4EchoRequest:
+			b := n.bind(natEndpoint{proto, src, binary.BigEndian.Uint16(l4[4:6])}, now)
+			if b == nil || dst == n.external {
+				return Drop
+			}
+			b.expires = now.Add(n.opts.ICMPTimeout)
+			rewriteAddr(ip, 12, n.external)
+			rewritePort(proto, l4, 4, b.port)
+			return Forward
+		case parser.ICMPv4DestUnreachable, parser.ICMPv4TimeExceeded, parser.ICMPv4ParamProblem:
+			// An error about a packet received through the NAT, to
+			// the internal endpoint quoted as the destination.
+			q, ok := quoted(l4)
+			if !ok {
+				return Drop
+			}
+			b := n.internal[natEndpoint{q.proto, q.dst(), q.port(2)}]
+			if b == nil || now.After(b.expires) {
+				return Drop
+			}
+			q.rewrite(16, n.external, 2, b.port)
+			rewriteAddr(ip, 12, n.external)
+			binary.BigEndian.PutUint16(l4[2:4], parser.ICMPv4Checksum(l4))
+			return Forward
+		}
+	}
+	return Drop
+}
+
+// Inbound translates p, a packet to the external address, whose
+// destination becomes the internal endpoint it's for. Other packets,
+// including IPv6 ones, are forwarded unchanged.
+func (n *NAT44) Inbound(p *tuntap.IPPacket) Action {
+	ip, ok := ipv4Packet(p)
+	if !ok {
+		return Forward
+	}
+	if ip == nil {
+		return Drop
+	}
+	hdr, l4 := ip.Header, ip.Payload
+	if [4]byte(hdr[16:20]) != n.external {
+		return Forward
+	}
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sweep(now, false)
+
+	proto := hdr[9]
+	switch proto {
+	case parser.ProtoTCP, parser.ProtoUDP:
+		if len(l4) < 8 {
+			return Drop
+		}
+		b := n.lookup(natEndpoint{proto: proto, port: binary.BigEndian.Uint16(l4[2:4])}, now)
+		if b == nil {
+			return Drop
+		}
+		b.track(l4, true, now, &n.opts)
+		rewriteAddr(ip, 16, b.internal.addr)
+		rewritePort(proto, l4, 2, b.internal.port)
+		return Forward
+
+	case parser.ProtoICMP:
+		if len(l4) < 8 {
+			return Drop
+		}
+		switch l4[0] {
+		case parser.ICMPv4EchoReply:
+			b := n.lookup(natEndpoint{proto: proto, port: binary.BigEndian.Uint16(l4[4:6])}, now)
+			if b == nil {
+				return Drop
+			}
+			rewriteAddr(ip, 16, b.internal.addr)
+			rewritePort(proto, l4, 4, b.internal.port)
+			return Forward
+		case parser.ICMPv4DestUnreachable, parser.ICMPv4TimeExceeded, parser.ICMPv4ParamProblem:
+			// An error about a packet sent through the NAT, from the
+			// external endpoint quoted as the source.
+			q, ok := quoted(l4)
+			if !ok || q.src() != n.external {
+				return Drop
+			}
+			b := n.lookup(natEndpoint{proto: q.proto, port: q.port(0)}, now)
+			if b == nil {
+				return Drop
+			}
+			q.rewrite(12, b.internal.addr, 0, b.internal.port)
+			rewriteAddr(ip, 16, b.internal.addr)
+			binary.BigEndian.PutUint16(l4[2:4], parser.ICMPv4Checksum(l4))
+			return Forward
+		}
+	}
+	return Drop
+}
+
+// ipv4Packet returns the IPv4 packet of p, false if it's not one, and
+// nil if it can't be translated.
+func ipv4Packet(p *tuntap.IPPacket) (*parser.Packet, bool) {
+	hdr := p.Header.Data
+	if len(hdr) < 20 || hdr[0]>>4 != 4 {
+		return nil, false
+	}
+	ip := &parser.Packet{Version: 4, Header: hdr, Payload: p.Payload}
+	if binary.BigEndian.Uint16(hdr[6:8])&0x3fff != 0 {
+		// A fragment.
+		return nil, true
+	}
+	return ip, true
+}
+
+// bind returns the binding of the internal endpoint, allocating one if
+// needed, or nil if the ports are exhausted.
+func (n *NAT44) bind(in natEndpoint, now time.Time) *binding {
+	if b := n.internal[in]; b != nil && !now.After(b.expires) {
+		return b
+	} else if b != nil {
+		n.remove(b)
+	}
+	port, ok := n.allocate(in.proto, in.port, now)
+	if !ok {
+		return nil
+	}
+	b := &binding{internal: in, port: port}
+	n.internal[in] = b
+	n.ports[natEndpoint{proto: in.proto, port: port}] = b
+	return b
+}
+
+// allocate returns a free external port, preferably want.
+func (n *NAT44) allocate(proto uint8, want uint16, now time.Time) (uint16, bool) {
+	free := func(port uint16) bool {
+		b := n.ports[natEndpoint{proto: proto, port: port}]
+		if b != nil && now.After(b.expires) {
+			n.remove(b)
+			b = nil
+		}
+		return b == nil
+	}
+	// Port preservation, as far as possible.
+	if want >= n.opts.MinPort && want <= n.opts.MaxPort && free(want) {
+		return want, true
+	}
+	size := int(n.opts.MaxPort-n.opts.MinPort) + 1
+	for i := 0; i < size; i++ {
+		port := n.next
+		if n.next == n.opts.MaxPort {
+			n.next = n.opts.MinPort
+		} else {
+			n.next++
+		}
+		if free(port) {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// lookup returns the live binding of the external endpoint, whose addr
+// is unset.
+func (n *NAT44) lookup(ext natEndpoint, now time.Time) *binding {
+	b := n.ports[ext]
+	if b == nil || now.After(b.expires) {
+		return nil
+	}
+	return b
+}
+
+func (n *NAT44) remove(b *binding) {
+	delete(n.internal, b.internal)
+	delete(n.ports, natEndpoint{proto: b.internal.proto, port: b.port})
+}
+
+// sweep removes the expired bindings, at most every sweepInterval
+// unless forced.
+func (n *NAT44) sweep(now time.Time, force bool) {
+	if !force && now.Sub(n.lastSweep) < sweepInterval {
+		return
+	}
+	n.lastSweep = now
+	for _, b := range n.internal {
+		if now.After(b.expires) {
+			n.remove(b)
+		}
+	}
+}
+
+// track follows the TCP connection or UDP flow of b through the segment
+// or datagram l4, and extends b accordingly.
+func (b *binding) track(l4 []byte, inbound bool, now time.Time, opts *NAT44Options) {
+	if b.internal.proto == parser.ProtoUDP {
+		b.expires = now.Add(opts.UDPTimeout)
+		return
+	}
+	if len(l4) >= 14 {
+		flags := l4[13]
+		switch {
+		case flags&parser.TCPFlagRST != 0:
+			b.reset = true
+		case flags&parser.TCPFlagSYN != 0:
+			// A new connection from the same endpoint.
+			if inbound {
+				b.synIn = true
+			} else {
+				b.synOut, b.finIn, b.finOut, b.reset = true, false, false, false
+			}
+		}
+		if flags&parser.TCPFlagFIN != 0 {
+			if inbound {
+				b.finIn = true
+			} else {
+				b.finOut = true
+			}
+		}
+	}
+	if b.synIn && b.synOut && !b.reset && !(b.finIn && b.finOut) {
+		b.expires = now.Add(opts.TCPTimeout)
+	} else {
+		b.expires = now.Add(opts.TCPTransitoryTimeout)
+	}
+}
+
+// rewriteAddr replaces the IPv4 address at off in the header of ip,
+// source or destination, and updates the checksums covering it.
+func rewriteAddr(ip *parser.Packet, off int, addr [4]byte) {
+	if off == 12 {
+		ip.RewriteAddrs(addr[:], nil)
+	} else {
+		ip.RewriteAddrs(nil, addr[:])
+	}
+}
+
+// rewritePort replaces the port or ICMP identifier at off in the
+// segment, datagram or message l4, and updates its checksum.
+func rewritePort(proto uint8, l4 []byte, off int, port uint16) {
+	var next [2]byte
+	binary.BigEndian.PutUint16(next[:], port)
+	updateChecksum(proto, l4, l4[off:off+2], next[:])
+	copy(l4[off:off+2], next[:])
+}
+
+// updateChecksum updates the checksum of the segment, datagram or
+// message l4, if it contains it, for old becoming new.
+func updateChecksum(proto uint8, l4, old, new []byte) {
+	var off int
+	switch proto {
+	case parser.ProtoTCP:
+		off = 16
+	case parser.ProtoUDP:
+		off = 6
+	case parser.ProtoICMP:
+		off = 2
+	default:
+		return
+	}
+	if len(l4) < off+2 {
+		return
+	}
+	csum := binary.BigEndian.Uint16(l4[off : off+2])
+	if proto == parser.ProtoUDP && csum == 0 {
+		// No checksum.
+		return
+	}
+	csum = parser.ChecksumUpdate(csum, old, new)
+	if proto == parser.ProtoUDP && csum == 0 {
+		csum = 0xffff
+	}
+	binary.BigEndian.PutUint16(l4[off:off+2], csum)
+}
+
+// quotedPacket is the start of the packet quoted by an ICMPv4 error,
+// whose transport header may be truncated.
+type quotedPacket struct {
+	hdr   []byte
+	l4    []byte
+	proto uint8
+}
+
+// quoted returns the TCP, UDP or ICMP echo packet quoted by the ICMPv4
+// error msg.
+func quoted(msg []byte) (*quotedPacket, bool) {
+	b := msg[8:]
+	if len(b) < 20 || b[0]>>4 != 4 {
+		return nil, false
+	}
+	hlen := int(b[0]&0x0f) * 4
+	if hlen < 20 || len(b) < hlen+8 {
+		return nil, false
+	}
+	q := &quotedPacket{hdr: b[:hlen], l4: b[hlen:], proto: b[9]}
+	switch q.proto {
+	case parser.ProtoTCP, parser.ProtoUDP:
+	case parser.ProtoICMP:
+		// Only echo requests from the internal network are bound.
+		if q.l4[0] != parser.ICMPv4EchoRequest {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	return q, true
+}
+
+func (q *quotedPacket) src() [4]byte {
+	return [4]byte(q.hdr[12:16])
+}
+
+func (q *quotedPacket) dst() [4]byte {
+	return [4]byte(q.hdr[16:20])
+}
+
+// port returns the port at off, the echo identifier for ICMP.
+func (q *quotedPacket) port(off int) uint16 {
+	if q.proto == parser.ProtoICMP {
+		off = 4
+	}
+	return binary.BigEndian.Uint16(q.l4[off : off+2])
+}
+
+// rewrite replaces the address at addrOff in the quoted header and the
+// port at portOff, updating the checksums it contains. The ICMPv4
+// checksum of the error must be computed again.
+func (q *quotedPacket) rewrite(addrOff int, addr [4]byte, portOff int, port uint16) {
+	if q.proto == parser.ProtoICMP {
+		portOff = 4
+	}
+	old := append([]byte(nil), q.hdr[addrOff:addrOff+4]...)
+	copy(q.hdr[addrOff:addrOff+4], addr[:])
+	binary.BigEndian.PutUint16(q.hdr[10:12], parser.ChecksumUpdate(binary.BigEndian.Uint16(q.hdr[10:12]), old, addr[:]))
+	if q.proto != parser.ProtoICMP {
+		// The pseudo-header.
+		updateChecksum(q.proto, q.l4, old, addr[:])
+	}
+	rewritePort(q.proto, q.l4, portOff, port)
+}
@@ -0,0 +1,574 @@
+package nat
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// WellKnownPrefix is the prefix of the IPv6 addresses representing IPv4
+// ones in NAT64 and DNS64 (RFC 6052).
+var WellKnownPrefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// Time an IPv6 client keeps its IPv4 address without traffic, by
+// default.
+const DefaultNAT64Timeout = time.Hour
+
+// NAT64Options configure a NAT64.
+type NAT64Options struct {
+	// Prefix of the IPv6 addresses representing IPv4 ones, 32, 40, 48,
+	// 56, 64 or 96 bits long (RFC 6052), WellKnownPrefix if unset.
+	Prefix netip.Prefix
+	// Pool of IPv4 addresses given to the IPv6 clients, one each.
+	Pool netip.Prefix
+	// Time a client keeps its address without traffic,
+	// DefaultNAT64Timeout if zero.
+	Timeout time.Duration
+}
+
+// NAT64 translates between IPv6 packets from the clients of an internal
+// network to the IPv4 addresses embedded in a prefix, and IPv4 packets
+// (RFC 7915). Each client gets an address of the pool, so that the
+// IPv4 packets can be shared, in turn, through a NAT44 for a stateful
+// NAT64 (RFC 6146):
+//
+//	p, err := t.ReadPacket()
+//	...
+//	p, action := n64.Outbound(p)
+//	if action == nat.Forward && n44.Outbound(p) == nat.Forward {
+//		// Send p upstream.
+//	}
+//
+// Fragmented packets are dropped: reassemble them first. IPv4 options
+// and IPv6 extension headers other than fragment headers are dropped
+// from the packets translated. It's safe for concurrent use.
+type NAT64 struct {
+	prefix  netip.Prefix
+	pool    netip.Prefix
+	timeout time.Duration
+
+	mu        sync.Mutex
+	clients   map[[16]byte]*client64
+	addrs     map[[4]byte]*client64
+	next      netip.Addr
+	id        uint16
+	lastSweep time.Time
+}
+
+type client64 struct {
+	v6       [16]byte
+	v4       [4]byte
+	lastSeen time.Time
+}
+
+// NewNAT64 returns a NAT64 configured by opts.
+func NewNAT64(opts NAT64Options) (*NAT64, error) {
+	if !opts.Prefix.IsValid() {
+		opts.Prefix = WellKnownPrefix
+	}
+	switch opts.Prefix.Bits() {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, errors.New("Invalid NAT64 prefix length")
+	}
+	if !opts.Prefix.Addr().Is6() || opts.Prefix.Addr().Is4In6() {
+		return nil, errors.New("NAT64 prefix must be an IPv6 prefix")
+	}
+	if !opts.Pool.IsValid() || !opts.Pool.Addr().Is4() {
+		return nil, errors.New("NAT64 pool must be an IPv4 prefix")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultNAT64Timeout
+	}
+	pool := opts.Pool.Masked()
+	return &NAT64{
+		prefix:  opts.Prefix.Masked(),
+		pool:    pool,
+		timeout: opts.Timeout,
+		clients: make(map[[16]byte]*client64),
+		addrs:   make(map[[4]byte]*client64),
+		next:    pool.Addr(),
+	}, nil
+}
+
+// Outbound translates p, an IPv6 packet from a client to an address of
+// the prefix, into an IPv4 packet. Other packets are forwarded
+// unchanged.
+func (n *NAT64) Outbound(p *tuntap.IPPacket) (*tuntap.IPPacket, Action) {
+	hdr := p.Header.Data
+	if len(hdr) < 40 || hdr[0]>>4 != 6 || !n.prefix.Contains(netip.AddrFrom16([16]byte(hdr[24:40]))) {
+		return p, Forward
+	}
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sweep(now)
+
+	b, ok := n.to4(hdr, p.Payload, false, now)
+	if !ok {
+		return nil, Drop
+	}
+	return &tuntap.IPPacket{Protocol: 0x0800, Header: tuntap.IPHeader{Data: b[:20]}, Payload: b[20:]}, Forward
+}
+
+// Inbound translates p, an IPv4 packet to an address of the pool, into
+// an IPv6 packet to the client it was given to. Other packets are
+// forwarded unchanged.
+func (n *NAT64) Inbound(p *tuntap.IPPacket) (*tuntap.IPPacket, Action) {
+	hdr := p.Header.Data
+	if len(hdr) < 20 || hdr[0]>>4 != 4 || !n.pool.Contains(netip.AddrFrom4([4]byte(hdr[16:20]))) {
+		return p, Forward
+	}
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sweep(now)
+
+	b, ok := n.to6(hdr, p.Payload, false, now)
+	if !ok {
+		return nil, Drop
+	}
+	return &tuntap.IPPacket{Protocol: 0x86dd, Header: tuntap.IPHeader{Data: b[:40]}, Payload: b[40:]}, Forward
+}
+
+// Synthesize returns the IPv6 address representing the IPv4 address a
+// with the prefix of n.
+func (n *NAT64) Synthesize(a netip.Addr) netip.Addr {
+	return netip.AddrFrom16(embed(n.prefix, a.Unmap().As4()))
+}
+
+// to4 returns the IPv4 translation of the IPv6 packet of header hdr and
+// payload, quoted by an ICMPv6 error if inner: then the payload may be
+// truncated, the hop limit isn't decremented and no client is added.
+func (n *NAT64) to4(hdr, payload []byte, inner bool, now time.Time) ([]byte, bool) {
+	it := parser.NewExtensionHeaders(hdr[6], payload)
+	for it.Next() {
+		if it.Header().Type == parser.IPv6Fragment {
+			return nil, false
+		}
+	}
+	if it.Err() != nil {
+		return nil, false
+	}
+	proto, data := it.Protocol(), payload[it.Offset():]
+	ttl := hdr[7]
+	if !inner {
+		if ttl <= 1 {
+			return nil, false
+		}
+		ttl--
+	}
+	src, ok := n.addr4([16]byte(hdr[8:24]), !inner, now)
+	if !ok {
+		return nil, false
+	}
+	dst, ok := n.addr4([16]byte(hdr[24:40]), false, now)
+	if !ok {
+		return nil, false
+	}
+
+	if proto == parser.ProtoICMPv6 {
+		if data, ok = n.icmp6to4(data, inner, now); !ok {
+			return nil, false
+		}
+		proto = parser.ProtoICMP
+	} else {
+		data = append([]byte(nil), data...)
+	}
+	// The length of the upper-layer data, as given by the header when
+	// truncated.
+	length := len(data)
+	if inner {
+		length = int(binary.BigEndian.Uint16(hdr[4:6])) - it.Offset()
+	}
+	n.id++
+	h := parser.IPv4Header{
+		DSCP:        hdr[0]&0x0f<<2 | hdr[1]>>6,
+		ECN:         hdr[1] >> 4 & 0x3,
+		TotalLength: 20 + length,
+		ID:          n.id,
+		Flags:       parser.IPv4DontFragment,
+		TTL:         ttl,
+		Protocol:    proto,
+		Src:         netip.AddrFrom4(src),
+		Dst:         netip.AddrFrom4(dst),
+	}
+	b, err := h.Marshal()
+	if err != nil {
+		return nil, false
+	}
+
+	switch proto {
+	case parser.ProtoTCP, parser.ProtoUDP:
+		// The pseudo-header sums the same length and protocol.
+		var pseudo [32]byte
+		copy(pseudo[0:4], src[:])
+		copy(pseudo[4:8], dst[:])
+		updateChecksum(proto, data, hdr[8:40], pseudo[:])
+	case parser.ProtoICMP:
+		if len(data) >= 4 {
+			binary.BigEndian.PutUint16(data[2:4], parser.ICMPv4Checksum(data))
+		}
+	}
+	return append(b, data...), true
+}
+
+// to6 returns the IPv6 translation of the IPv4 packet of header hdr and
+// payload, like to4.
+func (n *NAT64) to6(hdr, payload []byte, inner bool, now time.Time) ([]byte, bool) {
+	var h parser.IPv4Header
+	if h.Unmarshal(hdr) != nil || h.Flags&parser.IPv4MoreFragments != 0 || h.FragmentOffset != 0 {
+		return nil, false
+	}
+	ttl := h.TTL
+	if !inner {
+		if ttl <= 1 {
+			return nil, false
+		}
+		ttl--
+	}
+	src, ok := n.addr6(h.Src.As4(), now)
+	if !ok {
+		return nil, false
+	}
+	dst, ok := n.addr6(h.Dst.As4(), now)
+	if !ok {
+		return nil, false
+	}
+
+	proto, data := h.Protocol, payload
+	if proto == parser.ProtoICMP {
+		if data, ok = n.icmp4to6(data, inner, now); !ok {
+			return nil, false
+		}
+		proto = parser.ProtoICMPv6
+	} else {
+		data = append([]byte(nil), data...)
+	}
+	length := len(data)
+	if inner {
+		length = h.TotalLength - h.IHL*4
+	}
+	b := make([]byte, 40, 40+len(data))
+	tos := h.DSCP<<2 | h.ECN
+	b[0] = 6<<4 | tos>>4
+	b[1] = tos << 4
+	binary.BigEndian.PutUint16(b[4:6], uint16(length))
+	b[6] = proto
+	b[7] = ttl
+	copy(b[8:24], src[:])
+	copy(b[24:40], dst[:])
+
+	switch proto {
+	case parser.ProtoUDP:
+		if len(data) >= 8 && binary.BigEndian.Uint16(data[6:8]) == 0 {
+			// Checksums are mandatory over IPv6 (RFC 7915 section 4.5).
+			if !inner {
+				binary.BigEndian.PutUint16(data[6:8], parser.UDPChecksum(netip.AddrFrom16(src), netip.AddrFrom16(dst), data))
+			}
+			break
+		}
+		fallthrough
+	case parser.ProtoTCP:
+		var pseudo [32]byte
+		s4, d4 := h.Src.As4(), h.Dst.As4()
+		copy(pseudo[0:4], s4[:])
+		copy(pseudo[4:8], d4[:])
+		updateChecksum(proto, data, pseudo[:], b[8:40])
+	case parser.ProtoICMPv6:
+		if len(data) >= 4 {
+			binary.BigEndian.PutUint16(data[2:4], parser.ICMPv6Checksum(netip.AddrFrom16(src), netip.AddrFrom16(dst), data))
+		}
+	}
+	return append(b, data...), true
+}
+
+// icmp6to4 returns the ICMPv4 translation of the ICMPv6 message msg,
+// with the checksum left to compute.
+func (n *NAT64) icmp6to4(msg []byte, inner bool, now time.Time) ([]byte, bool) {
+	if len(msg) < 8 {
+		return nil, false
+	}
+	out := make([]byte, 8, len(msg))
+	copy(out, msg[:8])
+	typ, code := msg[0], msg[1]
+	switch typ {
+	case parser.ICMPv6EchoRequest:
+		return echo(msg, parser.ICMPv4EchoRequest), true
+	case parser.ICMPv6EchoReply:
+		return echo(msg, parser.ICMPv4EchoReply), true
+	case parser.ICMPv6DestUnreachable:
+		out[0] = parser.ICMPv4DestUnreachable
+		switch code {
+		case 0, 2, 3:
+			out[1] = parser.ICMPv4HostUnreachable
+		case 1:
+			// Communication with the host administratively prohibited.
+			out[1] = 10
+		case 4:
+			out[1] = parser.ICMPv4PortUnreachable
+		default:
+			return nil, false
+		}
+		binary.BigEndian.PutUint32(out[4:8], 0)
+	case parser.ICMPv6PacketTooBig:
+		out[0], out[1] = parser.ICMPv4DestUnreachable, parser.ICMPv4FragmentationNeeded
+		mtu := binary.BigEndian.Uint32(msg[4:8]) - 20
+		if mtu > 0xffff {
+			mtu = 0xffff
+		}
+		binary.BigEndian.PutUint32(out[4:8], mtu)
+	case parser.ICMPv6TimeExceeded:
+		out[0] = parser.ICMPv4TimeExceeded
+	case parser.ICMPv6ParamProblem:
+		switch code {
+		case 0:
+			// Erroneous header field, at a pointer translated as in RFC
+			// 7915 section 5.2.
+			ptr, ok := paramPointer6to4(binary.BigEndian.Uint32(msg[4:8]))
+			if !ok {
+				return nil, false
+			}
+			out[0], out[1] = parser.ICMPv4ParamProblem, 0
+			binary.BigEndian.PutUint32(out[4:8], uint32(ptr)<<24)
+		case 1:
+			out[0], out[1] = parser.ICMPv4DestUnreachable, parser.ICMPv4ProtoUnreachable
+			binary.BigEndian.PutUint32(out[4:8], 0)
+		default:
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	// An error, not quoted in another.
+	if inner {
+		return nil, false
+	}
+	q := msg[8:]
+	if len(q) < 40 || q[0]>>4 != 6 {
+		return nil, false
+	}
+	b, ok := n.to4(q[:40], q[40:], true, now)
+	if !ok {
+		return nil, false
+	}
+	return append(out, b...), true
+}
+
+// icmp4to6 returns the ICMPv6 translation of the ICMPv4 message msg,
+// with the checksum left to compute.
+func (n *NAT64) icmp4to6(msg []byte, inner bool, now time.Time) ([]byte, bool) {
+	if len(msg) < 8 {
+		return nil, false
+	}
+	out := make([]byte, 8, len(msg)+20)
+	copy(out, msg[:8])
+	typ, code := msg[0], msg[1]
+	switch typ {
+	case parser.ICMPv4EchoRequest:
+		return echo(msg, parser.ICMPv6EchoRequest), true
+	case parser.ICMPv4EchoReply:
+		return echo(msg, parser.ICMPv6EchoReply), true
+	case parser.ICMPv4DestUnreachable:
+		binary.BigEndian.PutUint32(out[4:8], 0)
+		switch code {
+		case 0, 1, 5, 6, 7, 8, 11, 12:
+			out[0], out[1] = parser.ICMPv6DestUnreachable, 0
+		case 9, 10, 13, 15:
+			out[0], out[1] = parser.ICMPv6DestUnreachable, 1
+		case parser.ICMPv4PortUnreachable:
+			out[0], out[1] = parser.ICMPv6DestUnreachable, 4
+		case parser.ICMPv4ProtoUnreachable:
+			// Unrecognized next header, the field at offset 6.
+			out[0], out[1] = parser.ICMPv6ParamProblem, 1
+			binary.BigEndian.PutUint32(out[4:8], 6)
+		case parser.ICMPv4FragmentationNeeded:
+			out[0], out[1] = parser.ICMPv6PacketTooBig, 0
+			mtu := uint32(binary.BigEndian.Uint16(msg[6:8])) + 20
+			if mtu < 1280 {
+				mtu = 1280
+			}
+			binary.BigEndian.PutUint32(out[4:8], mtu)
+		default:
+			return nil, false
+		}
+	case parser.ICMPv4TimeExceeded:
+		out[0] = parser.ICMPv6TimeExceeded
+	case parser.ICMPv4ParamProblem:
+		ptr, ok := paramPointer4to6(msg[4])
+		if code != 0 || !ok {
+			return nil, false
+		}
+		out[0], out[1] = parser.ICMPv6ParamProblem, 0
+		binary.BigEndian.PutUint32(out[4:8], ptr)
+	default:
+		return nil, false
+	}
+	if inner {
+		return nil, false
+	}
+	q := msg[8:]
+	if len(q) < 20 || q[0]>>4 != 4 {
+		return nil, false
+	}
+	hlen := int(q[0]&0x0f) * 4
+	if hlen < 20 || hlen > len(q) {
+		return nil, false
+	}
+	b, ok := n.to6(q[:hlen], q[hlen:], true, now)
+	if !ok {
+		return nil, false
+	}
+	out = append(out, b...)
+	// Within the minimum IPv6 MTU.
+	if len(out) > 1280-40 {
+		out = out[:1280-40]
+	}
+	return out, true
+}
+
+// echo returns a copy of the echo request or reply msg with type typ.
+func echo(msg []byte, typ uint8) []byte {
+	b := append([]byte(nil), msg...)
+	b[0] = typ
+	return b
+}
+
+// paramPointer6to4 translates the pointer of an ICMPv6 parameter
+// problem into the IPv4 header.
+func paramPointer6to4(ptr uint32) (uint8, bool) {
+	switch {
+	case ptr <= 1:
+		return uint8(ptr), true
+	case ptr == 4 || ptr == 5:
+		return 2, true
+	case ptr == 6:
+		return 9, true
+	case ptr == 7:
+		return 8, true
+	case ptr >= 8 && ptr < 24:
+		return 12, true
+	case ptr >= 24 && ptr < 40:
+		return 16, true
+	}
+	return 0, false
+}
+
+// paramPointer4to6 translates the pointer of an ICMPv4 parameter
+// problem into the IPv6 header.
+func paramPointer4to6(ptr uint8) (uint32, bool) {
+	switch {
+	case ptr <= 1:
+		return uint32(ptr), true
+	case ptr == 2 || ptr == 3:
+		return 4, true
+	case ptr == 8:
+		return 7, true
+	case ptr == 9:
+		return 6, true
+	case ptr >= 12 && ptr < 16:
+		return 8, true
+	case ptr >= 16 && ptr < 20:
+		return 24, true
+	}
+	return 0, false
+}
+
+// addr4 returns the IPv4 address embedded in a, or given to the client
+// a, a new one if add.
+func (n *NAT64) addr4(a [16]byte, add bool, now time.Time) ([4]byte, bool) {
+	if n.prefix.Contains(netip.AddrFrom16(a)) {
+		return extract(n.prefix, a), true
+	}
+	c := n.clients[a]
+	if c == nil && add {
+		c = n.allocate(a)
+	}
+	if c == nil {
+		return [4]byte{}, false
+	}
+	if add {
+		c.lastSeen = now
+	}
+	return c.v4, true
+}
+
+// addr6 returns the client given the IPv4 address a of the pool, or the
+// IPv6 address embedding a.
+func (n *NAT64) addr6(a [4]byte, now time.Time) ([16]byte, bool) {
+	if !n.pool.Contains(netip.AddrFrom4(a)) {
+		return embed(n.prefix, a), true
+	}
+	c := n.addrs[a]
+	if c == nil {
+		return [16]byte{}, false
+	}
+	return c.v6, true
+}
+
+// allocate gives the client v6 a free address of the pool, returning
+// nil if there's none.
+func (n *NAT64) allocate(v6 [16]byte) *client64 {
+	for i := 0; i < 1<<(32-n.pool.Bits()); i++ {
+		a := n.next
+		if n.next = a.Next(); !n.pool.Contains(n.next) {
+			n.next = n.pool.Addr()
+		}
+		if n.addrs[a.As4()] == nil {
+			c := &client64{v6: v6, v4: a.As4()}
+			n.clients[v6] = c
+			n.addrs[c.v4] = c
+			return c
+		}
+	}
+	return nil
+}
+
+// sweep removes the clients idle for longer than the timeout, at most
+// every sweepInterval.
+func (n *NAT64) sweep(now time.Time) {
+	if now.Sub(n.lastSweep) < sweepInterval {
+		return
+	}
+	n.lastSweep = now
+	for _, c := range n.clients {
+		if now.Sub(c.lastSeen) > n.timeout {
+			delete(n.clients, c.v6)
+			delete(n.addrs, c.v4)
+		}
+	}
+}
+
+// embed returns the IPv6 address of prefix embedding a (RFC 6052
+// section 2.2), skipping the u octet, bits 64 to 71, which stays zero.
+func embed(prefix netip.Prefix, a [4]byte) [16]byte {
+	b := prefix.Addr().As16()
+	off := prefix.Bits() / 8
+	for _, x := range a {
+		if off == 8 {
+			off++
+		}
+		b[off] = x
+		off++
+	}
+	return b
+}
+
+// extract returns the IPv4 address embedded in b with prefix.
+func extract(prefix netip.Prefix, b [16]byte) [4]byte {
+	var a [4]byte
+	off := prefix.Bits() / 8
+	for i := range a {
+		if off == 8 {
+			off++
+		}
+		a[i] = b[off]
+		off++
+	}
+	return a
+}
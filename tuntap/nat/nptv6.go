@@ -0,0 +1,172 @@
+// Package nat translates the addresses of the packets read from and
+// written to tuntap Interfaces, for interfaces facing an internal
+// network sharing the addresses of an external one. The translators
+// work on the packets between ReadPacket and WritePacket:
+//
+//	n := nat.NewNPTv6()
+//	err := n.AddMapping(netip.MustParsePrefix("fd01:203::/48"),
+//		netip.MustParsePrefix("2001:db8:1::/48"))
+//	...
+//	p, err := t.ReadPacket()
+//	if n.Outbound(p) {
+//		// Send p upstream.
+//	}
+package nat
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sync"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// NPTv6 is a stateless IPv6 prefix translator (RFC 6296), mapping
+// internal prefixes to external ones of the same length, one to one.
+// Translations are checksum-neutral: only the addresses change, and the
+// transport checksums stay valid. It's safe for concurrent use.
+type NPTv6 struct {
+	mu       sync.RWMutex
+	mappings []nptMapping
+}
+
+type nptMapping struct {
+	internal, external netip.Prefix
+	// Ones' complement difference between the sums of the internal and
+	// external prefixes, added to an address mapped to external.
+	adjust uint16
+}
+
+// NewNPTv6 returns an NPTv6 translator without mappings.
+func NewNPTv6() *NPTv6 {
+	return &NPTv6{}
+}
+
+// AddMapping maps the addresses of the internal prefix to the external
+// one, which must have the same length, up to 64 bits. The prefixes may
+// not overlap those of other mappings.
+func (n *NPTv6) AddMapping(internal, external netip.Prefix) error {
+	internal, external = internal.Masked(), external.Masked()
+	if !internal.Addr().Is6() || !external.Addr().Is6() || internal.Addr().Is4In6() || external.Addr().Is4In6() {
+		return errors.New("NPTv6 maps IPv6 prefixes")
+	}
+	if internal.Bits() != external.Bits() {
+		return errors.New("NPTv6 prefixes must have the same length")
+	}
+	if internal.Bits() > 64 {
+		return errors.New("NPTv6 prefixes must be at most 64 bits long")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, m := range n.mappings {
+		if m.internal.Overlaps(internal) || m.external.Overlaps(external) {
+			return errors.New("NPTv6 mapping overlaps " + m.internal.String() + " to " + m.external.String())
+		}
+	}
+	in, ex := internal.Addr().As16(), external.Addr().As16()
+	adjust := onesSub(parser.ChecksumFold(parser.ChecksumAdd(0, ex[:])), parser.ChecksumFold(parser.ChecksumAdd(0, in[:])))
+	n.mappings = append(n.mappings, nptMapping{internal: internal, external: external, adjust: adjust})
+	return nil
+}
+
+// Outbound translates the source address of p, a packet from the
+// internal network, and the destination address of the packet quoted
+// by ICMPv6 errors. Packets matching no mapping are left unchanged. It
+// returns false if p can't be translated and must be dropped.
+func (n *NPTv6) Outbound(p *tuntap.IPPacket) bool {
+	return n.translate(p, false)
+}
+
+// Inbound translates the destination address of p, a packet to the
+// internal network, and the source address of the packet quoted by
+// ICMPv6 errors, like Outbound.
+func (n *NPTv6) Inbound(p *tuntap.IPPacket) bool {
+	return n.translate(p, true)
+}
+
+func (n *NPTv6) translate(p *tuntap.IPPacket, inbound bool) bool {
+	hdr := p.Header.Data
+	if len(hdr) < 40 || hdr[0]>>4 != 6 {
+		return true
+	}
+	// The addresses are the source one outbound, the destination one
+	// inbound, and the other one in quoted packets.
+	off, quotedOff := 8, 24
+	if inbound {
+		off, quotedOff = 24, 8
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if !n.map6(hdr[off:off+16], inbound) {
+		return false
+	}
+	proto, data, err := p.UpperLayer()
+	if err != nil || proto != tuntap.ProtoICMPv6 || len(data) < 8+40 || data[0] >= 128 {
+		return true
+	}
+	// The ICMPv6 checksum stays valid too.
+	quoted := data[8:]
+	if quoted[0]>>4 == 6 {
+		n.map6(quoted[quotedOff:quotedOff+16], !inbound)
+	}
+	return true
+}
+
+// map6 rewrites addr in place if it belongs to the internal prefix of a
+// mapping, or to the external one if inbound. It returns false if addr
+// matches but can't be mapped.
+func (n *NPTv6) map6(addr []byte, inbound bool) bool {
+	a := netip.AddrFrom16([16]byte(addr))
+	for _, m := range n.mappings {
+		from, to, adjust := m.internal, m.external, m.adjust
+		if inbound {
+			from, to, adjust = m.external, m.internal, ^m.adjust
+		}
+		if !from.Contains(a) {
+			continue
+		}
+		// The word adjusted is the subnet ID after prefixes of up to 48
+		// bits, else the first word of the interface ID that isn't
+		// 0xffff (RFC 6296 sections 3.4 and 3.5).
+		word := 6
+		if from.Bits() > 48 {
+			for word = 8; word < 16 && binary.BigEndian.Uint16(addr[word:]) == 0xffff; word += 2 {
+			}
+			if word == 16 {
+				return false
+			}
+		} else if binary.BigEndian.Uint16(addr[word:]) == 0xffff {
+			return false
+		}
+
+		prefix := to.Addr().As16()
+		bits := to.Bits()
+		copy(addr, prefix[:bits/8])
+		if bits%8 != 0 {
+			mask := byte(0xff) << (8 - bits%8)
+			addr[bits/8] = addr[bits/8]&^mask | prefix[bits/8]&mask
+		}
+		w := onesAdd(binary.BigEndian.Uint16(addr[word:]), adjust)
+		if w == 0xffff {
+			w = 0
+		}
+		binary.BigEndian.PutUint16(addr[word:], w)
+		return true
+	}
+	return true
+}
+
+// onesAdd adds a and b in ones' complement arithmetic.
+func onesAdd(a, b uint16) uint16 {
+	s := uint32(a) + uint32(b)
+	return uint16(s + s>>16)
+}
+
+// onesSub subtracts b from a in ones' complement arithmetic.
+func onesSub(a, b uint16) uint16 {
+	return onesAdd(a, ^b)
+}
@@ -0,0 +1,85 @@
+package tuntap
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/izqui/tuntap/tuntap/internal/rtnl"
+)
+
+// OpenInNamespace is like Open, but creates the interface inside the
+// network namespace at nsPath, for example /var/run/netns/NAME or
+// /proc/PID/ns/net. Only the interface lives there: the descriptor can
+// be used from any namespace.
+func OpenInNamespace(nsPath string, ifPattern string, kind DevKind, meta bool) (*Interface, error) {
+	var t *Interface
+	err := inNamespace(nsPath, func() error {
+		var err error
+		t, err = Open(ifPattern, kind, meta)
+		return err
+	})
+	return t, err
+}
+
+// inNamespace runs f on an OS thread switched to the network namespace
+// at nsPath.
+func inNamespace(nsPath string, f func() error) error {
+	ns, err := os.Open(nsPath)
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		// The thread is only unlocked once it's back in its namespace.
+		// Otherwise it's terminated when this goroutine exits.
+		runtime.LockOSThread()
+		cur, err := os.Open("/proc/self/task/" + strconv.Itoa(syscall.Gettid()) + "/ns/net")
+		if err != nil {
+			runtime.UnlockOSThread()
+			done <- err
+			return
+		}
+		defer cur.Close()
+
+		if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			done <- err
+			return
+		}
+		ferr := f()
+		if err := unix.Setns(int(cur.Fd()), unix.CLONE_NEWNET); err != nil {
+			done <- err
+			return
+		}
+		runtime.UnlockOSThread()
+		done <- ferr
+	}()
+	return <-done
+}
+
+// MoveToNamespace moves the interface to the network namespace at
+// nsPath. The Interface keeps working, but the methods looking the
+// interface up by name, like MTU and Index, only work from inside that
+// namespace afterwards.
+func (t *Interface) MoveToNamespace(nsPath string) error {
+	index, err := t.Index()
+	if err != nil {
+		return err
+	}
+	ns, err := os.Open(nsPath)
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+
+	var b rtnl.Message
+	b.IfInfo(index, 0, 0)
+	b.Attr(unix.IFLA_NET_NS_FD, rtnl.Uint32(uint32(ns.Fd())))
+	return rtnl.Request(unix.RTM_NEWLINK, 0, b)
+}
@@ -0,0 +1,11 @@
+// +build !linux,!darwin,!freebsd,!openbsd
+
+package tuntap
+
+import (
+	"errors"
+)
+
+func (t *Interface) setBlocking() error {
+	return errors.New("Option not supported on this platform")
+}
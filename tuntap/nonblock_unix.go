@@ -0,0 +1,42 @@
+// +build linux darwin freebsd openbsd
+
+package tuntap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// setBlocking switches the descriptor of the interface to blocking mode,
+// for WithNonblock(false). It's replaced by a duplicate cleared of
+// O_NONBLOCK, which os.NewFile leaves out of the runtime poller: clearing
+// the flag of a descriptor registered with the poller would make Close
+// wait for the reads blocked on it.
+func (t *Interface) setBlocking() error {
+	d, ok := t.dev.(*osDevice)
+	if !ok {
+		return errors.New("Device has no file descriptor")
+	}
+	file, ok := d.ReadWriteCloser.(*os.File)
+	if !ok {
+		return errors.New("Device has no file descriptor")
+	}
+	var fd int
+	err := control(file, func(f uintptr) error {
+		var err error
+		fd, err = syscall.Dup(int(f))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	syscall.CloseOnExec(fd)
+	// The flag is shared with file, which is closed right after.
+	if err := syscall.SetNonblock(fd, false); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	d.ReadWriteCloser = os.NewFile(uintptr(fd), file.Name())
+	return file.Close()
+}
@@ -0,0 +1,63 @@
+// +build linux darwin freebsd openbsd
+
+package tuntap
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSetBlocking(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	tun := NewInterface(&osDevice{r, "pipe"}, DevTun, false)
+	if err := tun.setBlocking(); err != nil {
+		t.Fatal(err)
+	}
+	defer tun.Close()
+
+	file, err := tun.file()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file == r {
+		t.Fatal("descriptor not replaced")
+	}
+	// Unlike File.Fd, control doesn't change the mode.
+	var flags uintptr
+	err = control(file, func(fd uintptr) error {
+		var errno syscall.Errno
+		flags, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags&syscall.O_NONBLOCK != 0 {
+		t.Error("descriptor still non-blocking")
+	}
+	// Deadlines need the runtime poller, which the descriptor left.
+	if err := tun.SetReadDeadline(aLongTimeAgo); err == nil {
+		t.Error("deadline set on a blocking descriptor")
+	}
+
+	want := udpPacket(t, testSrc4, testDst4, 53, []byte("blocking"))
+	if _, err := w.Write(packetBytes(want)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tun.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packetBytes(got), packetBytes(want)) {
+		t.Errorf("read %x, want %x", packetBytes(got), packetBytes(want))
+	}
+}
@@ -0,0 +1,79 @@
+package tuntap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// An observer is called with each packet read (write false) or
+// written through an Interface, without the packet information, address
+// family or virtio header: an IP packet on DevTun, an Ethernet frame on
+// DevTap. data is only valid during the call.
+type observer func(write bool, data []byte)
+
+// observers are the observers of an Interface, behind Capture.
+type observers struct {
+	mu   sync.Mutex
+	next int
+	// map[int]observer, replaced on every change.
+	list atomic.Value
+}
+
+// add registers o on all queues of t and returns a function removing it.
+func (t *Interface) addObserver(o observer) (remove func()) {
+	queues := t.queues
+	if queues == nil {
+		queues = []*Interface{t}
+	}
+	ids := make([]int, len(queues))
+	for i, q := range queues {
+		ids[i] = q.obs.add(o)
+	}
+	return func() {
+		for i, q := range queues {
+			q.obs.remove(ids[i])
+		}
+	}
+}
+
+func (s *observers) add(o observer) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.copy()
+	id := s.next
+	s.next++
+	m[id] = o
+	s.list.Store(m)
+	return id
+}
+
+func (s *observers) remove(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.copy()
+	delete(m, id)
+	s.list.Store(m)
+}
+
+func (s *observers) copy() map[int]observer {
+	m := make(map[int]observer)
+	old, _ := s.list.Load().(map[int]observer)
+	for id, o := range old {
+		m[id] = o
+	}
+	return m
+}
+
+// active tells whether there are observers, so callers can skip
+// preparing the data.
+func (s *observers) active() bool {
+	m, _ := s.list.Load().(map[int]observer)
+	return len(m) > 0
+}
+
+func (s *observers) observe(write bool, data []byte) {
+	m, _ := s.list.Load().(map[int]observer)
+	for _, o := range m {
+		o(write, data)
+	}
+}
@@ -0,0 +1,120 @@
+package tuntap
+
+import (
+	"errors"
+	"net/netip"
+
+	"github.com/izqui/tuntap/tuntap/internal/mtu"
+)
+
+// An Option configures the interface created by OpenWithOptions.
+type Option func(*openOptions)
+
+type openOptions struct {
+	meta     bool
+	persist  bool
+	owner    int
+	group    int
+	queues   int
+	vnetHdr  bool
+	nonblock bool
+	mtu      int
+	ioUring  bool
+	echo     []netip.Addr
+}
+
+// WithMeta keeps the packet information header on a Linux interface,
+// like the meta argument of Open.
+func WithMeta() Option {
+	return func(o *openOptions) { o.meta = true }
+}
+
+// WithPersist makes the interface persistent (TUNSETPERSIST): it
+// outlives the process and is only removed when marked non-persistent
+// again. Linux only.
+func WithPersist() Option {
+	return func(o *openOptions) { o.persist = true }
+}
+
+// WithOwner lets the user uid open the interface without CAP_NET_ADMIN
+// (TUNSETOWNER). Linux only.
+func WithOwner(uid int) Option {
+	return func(o *openOptions) { o.owner = uid }
+}
+
+// WithGroup lets members of the group gid open the interface without
+// CAP_NET_ADMIN (TUNSETGROUP). Linux only.
+func WithGroup(gid int) Option {
+	return func(o *openOptions) { o.group = gid }
+}
+
+// WithMultiQueue creates a multiqueue interface with n queues, see
+// OpenMultiQueue. Linux only.
+func WithMultiQueue(n int) Option {
+	return func(o *openOptions) { o.queues = n }
+}
+
+// WithVnetHdr enables virtio-net headers, see OpenVnetHdr. Linux only.
+func WithVnetHdr() Option {
+	return func(o *openOptions) { o.vnetHdr = true }
+}
+
+// WithNonblock sets whether the descriptors are left in non-blocking
+// mode, which is the default and what deadlines, unblocking Close and
+// WithIOUring rely on. Blocking descriptors are only useful when they
+// are handed to code outside this package. Unix only.
+func WithNonblock(enabled bool) Option {
+	return func(o *openOptions) { o.nonblock = enabled }
+}
+
+// WithMTU sets the MTU of the interface once it's created.
+func WithMTU(n int) Option {
+	return func(o *openOptions) { o.mtu = n }
+}
+
+// WithIOUring makes ReadPackets and WritePackets submit the reads and
+// writes of a batch to io_uring at once, instead of making a system
+// call per packet. Waiting for packets, deadlines and Close are
+// unchanged, as is the I/O of single packets. Linux 5.1 or later only.
+func WithIOUring() Option {
+	return func(o *openOptions) { o.ioUring = true }
+}
+
+// OpenWithOptions is like Open, with the settings given as options.
+// Options the platform doesn't support make it fail rather than being
+// ignored.
+func OpenWithOptions(ifPattern string, kind DevKind, opts ...Option) (*Interface, error) {
+	o := openOptions{owner: -1, group: -1, nonblock: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.ioUring && !o.nonblock {
+		return nil, errors.New("WithIOUring needs non-blocking descriptors")
+	}
+
+	t, err := openWithOptions(ifPattern, kind, &o)
+	if err != nil {
+		return nil, err
+	}
+	if o.mtu > 0 {
+		if err := mtu.Set(t.Name(), o.mtu); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	if !o.nonblock {
+		for i := 0; i < t.Queues(); i++ {
+			if err := t.Queue(i).setBlocking(); err != nil {
+				t.Close()
+				return nil, err
+			}
+		}
+	}
+	if len(o.echo) > 0 {
+		echo := newEchoAddrs(o.echo)
+		for i := 0; i < t.Queues(); i++ {
+			t.Queue(i).echo = echo
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,14 @@
+// +build !linux
+
+package tuntap
+
+import (
+	"errors"
+)
+
+func openWithOptions(ifPattern string, kind DevKind, o *openOptions) (*Interface, error) {
+	if o.persist || o.owner >= 0 || o.group >= 0 || o.queues > 0 || o.vnetHdr || o.ioUring {
+		return nil, errors.New("Option not supported on this platform")
+	}
+	return Open(ifPattern, kind, o.meta)
+}
@@ -0,0 +1,102 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+var _ net.PacketConn = (*Interface)(nil)
+
+// Addr is the net.Addr of an Interface, as returned by LocalAddr.
+type Addr struct {
+	Name string
+	Kind DevKind
+}
+
+// Network returns "tun" or "tap".
+func (a *Addr) Network() string {
+	if a.Kind == DevTap {
+		return "tap"
+	}
+	return "tun"
+}
+
+func (a *Addr) String() string {
+	return a.Name
+}
+
+// HardwareAddr is a MAC address as a net.Addr, the source address
+// returned by ReadFrom on DevTap interfaces.
+type HardwareAddr net.HardwareAddr
+
+// Network returns "ethernet".
+func (a HardwareAddr) Network() string {
+	return "ethernet"
+}
+
+func (a HardwareAddr) String() string {
+	return net.HardwareAddr(a).String()
+}
+
+// LocalAddr returns the Addr of the interface.
+func (t *Interface) LocalAddr() net.Addr {
+	return &Addr{Name: t.Name(), Kind: t.kind}
+}
+
+// ReadFrom reads a single raw packet into p, without the packet
+// information or address family header, so Interface can be used as a
+// net.PacketConn. addr is the source address of the packet: a
+// *net.IPAddr on DevTun, a HardwareAddr on DevTap. It may be nil if the
+// packet is too short to have one.
+//
+// Since the device headers are read into p too, p should have room for
+// a few bytes more than the largest packet.
+func (t *Interface) ReadFrom(p []byte) (int, net.Addr, error) {
+	data, _, err := t.readRaw(p)
+	if err != nil {
+		return 0, nil, err
+	}
+	n := copy(p, data)
+	return n, sourceAddr(t.kind, p[:n]), nil
+}
+
+// sourceAddr returns the source address of a raw packet or frame.
+func sourceAddr(kind DevKind, b []byte) net.Addr {
+	if kind == DevTap {
+		if len(b) < ethHeaderLength {
+			return nil
+		}
+		return HardwareAddr(append([]byte(nil), b[6:12]...))
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) >= 20 {
+			return &net.IPAddr{IP: net.IP(append([]byte(nil), b[12:16]...))}
+		}
+	case 6:
+		if len(b) >= ipHeaderLength {
+			return &net.IPAddr{IP: net.IP(append([]byte(nil), b[8:24]...))}
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the raw packet (DevTun) or frame (DevTap) p. addr is
+// ignored: the packet carries its own destination.
+func (t *Interface) WriteTo(p []byte, addr net.Addr) (int, error) {
+	proto := etherTypeIPv6
+	if t.kind == DevTap {
+		if len(p) >= ethHeaderLength {
+			proto = int(binary.BigEndian.Uint16(p[12:14]))
+		}
+	} else if len(p) > 0 && p[0]>>4 == 4 {
+		proto = etherTypeIPv4
+	}
+	if err := t.writeRaw(proto, nil, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
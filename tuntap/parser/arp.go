@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// ARP operations.
+const (
+	ARPRequest = 1
+	ARPReply   = 2
+)
+
+const (
+	arpLength       = 28
+	arpHardwareEth  = 1
+	arpHardwareSize = 6
+	arpProtocolSize = 4
+)
+
+// ARPPacket is a decoded ARP packet (RFC 826) mapping IPv4 addresses to
+// Ethernet addresses, the only kind found on Ethernet links.
+type ARPPacket struct {
+	// ARPRequest or ARPReply.
+	Operation uint16
+	SenderMAC net.HardwareAddr
+	SenderIP  netip.Addr
+	// Zero in requests.
+	TargetMAC net.HardwareAddr
+	TargetIP  netip.Addr
+}
+
+// Unmarshal decodes the ARP packet at the start of b. The addresses
+// reference b.
+func (a *ARPPacket) Unmarshal(b []byte) error {
+	if len(b) < arpLength {
+		return errShortARP
+	}
+	if binary.BigEndian.Uint16(b[0:2]) != arpHardwareEth || binary.BigEndian.Uint16(b[2:4]) != EtherTypeIPv4 ||
+		b[4] != arpHardwareSize || b[5] != arpProtocolSize {
+		return errors.New("Not an Ethernet IPv4 ARP packet")
+	}
+	*a = ARPPacket{
+		Operation: binary.BigEndian.Uint16(b[6:8]),
+		SenderMAC: net.HardwareAddr(b[8:14]),
+		SenderIP:  netip.AddrFrom4([4]byte(b[14:18])),
+		TargetMAC: net.HardwareAddr(b[18:24]),
+		TargetIP:  netip.AddrFrom4([4]byte(b[24:28])),
+	}
+	return nil
+}
+
+// Marshal encodes a. A nil TargetMAC is encoded as zeros.
+func (a *ARPPacket) Marshal() ([]byte, error) {
+	if len(a.SenderMAC) != arpHardwareSize || a.TargetMAC != nil && len(a.TargetMAC) != arpHardwareSize {
+		return nil, errors.New("ARP needs 6-byte MAC addresses")
+	}
+	if !a.SenderIP.Unmap().Is4() || !a.TargetIP.Unmap().Is4() {
+		return nil, errors.New("ARP needs IPv4 addresses")
+	}
+	b := make([]byte, arpLength)
+	binary.BigEndian.PutUint16(b[0:2], arpHardwareEth)
+	binary.BigEndian.PutUint16(b[2:4], EtherTypeIPv4)
+	b[4] = arpHardwareSize
+	b[5] = arpProtocolSize
+	binary.BigEndian.PutUint16(b[6:8], a.Operation)
+	copy(b[8:14], a.SenderMAC)
+	sender, target := a.SenderIP.Unmap().As4(), a.TargetIP.Unmap().As4()
+	copy(b[14:18], sender[:])
+	copy(b[18:24], a.TargetMAC)
+	copy(b[24:28], target[:])
+	return b, nil
+}
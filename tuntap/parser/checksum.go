@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"encoding/binary"
+)
+
+// ChecksumAdd adds the 16-bit big endian words of b to the ones'
+// complement sum, padding an odd trailing byte with zero.
+func ChecksumAdd(sum uint32, b []byte) uint32 {
+	for len(b) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	return sum
+}
+
+// ChecksumFold folds sum into 16 bits and returns its complement, the
+// value to store in a checksum field.
+func ChecksumFold(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// PseudoHeaderSum returns the sum of the IPv4 or IPv6 pseudo-header
+// covered by TCP, UDP and ICMPv6 checksums.
+func PseudoHeaderSum(src, dst []byte, proto uint8, length int) uint32 {
+	sum := ChecksumAdd(0, src)
+	sum = ChecksumAdd(sum, dst)
+	sum += uint32(proto)
+	sum += uint32(length >> 16)
+	sum += uint32(length & 0xffff)
+	return sum
+}
+
+// ChecksumUpdate returns the checksum csum updated for the bytes old of
+// the data it covers being replaced by new, without summing the rest of
+// the data again (RFC 1624). old and new have the same even length.
+func ChecksumUpdate(csum uint16, old, new []byte) uint16 {
+	sum := uint32(^csum)
+	for i := 0; i+1 < len(old); i += 2 {
+		sum += uint32(^binary.BigEndian.Uint16(old[i:]))
+		sum += uint32(binary.BigEndian.Uint16(new[i:]))
+	}
+	return ChecksumFold(sum)
+}
@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestChecksumFold(t *testing.T) {
+	// The example of RFC 1071 section 3.
+	b := []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}
+	if got := ChecksumFold(ChecksumAdd(0, b)); got != ^uint16(0xddf2) {
+		t.Errorf("got %#04x, want %#04x", got, ^uint16(0xddf2))
+	}
+	// An odd trailing byte is padded with zero.
+	if got, want := ChecksumAdd(0, []byte{1, 2, 3}), ChecksumAdd(0, []byte{1, 2, 3, 0}); got != want {
+		t.Errorf("got sum %#x with an odd length, want %#x", got, want)
+	}
+}
+
+func TestChecksumUpdate(t *testing.T) {
+	data := make([]byte, 64)
+	for i := range data {
+		data[i] = byte(i*37 + 11)
+	}
+	tests := []struct {
+		name string
+		off  int
+		new  []byte
+	}{
+		{"word", 10, []byte{0xab, 0xcd}},
+		{"address", 12, []byte{10, 0, 0, 1}},
+		{"zeros", 20, []byte{0, 0, 0, 0, 0, 0}},
+		{"ones", 32, []byte{0xff, 0xff, 0xff, 0xff}},
+		{"unchanged", 40, data[40:44]},
+		{"whole", 0, make([]byte, 64)},
+	}
+	for _, tt := range tests {
+		b := append([]byte(nil), data...)
+		csum := ChecksumFold(ChecksumAdd(0, b))
+		old := append([]byte(nil), b[tt.off:tt.off+len(tt.new)]...)
+		copy(b[tt.off:], tt.new)
+		want := ChecksumFold(ChecksumAdd(0, b))
+		got := ChecksumUpdate(csum, old, tt.new)
+		// Zero data sums to -0, which the update may give as +0.
+		if got != want && !(got == 0 && want == 0xffff) {
+			t.Errorf("%s: got %#04x, want %#04x", tt.name, got, want)
+		}
+	}
+}
+
+func TestChecksumUpdateVerifies(t *testing.T) {
+	// A header carrying its own checksum still verifies after updates.
+	b := ipv4Packet(ProtoUDP, nil, nil, nil)
+	for _, addr := range [][]byte{{10, 0, 0, 1}, {255, 255, 255, 255}, {0, 0, 0, 0}, {172, 16, 254, 3}} {
+		old := append([]byte(nil), b[12:16]...)
+		copy(b[12:16], addr)
+		csum := ChecksumUpdate(binary.BigEndian.Uint16(b[10:12]), old, addr)
+		binary.BigEndian.PutUint16(b[10:12], csum)
+		if !VerifyIPv4Checksum(b) {
+			t.Errorf("checksum %#04x not verifying after setting %v", csum, addr)
+		}
+		if csum != IPv4Checksum(b) {
+			t.Errorf("got %#04x for %v, want %#04x", csum, addr, IPv4Checksum(b))
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package parser
+
+import (
+	"errors"
+)
+
+// Errors matched with errors.Is by the errors of the parsing functions.
+var (
+	// The data isn't an IP packet, or not of the expected IP version.
+	ErrNotIP = errors.New("Not an IP packet")
+	// A length field doesn't match the data.
+	ErrLengthMismatch = errors.New("Payload length not matching")
+	// The data ends before a header it should contain.
+	ErrTruncated = errors.New("Truncated packet")
+)
+
+var (
+	errNotIPv4        = &wrapError{"Not an IPv4 packet", ErrNotIP}
+	errNotIPv6        = &wrapError{"Not an IPv6 packet", ErrNotIP}
+	errIPv4HeaderLen  = &wrapError{"Invalid IPv4 header length", ErrLengthMismatch}
+	errShortEthernet  = &wrapError{"Not an Ethernet frame", ErrTruncated}
+	errShortExtension = &wrapError{"Truncated IPv6 extension header", ErrTruncated}
+	errShortTCP       = &wrapError{"Not a TCP segment", ErrTruncated}
+	errTCPHeaderLen   = &wrapError{"Invalid TCP header length", ErrLengthMismatch}
+	errShortUDP       = &wrapError{"Not a UDP datagram", ErrTruncated}
+	errUDPLen         = &wrapError{"Invalid UDP length", ErrLengthMismatch}
+	errShortICMPv4    = &wrapError{"Not an ICMPv4 message", ErrTruncated}
+	errShortICMPv6    = &wrapError{"Not an ICMPv6 message", ErrTruncated}
+	errIPHeaderLen    = &wrapError{"Invalid IP header length", ErrLengthMismatch}
+	errShortGRE       = &wrapError{"Not a GRE packet", ErrTruncated}
+	errShortVXLAN     = &wrapError{"Not a VXLAN packet", ErrTruncated}
+	errShortARP       = &wrapError{"Not an ARP packet", ErrTruncated}
+)
+
+// wrapError is an error with its own message that errors.Is matches
+// with a more general one.
+type wrapError struct {
+	msg string
+	err error
+}
+
+func (e *wrapError) Error() string {
+	return e.msg
+}
+
+func (e *wrapError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const (
+	ethHeaderLength = 14
+	dot1QTagLength  = 4
+)
+
+// Ethernet types.
+const (
+	EtherTypeIPv4  = 0x0800
+	EtherTypeARP   = 0x0806
+	EtherTypeDot1Q = 0x8100
+	EtherTypeIPv6  = 0x86dd
+	// Smaller values are 802.3 length fields, not Ethernet types.
+	EtherTypeMinimum = 0x0600
+)
+
+// VLANTag is an IEEE 802.1Q tag.
+type VLANTag struct {
+	// Priority code point, 0-7.
+	Priority uint8
+	// Drop eligible indicator.
+	DropEligible bool
+	// VLAN identifier, 0-4095.
+	ID uint16
+}
+
+// EthernetFrame is an Ethernet II frame, as exchanged with a TAP
+// interface.
+type EthernetFrame struct {
+	DstMAC net.HardwareAddr
+	SrcMAC net.HardwareAddr
+	// The 802.1Q tag, nil for untagged frames.
+	VLAN *VLANTag
+	// The Ethernet type of the payload, after the VLAN tag if any.
+	EtherType int
+	Payload   []byte
+}
+
+// ParseEthernet decodes the Ethernet frame in b. The returned frame
+// references b.
+func ParseEthernet(b []byte) (*EthernetFrame, error) {
+	if len(b) < ethHeaderLength {
+		return nil, errShortEthernet
+	}
+	f := &EthernetFrame{
+		DstMAC: net.HardwareAddr(b[0:6]),
+		SrcMAC: net.HardwareAddr(b[6:12]),
+	}
+	typ := int(binary.BigEndian.Uint16(b[12:14]))
+	off := ethHeaderLength
+	if typ == EtherTypeDot1Q {
+		if len(b) < ethHeaderLength+dot1QTagLength {
+			return nil, errShortEthernet
+		}
+		tci := binary.BigEndian.Uint16(b[14:16])
+		f.VLAN = &VLANTag{
+			Priority:     uint8(tci >> 13),
+			DropEligible: tci&0x1000 != 0,
+			ID:           tci & 0x0fff,
+		}
+		typ = int(binary.BigEndian.Uint16(b[16:18]))
+		off += dot1QTagLength
+	}
+	if typ < EtherTypeMinimum {
+		return nil, errors.New("802.3 length fields are not supported")
+	}
+	f.EtherType = typ
+	f.Payload = b[off:]
+	return f, nil
+}
+
+// Header returns the encoded Ethernet (and 802.1Q) header of f.
+func (f *EthernetFrame) Header() ([]byte, error) {
+	if len(f.DstMAC) != 6 || len(f.SrcMAC) != 6 {
+		return nil, errors.New("MAC addresses must be 6 bytes long")
+	}
+	n := ethHeaderLength
+	if f.VLAN != nil {
+		n += dot1QTagLength
+	}
+	b := make([]byte, n)
+	copy(b[0:6], f.DstMAC)
+	copy(b[6:12], f.SrcMAC)
+	off := 12
+	if f.VLAN != nil {
+		tci := uint16(f.VLAN.Priority&0x7)<<13 | f.VLAN.ID&0x0fff
+		if f.VLAN.DropEligible {
+			tci |= 0x1000
+		}
+		binary.BigEndian.PutUint16(b[12:14], EtherTypeDot1Q)
+		binary.BigEndian.PutUint16(b[14:16], tci)
+		off += dot1QTagLength
+	}
+	binary.BigEndian.PutUint16(b[off:off+2], uint16(f.EtherType))
+	return b, nil
+}
+
+// Marshal returns the wire encoding of the frame.
+func (f *EthernetFrame) Marshal() ([]byte, error) {
+	b, err := f.Header()
+	if err != nil {
+		return nil, err
+	}
+	return append(b, f.Payload...), nil
+}
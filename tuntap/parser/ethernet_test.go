@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseEthernet(t *testing.T) {
+	dst := []byte{0x02, 0, 0, 0, 0, 1}
+	src := []byte{0x02, 0, 0, 0, 0, 2}
+	payload := []byte{0x45, 0, 0, 20}
+	cat := func(bs ...[]byte) []byte { return bytes.Join(bs, nil) }
+
+	tests := []struct {
+		name      string
+		b         []byte
+		header    int
+		vlan      *VLANTag
+		etherType int
+		err       error
+	}{
+		{"IPv4", cat(dst, src, []byte{0x08, 0x00}, payload), 14, nil, EtherTypeIPv4, nil},
+		{"no payload", cat(dst, src, []byte{0x86, 0xdd}), 14, nil, EtherTypeIPv6, nil},
+		{
+			"VLAN", cat(dst, src, []byte{0x81, 0x00, 0xb0, 0x2a, 0x86, 0xdd}, payload), 18,
+			&VLANTag{Priority: 5, DropEligible: true, ID: 42}, EtherTypeIPv6, nil,
+		},
+		{"truncated header", cat(dst, src, []byte{0x08}), 0, nil, 0, ErrTruncated},
+		{"truncated VLAN tag", cat(dst, src, []byte{0x81, 0x00, 0, 1, 0x08}), 0, nil, 0, ErrTruncated},
+		{"802.3 length", cat(dst, src, []byte{0x00, 0x04}, payload), 0, nil, 0, nil},
+	}
+	for _, tt := range tests {
+		f, err := ParseEthernet(tt.b)
+		if tt.header == 0 {
+			if err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(f.DstMAC, dst) || !bytes.Equal(f.SrcMAC, src) {
+			t.Errorf("%s: got addresses %v and %v", tt.name, f.DstMAC, f.SrcMAC)
+		}
+		if f.EtherType != tt.etherType {
+			t.Errorf("%s: got EtherType %#x, want %#x", tt.name, f.EtherType, tt.etherType)
+		}
+		if (f.VLAN == nil) != (tt.vlan == nil) || f.VLAN != nil && *f.VLAN != *tt.vlan {
+			t.Errorf("%s: got VLAN tag %+v, want %+v", tt.name, f.VLAN, tt.vlan)
+		}
+		if !bytes.Equal(f.Payload, tt.b[tt.header:]) {
+			t.Errorf("%s: got payload %v", tt.name, f.Payload)
+		}
+
+		// The header encodes back to the same bytes.
+		if h, err := f.Header(); err != nil || !bytes.Equal(h, tt.b[:tt.header]) {
+			t.Errorf("%s: Header() = %x, %v, want %x", tt.name, h, err, tt.b[:tt.header])
+		}
+	}
+}
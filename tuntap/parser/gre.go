@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// IP protocol number of GRE.
+const ProtoGRE = 47
+
+// Ethernet type of the Ethernet frames carried by GRE, as with Linux
+// gretap devices.
+const EtherTypeTransparentEthernet = 0x6558
+
+const greMinHeaderLength = 4
+
+// GRE flags, in the first byte of the header.
+const (
+	greFlagChecksum = 0x80
+	greFlagRouting  = 0x40
+	greFlagKey      = 0x20
+	greFlagSequence = 0x10
+	greFlagStrict   = 0x08
+	// The recursion control of RFC 1701.
+	greRecursion = 0x07
+)
+
+// GREHeader is a decoded GRE header (RFC 2784), with the key and
+// sequence number extensions of RFC 2890.
+type GREHeader struct {
+	// Whether the header carries a checksum of the header and payload.
+	ChecksumPresent bool
+	Checksum        uint16
+	KeyPresent      bool
+	Key             uint32
+	SequencePresent bool
+	Sequence        uint32
+	// The Ethernet type of the payload.
+	Protocol int
+}
+
+// Unmarshal decodes the GRE header at the start of b, without verifying
+// its checksum. The payload starts after Length bytes. Only version 0
+// headers without the routing fields of RFC 1701 are supported.
+func (h *GREHeader) Unmarshal(b []byte) error {
+	if len(b) < greMinHeaderLength {
+		return errShortGRE
+	}
+	if b[0]&(greFlagRouting|greFlagStrict|greRecursion) != 0 {
+		return errors.New("Unsupported GRE flags")
+	}
+	if b[1]&0x07 != 0 {
+		return errors.New("Unsupported GRE version")
+	}
+	*h = GREHeader{
+		ChecksumPresent: b[0]&greFlagChecksum != 0,
+		KeyPresent:      b[0]&greFlagKey != 0,
+		SequencePresent: b[0]&greFlagSequence != 0,
+		Protocol:        int(binary.BigEndian.Uint16(b[2:4])),
+	}
+	if len(b) < h.Length() {
+		return errShortGRE
+	}
+	off := greMinHeaderLength
+	if h.ChecksumPresent {
+		h.Checksum = binary.BigEndian.Uint16(b[off : off+2])
+		off += 4
+	}
+	if h.KeyPresent {
+		h.Key = binary.BigEndian.Uint32(b[off : off+4])
+		off += 4
+	}
+	if h.SequencePresent {
+		h.Sequence = binary.BigEndian.Uint32(b[off : off+4])
+	}
+	return nil
+}
+
+// Length returns the length of the encoded header, depending on the
+// fields present.
+func (h *GREHeader) Length() int {
+	n := greMinHeaderLength
+	if h.ChecksumPresent {
+		n += 4
+	}
+	if h.KeyPresent {
+		n += 4
+	}
+	if h.SequencePresent {
+		n += 4
+	}
+	return n
+}
+
+// Marshal encodes h. The checksum is copied from h: compute it with
+// GREChecksum once the payload is appended.
+func (h *GREHeader) Marshal() []byte {
+	b := make([]byte, h.Length())
+	binary.BigEndian.PutUint16(b[2:4], uint16(h.Protocol))
+	off := greMinHeaderLength
+	if h.ChecksumPresent {
+		b[0] |= greFlagChecksum
+		binary.BigEndian.PutUint16(b[off:off+2], h.Checksum)
+		off += 4
+	}
+	if h.KeyPresent {
+		b[0] |= greFlagKey
+		binary.BigEndian.PutUint32(b[off:off+4], h.Key)
+		off += 4
+	}
+	if h.SequencePresent {
+		b[0] |= greFlagSequence
+		binary.BigEndian.PutUint32(b[off:off+4], h.Sequence)
+	}
+	return b
+}
+
+// GREChecksum computes the checksum of the GRE packet b with a checksum
+// field, ignoring its current value.
+func GREChecksum(b []byte) uint16 {
+	return ChecksumFold(ChecksumAdd(ChecksumAdd(0, b[:4]), b[6:]))
+}
+
+// VerifyGREChecksum tells whether the checksum of the GRE packet b is
+// correct. Packets without a checksum field are accepted.
+func VerifyGREChecksum(b []byte) bool {
+	if len(b) < greMinHeaderLength {
+		return false
+	}
+	if b[0]&greFlagChecksum == 0 {
+		return true
+	}
+	return len(b) >= greMinHeaderLength+4 && ChecksumFold(ChecksumAdd(0, b)) == 0
+}
@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ICMPEcho is the body of an ICMP or ICMPv6 echo request or reply.
+type ICMPEcho struct {
+	ID   uint16
+	Seq  uint16
+	Data []byte
+}
+
+// ParseICMPEcho decodes an echo message body. Data references b.
+func ParseICMPEcho(b []byte) (*ICMPEcho, error) {
+	if len(b) < 4 {
+		return nil, errors.New("Invalid ICMP echo length")
+	}
+	return &ICMPEcho{
+		ID:   binary.BigEndian.Uint16(b[0:2]),
+		Seq:  binary.BigEndian.Uint16(b[2:4]),
+		Data: b[4:],
+	}, nil
+}
+
+// Marshal encodes e as a message body.
+func (e *ICMPEcho) Marshal() []byte {
+	b := make([]byte, 4+len(e.Data))
+	binary.BigEndian.PutUint16(b[0:2], e.ID)
+	binary.BigEndian.PutUint16(b[2:4], e.Seq)
+	copy(b[4:], e.Data)
+	return b
+}
+
+// ICMPError is the body of an ICMP or ICMPv6 error message, such as
+// destination unreachable or time exceeded.
+type ICMPError struct {
+	// The 32-bit field preceding the invoking packet: the MTU of
+	// packet too big messages, the pointer of parameter problems, or
+	// zero.
+	Param uint32
+	// The start of the packet that caused the error.
+	Packet []byte
+}
+
+// ParseICMPError decodes an error message body. Packet references b.
+func ParseICMPError(b []byte) (*ICMPError, error) {
+	if len(b) < 4 {
+		return nil, errors.New("Invalid ICMP error length")
+	}
+	return &ICMPError{Param: binary.BigEndian.Uint32(b[0:4]), Packet: b[4:]}, nil
+}
+
+// Marshal encodes e as a message body. limit is the largest size of the
+// encoded body, the invoking packet is truncated to fit.
+func (e *ICMPError) Marshal(limit int) []byte {
+	invoking := e.Packet
+	if limit >= 4 && len(invoking) > limit-4 {
+		invoking = invoking[:limit-4]
+	}
+	b := make([]byte, 4+len(invoking))
+	binary.BigEndian.PutUint32(b[0:4], e.Param)
+	copy(b[4:], invoking)
+	return b
+}
@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"encoding/binary"
+)
+
+// IP protocol number of ICMP.
+const ProtoICMP = 1
+
+// ICMPv4 message types.
+const (
+	ICMPv4EchoReply       = 0
+	ICMPv4DestUnreachable = 3
+	ICMPv4Redirect        = 5
+	ICMPv4EchoRequest     = 8
+	ICMPv4TimeExceeded    = 11
+	ICMPv4ParamProblem    = 12
+)
+
+// ICMPv4 destination unreachable codes.
+const (
+	ICMPv4NetUnreachable      = 0
+	ICMPv4HostUnreachable     = 1
+	ICMPv4ProtoUnreachable    = 2
+	ICMPv4PortUnreachable     = 3
+	ICMPv4FragmentationNeeded = 4
+	ICMPv4AdminProhibited     = 13
+)
+
+// ICMPv4 time exceeded codes.
+const (
+	ICMPv4TTLExceeded        = 0
+	ICMPv4ReassemblyExceeded = 1
+)
+
+// The invoking packet of ICMPv4 error messages is truncated so that the
+// error fits in 576 bytes (RFC 1812 section 4.3.2.3).
+const icmpv4ErrorLimit = 576 - ipv4MinHeaderLength - 4
+
+// ICMPv4Message is a decoded ICMPv4 message.
+type ICMPv4Message struct {
+	Type     uint8
+	Code     uint8
+	Checksum uint16
+	// The rest of the message, decoded by ParseICMPEcho or
+	// ParseICMPError depending on Type.
+	Body []byte
+}
+
+// Unmarshal decodes the ICMPv4 message in b, without verifying its
+// checksum. Body references b.
+func (m *ICMPv4Message) Unmarshal(b []byte) error {
+	if len(b) < 4 {
+		return errShortICMPv4
+	}
+	*m = ICMPv4Message{
+		Type:     b[0],
+		Code:     b[1],
+		Checksum: binary.BigEndian.Uint16(b[2:4]),
+		Body:     b[4:],
+	}
+	return nil
+}
+
+// Marshal encodes m, filling in Checksum.
+func (m *ICMPv4Message) Marshal() []byte {
+	b := make([]byte, 4+len(m.Body))
+	b[0] = m.Type
+	b[1] = m.Code
+	copy(b[4:], m.Body)
+	m.Checksum = ICMPv4Checksum(b)
+	binary.BigEndian.PutUint16(b[2:4], m.Checksum)
+	return b
+}
+
+// ICMPv4Checksum computes the checksum of the ICMPv4 message b,
+// ignoring the current value of its checksum field.
+func ICMPv4Checksum(b []byte) uint16 {
+	return ChecksumFold(ChecksumAdd(ChecksumAdd(0, b[:2]), b[4:]))
+}
+
+// VerifyICMPv4Checksum tells whether the checksum of the ICMPv4 message
+// b is correct.
+func VerifyICMPv4Checksum(b []byte) bool {
+	return len(b) >= 4 && ChecksumFold(ChecksumAdd(0, b)) == 0
+}
+
+// NewICMPv4Error returns an error message of the given type about the
+// invoking packet, truncated to keep the error within 576 bytes. param
+// is the next-hop MTU of fragmentation needed messages, the pointer of
+// parameter problems shifted left by 24 bits, and zero otherwise.
+func NewICMPv4Error(typ, code uint8, param uint32, invoking []byte) *ICMPv4Message {
+	e := ICMPError{Param: param, Packet: invoking}
+	return &ICMPv4Message{Type: typ, Code: code, Body: e.Marshal(icmpv4ErrorLimit)}
+}
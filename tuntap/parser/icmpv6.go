@@ -0,0 +1,363 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+)
+
+// IP protocol number of ICMPv6.
+const ProtoICMPv6 = 58
+
+// ICMPv6 message types.
+const (
+	ICMPv6DestUnreachable       = 1
+	ICMPv6PacketTooBig          = 2
+	ICMPv6TimeExceeded          = 3
+	ICMPv6ParamProblem          = 4
+	ICMPv6EchoRequest           = 128
+	ICMPv6EchoReply             = 129
+	ICMPv6RouterSolicitation    = 133
+	ICMPv6RouterAdvertisement   = 134
+	ICMPv6NeighborSolicitation  = 135
+	ICMPv6NeighborAdvertisement = 136
+	ICMPv6Redirect              = 137
+)
+
+// ICMPv6 destination unreachable codes.
+const (
+	ICMPv6NoRoute         = 0
+	ICMPv6AdminProhibited = 1
+	ICMPv6AddrUnreachable = 3
+	ICMPv6PortUnreachable = 4
+	ICMPv6RejectRoute     = 6
+)
+
+// ICMPv6 time exceeded codes.
+const (
+	ICMPv6HopLimitExceeded   = 0
+	ICMPv6ReassemblyExceeded = 1
+)
+
+// The invoking packet of ICMPv6 error messages is truncated so that the
+// error fits in the minimum IPv6 MTU.
+const icmpv6ErrorLimit = 1280 - ipv6HeaderLength - 4
+
+// ICMPv6Message is a decoded ICMPv6 message.
+type ICMPv6Message struct {
+	Type     uint8
+	Code     uint8
+	Checksum uint16
+	// The rest of the message, decoded by ParseICMPEcho, ParseICMPError
+	// or the NDP functions depending on Type.
+	Body []byte
+}
+
+// Unmarshal decodes the ICMPv6 message in b, without verifying its
+// checksum. Body references b.
+func (m *ICMPv6Message) Unmarshal(b []byte) error {
+	if len(b) < 4 {
+		return errShortICMPv6
+	}
+	*m = ICMPv6Message{
+		Type:     b[0],
+		Code:     b[1],
+		Checksum: binary.BigEndian.Uint16(b[2:4]),
+		Body:     b[4:],
+	}
+	return nil
+}
+
+// Marshal encodes m as sent from src to dst, filling in Checksum.
+func (m *ICMPv6Message) Marshal(src, dst netip.Addr) []byte {
+	b := make([]byte, 4+len(m.Body))
+	b[0] = m.Type
+	b[1] = m.Code
+	copy(b[4:], m.Body)
+	m.Checksum = ICMPv6Checksum(src, dst, b)
+	binary.BigEndian.PutUint16(b[2:4], m.Checksum)
+	return b
+}
+
+// ICMPv6Checksum computes the checksum of the ICMPv6 message b sent
+// from src to dst, ignoring the current value of its checksum field.
+func ICMPv6Checksum(src, dst netip.Addr, b []byte) uint16 {
+	return transportChecksum(src, dst, ProtoICMPv6, b, 2)
+}
+
+// VerifyICMPv6Checksum tells whether the checksum of the ICMPv6 message
+// b sent from src to dst is correct.
+func VerifyICMPv6Checksum(src, dst netip.Addr, b []byte) bool {
+	return len(b) >= 4 && verifyTransportChecksum(src, dst, ProtoICMPv6, b)
+}
+
+// NewICMPv6Error returns an error message of the given type about the
+// invoking packet, truncated to keep the error within the minimum IPv6
+// MTU. param is the MTU of packet too big messages, the pointer of
+// parameter problems, and zero otherwise.
+func NewICMPv6Error(typ, code uint8, param uint32, invoking []byte) *ICMPv6Message {
+	e := ICMPError{Param: param, Packet: invoking}
+	return &ICMPv6Message{Type: typ, Code: code, Body: e.Marshal(icmpv6ErrorLimit)}
+}
+
+// NDP option types.
+const (
+	NDPSourceLinkLayerAddr = 1
+	NDPTargetLinkLayerAddr = 2
+	NDPPrefixInfo          = 3
+	NDPRedirectedHeader    = 4
+	NDPMTU                 = 5
+	NDPRDNSS               = 25
+)
+
+// NDPOption is a neighbor discovery option.
+type NDPOption struct {
+	Type uint8
+	// The option value, without the type and length bytes.
+	Data []byte
+}
+
+// NDPLinkLayerAddr returns a source or target link-layer address option.
+func NDPLinkLayerAddr(typ uint8, addr net.HardwareAddr) NDPOption {
+	return NDPOption{Type: typ, Data: addr}
+}
+
+// LinkLayerAddr returns the address carried by a source or target
+// link-layer address option.
+func (o NDPOption) LinkLayerAddr() net.HardwareAddr {
+	return net.HardwareAddr(o.Data)
+}
+
+// NDPMTUOption returns an MTU option.
+func NDPMTUOption(mtu uint32) NDPOption {
+	b := make([]byte, 6)
+	binary.BigEndian.PutUint32(b[2:6], mtu)
+	return NDPOption{Type: NDPMTU, Data: b}
+}
+
+// NDPRDNSSOption returns a recursive DNS server option (RFC 8106)
+// giving servers for lifetime seconds.
+func NDPRDNSSOption(lifetime uint32, servers []netip.Addr) NDPOption {
+	b := make([]byte, 6, 6+16*len(servers))
+	binary.BigEndian.PutUint32(b[2:6], lifetime)
+	for _, s := range servers {
+		a := s.As16()
+		b = append(b, a[:]...)
+	}
+	return NDPOption{Type: NDPRDNSS, Data: b}
+}
+
+// NDPPrefix is the value of a prefix information option.
+type NDPPrefix struct {
+	Prefix     netip.Prefix
+	OnLink     bool
+	Autonomous bool
+	// Lifetimes in seconds, 0xffffffff meaning infinity.
+	ValidLifetime     uint32
+	PreferredLifetime uint32
+}
+
+// Option encodes p as a prefix information option.
+func (p NDPPrefix) Option() NDPOption {
+	b := make([]byte, 30)
+	b[0] = uint8(p.Prefix.Bits())
+	if p.OnLink {
+		b[1] |= 0x80
+	}
+	if p.Autonomous {
+		b[1] |= 0x40
+	}
+	binary.BigEndian.PutUint32(b[2:6], p.ValidLifetime)
+	binary.BigEndian.PutUint32(b[6:10], p.PreferredLifetime)
+	a := p.Prefix.Masked().Addr().As16()
+	copy(b[14:30], a[:])
+	return NDPOption{Type: NDPPrefixInfo, Data: b}
+}
+
+// Prefix decodes a prefix information option.
+func (o NDPOption) Prefix() (*NDPPrefix, error) {
+	if o.Type != NDPPrefixInfo || len(o.Data) < 30 || o.Data[0] > 128 {
+		return nil, errors.New("Invalid NDP prefix information")
+	}
+	addr := netip.AddrFrom16([16]byte(o.Data[14:30]))
+	return &NDPPrefix{
+		Prefix:            netip.PrefixFrom(addr, int(o.Data[0])),
+		OnLink:            o.Data[1]&0x80 != 0,
+		Autonomous:        o.Data[1]&0x40 != 0,
+		ValidLifetime:     binary.BigEndian.Uint32(o.Data[2:6]),
+		PreferredLifetime: binary.BigEndian.Uint32(o.Data[6:10]),
+	}, nil
+}
+
+// ParseNDPOptions decodes the options ending an NDP message body. The
+// values reference b.
+func ParseNDPOptions(b []byte) ([]NDPOption, error) {
+	var opts []NDPOption
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errors.New("Invalid NDP option length")
+		}
+		n := int(b[1]) * 8
+		if n == 0 || n > len(b) {
+			return nil, errors.New("Invalid NDP option length")
+		}
+		opts = append(opts, NDPOption{Type: b[0], Data: b[2:n]})
+		b = b[n:]
+	}
+	return opts, nil
+}
+
+// appendNDPOptions encodes opts after b, padding each to a multiple of
+// 8 bytes.
+func appendNDPOptions(b []byte, opts []NDPOption) []byte {
+	for _, o := range opts {
+		n := (len(o.Data) + 2 + 7) &^ 7
+		b = append(b, o.Type, uint8(n/8))
+		b = append(b, o.Data...)
+		b = append(b, make([]byte, n-2-len(o.Data))...)
+	}
+	return b
+}
+
+// RouterSolicitation is the body of a router solicitation.
+type RouterSolicitation struct {
+	Options []NDPOption
+}
+
+// ParseRouterSolicitation decodes a router solicitation body.
+func ParseRouterSolicitation(b []byte) (*RouterSolicitation, error) {
+	if len(b) < 4 {
+		return nil, errors.New("Invalid router solicitation length")
+	}
+	opts, err := ParseNDPOptions(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &RouterSolicitation{Options: opts}, nil
+}
+
+// Marshal encodes m as a message body.
+func (m *RouterSolicitation) Marshal() []byte {
+	return appendNDPOptions(make([]byte, 4), m.Options)
+}
+
+// RouterAdvertisement is the body of a router advertisement.
+type RouterAdvertisement struct {
+	HopLimit uint8
+	Managed  bool
+	Other    bool
+	// Lifetime of the default route in seconds, zero if the router
+	// isn't a default router.
+	RouterLifetime uint16
+	// In milliseconds, zero if unspecified.
+	ReachableTime uint32
+	RetransTimer  uint32
+	Options       []NDPOption
+}
+
+// ParseRouterAdvertisement decodes a router advertisement body.
+func ParseRouterAdvertisement(b []byte) (*RouterAdvertisement, error) {
+	if len(b) < 12 {
+		return nil, errors.New("Invalid router advertisement length")
+	}
+	opts, err := ParseNDPOptions(b[12:])
+	if err != nil {
+		return nil, err
+	}
+	return &RouterAdvertisement{
+		HopLimit:       b[0],
+		Managed:        b[1]&0x80 != 0,
+		Other:          b[1]&0x40 != 0,
+		RouterLifetime: binary.BigEndian.Uint16(b[2:4]),
+		ReachableTime:  binary.BigEndian.Uint32(b[4:8]),
+		RetransTimer:   binary.BigEndian.Uint32(b[8:12]),
+		Options:        opts,
+	}, nil
+}
+
+// Marshal encodes m as a message body.
+func (m *RouterAdvertisement) Marshal() []byte {
+	b := make([]byte, 12)
+	b[0] = m.HopLimit
+	if m.Managed {
+		b[1] |= 0x80
+	}
+	if m.Other {
+		b[1] |= 0x40
+	}
+	binary.BigEndian.PutUint16(b[2:4], m.RouterLifetime)
+	binary.BigEndian.PutUint32(b[4:8], m.ReachableTime)
+	binary.BigEndian.PutUint32(b[8:12], m.RetransTimer)
+	return appendNDPOptions(b, m.Options)
+}
+
+// NeighborSolicitation is the body of a neighbor solicitation.
+type NeighborSolicitation struct {
+	Target  netip.Addr
+	Options []NDPOption
+}
+
+// ParseNeighborSolicitation decodes a neighbor solicitation body.
+func ParseNeighborSolicitation(b []byte) (*NeighborSolicitation, error) {
+	if len(b) < 20 {
+		return nil, errors.New("Invalid neighbor solicitation length")
+	}
+	opts, err := ParseNDPOptions(b[20:])
+	if err != nil {
+		return nil, err
+	}
+	return &NeighborSolicitation{Target: netip.AddrFrom16([16]byte(b[4:20])), Options: opts}, nil
+}
+
+// Marshal encodes m as a message body.
+func (m *NeighborSolicitation) Marshal() []byte {
+	b := make([]byte, 20)
+	a := m.Target.As16()
+	copy(b[4:20], a[:])
+	return appendNDPOptions(b, m.Options)
+}
+
+// NeighborAdvertisement is the body of a neighbor advertisement.
+type NeighborAdvertisement struct {
+	Router    bool
+	Solicited bool
+	Override  bool
+	Target    netip.Addr
+	Options   []NDPOption
+}
+
+// ParseNeighborAdvertisement decodes a neighbor advertisement body.
+func ParseNeighborAdvertisement(b []byte) (*NeighborAdvertisement, error) {
+	if len(b) < 20 {
+		return nil, errors.New("Invalid neighbor advertisement length")
+	}
+	opts, err := ParseNDPOptions(b[20:])
+	if err != nil {
+		return nil, err
+	}
+	return &NeighborAdvertisement{
+		Router:    b[0]&0x80 != 0,
+		Solicited: b[0]&0x40 != 0,
+		Override:  b[0]&0x20 != 0,
+		Target:    netip.AddrFrom16([16]byte(b[4:20])),
+		Options:   opts,
+	}, nil
+}
+
+// Marshal encodes m as a message body.
+func (m *NeighborAdvertisement) Marshal() []byte {
+	b := make([]byte, 20)
+	if m.Router {
+		b[0] |= 0x80
+	}
+	if m.Solicited {
+		b[0] |= 0x40
+	}
+	if m.Override {
+		b[0] |= 0x20
+	}
+	a := m.Target.As16()
+	copy(b[4:20], a[:])
+	return appendNDPOptions(b, m.Options)
+}
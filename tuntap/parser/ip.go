@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"encoding/binary"
+)
+
+const (
+	ipv4MinHeaderLength = 20
+	ipv6HeaderLength    = 40
+)
+
+// Packet is an IP packet split into its header and payload.
+type Packet struct {
+	// IP version, 4 or 6.
+	Version int
+	// The IPv4 header including its options, or the fixed IPv6 header.
+	Header []byte
+	// The payload, as long as the header says. For IPv6, it starts
+	// with the extension headers if any.
+	Payload []byte
+}
+
+// ParseIP decodes the IPv4 or IPv6 packet in b, depending on its
+// version.
+func ParseIP(b []byte) (*Packet, error) {
+	if len(b) == 0 {
+		return nil, ErrNotIP
+	}
+	switch b[0] >> 4 {
+	case 4:
+		return ParseIPv4(b)
+	case 6:
+		return ParseIPv6(b)
+	}
+	return nil, ErrNotIP
+}
+
+// ParseIPv4 decodes the IPv4 packet in b. Bytes past the total length
+// given by the header, like Ethernet padding, are ignored.
+func ParseIPv4(b []byte) (*Packet, error) {
+	if len(b) < ipv4MinHeaderLength || b[0]>>4 != 4 {
+		return nil, errNotIPv4
+	}
+	hlen := int(b[0]&0x0f) * 4
+	if hlen < ipv4MinHeaderLength || hlen > len(b) {
+		return nil, errIPv4HeaderLen
+	}
+	total := int(binary.BigEndian.Uint16(b[2:4]))
+	if total < hlen || total > len(b) {
+		return nil, ErrLengthMismatch
+	}
+	return &Packet{Version: 4, Header: b[:hlen], Payload: b[hlen:total]}, nil
+}
+
+// ParseIPv6 decodes the IPv6 packet in b. Bytes past the payload length
+// given by the header, like Ethernet padding, are ignored.
+func ParseIPv6(b []byte) (*Packet, error) {
+	if len(b) < ipv6HeaderLength || b[0]>>4 != 6 {
+		return nil, errNotIPv6
+	}
+	end := ipv6HeaderLength + int(binary.BigEndian.Uint16(b[4:6]))
+	if end > len(b) {
+		return nil, ErrLengthMismatch
+	}
+	return &Packet{Version: 6, Header: b[:ipv6HeaderLength], Payload: b[ipv6HeaderLength:end]}, nil
+}
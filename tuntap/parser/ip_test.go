@@ -0,0 +1,195 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// ipv4Packet returns an IPv4 packet of protocol proto carrying payload,
+// with the given header options and bytes past its total length.
+func ipv4Packet(proto uint8, options, payload, trailer []byte) []byte {
+	hlen := ipv4MinHeaderLength + len(options)
+	b := make([]byte, hlen, hlen+len(payload)+len(trailer))
+	b[0] = 4<<4 | uint8(hlen/4)
+	binary.BigEndian.PutUint16(b[2:4], uint16(hlen+len(payload)))
+	b[8] = 64
+	b[9] = proto
+	copy(b[12:16], []byte{192, 0, 2, 1})
+	copy(b[16:20], []byte{198, 51, 100, 1})
+	copy(b[ipv4MinHeaderLength:], options)
+	binary.BigEndian.PutUint16(b[10:12], IPv4Checksum(b))
+	b = append(b, payload...)
+	return append(b, trailer...)
+}
+
+// ipv6Packet returns an IPv6 packet whose next header is next, carrying
+// payload, with bytes past its payload length.
+func ipv6Packet(next uint8, payload, trailer []byte) []byte {
+	b := make([]byte, ipv6HeaderLength, ipv6HeaderLength+len(payload)+len(trailer))
+	b[0] = 6 << 4
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(payload)))
+	b[6] = next
+	b[7] = 64
+	b[8], b[9], b[23] = 0x20, 0x01, 1
+	b[24], b[25], b[39] = 0x20, 0x01, 2
+	b = append(b, payload...)
+	return append(b, trailer...)
+}
+
+func TestParseIPv4(t *testing.T) {
+	payload := []byte{1, 2, 3, 4, 5}
+	options := []byte{1, 1, 1, 0}
+	tests := []struct {
+		name    string
+		b       []byte
+		header  int
+		payload []byte
+		err     error
+	}{
+		{"plain", ipv4Packet(ProtoUDP, nil, payload, nil), 20, payload, nil},
+		{"options", ipv4Packet(ProtoUDP, options, payload, nil), 24, payload, nil},
+		{"padding", ipv4Packet(ProtoUDP, nil, payload, []byte{0, 0, 0}), 20, payload, nil},
+		{"empty", nil, 0, nil, ErrNotIP},
+		{"truncated header", ipv4Packet(ProtoUDP, nil, nil, nil)[:19], 0, nil, ErrNotIP},
+		{"truncated options", ipv4Packet(ProtoUDP, options, nil, nil)[:22], 0, nil, ErrLengthMismatch},
+		{"truncated payload", ipv4Packet(ProtoUDP, nil, payload, nil)[:23], 0, nil, ErrLengthMismatch},
+		{"IPv6", ipv6Packet(ProtoUDP, payload, nil), 0, nil, ErrNotIP},
+	}
+	for _, tt := range tests {
+		p, err := ParseIPv4(tt.b)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if p.Version != 4 || len(p.Header) != tt.header || !bytes.Equal(p.Payload, tt.payload) {
+			t.Errorf("%s: got version %d, %d-byte header, payload %v", tt.name, p.Version, len(p.Header), p.Payload)
+		}
+	}
+}
+
+func TestParseIPv6(t *testing.T) {
+	payload := []byte{1, 2, 3, 4, 5}
+	tests := []struct {
+		name    string
+		b       []byte
+		payload []byte
+		err     error
+	}{
+		{"plain", ipv6Packet(ProtoUDP, payload, nil), payload, nil},
+		{"padding", ipv6Packet(ProtoUDP, payload, []byte{0, 0}), payload, nil},
+		{"empty payload", ipv6Packet(IPv6NoNextHeader, nil, nil), []byte{}, nil},
+		{"truncated header", ipv6Packet(ProtoUDP, nil, nil)[:39], nil, ErrNotIP},
+		{"truncated payload", ipv6Packet(ProtoUDP, payload, nil)[:44], nil, ErrLengthMismatch},
+		{"IPv4", ipv4Packet(ProtoUDP, nil, make([]byte, 20), nil), nil, ErrNotIP},
+	}
+	for _, tt := range tests {
+		p, err := ParseIPv6(tt.b)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if p.Version != 6 || len(p.Header) != ipv6HeaderLength || !bytes.Equal(p.Payload, tt.payload) {
+			t.Errorf("%s: got version %d, %d-byte header, payload %v", tt.name, p.Version, len(p.Header), p.Payload)
+		}
+	}
+}
+
+func TestParseIPDispatch(t *testing.T) {
+	for _, b := range [][]byte{ipv4Packet(ProtoUDP, nil, nil, nil), ipv6Packet(ProtoUDP, nil, nil)} {
+		p, err := ParseIP(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Version != int(b[0]>>4) {
+			t.Errorf("got version %d for a version %d packet", p.Version, b[0]>>4)
+		}
+	}
+	if _, err := ParseIP([]byte{0x50, 0, 0, 0}); !errors.Is(err, ErrNotIP) {
+		t.Errorf("got error %v for version 5, want ErrNotIP", err)
+	}
+}
+
+func TestExtensionHeaders(t *testing.T) {
+	hopByHop := []byte{IPv6Routing, 0, 1, 4, 0, 0, 0, 0}
+	routing := []byte{IPv6Fragment, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	fragment := []byte{IPv6DestOpts, 0, 0, 0, 0, 0, 0, 1}
+	destOpts := []byte{ProtoUDP, 0, 1, 4, 0, 0, 0, 0}
+	auth := []byte{ProtoTCP, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1}
+	later := []byte{ProtoUDP, 0, 0x05, 0xa8, 0, 0, 0, 1}
+	data := []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0, 0}
+	cat := func(bs ...[]byte) []byte { return bytes.Join(bs, nil) }
+
+	tests := []struct {
+		name  string
+		next  uint8
+		b     []byte
+		types []uint8
+		proto uint8
+		first bool
+		err   error
+	}{
+		{"none", ProtoUDP, data, nil, ProtoUDP, true, nil},
+		{
+			"chain", IPv6HopByHop, cat(hopByHop, routing, fragment, destOpts, data),
+			[]uint8{IPv6HopByHop, IPv6Routing, IPv6Fragment, IPv6DestOpts}, ProtoUDP, true, nil,
+		},
+		{"authentication", IPv6AuthHeader, cat(auth, data), []uint8{IPv6AuthHeader}, ProtoTCP, true, nil},
+		{"later fragment", IPv6Fragment, cat(later, data), []uint8{IPv6Fragment}, ProtoUDP, false, nil},
+		{"no next header", IPv6DestOpts, cat([]byte{IPv6NoNextHeader, 0, 1, 4, 0, 0, 0, 0}), []uint8{IPv6DestOpts}, IPv6NoNextHeader, true, nil},
+		{"truncated length", IPv6HopByHop, hopByHop[:1], nil, 0, true, ErrTruncated},
+		{"truncated header", IPv6HopByHop, cat(hopByHop, routing[:12]), []uint8{IPv6HopByHop}, 0, true, ErrTruncated},
+		{"truncated fragment", IPv6Fragment, fragment[:6], nil, 0, true, ErrTruncated},
+	}
+	for _, tt := range tests {
+		it := NewExtensionHeaders(tt.next, tt.b)
+		var types []uint8
+		off := 0
+		for it.Next() {
+			h := it.Header()
+			if !bytes.Equal(h.Data, tt.b[off:off+len(h.Data)]) {
+				t.Errorf("%s: header %d doesn't reference the payload", tt.name, len(types))
+			}
+			off += len(h.Data)
+			types = append(types, h.Type)
+		}
+		if !bytes.Equal(types, tt.types) {
+			t.Errorf("%s: walked %v, want %v", tt.name, types, tt.types)
+		}
+
+		p, err := ParseIPv6(ipv6Packet(tt.next, tt.b, nil))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		proto, l4, err := p.UpperLayer()
+		if tt.err != nil {
+			if !errors.Is(it.Err(), tt.err) || !errors.Is(err, tt.err) {
+				t.Errorf("%s: got errors %v and %v, want %v", tt.name, it.Err(), err, tt.err)
+			}
+			continue
+		}
+		if it.Err() != nil || err != nil {
+			t.Errorf("%s: got errors %v and %v", tt.name, it.Err(), err)
+			continue
+		}
+		if it.Protocol() != tt.proto || it.Offset() != off || proto != tt.proto || !bytes.Equal(l4, tt.b[off:]) {
+			t.Errorf("%s: got protocol %d at %d, want %d at %d", tt.name, proto, len(tt.b)-len(l4), tt.proto, off)
+		}
+		if p.FirstFragment() != tt.first {
+			t.Errorf("%s: FirstFragment() = %v, want %v", tt.name, !tt.first, tt.first)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// IP protocol numbers of the packets encapsulated in IPv4: IPv4 in IPv4
+// (RFC 2003) and IPv6 in IPv4, or 6in4 (RFC 4213).
+const (
+	ProtoIPIP = 4
+	ProtoIPv6 = 41
+)
+
+// Default TTL of the packets made by EncapsulateIPv4.
+const defaultTTL = 64
+
+// EncapsulateIPv4 returns the IPv4 or IPv6 packet inner encapsulated in
+// an IPv4 packet from src to dst, with protocol ProtoIPIP or ProtoIPv6
+// depending on its version, and the ECN field of inner. A zero ttl
+// stands for 64.
+func EncapsulateIPv4(src, dst netip.Addr, ttl uint8, inner []byte) ([]byte, error) {
+	if len(inner) == 0 {
+		return nil, ErrNotIP
+	}
+	h := IPv4Header{
+		TotalLength: ipv4MinHeaderLength + len(inner),
+		TTL:         ttl,
+		Src:         src,
+		Dst:         dst,
+	}
+	if h.TTL == 0 {
+		h.TTL = defaultTTL
+	}
+	switch inner[0] >> 4 {
+	case 4:
+		h.Protocol = ProtoIPIP
+		if len(inner) > 1 {
+			h.ECN = inner[1] & 0x3
+		}
+	case 6:
+		h.Protocol = ProtoIPv6
+		if len(inner) > 1 {
+			h.ECN = inner[1] >> 4 & 0x3
+		}
+	default:
+		return nil, ErrNotIP
+	}
+	b, err := h.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return append(b, inner...), nil
+}
+
+// DecapsulateIPv4 decodes the IPv4 packet b carrying an IPv4 or IPv6
+// packet, as made by EncapsulateIPv4, and returns its header and the
+// inner packet, which references b. Fragmented packets are refused.
+func DecapsulateIPv4(b []byte) (*IPv4Header, []byte, error) {
+	p, err := ParseIPv4(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := &IPv4Header{}
+	if err := h.Unmarshal(p.Header); err != nil {
+		return nil, nil, err
+	}
+	if h.Flags&IPv4MoreFragments != 0 || h.FragmentOffset != 0 {
+		return nil, nil, errors.New("Fragmented tunnel packets are not supported")
+	}
+	var version byte
+	switch h.Protocol {
+	case ProtoIPIP:
+		version = 4
+	case ProtoIPv6:
+		version = 6
+	default:
+		return nil, nil, errors.New("Not an encapsulated IP packet")
+	}
+	if len(p.Payload) == 0 || p.Payload[0]>>4 != version {
+		return nil, nil, ErrNotIP
+	}
+	return h, p.Payload, nil
+}
@@ -0,0 +1,113 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+)
+
+// IPv4 header flags.
+const (
+	IPv4MoreFragments = 0x1
+	IPv4DontFragment  = 0x2
+)
+
+// IPv4Header is a decoded IPv4 header.
+type IPv4Header struct {
+	// Header length in 32-bit words, set by Marshal.
+	IHL int
+	// Differentiated services code point and explicit congestion
+	// notification, the two parts of the TOS byte.
+	DSCP uint8
+	ECN  uint8
+	// Length of the header and payload.
+	TotalLength int
+	ID          uint16
+	// IPv4DontFragment and IPv4MoreFragments.
+	Flags uint8
+	// Offset of the fragment in 8-byte units.
+	FragmentOffset int
+	TTL            uint8
+	Protocol       uint8
+	// Set by Marshal.
+	Checksum uint16
+	Src      netip.Addr
+	Dst      netip.Addr
+	// Options, padded to a multiple of 4 bytes.
+	Options []byte
+}
+
+// Unmarshal decodes the IPv4 header at the start of b, without
+// verifying its checksum. Options reference b.
+func (h *IPv4Header) Unmarshal(b []byte) error {
+	if len(b) < ipv4MinHeaderLength || b[0]>>4 != 4 {
+		return errNotIPv4
+	}
+	hlen := int(b[0]&0x0f) * 4
+	if hlen < ipv4MinHeaderLength || hlen > len(b) {
+		return errIPv4HeaderLen
+	}
+	frag := binary.BigEndian.Uint16(b[6:8])
+	*h = IPv4Header{
+		IHL:            hlen / 4,
+		DSCP:           b[1] >> 2,
+		ECN:            b[1] & 0x3,
+		TotalLength:    int(binary.BigEndian.Uint16(b[2:4])),
+		ID:             binary.BigEndian.Uint16(b[4:6]),
+		Flags:          uint8(frag >> 13),
+		FragmentOffset: int(frag & 0x1fff),
+		TTL:            b[8],
+		Protocol:       b[9],
+		Checksum:       binary.BigEndian.Uint16(b[10:12]),
+		Src:            netip.AddrFrom4([4]byte(b[12:16])),
+		Dst:            netip.AddrFrom4([4]byte(b[16:20])),
+	}
+	if hlen > ipv4MinHeaderLength {
+		h.Options = b[ipv4MinHeaderLength:hlen]
+	}
+	return nil
+}
+
+// Marshal encodes h, filling in IHL and Checksum.
+func (h *IPv4Header) Marshal() ([]byte, error) {
+	if !h.Src.Is4() || !h.Dst.Is4() {
+		return nil, errors.New("IPv4 headers need IPv4 addresses")
+	}
+	if len(h.Options)%4 != 0 || len(h.Options) > 40 {
+		return nil, errors.New("Invalid IPv4 options length")
+	}
+	if h.TotalLength > 0xffff {
+		return nil, errors.New("IPv4 packet too long")
+	}
+	h.IHL = (ipv4MinHeaderLength + len(h.Options)) / 4
+
+	b := make([]byte, h.IHL*4)
+	b[0] = 4<<4 | uint8(h.IHL)
+	b[1] = h.DSCP<<2 | h.ECN&0x3
+	binary.BigEndian.PutUint16(b[2:4], uint16(h.TotalLength))
+	binary.BigEndian.PutUint16(b[4:6], h.ID)
+	binary.BigEndian.PutUint16(b[6:8], uint16(h.Flags&0x7)<<13|uint16(h.FragmentOffset&0x1fff))
+	b[8] = h.TTL
+	b[9] = h.Protocol
+	src, dst := h.Src.As4(), h.Dst.As4()
+	copy(b[12:16], src[:])
+	copy(b[16:20], dst[:])
+	copy(b[ipv4MinHeaderLength:], h.Options)
+
+	h.Checksum = IPv4Checksum(b)
+	binary.BigEndian.PutUint16(b[10:12], h.Checksum)
+	return b, nil
+}
+
+// IPv4Checksum computes the checksum of the encoded IPv4 header b,
+// ignoring the current value of its checksum field.
+func IPv4Checksum(b []byte) uint16 {
+	sum := ChecksumAdd(0, b[:10])
+	return ChecksumFold(ChecksumAdd(sum, b[12:]))
+}
+
+// VerifyIPv4Checksum tells whether the checksum of the encoded IPv4
+// header b is correct.
+func VerifyIPv4Checksum(b []byte) bool {
+	return ChecksumFold(ChecksumAdd(0, b)) == 0
+}
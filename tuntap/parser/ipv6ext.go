@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"encoding/binary"
+)
+
+// Protocol numbers of the IPv6 extension headers.
+const (
+	IPv6HopByHop     = 0
+	IPv6Routing      = 43
+	IPv6Fragment     = 44
+	IPv6AuthHeader   = 51
+	IPv6NoNextHeader = 59
+	IPv6DestOpts     = 60
+)
+
+const ipv6FragmentHeaderLength = 8
+
+// ExtensionHeader is an IPv6 extension header.
+type ExtensionHeader struct {
+	// Protocol number of the header, such as IPv6Routing.
+	Type uint8
+	// Protocol number of the header or upper-layer protocol following
+	// this one.
+	NextHeader uint8
+	// The whole header, including its next header and length fields.
+	Data []byte
+}
+
+// FragmentOffset returns the offset of the fragment in bytes, and
+// whether more fragments follow, for an IPv6Fragment header.
+func (h ExtensionHeader) FragmentOffset() (int, bool) {
+	v := binary.BigEndian.Uint16(h.Data[2:4])
+	return int(v &^ 0x7), v&0x1 != 0
+}
+
+// ExtensionHeaders walks the extension headers at the start of an IPv6
+// payload, in order:
+//
+//	it := parser.NewExtensionHeaders(next, payload)
+//	for it.Next() {
+//		h := it.Header()
+//		...
+//	}
+//	if it.Err() != nil {
+//		...
+//	}
+//	proto, data := it.Protocol(), payload[it.Offset():]
+//
+// Walking stops at the first header that isn't an extension header
+// with a known layout: the upper-layer protocol, IPv6NoNextHeader, or
+// ESP.
+type ExtensionHeaders struct {
+	next uint8
+	b    []byte
+	off  int
+	hdr  ExtensionHeader
+	err  error
+}
+
+// NewExtensionHeaders returns an iterator over the extension headers
+// in payload. next is the next header field of the IPv6 header.
+func NewExtensionHeaders(next uint8, payload []byte) *ExtensionHeaders {
+	return &ExtensionHeaders{next: next, b: payload}
+}
+
+// Next advances to the next extension header. It returns false when
+// there are none left or the headers are malformed, see Err.
+func (it *ExtensionHeaders) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	var n int
+	switch it.next {
+	case IPv6HopByHop, IPv6Routing, IPv6DestOpts:
+		if len(it.b)-it.off < 2 {
+			it.err = errShortExtension
+			return false
+		}
+		n = (int(it.b[it.off+1]) + 1) * 8
+	case IPv6Fragment:
+		n = ipv6FragmentHeaderLength
+	case IPv6AuthHeader:
+		if len(it.b)-it.off < 2 {
+			it.err = errShortExtension
+			return false
+		}
+		n = (int(it.b[it.off+1]) + 2) * 4
+	default:
+		return false
+	}
+	if len(it.b)-it.off < n {
+		it.err = errShortExtension
+		return false
+	}
+
+	data := it.b[it.off : it.off+n]
+	it.hdr = ExtensionHeader{Type: it.next, NextHeader: data[0], Data: data}
+	it.next = data[0]
+	it.off += n
+	return true
+}
+
+// Header returns the extension header Next advanced to.
+func (it *ExtensionHeaders) Header() ExtensionHeader {
+	return it.hdr
+}
+
+// Err returns the error that stopped the walk, if any.
+func (it *ExtensionHeaders) Err() error {
+	return it.err
+}
+
+// Protocol returns the protocol number following the headers walked so
+// far: once Next returns false without error, the upper-layer protocol.
+func (it *ExtensionHeaders) Protocol() uint8 {
+	return it.next
+}
+
+// Offset returns the offset in the payload of the data following the
+// headers walked so far.
+func (it *ExtensionHeaders) Offset() int {
+	return it.off
+}
+
+// UpperLayer returns the upper-layer protocol number and payload of p,
+// skipping the IPv6 extension headers. In a fragment other than the
+// first, the payload is the middle of the upper-layer data rather than
+// its header.
+func (p *Packet) UpperLayer() (uint8, []byte, error) {
+	if !p.valid() {
+		return 0, nil, errIPHeaderLen
+	}
+	if p.Version == 4 {
+		return p.Header[9], p.Payload, nil
+	}
+	it := NewExtensionHeaders(p.Header[6], p.Payload)
+	for it.Next() {
+	}
+	if it.err != nil {
+		return 0, nil, it.err
+	}
+	return it.next, p.Payload[it.off:], nil
+}
+
+// FirstFragment tells whether p is unfragmented or the first fragment
+// of a packet, the one starting with the upper-layer header.
+func (p *Packet) FirstFragment() bool {
+	if !p.valid() {
+		return false
+	}
+	if p.Version == 4 {
+		return binary.BigEndian.Uint16(p.Header[6:8])&0x1fff == 0
+	}
+	it := NewExtensionHeaders(p.Header[6], p.Payload)
+	for it.Next() {
+		if h := it.Header(); h.Type == IPv6Fragment {
+			if off, _ := h.FragmentOffset(); off != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// valid tells whether the header of p is long enough for its version.
+func (p *Packet) valid() bool {
+	switch p.Version {
+	case 4:
+		return len(p.Header) >= ipv4MinHeaderLength
+	case 6:
+		return len(p.Header) >= ipv6HeaderLength
+	}
+	return false
+}
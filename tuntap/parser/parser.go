@@ -0,0 +1,5 @@
+// Package parser decodes the headers of the packets carried by TUN/TAP
+// interfaces: Ethernet frames and IP packets. It works on any []byte,
+// not only on packets read by package tuntap, and the values it returns
+// reference the parsed buffer rather than copying it.
+package parser
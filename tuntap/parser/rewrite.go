@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// RewriteAddrs replaces the source and destination addresses of p in
+// place, leaving the nil ones unchanged, and updates the IPv4 header
+// checksum and the TCP, UDP or ICMPv6 checksum covering them
+// incrementally. Transport checksums are left alone in fragments other
+// than the first, which don't carry them.
+func (p *Packet) RewriteAddrs(src, dst []byte) error {
+	var off, n int
+	switch p.Version {
+	case 4:
+		off, n = 12, 4
+	case 6:
+		off, n = 8, 16
+	default:
+		return ErrNotIP
+	}
+	if (src != nil && len(src) != n) || (dst != nil && len(dst) != n) {
+		return errors.New("Address length not matching the IP version")
+	}
+	if len(p.Header) < off+2*n {
+		return errIPHeaderLen
+	}
+
+	addrs := p.Header[off : off+2*n]
+	old := append([]byte(nil), addrs...)
+	if src != nil {
+		copy(addrs[:n], src)
+	}
+	if dst != nil {
+		copy(addrs[n:], dst)
+	}
+
+	if p.Version == 4 {
+		updateChecksumAt(p.Header, 10, old, addrs)
+	}
+	if !p.FirstFragment() {
+		return nil
+	}
+	proto, data, err := p.UpperLayer()
+	if err != nil {
+		return err
+	}
+	switch {
+	case proto == ProtoTCP && len(data) >= 18:
+		updateChecksumAt(data, 16, old, addrs)
+	case proto == ProtoUDP && len(data) >= 8:
+		// A zero UDP checksum over IPv4 means there's none, and a
+		// computed zero is sent as 0xffff.
+		if binary.BigEndian.Uint16(data[6:8]) == 0 {
+			break
+		}
+		updateChecksumAt(data, 6, old, addrs)
+		if binary.BigEndian.Uint16(data[6:8]) == 0 {
+			binary.BigEndian.PutUint16(data[6:8], 0xffff)
+		}
+	case proto == ProtoICMPv6 && p.Version == 6 && len(data) >= 4:
+		updateChecksumAt(data, 2, old, addrs)
+	}
+	return nil
+}
+
+// updateChecksumAt updates the checksum stored at off in b.
+func updateChecksumAt(b []byte, off int, old, new []byte) {
+	csum := binary.BigEndian.Uint16(b[off : off+2])
+	binary.BigEndian.PutUint16(b[off:off+2], ChecksumUpdate(csum, old, new))
+}
@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+// segment returns a transport message of protocol proto with a correct
+// checksum for the addresses of the IPv4 or IPv6 packet header h.
+func segment(h []byte, proto uint8) []byte {
+	src, dst := packetAddrs(h)
+	switch proto {
+	case ProtoTCP:
+		b := []byte{0x30, 0x39, 0x00, 0x50, 0, 0, 0, 1, 0, 0, 0, 0, 0x50, 0x18, 0xff, 0xff, 0, 0, 0, 0, 'h', 'i', '!'}
+		binary.BigEndian.PutUint16(b[16:18], TCPChecksum(src, dst, b))
+		return b
+	case ProtoUDP:
+		b := []byte{0x30, 0x39, 0x00, 0x35, 0, 11, 0, 0, 'd', 'n', 's'}
+		binary.BigEndian.PutUint16(b[6:8], UDPChecksum(src, dst, b))
+		return b
+	default:
+		b := []byte{ICMPv6EchoRequest, 0, 0, 0, 0, 1, 0, 1, 'p', 'i', 'n', 'g'}
+		binary.BigEndian.PutUint16(b[2:4], ICMPv6Checksum(src, dst, b))
+		return b
+	}
+}
+
+// packetAddrs returns the addresses of the IPv4 or IPv6 header h.
+func packetAddrs(h []byte) (netip.Addr, netip.Addr) {
+	if h[0]>>4 == 4 {
+		return netip.AddrFrom4([4]byte(h[12:16])), netip.AddrFrom4([4]byte(h[16:20]))
+	}
+	return netip.AddrFrom16([16]byte(h[8:24])), netip.AddrFrom16([16]byte(h[24:40]))
+}
+
+func TestRewriteAddrs(t *testing.T) {
+	v4 := ipv4Packet(0, nil, nil, nil)
+	v6 := ipv6Packet(0, nil, nil)
+	hopByHop := []byte{ProtoTCP, 0, 1, 4, 0, 0, 0, 0}
+	newV4 := []byte{10, 1, 2, 3}
+	newV6 := netip.MustParseAddr("fd00::1234:5678").AsSlice()
+
+	tests := []struct {
+		name     string
+		b        []byte
+		src, dst []byte
+	}{
+		{"TCP over IPv4", ipv4Packet(ProtoTCP, nil, segment(v4, ProtoTCP), nil), newV4, nil},
+		{"UDP over IPv4", ipv4Packet(ProtoUDP, nil, segment(v4, ProtoUDP), nil), nil, newV4},
+		{"both over IPv4", ipv4Packet(ProtoTCP, nil, segment(v4, ProtoTCP), nil), newV4, []byte{192, 168, 255, 255}},
+		{"IPv4 options", ipv4Packet(ProtoUDP, []byte{1, 1, 1, 0}, segment(v4, ProtoUDP), nil), newV4, newV4},
+		{"TCP over IPv6", ipv6Packet(ProtoTCP, segment(v6, ProtoTCP), nil), newV6, nil},
+		{"UDP over IPv6", ipv6Packet(ProtoUDP, segment(v6, ProtoUDP), nil), nil, newV6},
+		{"ICMPv6", ipv6Packet(ProtoICMPv6, segment(v6, ProtoICMPv6), nil), newV6, newV6},
+		{
+			"extension headers", ipv6Packet(IPv6HopByHop, append(hopByHop, segment(v6, ProtoTCP)...), nil),
+			newV6, nil,
+		},
+	}
+	for _, tt := range tests {
+		p, err := ParseIP(tt.b)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := p.RewriteAddrs(tt.src, tt.dst); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		src, dst := packetAddrs(p.Header)
+		if tt.src != nil && !bytes.Equal(src.AsSlice(), tt.src) || tt.dst != nil && !bytes.Equal(dst.AsSlice(), tt.dst) {
+			t.Errorf("%s: got addresses %v and %v", tt.name, src, dst)
+		}
+		if p.Version == 4 && !VerifyIPv4Checksum(p.Header) {
+			t.Errorf("%s: wrong IPv4 header checksum", tt.name)
+		}
+		proto, l4, _ := p.UpperLayer()
+		var ok bool
+		switch proto {
+		case ProtoTCP:
+			ok = VerifyTCPChecksum(src, dst, l4)
+		case ProtoUDP:
+			ok = VerifyUDPChecksum(src, dst, l4) && binary.BigEndian.Uint16(l4[6:8]) != 0
+		case ProtoICMPv6:
+			ok = VerifyICMPv6Checksum(src, dst, l4)
+		}
+		if !ok {
+			t.Errorf("%s: wrong %d checksum after rewriting", tt.name, proto)
+		}
+	}
+}
+
+func TestRewriteAddrsUDPNoChecksum(t *testing.T) {
+	udp := []byte{0x30, 0x39, 0x00, 0x35, 0, 8, 0, 0}
+	p, err := ParseIPv4(ipv4Packet(ProtoUDP, nil, udp, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RewriteAddrs([]byte{10, 0, 0, 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if csum := binary.BigEndian.Uint16(p.Payload[6:8]); csum != 0 {
+		t.Errorf("got UDP checksum %#04x, want none", csum)
+	}
+	if !VerifyIPv4Checksum(p.Header) {
+		t.Error("wrong IPv4 header checksum")
+	}
+}
+
+func TestRewriteAddrsLaterFragment(t *testing.T) {
+	// The payload of a later fragment is upper-layer data, not a
+	// header, and must be left alone.
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	b := ipv4Packet(ProtoTCP, nil, data, nil)
+	binary.BigEndian.PutUint16(b[6:8], 0x00b9)
+	binary.BigEndian.PutUint16(b[10:12], IPv4Checksum(b[:20]))
+	p, err := ParseIPv4(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RewriteAddrs(nil, []byte{10, 0, 0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.Payload, data) {
+		t.Errorf("later fragment payload changed to %v", p.Payload)
+	}
+	if !VerifyIPv4Checksum(p.Header) {
+		t.Error("wrong IPv4 header checksum")
+	}
+}
+
+func TestRewriteAddrsLength(t *testing.T) {
+	p, err := ParseIPv4(ipv4Packet(ProtoUDP, nil, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RewriteAddrs(netip.MustParseAddr("fd00::1").AsSlice(), nil); err == nil {
+		t.Error("IPv6 address accepted in an IPv4 packet")
+	}
+}
@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+)
+
+// IP protocol number of TCP.
+const ProtoTCP = 6
+
+const tcpMinHeaderLength = 20
+
+// TCP header flags.
+const (
+	TCPFlagFIN = 0x01
+	TCPFlagSYN = 0x02
+	TCPFlagRST = 0x04
+	TCPFlagPSH = 0x08
+	TCPFlagACK = 0x10
+	TCPFlagURG = 0x20
+	TCPFlagECE = 0x40
+	TCPFlagCWR = 0x80
+)
+
+// TCP option kinds.
+const (
+	TCPOptionEnd           = 0
+	TCPOptionNop           = 1
+	TCPOptionMSS           = 2
+	TCPOptionWindowScale   = 3
+	TCPOptionSACKPermitted = 4
+	TCPOptionSACK          = 5
+	TCPOptionTimestamps    = 8
+)
+
+// TCPHeader is a decoded TCP header.
+type TCPHeader struct {
+	SrcPort uint16
+	DstPort uint16
+	Seq     uint32
+	Ack     uint32
+	// Header length in 32-bit words, set by Marshal.
+	DataOffset int
+	// TCPFlagFIN, TCPFlagSYN...
+	Flags    uint8
+	Window   uint16
+	Checksum uint16
+	Urgent   uint16
+	// Options, padded to a multiple of 4 bytes.
+	Options []byte
+}
+
+// Unmarshal decodes the TCP header at the start of b. Options reference
+// b.
+func (h *TCPHeader) Unmarshal(b []byte) error {
+	if len(b) < tcpMinHeaderLength {
+		return errShortTCP
+	}
+	hlen := int(b[12]>>4) * 4
+	if hlen < tcpMinHeaderLength || hlen > len(b) {
+		return errTCPHeaderLen
+	}
+	*h = TCPHeader{
+		SrcPort:    binary.BigEndian.Uint16(b[0:2]),
+		DstPort:    binary.BigEndian.Uint16(b[2:4]),
+		Seq:        binary.BigEndian.Uint32(b[4:8]),
+		Ack:        binary.BigEndian.Uint32(b[8:12]),
+		DataOffset: hlen / 4,
+		Flags:      b[13],
+		Window:     binary.BigEndian.Uint16(b[14:16]),
+		Checksum:   binary.BigEndian.Uint16(b[16:18]),
+		Urgent:     binary.BigEndian.Uint16(b[18:20]),
+	}
+	if hlen > tcpMinHeaderLength {
+		h.Options = b[tcpMinHeaderLength:hlen]
+	}
+	return nil
+}
+
+// Marshal encodes h, filling in DataOffset. The checksum is copied from
+// h: compute it with TCPChecksum once the payload is appended.
+func (h *TCPHeader) Marshal() ([]byte, error) {
+	if len(h.Options)%4 != 0 || len(h.Options) > 40 {
+		return nil, errors.New("Invalid TCP options length")
+	}
+	h.DataOffset = (tcpMinHeaderLength + len(h.Options)) / 4
+
+	b := make([]byte, h.DataOffset*4)
+	binary.BigEndian.PutUint16(b[0:2], h.SrcPort)
+	binary.BigEndian.PutUint16(b[2:4], h.DstPort)
+	binary.BigEndian.PutUint32(b[4:8], h.Seq)
+	binary.BigEndian.PutUint32(b[8:12], h.Ack)
+	b[12] = uint8(h.DataOffset) << 4
+	b[13] = h.Flags
+	binary.BigEndian.PutUint16(b[14:16], h.Window)
+	binary.BigEndian.PutUint16(b[16:18], h.Checksum)
+	binary.BigEndian.PutUint16(b[18:20], h.Urgent)
+	copy(b[tcpMinHeaderLength:], h.Options)
+	return b, nil
+}
+
+// TCPOption is a TCP option.
+type TCPOption struct {
+	Kind uint8
+	// The option value, without the kind and length bytes.
+	Data []byte
+}
+
+// ParseOptions decodes the options of h, skipping padding. The values
+// reference h.Options.
+func (h *TCPHeader) ParseOptions() ([]TCPOption, error) {
+	var opts []TCPOption
+	b := h.Options
+	for len(b) > 0 {
+		switch b[0] {
+		case TCPOptionEnd:
+			return opts, nil
+		case TCPOptionNop:
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 || b[1] < 2 || int(b[1]) > len(b) {
+			return nil, errors.New("Invalid TCP option length")
+		}
+		opts = append(opts, TCPOption{Kind: b[0], Data: b[2:b[1]]})
+		b = b[b[1]:]
+	}
+	return opts, nil
+}
+
+// TCPChecksum computes the checksum of the TCP segment b sent from src
+// to dst, ignoring the current value of its checksum field. src and dst
+// are both IPv4 or both IPv6 addresses.
+func TCPChecksum(src, dst netip.Addr, b []byte) uint16 {
+	return transportChecksum(src, dst, ProtoTCP, b, 16)
+}
+
+// VerifyTCPChecksum tells whether the checksum of the TCP segment b
+// sent from src to dst is correct.
+func VerifyTCPChecksum(src, dst netip.Addr, b []byte) bool {
+	return verifyTransportChecksum(src, dst, ProtoTCP, b)
+}
+
+// transportChecksum computes the checksum of the upper-layer message b
+// covered by the pseudo-header, skipping the checksum field at off.
+func transportChecksum(src, dst netip.Addr, proto uint8, b []byte, off int) uint16 {
+	sum := PseudoHeaderSum(src.AsSlice(), dst.AsSlice(), proto, len(b))
+	sum = ChecksumAdd(sum, b[:off])
+	return ChecksumFold(ChecksumAdd(sum, b[off+2:]))
+}
+
+func verifyTransportChecksum(src, dst netip.Addr, proto uint8, b []byte) bool {
+	sum := PseudoHeaderSum(src.AsSlice(), dst.AsSlice(), proto, len(b))
+	return ChecksumFold(ChecksumAdd(sum, b)) == 0
+}
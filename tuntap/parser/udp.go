@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+)
+
+// IP protocol number of UDP.
+const ProtoUDP = 17
+
+const udpHeaderLength = 8
+
+// UDPHeader is a decoded UDP header.
+type UDPHeader struct {
+	SrcPort uint16
+	DstPort uint16
+	// Length of the header and payload.
+	Length   int
+	Checksum uint16
+}
+
+// Unmarshal decodes the UDP header at the start of b.
+func (h *UDPHeader) Unmarshal(b []byte) error {
+	if len(b) < udpHeaderLength {
+		return errShortUDP
+	}
+	*h = UDPHeader{
+		SrcPort:  binary.BigEndian.Uint16(b[0:2]),
+		DstPort:  binary.BigEndian.Uint16(b[2:4]),
+		Length:   int(binary.BigEndian.Uint16(b[4:6])),
+		Checksum: binary.BigEndian.Uint16(b[6:8]),
+	}
+	if h.Length < udpHeaderLength || h.Length > len(b) {
+		return errUDPLen
+	}
+	return nil
+}
+
+// Marshal encodes h. The checksum is copied from h: compute it with
+// UDPChecksum once the payload is appended.
+func (h *UDPHeader) Marshal() ([]byte, error) {
+	if h.Length < udpHeaderLength || h.Length > 0xffff {
+		return nil, errors.New("Invalid UDP length")
+	}
+	b := make([]byte, udpHeaderLength)
+	binary.BigEndian.PutUint16(b[0:2], h.SrcPort)
+	binary.BigEndian.PutUint16(b[2:4], h.DstPort)
+	binary.BigEndian.PutUint16(b[4:6], uint16(h.Length))
+	binary.BigEndian.PutUint16(b[6:8], h.Checksum)
+	return b, nil
+}
+
+// UDPChecksum computes the checksum of the UDP datagram b sent from src
+// to dst, ignoring the current value of its checksum field. A computed
+// checksum of zero is returned as 0xffff, since zero means no checksum.
+func UDPChecksum(src, dst netip.Addr, b []byte) uint16 {
+	sum := transportChecksum(src, dst, ProtoUDP, b, 6)
+	if sum == 0 {
+		return 0xffff
+	}
+	return sum
+}
+
+// VerifyUDPChecksum tells whether the checksum of the UDP datagram b
+// sent from src to dst is correct. A zero checksum is accepted over
+// IPv4, where it's optional, but not over IPv6, where it's mandatory
+// (RFC 8200 section 8.1).
+func VerifyUDPChecksum(src, dst netip.Addr, b []byte) bool {
+	if len(b) < udpHeaderLength {
+		return false
+	}
+	if binary.BigEndian.Uint16(b[6:8]) == 0 {
+		return src.Is4()
+	}
+	return verifyTransportChecksum(src, dst, ProtoUDP, b)
+}
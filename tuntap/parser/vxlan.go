@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// UDP port assigned to VXLAN by IANA.
+const VXLANPort = 4789
+
+const vxlanHeaderLength = 8
+
+// The I flag, set when the VNI is valid.
+const vxlanFlagVNI = 0x08
+
+// VXLANHeader is a decoded VXLAN header (RFC 7348), followed by the
+// encapsulated Ethernet frame.
+type VXLANHeader struct {
+	// VXLAN network identifier, 24 bits.
+	VNI uint32
+}
+
+// Unmarshal decodes the VXLAN header at the start of b. The frame
+// starts after 8 bytes.
+func (h *VXLANHeader) Unmarshal(b []byte) error {
+	if len(b) < vxlanHeaderLength {
+		return errShortVXLAN
+	}
+	if b[0]&vxlanFlagVNI == 0 {
+		return errors.New("VXLAN header without a VNI")
+	}
+	h.VNI = binary.BigEndian.Uint32(b[4:8]) >> 8
+	return nil
+}
+
+// Marshal encodes h.
+func (h *VXLANHeader) Marshal() ([]byte, error) {
+	if h.VNI > 0xffffff {
+		return nil, errors.New("VNI must fit in 24 bits")
+	}
+	b := make([]byte, vxlanHeaderLength)
+	b[0] = vxlanFlagVNI
+	binary.BigEndian.PutUint32(b[4:8], h.VNI<<8)
+	return b, nil
+}
@@ -0,0 +1,54 @@
+package tuntap
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const capNetAdmin = 12
+
+// hasCapability tells whether capability cap is in the effective set of
+// the process. ok is false if that can't be found out.
+func hasCapability(cap uint) (has, ok bool) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false, false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if v, found := strings.CutPrefix(s.Text(), "CapEff:"); found {
+			caps, err := strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+			if err != nil {
+				return false, false
+			}
+			return caps&(1<<cap) != 0, true
+		}
+	}
+	return false, false
+}
+
+// setIffPermissionError explains err, the error of TUNSETIFF on the
+// interface name, if it's a permission error.
+func setIffPermissionError(name string, err error) error {
+	if !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+	var hint string
+	if has, ok := hasCapability(capNetAdmin); ok && has {
+		hint = "Attaching to the interface isn't allowed: it may be a persistent interface of another user or group (see WithOwner and WithGroup), or belong to another network namespace"
+	} else {
+		hint = "Creating tun/tap interfaces needs CAP_NET_ADMIN: run as root, grant it to the binary with setcap cap_net_admin+ep, or attach to a persistent interface created for this user with CreatePersistent and WithOwner"
+	}
+	return &PermissionError{
+		Op:         "TUNSETIFF",
+		Path:       name,
+		Capability: "CAP_NET_ADMIN",
+		UID:        os.Geteuid(),
+		Hint:       hint,
+		Err:        err,
+	}
+}
@@ -0,0 +1,39 @@
+// +build linux darwin freebsd openbsd
+
+package tuntap
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// openPermissionError explains err, the error opening the device node
+// path, if it's a permission error.
+func openPermissionError(path string, err error) error {
+	if !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+	uid := os.Geteuid()
+	hint := "The device node isn't readable and writable by uid " + strconv.Itoa(uid)
+	if fi, serr := os.Stat(path); serr == nil {
+		hint += " (mode " + fi.Mode().Perm().String()
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			hint += ", owner " + strconv.Itoa(int(st.Uid)) + ":" + strconv.Itoa(int(st.Gid))
+		}
+		hint += ")"
+	}
+	hint += ": run as root, or give the user access to " + path + " with chmod or its group"
+	return &PermissionError{Op: "open", Path: path, UID: uid, Hint: hint, Err: unwrapPathError(err)}
+}
+
+// unwrapPathError returns the system error of an *os.PathError, whose
+// operation and path a PermissionError already gives.
+func unwrapPathError(err error) error {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
@@ -0,0 +1,182 @@
+package tuntap
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// Packets queued on each side of a pipe before writes drop them,
+	// like the default txqueuelen of a tun device.
+	pipeQueueLen = 500
+	pipeMTU      = 1500
+)
+
+// NewPipe returns two Interfaces of the given kind connected to each
+// other in memory: packets written to one are read from the other. They
+// need no privileges nor a kernel device, for testing and fuzzing code
+// using Interfaces.
+//
+// Like a real device, a pipe drops the packets written while the queue
+// of the other side is full, and keeps the packet boundaries. Writing
+// to a pipe whose other side is closed fails with io.ErrClosedPipe.
+func NewPipe(kind DevKind) (*Interface, *Interface) {
+	a := newPipeDevice("pipe0")
+	b := newPipeDevice("pipe1")
+	a.peer, b.peer = b, a
+	return NewInterface(a, kind, false), NewInterface(b, kind, false)
+}
+
+// pipeDevice is one side of a pipe.
+type pipeDevice struct {
+	name      string
+	in        chan []byte
+	peer      *pipeDevice
+	done      chan struct{}
+	closeOnce sync.Once
+
+	readDeadline, writeDeadline *pipeDeadline
+}
+
+func newPipeDevice(name string) *pipeDevice {
+	return &pipeDevice{
+		name:          name,
+		in:            make(chan []byte, pipeQueueLen),
+		done:          make(chan struct{}),
+		readDeadline:  &pipeDeadline{cancel: make(chan struct{})},
+		writeDeadline: &pipeDeadline{cancel: make(chan struct{})},
+	}
+}
+
+func (d *pipeDevice) Read(b []byte) (int, error) {
+	select {
+	case <-d.done:
+		return 0, os.ErrClosed
+	case <-d.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case p := <-d.in:
+		return copy(b, p), nil
+	case <-d.done:
+		return 0, os.ErrClosed
+	case <-d.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (d *pipeDevice) readBatch(bufs [][]byte, sizes []int) (int, error) {
+	n, err := d.Read(bufs[0])
+	if err != nil {
+		return 0, err
+	}
+	sizes[0] = n
+	count := 1
+	for ; count < len(bufs); count++ {
+		select {
+		case p := <-d.in:
+			sizes[count] = copy(bufs[count], p)
+		default:
+			return count, nil
+		}
+	}
+	return count, nil
+}
+
+func (d *pipeDevice) Write(b []byte) (int, error) {
+	select {
+	case <-d.done:
+		return 0, os.ErrClosed
+	case <-d.peer.done:
+		return 0, io.ErrClosedPipe
+	case <-d.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	p := make([]byte, len(b))
+	copy(p, b)
+	select {
+	case d.peer.in <- p:
+	default:
+		// Queue full: dropped.
+	}
+	return len(b), nil
+}
+
+func (d *pipeDevice) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
+	return nil
+}
+
+func (d *pipeDevice) Name() string {
+	return d.name
+}
+
+func (d *pipeDevice) MTU() (int, error) {
+	return pipeMTU, nil
+}
+
+func (d *pipeDevice) SetReadDeadline(t time.Time) error {
+	d.readDeadline.set(t)
+	return nil
+}
+
+func (d *pipeDevice) SetWriteDeadline(t time.Time) error {
+	d.writeDeadline.set(t)
+	return nil
+}
+
+// pipeDeadline is a deadline that I/O blocked before it's set or changed
+// still observes: wait returns a channel closed once it's past.
+type pipeDeadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func (d *pipeDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// Wait for the timer to close cancel.
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *pipeDeadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
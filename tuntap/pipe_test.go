@@ -0,0 +1,83 @@
+package tuntap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+var (
+	testSrc4 = netip.MustParseAddr("192.0.2.1")
+	testDst4 = netip.MustParseAddr("198.51.100.1")
+	testSrc6 = netip.MustParseAddr("2001:db8::1")
+	testDst6 = netip.MustParseAddr("2001:db8::2")
+
+	testSrcMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testDstMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+// udpPacket returns a UDP packet to port, carrying data.
+func udpPacket(t *testing.T, src, dst netip.Addr, port uint16, data []byte) *IPPacket {
+	t.Helper()
+	udp := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint16(udp[0:2], 4000)
+	binary.BigEndian.PutUint16(udp[2:4], port)
+	udp = append(udp, data...)
+	var pkt *IPPacket
+	var err error
+	if src.Is4() {
+		pkt, err = NewIPv4Packet(src, dst, ProtoUDP, udp)
+	} else {
+		pkt, err = NewIPv6Packet(src, dst, ProtoUDP, udp)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+// withFrame sets the Ethernet frame of pkt, for DevTap interfaces.
+func withFrame(pkt *IPPacket) *IPPacket {
+	pkt.Frame = &EthernetFrame{DstMAC: testDstMAC, SrcMAC: testSrcMAC}
+	return pkt
+}
+
+// packetBytes returns the IP packet pkt is made of.
+func packetBytes(pkt *IPPacket) []byte {
+	return append(append([]byte(nil), pkt.Header.Data...), pkt.Payload...)
+}
+
+func TestPipe(t *testing.T) {
+	for _, kind := range []DevKind{DevTun, DevTap} {
+		a, b := NewPipe(kind)
+		want := udpPacket(t, testSrc6, testDst6, 53, []byte("query"))
+		if kind == DevTap {
+			withFrame(want)
+		}
+		if err := a.WritePacket(want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := b.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(packetBytes(got), packetBytes(want)) {
+			t.Errorf("kind %v: read %x, want %x", kind, packetBytes(got), packetBytes(want))
+		}
+		if kind == DevTap && (!bytes.Equal(got.Frame.SrcMAC, testSrcMAC) || !bytes.Equal(got.Frame.DstMAC, testDstMAC)) {
+			t.Errorf("read frame from %v to %v", got.Frame.SrcMAC, got.Frame.DstMAC)
+		}
+
+		b.Close()
+		if err := a.WritePacket(want); err == nil {
+			t.Errorf("kind %v: wrote to a closed pipe", kind)
+		}
+		if _, err := b.ReadPacket(); !errors.Is(err, ErrClosed) {
+			t.Errorf("kind %v: got error %v reading a closed pipe, want ErrClosed", kind, err)
+		}
+		a.Close()
+	}
+}
@@ -0,0 +1,97 @@
+package tuntap
+
+import (
+	"log"
+	"net/netip"
+	"strconv"
+)
+
+// A Handler handles a packet read from an Interface, like the last
+// stage of a pipeline built with Chain. It returns an error only when
+// serving must stop.
+type Handler func(p *IPPacket) error
+
+// A Middleware is a stage of a packet pipeline. It gets each packet and
+// the next stage of the pipeline, which it calls with the packet, a
+// modified packet or another one to pass it on; it may also drop the
+// packet, or handle it itself, by not calling next.
+type Middleware func(p *IPPacket, next Handler) error
+
+// Chain returns the Handler running the stages in order, each passing
+// the packet on to the next one.
+func Chain(stages ...Middleware) Handler {
+	h := Handler(func(*IPPacket) error { return nil })
+	for i := len(stages) - 1; i >= 0; i-- {
+		stage, next := stages[i], h
+		h = func(p *IPPacket) error { return stage(p, next) }
+	}
+	return h
+}
+
+// Serve reads packets from the interface and hands them to h, one at a
+// time, until reading fails or h returns an error, which Serve returns.
+// Like Packets, Serve skips the packets that fail to parse, counting
+// them in RxDropped. It serves only this queue of a multiqueue
+// interface.
+func (t *Interface) Serve(h Handler) error {
+	for {
+		data, info, err := t.readRaw(make([]byte, t.bufferSize()))
+		if err != nil {
+			return err
+		}
+		pkt, err := t.decodePacket(data, info)
+		if err != nil {
+			t.stats.rxDropped.Add(1)
+			continue
+		}
+		if pkt = t.hooked(pkt, false); pkt == nil {
+			continue
+		}
+		if err := h(pkt); err != nil {
+			return err
+		}
+	}
+}
+
+// PacketWriter is implemented by Interfaces, and the other destinations
+// packets can be forwarded to.
+type PacketWriter interface {
+	WritePacket(p *IPPacket) error
+}
+
+// Forwarder returns the Middleware writing the packets to upstream, the
+// last stage of a pipeline forwarding them. Write errors stop serving.
+func Forwarder(upstream PacketWriter) Middleware {
+	return func(p *IPPacket, next Handler) error {
+		return upstream.WritePacket(p)
+	}
+}
+
+// Logger returns the Middleware logging a line about each packet to l,
+// or to the standard logger if l is nil, before passing it on.
+func Logger(l *log.Logger) Middleware {
+	if l == nil {
+		l = log.Default()
+	}
+	return func(p *IPPacket, next Handler) error {
+		l.Print(describePacket(p))
+		return next(p)
+	}
+}
+
+// describePacket returns a one-line description of p, such as
+// "10.0.0.1:1234 > 10.0.0.2:80 TCP 60 bytes".
+func describePacket(p *IPPacket) string {
+	size := strconv.Itoa(len(p.Header.Data)+len(p.Payload)) + " bytes"
+	k, err := p.FlowKey()
+	if err != nil {
+		return "malformed packet, " + size
+	}
+	src, dst := k.Src.String(), k.Dst.String()
+	switch k.Proto {
+	case ProtoTCP, ProtoUDP, ProtoSCTP:
+		src = netip.AddrPortFrom(k.Src, k.SrcPort).String()
+		dst = netip.AddrPortFrom(k.Dst, k.DstPort).String()
+	}
+	return src + " > " + dst + " " + k.Proto.String() + " " + size
+}
@@ -0,0 +1,91 @@
+package tuntap
+
+import (
+	"errors"
+	"net/netip"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// PacketTooBig returns the error telling the sender of p that it
+// doesn't fit in mtu bytes, for path MTU discovery: an ICMPv6 packet too
+// big message for IPv6 packets, and an ICMP fragmentation needed one for
+// IPv4 packets with the don't fragment flag set, sent from the
+// destination of p. It returns nil if p fits, if it's an IPv4 packet
+// that may be fragmented, and if it must not be answered: ICMP errors,
+// non-initial IPv4 fragments, packets from unspecified or multicast
+// addresses, and IPv4 packets to multicast ones. IPv6 packets to
+// multicast addresses are answered by PacketTooBigFrom only, since the
+// error can't be sent from their destination.
+//
+// GSO super-packets should be split with SplitGSO first.
+func PacketTooBig(p *IPPacket, mtu int) (*IPPacket, error) {
+	return PacketTooBigFrom(p, netip.Addr{}, mtu)
+}
+
+// PacketTooBigFrom is like PacketTooBig, but sends the error from the
+// address from of the router, if valid. IPv6 packets to multicast
+// addresses are then answered too, as multicast path MTU discovery
+// relies on it (RFC 4443 section 2.4 (e.2)).
+func PacketTooBigFrom(p *IPPacket, from netip.Addr, mtu int) (*IPPacket, error) {
+	from = from.Unmap()
+	if from.IsValid() && (from.IsUnspecified() || from.IsMulticast()) {
+		return nil, errors.New("Invalid source address " + from.String())
+	}
+	h := p.Header.Data
+	if len(h)+len(p.Payload) <= mtu {
+		return nil, nil
+	}
+	if p.Header.version() == 4 {
+		if mtu < 68 {
+			return nil, errors.New("IPv4 MTU below 68")
+		}
+		if len(h) < 20 || h[6]&0x40 == 0 {
+			return nil, nil
+		}
+		return icmpError(p, from, parser.ICMPv4DestUnreachable, parser.ICMPv4FragmentationNeeded, uint32(mtu), false)
+	}
+	if mtu < 1280 {
+		return nil, errors.New("IPv6 MTU below 1280")
+	}
+	return icmpError(p, from, parser.ICMPv6PacketTooBig, 0, uint32(mtu), true)
+}
+
+// MTULimit returns the Middleware keeping the packets passed on within
+// mtu bytes, for a tunnel of that MTU. The packets too big for it are
+// answered with PacketTooBigFrom, the replies written to w, typically
+// the Interface served, from the first of addrs of their IP version, or
+// from their destination if none; the IPv4 ones that may be fragmented
+// are passed on as fragments instead, and the others dropped.
+func MTULimit(mtu int, w PacketWriter, addrs ...netip.Addr) Middleware {
+	from4, from6 := routerAddrs(addrs)
+	return func(p *IPPacket, next Handler) error {
+		if len(p.Header.Data)+len(p.Payload) <= mtu {
+			return next(p)
+		}
+		from := from6
+		if p.Header.version() == 4 {
+			from = from4
+		}
+		resp, err := PacketTooBigFrom(p, from, mtu)
+		if err != nil {
+			return nil
+		}
+		if resp != nil {
+			return w.WritePacket(resp)
+		}
+		if p.Header.Data[0]>>4 != 4 {
+			return nil
+		}
+		frags, err := Fragment(p, mtu)
+		if err != nil {
+			return nil
+		}
+		for _, f := range frags {
+			if err := next(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+func TestPacketTooBig(t *testing.T) {
+	router6 := netip.MustParseAddr("2001:db8::fe")
+	router4 := netip.MustParseAddr("192.0.2.254")
+	group6 := netip.MustParseAddr("ff0e::1")
+	group4 := netip.MustParseAddr("239.1.2.3")
+	big := make([]byte, 1400)
+
+	tests := []struct {
+		name     string
+		dst      netip.Addr
+		from     netip.Addr
+		mtu      int
+		answered bool
+		// Source of the answer.
+		want netip.Addr
+	}{
+		{"IPv6", testDst6, netip.Addr{}, 1280, true, testDst6},
+		{"IPv6 from router", testDst6, router6, 1280, true, router6},
+		{"IPv6 fitting", testDst6, netip.Addr{}, 1500, false, netip.Addr{}},
+		// RFC 4443 section 2.4 (e.2).
+		{"IPv6 multicast", group6, router6, 1280, true, router6},
+		{"IPv6 multicast without source", group6, netip.Addr{}, 1280, false, netip.Addr{}},
+		{"IPv4", testDst4, netip.Addr{}, 1280, true, testDst4},
+		{"IPv4 multicast", group4, router4, 1280, false, netip.Addr{}},
+	}
+	for _, tt := range tests {
+		src := testSrc6
+		if tt.dst.Is4() {
+			src = testSrc4
+		}
+		p := udpPacket(t, src, tt.dst, 5000, big)
+		resp, err := PacketTooBigFrom(p, tt.from, tt.mtu)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if (resp != nil) != tt.answered {
+			t.Fatalf("%s: answered %v, want %v", tt.name, resp != nil, tt.answered)
+		}
+		if !tt.from.IsValid() {
+			if resp2, _ := PacketTooBig(p, tt.mtu); (resp2 != nil) != tt.answered {
+				t.Errorf("%s: PacketTooBig answered %v, want %v", tt.name, resp2 != nil, tt.answered)
+			}
+		}
+		if resp == nil {
+			continue
+		}
+		if resp.Header.Src() != tt.want || resp.Header.Dst() != src {
+			t.Errorf("%s: answered from %v to %v, want from %v to %v", tt.name, resp.Header.Src(), resp.Header.Dst(), tt.want, src)
+		}
+		_, l4, _ := resp.UpperLayer()
+		if tt.dst.Is6() {
+			if l4[0] != parser.ICMPv6PacketTooBig || !parser.VerifyICMPv6Checksum(tt.want, src, l4) {
+				t.Errorf("%s: got ICMPv6 type %d or a wrong checksum", tt.name, l4[0])
+			}
+			if mtu := binary.BigEndian.Uint32(l4[4:8]); mtu != uint32(tt.mtu) {
+				t.Errorf("%s: got MTU %d, want %d", tt.name, mtu, tt.mtu)
+			}
+		} else if l4[0] != parser.ICMPv4DestUnreachable || l4[1] != parser.ICMPv4FragmentationNeeded {
+			t.Errorf("%s: got ICMP type %d code %d", tt.name, l4[0], l4[1])
+		}
+	}
+
+	if _, err := PacketTooBigFrom(udpPacket(t, testSrc6, testDst6, 5000, big), group6, 1280); err == nil {
+		t.Error("accepted a multicast source address")
+	}
+}
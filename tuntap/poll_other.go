@@ -0,0 +1,13 @@
+// +build !linux,!windows,!solaris
+
+package tuntap
+
+import (
+	"os"
+)
+
+// pollFile returns file: descriptors are ready for the runtime poller
+// as soon as they're open.
+func pollFile(file *os.File) (*os.File, error) {
+	return file, nil
+}
@@ -0,0 +1,256 @@
+package tuntap
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errWouldBlock is returned by reads that would have to wait for a
+// packet.
+var errWouldBlock = errors.New("Read would block")
+
+// pollBatch is the number of ready descriptors fetched per poll.
+const pollBatch = 64
+
+// A PollGroup waits for packets on many interfaces at once, with a
+// single epoll or kqueue descriptor, so that serving them doesn't take
+// a goroutine per interface. It's safe for concurrent use.
+//
+// PollGroups are only supported on Linux, macOS, FreeBSD and OpenBSD,
+// for interfaces backed by a file descriptor.
+type PollGroup struct {
+	file *os.File
+	mu   sync.Mutex
+	// Queues in the group, by descriptor.
+	members map[int]*Interface
+	// Descriptors found readable and not drained yet, in the order
+	// ReadAny serves them.
+	ready  []int
+	queued map[int]bool
+	// Set to 1 by Close.
+	closed int32
+}
+
+// NewPollGroup creates an empty PollGroup.
+func NewPollGroup() (*PollGroup, error) {
+	file, err := openPoller()
+	if err != nil {
+		return nil, err
+	}
+	return &PollGroup{
+		file:    file,
+		members: make(map[int]*Interface),
+		queued:  make(map[int]bool),
+	}, nil
+}
+
+// Add adds t to the group, with all its queues if it's a multiqueue
+// interface. Remove it before closing it.
+func (g *PollGroup) Add(t *Interface) error {
+	queues := t.queues
+	if queues == nil {
+		queues = []*Interface{t}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, q := range queues {
+		file, err := q.file()
+		if err != nil {
+			return err
+		}
+		err = control(file, func(fd uintptr) error {
+			if _, ok := g.members[int(fd)]; ok {
+				return errors.New("Interface already in the PollGroup")
+			}
+			if err := g.control(func(pfd int) error { return pollAdd(pfd, int(fd)) }); err != nil {
+				return err
+			}
+			g.members[int(fd)] = q
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove removes t and its queues from the group.
+func (g *PollGroup) Remove(t *Interface) error {
+	queues := t.queues
+	if queues == nil {
+		queues = []*Interface{t}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	found := false
+	for fd, m := range g.members {
+		for _, q := range queues {
+			if m == q {
+				g.remove(fd)
+				found = true
+			}
+		}
+	}
+	if !found {
+		return errors.New("Interface not in the PollGroup")
+	}
+	return nil
+}
+
+// remove drops fd from the group. g.mu must be held.
+func (g *PollGroup) remove(fd int) {
+	// The descriptor may already be closed, which removed it from the
+	// kernel side.
+	g.control(func(pfd int) error { return pollRemove(pfd, fd) })
+	delete(g.members, fd)
+	if g.queued[fd] {
+		delete(g.queued, fd)
+		for i, r := range g.ready {
+			if r == fd {
+				g.ready = append(g.ready[:i], g.ready[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// control runs f on the poll descriptor.
+func (g *PollGroup) control(f func(pfd int) error) error {
+	return control(g.file, func(fd uintptr) error { return f(int(fd)) })
+}
+
+// poll waits until some of the descriptors in the group are readable
+// and returns them.
+func (g *PollGroup) poll() ([]int, error) {
+	rc, err := g.file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	fds := make([]int, pollBatch)
+	var n int
+	var perr error
+	err = rc.Read(func(fd uintptr) bool {
+		n, perr = pollWait(int(fd), fds)
+		return n > 0 || perr != nil
+	})
+	if err != nil {
+		if atomic.LoadInt32(&g.closed) != 0 {
+			return nil, ErrClosed
+		}
+		return nil, err
+	}
+	if perr != nil {
+		return nil, perr
+	}
+	return fds[:n], nil
+}
+
+// Wait blocks until at least one interface in the group has packets to
+// read, and returns the interfaces that have. Each call polls anew, so
+// it can be mixed with reading the packets with ReadPacket.
+func (g *PollGroup) Wait() ([]*Interface, error) {
+	for {
+		fds, err := g.poll()
+		if err != nil {
+			return nil, err
+		}
+		var ready []*Interface
+		g.mu.Lock()
+		for _, fd := range fds {
+			if t, ok := g.members[fd]; ok {
+				ready = append(ready, t)
+			}
+		}
+		g.mu.Unlock()
+		if len(ready) > 0 {
+			return ready, nil
+		}
+	}
+}
+
+// ReadAny reads a packet from any interface in the group, blocking until
+// one has some, and returns it with the interface it was read from. The
+// interfaces with packets waiting are served in turn, so a busy one
+// doesn't starve the others.
+//
+// An error reading from an interface is returned with the interface,
+// like ReadPacket would return it. Interfaces failing with ErrClosed or
+// ErrDeviceGone are removed from the group.
+func (g *PollGroup) ReadAny() (*Interface, *IPPacket, error) {
+	for {
+		g.mu.Lock()
+		if len(g.ready) == 0 {
+			g.mu.Unlock()
+			fds, err := g.poll()
+			if err != nil {
+				return nil, nil, err
+			}
+			g.mu.Lock()
+			for _, fd := range fds {
+				if _, ok := g.members[fd]; ok && !g.queued[fd] {
+					g.queued[fd] = true
+					g.ready = append(g.ready, fd)
+				}
+			}
+			g.mu.Unlock()
+			continue
+		}
+		fd := g.ready[0]
+		g.ready = g.ready[1:]
+		delete(g.queued, fd)
+		t := g.members[fd]
+		g.mu.Unlock()
+
+		pkt, err := t.readPacketNow()
+		if err == errWouldBlock {
+			continue
+		}
+
+		g.mu.Lock()
+		if g.members[fd] == t {
+			switch {
+			case errors.Is(err, ErrClosed) || errors.Is(err, ErrDeviceGone):
+				g.remove(fd)
+			case !g.queued[fd]:
+				// More packets may be waiting: serve t again after the
+				// others, until a read finds it drained.
+				g.queued[fd] = true
+				g.ready = append(g.ready, fd)
+			}
+		}
+		g.mu.Unlock()
+		return t, pkt, err
+	}
+}
+
+// readPacketNow is ReadPacket failing with errWouldBlock instead of
+// waiting if no packet is queued.
+func (t *Interface) readPacketNow() (*IPPacket, error) {
+	file, err := t.file()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	return t.readPacket(func(b []byte) (int, error) { return readNow(rc, b) })
+}
+
+// SetDeadline sets the deadline for Wait and ReadAny. Once it's past,
+// they fail with an error wrapping os.ErrDeadlineExceeded. A zero t
+// means no deadline.
+func (g *PollGroup) SetDeadline(t time.Time) error {
+	return g.file.SetReadDeadline(t)
+}
+
+// Close closes the PollGroup, unblocking Wait and ReadAny, which then
+// fail with ErrClosed. The interfaces in it are left open.
+func (g *PollGroup) Close() error {
+	atomic.StoreInt32(&g.closed, 1)
+	return g.file.Close()
+}
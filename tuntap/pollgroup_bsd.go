@@ -0,0 +1,51 @@
+// +build darwin freebsd openbsd
+
+package tuntap
+
+import (
+	"os"
+	"syscall"
+)
+
+func openPoller() (*os.File, error) {
+	fd, err := syscall.Kqueue()
+	if err != nil {
+		return nil, os.NewSyscallError("kqueue", err)
+	}
+	return newPollFile(fd, "kqueue")
+}
+
+func pollChange(pfd, fd, flags int) error {
+	var ev syscall.Kevent_t
+	syscall.SetKevent(&ev, fd, syscall.EVFILT_READ, flags)
+	_, err := syscall.Kevent(pfd, []syscall.Kevent_t{ev}, nil, nil)
+	return os.NewSyscallError("kevent", err)
+}
+
+func pollAdd(pfd, fd int) error {
+	return pollChange(pfd, fd, syscall.EV_ADD)
+}
+
+func pollRemove(pfd, fd int) error {
+	return pollChange(pfd, fd, syscall.EV_DELETE)
+}
+
+// pollWait stores the readable descriptors in fds without waiting, and
+// returns how many there are.
+func pollWait(pfd int, fds []int) (int, error) {
+	evs := make([]syscall.Kevent_t, len(fds))
+	var zero syscall.Timespec
+	for {
+		n, err := syscall.Kevent(pfd, nil, evs, &zero)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, os.NewSyscallError("kevent", err)
+		}
+		for i := 0; i < n; i++ {
+			fds[i] = int(evs[i].Ident)
+		}
+		return n, nil
+	}
+}
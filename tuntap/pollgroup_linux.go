@@ -0,0 +1,42 @@
+package tuntap
+
+import (
+	"os"
+	"syscall"
+)
+
+func openPoller() (*os.File, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	return newPollFile(fd, "epoll")
+}
+
+func pollAdd(pfd, fd int) error {
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	return os.NewSyscallError("epoll_ctl", syscall.EpollCtl(pfd, syscall.EPOLL_CTL_ADD, fd, &ev))
+}
+
+func pollRemove(pfd, fd int) error {
+	return os.NewSyscallError("epoll_ctl", syscall.EpollCtl(pfd, syscall.EPOLL_CTL_DEL, fd, nil))
+}
+
+// pollWait stores the readable descriptors in fds without waiting, and
+// returns how many there are.
+func pollWait(pfd int, fds []int) (int, error) {
+	evs := make([]syscall.EpollEvent, len(fds))
+	for {
+		n, err := syscall.EpollWait(pfd, evs, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, os.NewSyscallError("epoll_wait", err)
+		}
+		for i := 0; i < n; i++ {
+			fds[i] = int(evs[i].Fd)
+		}
+		return n, nil
+	}
+}
@@ -0,0 +1,29 @@
+// +build !linux,!darwin,!freebsd,!openbsd
+
+package tuntap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func openPoller() (*os.File, error) {
+	return nil, errors.New("PollGroup is not supported on this platform")
+}
+
+func pollAdd(pfd, fd int) error {
+	return errors.New("PollGroup is not supported on this platform")
+}
+
+func pollRemove(pfd, fd int) error {
+	return errors.New("PollGroup is not supported on this platform")
+}
+
+func pollWait(pfd int, fds []int) (int, error) {
+	return 0, errors.New("PollGroup is not supported on this platform")
+}
+
+func readNow(rc syscall.RawConn, b []byte) (int, error) {
+	return 0, errors.New("PollGroup is not supported on this platform")
+}
@@ -0,0 +1,45 @@
+// +build linux darwin freebsd openbsd
+
+package tuntap
+
+import (
+	"os"
+	"syscall"
+)
+
+// newPollFile wraps the poll descriptor fd in a File registered with the
+// runtime poller: waiting for it parks the goroutine instead of a
+// thread, and deadlines and Close work as on an Interface.
+func newPollFile(fd int, name string) (*os.File, error) {
+	syscall.CloseOnExec(fd)
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("fcntl", err)
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// readNow reads a packet from rc without waiting, failing with
+// errWouldBlock if none is queued.
+func readNow(rc syscall.RawConn, b []byte) (int, error) {
+	var n int
+	var rerr error
+	err := rc.Read(func(fd uintptr) bool {
+		for {
+			n, rerr = syscall.Read(int(fd), b)
+			if rerr != syscall.EINTR {
+				return true
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if rerr == syscall.EAGAIN {
+		return 0, errWouldBlock
+	}
+	if rerr != nil {
+		return 0, rerr
+	}
+	return n, nil
+}
@@ -0,0 +1,94 @@
+package tuntap
+
+import (
+	"strconv"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// IPProtocol is an IP protocol number, as found in the IPv6 next header
+// and IPv4 protocol fields.
+type IPProtocol uint8
+
+const (
+	ProtoHopByHop IPProtocol = parser.IPv6HopByHop
+	ProtoICMP     IPProtocol = parser.ProtoICMP
+	ProtoIGMP     IPProtocol = 2
+	ProtoIPIP     IPProtocol = parser.ProtoIPIP
+	ProtoTCP      IPProtocol = parser.ProtoTCP
+	ProtoUDP      IPProtocol = parser.ProtoUDP
+	ProtoIPv6     IPProtocol = parser.ProtoIPv6
+	ProtoRouting  IPProtocol = parser.IPv6Routing
+	ProtoFragment IPProtocol = parser.IPv6Fragment
+	ProtoGRE      IPProtocol = parser.ProtoGRE
+	ProtoESP      IPProtocol = 50
+	ProtoAH       IPProtocol = parser.IPv6AuthHeader
+	ProtoICMPv6   IPProtocol = parser.ProtoICMPv6
+	ProtoNoNext   IPProtocol = parser.IPv6NoNextHeader
+	ProtoDestOpts IPProtocol = parser.IPv6DestOpts
+	ProtoSCTP     IPProtocol = 132
+)
+
+var protocolNames = map[IPProtocol]string{
+	ProtoHopByHop: "HOPOPT",
+	ProtoICMP:     "ICMP",
+	ProtoIGMP:     "IGMP",
+	ProtoIPIP:     "IPIP",
+	ProtoTCP:      "TCP",
+	ProtoUDP:      "UDP",
+	ProtoIPv6:     "IPv6",
+	ProtoRouting:  "IPv6-Route",
+	ProtoFragment: "IPv6-Frag",
+	ProtoGRE:      "GRE",
+	ProtoESP:      "ESP",
+	ProtoAH:       "AH",
+	ProtoICMPv6:   "ICMPv6",
+	ProtoNoNext:   "IPv6-NoNxt",
+	ProtoDestOpts: "IPv6-Opts",
+	ProtoSCTP:     "SCTP",
+}
+
+func (p IPProtocol) String() string {
+	if name, ok := protocolNames[p]; ok {
+		return name
+	}
+	return "IPProtocol(" + strconv.Itoa(int(p)) + ")"
+}
+
+// protocolOffset returns the offset of the IPv6 next header or IPv4
+// protocol field.
+func (h IPHeader) protocolOffset() (int, error) {
+	switch {
+	case len(h.Data) >= 40 && h.version() == 6:
+		return 6, nil
+	case len(h.Data) >= 20 && h.version() == 4:
+		return 9, nil
+	}
+	return 0, ErrNotIP
+}
+
+// NextHeader returns the IPv6 next header field, which may be an
+// extension header, or the protocol of an IPv4 header. See
+// IPPacket.UpperLayer for the upper-layer protocol.
+func (h IPHeader) NextHeader() IPProtocol {
+	off, err := h.protocolOffset()
+	if err != nil {
+		return 0
+	}
+	return IPProtocol(h.Data[off])
+}
+
+// SetNextHeader sets the IPv6 next header field or the protocol of an
+// IPv4 header, updating the IPv4 header checksum.
+func (h IPHeader) SetNextHeader(p IPProtocol) error {
+	off, err := h.protocolOffset()
+	if err != nil {
+		return err
+	}
+	if h.version() == 4 {
+		h.setIPv4Byte(off, uint8(p))
+		return nil
+	}
+	h.Data[off] = uint8(p)
+	return nil
+}
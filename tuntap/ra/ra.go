@@ -0,0 +1,313 @@
+// Package ra sends IPv6 router advertisements on DevTap interfaces, so
+// that the hosts behind them configure their addresses with SLAAC, their
+// MTU and their DNS servers without a radvd. An Advertiser multicasts
+// advertisements periodically from Run, and answers the router
+// solicitations given to HandleFrame:
+//
+//	a, err := ra.NewAdvertiser(t, ra.Config{
+//		SourceMAC: mac,
+//		Prefixes:  []netip.Prefix{netip.MustParsePrefix("fd00:1::/64")},
+//		DNS:       []netip.Addr{netip.MustParseAddr("fd00:1::1")},
+//	})
+//	go a.Run(ctx)
+//	for {
+//		f, err := t.ReadFrame()
+//		...
+//		if ok, err := a.HandleFrame(f); ok {
+//			continue
+//		}
+//		// Not a router solicitation.
+//	}
+package ra
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// Defaults of the Config fields (RFC 4861 section 6.2.1).
+const (
+	DefaultInterval          = 10 * time.Minute
+	DefaultValidLifetime     = 30 * 24 * time.Hour
+	DefaultPreferredLifetime = 7 * 24 * time.Hour
+)
+
+const (
+	// Hop limit of NDP messages, checked by their receivers.
+	ndpHopLimit = 255
+	// The first advertisements are sent at most this far apart.
+	initialInterval = 16 * time.Second
+	initialCount    = 3
+	// Minimum time between multicast advertisements.
+	minDelay = 3 * time.Second
+	// Largest router lifetime, in seconds.
+	maxRouterLifetime = 9000
+)
+
+var (
+	allNodes    = netip.MustParseAddr("ff02::1")
+	allNodesMAC = net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1}
+)
+
+// Config configures an Advertiser.
+type Config struct {
+	// MAC address the advertisements are sent from, and advertised in
+	// their source link-layer address option.
+	SourceMAC net.HardwareAddr
+	// Link-local source address of the advertisements, by default the
+	// EUI-64 one derived from SourceMAC.
+	SourceIP netip.Addr
+	// Prefixes advertised on-link, those of length 64 also for SLAAC.
+	Prefixes []netip.Prefix
+	// Lifetimes of the prefixes, DefaultValidLifetime and
+	// DefaultPreferredLifetime if zero.
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+	// Advertised link MTU, none if zero.
+	MTU int
+	// Advertised recursive DNS servers.
+	DNS []netip.Addr
+	// Advertised hop limit, none if zero.
+	HopLimit uint8
+	// Lifetime of the default route through the advertiser, three times
+	// Interval if zero, and no default route if negative.
+	RouterLifetime time.Duration
+	// Maximum time between the periodic advertisements, DefaultInterval
+	// if zero. They are sent at random times between a third of it and
+	// it.
+	Interval time.Duration
+}
+
+// Advertiser sends router advertisements on a DevTap interface. It's
+// safe for concurrent use.
+type Advertiser struct {
+	t        *tuntap.Interface
+	mac      net.HardwareAddr
+	src      netip.Addr
+	interval time.Duration
+	// The advertisement body, and the one ceasing to be a router.
+	body  []byte
+	final []byte
+
+	mu sync.Mutex
+	// When the last multicast advertisement was sent.
+	last time.Time
+}
+
+// NewAdvertiser returns an Advertiser for the DevTap interface t.
+func NewAdvertiser(t *tuntap.Interface, cfg Config) (*Advertiser, error) {
+	if a, ok := t.LocalAddr().(*tuntap.Addr); !ok || a.Kind != tuntap.DevTap {
+		return nil, errors.New("Router advertisements need a DevTap interface")
+	}
+	if len(cfg.SourceMAC) != 6 {
+		return nil, errors.New("Router advertisements need a 6-byte source MAC address")
+	}
+	a := &Advertiser{
+		t:        t,
+		mac:      append(net.HardwareAddr(nil), cfg.SourceMAC...),
+		src:      cfg.SourceIP,
+		interval: cfg.Interval,
+	}
+	if !a.src.IsValid() {
+		a.src = linkLocal(a.mac)
+	}
+	if !a.src.Is6() || !a.src.IsLinkLocalUnicast() {
+		return nil, errors.New("Router advertisements are sent from a link-local address")
+	}
+	if a.interval <= 0 {
+		a.interval = DefaultInterval
+	}
+
+	lifetime := cfg.RouterLifetime
+	if lifetime == 0 {
+		lifetime = 3 * a.interval
+	}
+	adv := parser.RouterAdvertisement{
+		HopLimit:       cfg.HopLimit,
+		RouterLifetime: uint16(seconds(lifetime, maxRouterLifetime)),
+		Options:        []parser.NDPOption{parser.NDPLinkLayerAddr(parser.NDPSourceLinkLayerAddr, a.mac)},
+	}
+	if cfg.MTU != 0 {
+		if cfg.MTU < 1280 {
+			return nil, errors.New("IPv6 MTU below 1280")
+		}
+		adv.Options = append(adv.Options, parser.NDPMTUOption(uint32(cfg.MTU)))
+	}
+	valid, preferred := cfg.ValidLifetime, cfg.PreferredLifetime
+	if valid <= 0 {
+		valid = DefaultValidLifetime
+	}
+	if preferred <= 0 {
+		preferred = DefaultPreferredLifetime
+	}
+	if preferred > valid {
+		preferred = valid
+	}
+	for _, p := range cfg.Prefixes {
+		if !p.Addr().Is6() || p.Addr().Is4In6() {
+			return nil, errors.New("Prefix " + p.String() + " isn't an IPv6 prefix")
+		}
+		adv.Options = append(adv.Options, parser.NDPPrefix{
+			Prefix:            p.Masked(),
+			OnLink:            true,
+			Autonomous:        p.Bits() == 64,
+			ValidLifetime:     seconds(valid, 0xffffffff),
+			PreferredLifetime: seconds(preferred, 0xffffffff),
+		}.Option())
+	}
+	if len(cfg.DNS) > 0 {
+		for _, s := range cfg.DNS {
+			if !s.Is6() || s.Is4In6() {
+				return nil, errors.New("DNS server " + s.String() + " isn't an IPv6 address")
+			}
+		}
+		// RFC 8106 section 5.1.
+		adv.Options = append(adv.Options, parser.NDPRDNSSOption(seconds(3*a.interval, 0xffffffff), cfg.DNS))
+	}
+	a.body = adv.Marshal()
+	adv.RouterLifetime = 0
+	a.final = adv.Marshal()
+	return a, nil
+}
+
+// seconds returns d in whole seconds, at most max and zero if negative.
+func seconds(d time.Duration, max uint32) uint32 {
+	s := d / time.Second
+	if s < 0 {
+		return 0
+	}
+	if s > time.Duration(max) {
+		return max
+	}
+	return uint32(s)
+}
+
+// linkLocal returns the link-local address with the modified EUI-64
+// interface identifier of mac.
+func linkLocal(mac net.HardwareAddr) netip.Addr {
+	b := [16]byte{0: 0xfe, 1: 0x80}
+	b[8] = mac[0] ^ 0x02
+	b[9], b[10] = mac[1], mac[2]
+	b[11], b[12] = 0xff, 0xfe
+	b[13], b[14], b[15] = mac[3], mac[4], mac[5]
+	return netip.AddrFrom16(b)
+}
+
+// SourceIP returns the address the advertisements are sent from.
+func (a *Advertiser) SourceIP() netip.Addr {
+	return a.src
+}
+
+// Advertise multicasts an advertisement to all nodes now.
+func (a *Advertiser) Advertise() error {
+	a.mu.Lock()
+	a.last = time.Now()
+	a.mu.Unlock()
+	return a.send(a.body, allNodes, allNodesMAC, nil)
+}
+
+// Run multicasts advertisements until ctx is done, the first few of them
+// in quick succession, and then one telling the hosts to stop using the
+// advertiser as a default router. It returns the error of sending one.
+func (a *Advertiser) Run(ctx context.Context) error {
+	for n := 0; ; n++ {
+		if err := a.Advertise(); err != nil {
+			return err
+		}
+		d := a.interval/3 + time.Duration(rand.Int63n(int64(a.interval-a.interval/3)+1))
+		if n < initialCount-1 && d > initialInterval {
+			d = initialInterval
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return a.send(a.final, allNodes, allNodesMAC, nil)
+		}
+	}
+}
+
+// HandleFrame handles f if it's a router solicitation, answering it. The
+// advertisement is unicast to the soliciting host if its address is
+// known, and multicast otherwise, no more often than every 3 seconds.
+// It returns false for other frames, and the error of sending the
+// advertisement.
+func (a *Advertiser) HandleFrame(f *tuntap.EthernetFrame) (bool, error) {
+	if f.EtherType != parser.EtherTypeIPv6 {
+		return false, nil
+	}
+	ip, err := parser.ParseIPv6(f.Payload)
+	if err != nil {
+		return false, nil
+	}
+	p := &tuntap.IPPacket{Protocol: parser.EtherTypeIPv6, Header: tuntap.IPHeader{Data: ip.Header}, Payload: ip.Payload}
+	proto, data, err := p.UpperLayer()
+	if err != nil || proto != tuntap.ProtoICMPv6 {
+		return false, nil
+	}
+	var m parser.ICMPv6Message
+	if err := m.Unmarshal(data); err != nil || m.Type != parser.ICMPv6RouterSolicitation {
+		return false, nil
+	}
+
+	// RFC 4861 section 6.1.1.
+	src, dst := p.Header.Src(), p.Header.Dst()
+	if m.Code != 0 || p.Header.HopLimit() != ndpHopLimit || !parser.VerifyICMPv6Checksum(src, dst, data) {
+		return true, nil
+	}
+	rs, err := parser.ParseRouterSolicitation(m.Body)
+	if err != nil {
+		return true, nil
+	}
+	var lladdr net.HardwareAddr
+	for _, o := range rs.Options {
+		if o.Type == parser.NDPSourceLinkLayerAddr {
+			lladdr = o.LinkLayerAddr()
+		}
+	}
+	if src.IsUnspecified() {
+		if lladdr != nil {
+			return true, nil
+		}
+		a.mu.Lock()
+		now := time.Now()
+		if now.Sub(a.last) < minDelay {
+			a.mu.Unlock()
+			return true, nil
+		}
+		a.last = now
+		a.mu.Unlock()
+		return true, a.send(a.body, allNodes, allNodesMAC, f.VLAN)
+	}
+	if lladdr == nil {
+		lladdr = f.SrcMAC
+	}
+	return true, a.send(a.body, src, lladdr, f.VLAN)
+}
+
+func (a *Advertiser) send(body []byte, dst netip.Addr, dstMAC net.HardwareAddr, vlan *parser.VLANTag) error {
+	m := parser.ICMPv6Message{Type: parser.ICMPv6RouterAdvertisement, Body: body}
+	p, err := tuntap.NewIPv6Packet(a.src, dst, tuntap.ProtoICMPv6, m.Marshal(a.src, dst))
+	if err != nil {
+		return err
+	}
+	if err := p.Header.SetHopLimit(ndpHopLimit); err != nil {
+		return err
+	}
+	p.Frame = &tuntap.EthernetFrame{
+		DstMAC:    dstMAC,
+		SrcMAC:    a.mac,
+		VLAN:      vlan,
+		EtherType: parser.EtherTypeIPv6,
+	}
+	return a.t.WritePacket(p)
+}
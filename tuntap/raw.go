@@ -0,0 +1,51 @@
+package tuntap
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Raw returns the interface as a plain io.ReadWriteCloser. Each Read
+// and Write transfers exactly one packet (DevTun) or frame (DevTap) as
+// exchanged with the device, including the packet information, address
+// family or virtio header if the interface uses one. Nothing is parsed
+// or validated.
+//
+// Closing it closes the Interface.
+func (t *Interface) Raw() io.ReadWriteCloser {
+	return rawInterface{t}
+}
+
+type rawInterface struct {
+	t *Interface
+}
+
+func (r rawInterface) Read(b []byte) (int, error) {
+	if atomic.LoadInt32(&r.t.closed) != 0 {
+		return 0, ErrClosed
+	}
+	start := r.t.stats.start()
+	n, err := retryIO(func() (int, error) { return r.t.dev.Read(b) })
+	if err != nil {
+		err = r.t.ioError("read", err)
+	}
+	r.t.stats.received(start, n, err)
+	return n, err
+}
+
+func (r rawInterface) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&r.t.closed) != 0 {
+		return 0, ErrClosed
+	}
+	start := r.t.stats.start()
+	n, err := retryIO(func() (int, error) { return r.t.dev.Write(b) })
+	if err != nil {
+		err = r.t.ioError("write", err)
+	}
+	r.t.stats.sent(start, n, err)
+	return n, err
+}
+
+func (r rawInterface) Close() error {
+	return r.t.Close()
+}
@@ -0,0 +1,38 @@
+package tuntap
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+const (
+	// Retries of an I/O failing with EAGAIN, and the longest wait
+	// between them.
+	maxAgainRetries = 8
+	maxAgainDelay   = 64 * time.Millisecond
+)
+
+// retryIO runs the read or write f until it fails with something else
+// than a transient error. EINTR is retried at once. EAGAIN, which
+// descriptors handled by the runtime poller never return but others
+// may, is retried a few times with a growing delay.
+func retryIO(f func() (int, error)) (int, error) {
+	delay := time.Millisecond
+	for again := 0; ; {
+		n, err := f()
+		switch {
+		case err == nil || n > 0:
+			return n, err
+		case errors.Is(err, syscall.EINTR):
+		case errors.Is(err, syscall.EAGAIN) && again < maxAgainRetries:
+			again++
+			time.Sleep(delay)
+			if delay *= 2; delay > maxAgainDelay {
+				delay = maxAgainDelay
+			}
+		default:
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package tuntap
+
+import (
+	"errors"
+
+	"golang.org/x/net/bpf"
+)
+
+// newFilterVM returns the BPF VM running prog.
+func newFilterVM(prog []bpf.RawInstruction) (*bpf.VM, error) {
+	if len(prog) == 0 {
+		return nil, errors.New("Empty filter program")
+	}
+	insns, ok := bpf.Disassemble(prog)
+	if !ok {
+		return nil, errors.New("Filter program has instructions the BPF VM doesn't support")
+	}
+	return bpf.NewVM(insns)
+}
+
+// setSoftFilter installs vm on all queues of the interface as the
+// filter run on the packets read, in userspace; nil removes it.
+func (t *Interface) setSoftFilter(vm *bpf.VM) {
+	queues := t.queues
+	if queues == nil {
+		queues = []*Interface{t}
+	}
+	for _, q := range queues {
+		q.softFilter.Store(vm)
+	}
+}
+
+// softFiltered runs the userspace filter, if any, on data read from the
+// device, and returns what it keeps, like the kernel does: nil if it's
+// dropped, counted in RxDropped, and the first bytes of it, marked in
+// info as truncated, if the filter returns less than its length.
+func (t *Interface) softFiltered(data []byte, info *rawInfo) []byte {
+	vm, _ := t.softFilter.Load().(*bpf.VM)
+	if vm == nil {
+		return data
+	}
+	n, err := vm.Run(data)
+	if err != nil || n == 0 {
+		t.stats.rxDropped.Add(1)
+		return nil
+	}
+	if n < len(data) {
+		info.truncated = true
+		data = data[:n]
+	}
+	return data
+}
+
+// BPFFilter returns the Middleware passing on the packets the classic
+// BPF program prog accepts, and dropping the others, run in userspace
+// by the golang.org/x/net/bpf VM. The program sees the IP packet from
+// its first byte, like a pcap filter compiled for raw IP (DLT_RAW), and
+// the packets it accepts are passed on whole.
+func BPFFilter(prog []bpf.RawInstruction) (Middleware, error) {
+	vm, err := newFilterVM(prog)
+	if err != nil {
+		return nil, err
+	}
+	return func(p *IPPacket, next Handler) error {
+		if n, err := vm.Run(p.ipBytes()); err != nil || n == 0 {
+			return nil
+		}
+		return next(p)
+	}, nil
+}
+
+// ipBytes returns the packet in one slice, without copying if its
+// header and payload are contiguous.
+func (p *IPPacket) ipBytes() []byte {
+	h := p.Header.Data
+	if len(p.Payload) == 0 {
+		return h
+	}
+	if n := len(h); cap(h) >= n+len(p.Payload) && &h[:n+1][n] == &p.Payload[0] {
+		return h[:n+len(p.Payload)]
+	}
+	return append(append(make([]byte, 0, len(h)+len(p.Payload)), h...), p.Payload...)
+}
@@ -0,0 +1,78 @@
+package tuntap
+
+import (
+	"errors"
+	"io"
+	"syscall"
+)
+
+// spliceBatch is the number of packets CopyPackets moves per SpliceTo.
+const spliceBatch = 64
+
+// SpliceTo moves up to n packets read from the interface to dst, as
+// exchanged with the device like through Raw, each in a single write.
+// It returns the number of packets moved, blocking until there are n
+// unless an error stops it.
+//
+// On Linux, it tries splice(2) through a pipe, which keeps the packets
+// in the kernel. The tun driver of current kernels doesn't support it
+// though: SpliceTo then copies each packet once, through a buffer
+// reused for all of them, which needs dst to be an io.Writer too.
+func (t *Interface) SpliceTo(dst syscall.Conn, n int) (int, error) {
+	rc, err := dst.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	if moved, ok, err := t.splice(rc, n); ok {
+		return moved, err
+	}
+
+	w, ok := dst.(io.Writer)
+	if !ok {
+		return 0, errors.New("Destination can't be written to")
+	}
+	return t.copyPackets(w, n)
+}
+
+// copyPackets copies up to n packets read from t to w.
+func (t *Interface) copyPackets(w io.Writer, n int) (int, error) {
+	r := t.Raw()
+	var buf []byte
+	for i := 0; i < n; i++ {
+		if size := t.bufferSize(); len(buf) < size {
+			// Grown after a truncated read.
+			buf = make([]byte, size)
+		}
+		m, err := r.Read(buf)
+		if err != nil {
+			return i, err
+		}
+		t.filled(m, len(buf))
+		if _, err := w.Write(buf[:m]); err != nil {
+			return i, err
+		}
+	}
+	return n, nil
+}
+
+// CopyPackets copies packets from src to dst until reading or writing
+// fails, and returns the number of packets copied and the error. Each
+// packet is given to dst in a single Write, as exchanged with the
+// device like through Raw. When dst is a syscall.Conn, like os.File and
+// the net connections, the packets are moved with SpliceTo.
+func CopyPackets(dst io.Writer, src *Interface) (int64, error) {
+	var total int64
+	for {
+		var n int
+		var err error
+		if c, ok := dst.(syscall.Conn); ok {
+			n, err = src.SpliceTo(c, spliceBatch)
+		} else {
+			n, err = src.copyPackets(dst, spliceBatch)
+		}
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package tuntap
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+	"syscall"
+)
+
+const spliceFNonblock = 0x2
+
+func (t *Interface) splice(dst syscall.RawConn, n int) (int, bool, error) {
+	if t.noSplice.Load() {
+		return 0, false, nil
+	}
+	file, err := t.file()
+	if err != nil {
+		return 0, false, nil
+	}
+	src, err := file.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		return 0, true, os.NewSyscallError("pipe2", err)
+	}
+	defer syscall.Close(p[0])
+	defer syscall.Close(p[1])
+
+	for i := 0; i < n; i++ {
+		if atomic.LoadInt32(&t.closed) != 0 {
+			return i, true, ErrClosed
+		}
+		start := t.stats.start()
+		var size int
+		var serr error
+		err := src.Read(func(fd uintptr) bool {
+			for {
+				// The count is an int64 on 64-bit systems only.
+				sn, e := syscall.Splice(int(fd), nil, p[1], nil, t.bufferSize(), spliceFNonblock)
+				size, serr = int(sn), e
+				if serr != syscall.EINTR {
+					return serr != syscall.EAGAIN
+				}
+			}
+		})
+		// The kernel doesn't splice from this tun descriptor, so
+		// there's no point trying again.
+		if i == 0 && (serr == syscall.EINVAL || serr == syscall.ENOSYS) {
+			t.noSplice.Store(true)
+			return 0, false, nil
+		}
+		if err == nil {
+			err = serr
+		}
+		if err != nil {
+			err = t.ioError("read", err)
+			t.stats.received(start, 0, err)
+			return i, true, err
+		}
+		t.stats.received(start, size, nil)
+
+		// Send the packet in one go, an empty pipe in between.
+		for left := size; left > 0; {
+			var m int
+			var werr error
+			err := dst.Write(func(fd uintptr) bool {
+				for {
+					sm, e := syscall.Splice(p[0], nil, int(fd), nil, left, spliceFNonblock)
+					m, werr = int(sm), e
+					if werr != syscall.EINTR {
+						return werr != syscall.EAGAIN
+					}
+				}
+			})
+			if err == nil {
+				err = werr
+			}
+			if err == nil && m == 0 {
+				err = io.ErrShortWrite
+			}
+			if err != nil {
+				return i, true, os.NewSyscallError("splice", err)
+			}
+			left -= m
+		}
+	}
+	return n, true, nil
+}
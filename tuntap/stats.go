@@ -0,0 +1,131 @@
+package tuntap
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are packet counters of an interface.
+type Stats struct {
+	RxPackets uint64
+	RxBytes   uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxPackets uint64
+	TxBytes   uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// counters maintain the Stats of the I/O done through an Interface.
+type counters struct {
+	rxPackets atomic.Uint64
+	rxBytes   atomic.Uint64
+	rxErrors  atomic.Uint64
+	rxDropped atomic.Uint64
+	txPackets atomic.Uint64
+	txBytes   atomic.Uint64
+	txErrors  atomic.Uint64
+	// The IOHook, if any.
+	hook atomic.Value
+}
+
+// An IOHook observes the I/O done through an Interface. It's called
+// after each packet read or written, with the time the operation took
+// and its error, nil on success. Reads include the time spent waiting
+// for a packet.
+type IOHook func(write bool, d time.Duration, err error)
+
+// SetIOHook installs h on all queues of the interface, replacing the
+// previous hook; nil removes it. h is called by the goroutines doing
+// the I/O, so it must be fast and safe for concurrent use.
+func (t *Interface) SetIOHook(h IOHook) {
+	queues := t.queues
+	if queues == nil {
+		queues = []*Interface{t}
+	}
+	for _, q := range queues {
+		q.stats.hook.Store(h)
+	}
+}
+
+// start returns the start time of an operation to pass to received or
+// sent, the zero Time if there's no hook to time it for.
+func (c *counters) start() time.Time {
+	if h, _ := c.hook.Load().(IOHook); h != nil {
+		return time.Now()
+	}
+	return time.Time{}
+}
+
+func (c *counters) observe(write bool, start time.Time, err error) {
+	if start.IsZero() {
+		return
+	}
+	if h, _ := c.hook.Load().(IOHook); h != nil {
+		h(write, time.Since(start), err)
+	}
+}
+
+// countable tells whether err is a genuine I/O error, rather than the
+// result of a deadline or of closing the interface.
+func countable(err error) bool {
+	return !errors.Is(err, ErrClosed) && !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// received counts the result of a read of n bytes.
+func (c *counters) received(start time.Time, n int, err error) {
+	c.observe(false, start, err)
+	if err != nil {
+		if countable(err) {
+			c.rxErrors.Add(1)
+		}
+		return
+	}
+	c.rxPackets.Add(1)
+	c.rxBytes.Add(uint64(n))
+}
+
+// sent counts the result of a write of n bytes.
+func (c *counters) sent(start time.Time, n int, err error) {
+	c.observe(true, start, err)
+	if err != nil {
+		if countable(err) {
+			c.txErrors.Add(1)
+		}
+		return
+	}
+	c.txPackets.Add(1)
+	c.txBytes.Add(uint64(n))
+}
+
+// Stats returns the counters of the packets read and written through
+// the interface by this package, summed over all queues of a multiqueue
+// interface. Byte counts include the packet information, address family
+// and virtio headers.
+//
+// RxErrors and TxErrors count failed reads and writes, not counting
+// deadlines and Close. RxDropped counts the packets read but discarded:
+// the malformed packets skipped by ReadPackets and Packets, and the
+// packets dropped by the DropPolicy of Packets. TxDropped is only
+// meaningful in LinkStats.
+func (t *Interface) Stats() Stats {
+	queues := t.queues
+	if queues == nil {
+		queues = []*Interface{t}
+	}
+	var s Stats
+	for _, q := range queues {
+		c := &q.stats
+		s.RxPackets += c.rxPackets.Load()
+		s.RxBytes += c.rxBytes.Load()
+		s.RxErrors += c.rxErrors.Load()
+		s.RxDropped += c.rxDropped.Load()
+		s.TxPackets += c.txPackets.Load()
+		s.TxBytes += c.txBytes.Load()
+		s.TxErrors += c.txErrors.Load()
+	}
+	return s
+}
@@ -0,0 +1,37 @@
+package tuntap
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// LinkStats returns the counters kept by the kernel for the network
+// interface, as in /proc/net/dev. They're seen from the network stack:
+// Tx counts the packets the kernel sent to the device, to be read from
+// the Interface, and Rx the ones written to it.
+func (t *Interface) LinkStats() (Stats, error) {
+	var s Stats
+	for _, c := range []struct {
+		name string
+		v    *uint64
+	}{
+		{"rx_packets", &s.RxPackets},
+		{"rx_bytes", &s.RxBytes},
+		{"rx_errors", &s.RxErrors},
+		{"rx_dropped", &s.RxDropped},
+		{"tx_packets", &s.TxPackets},
+		{"tx_bytes", &s.TxBytes},
+		{"tx_errors", &s.TxErrors},
+		{"tx_dropped", &s.TxDropped},
+	} {
+		b, err := ioutil.ReadFile("/sys/class/net/" + t.Name() + "/statistics/" + c.name)
+		if err != nil {
+			return Stats{}, err
+		}
+		if *c.v, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return Stats{}, err
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package tuntap
+
+import (
+	"errors"
+)
+
+// LinkStats returns the counters kept by the kernel for the network
+// interface.
+func (t *Interface) LinkStats() (Stats, error) {
+	return Stats{}, errors.New("Link statistics are not supported on this platform")
+}
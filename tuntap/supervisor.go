@@ -0,0 +1,237 @@
+package tuntap
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// Defaults of SupervisorOptions.
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+// SupervisorOptions configure a Supervisor.
+type SupervisorOptions struct {
+	// Open opens the interface, at first and again each time it's
+	// lost, for example with Open or OpenWithOptions.
+	Open func() (*Interface, error)
+	// Configure, if set, is called on each reopened interface before
+	// it's used, to restore its addresses, routes and settings. The
+	// interface is closed and opened again if it fails.
+	Configure func(*Interface) error
+	// Delay between failed attempts to reopen the interface, doubling
+	// from MinBackoff up to MaxBackoff.
+	MinBackoff, MaxBackoff time.Duration
+}
+
+// A Reconnect tells that a Supervisor replaced its interface.
+type Reconnect struct {
+	// The new interface.
+	Interface *Interface
+	// The error that revealed the loss of the previous one.
+	Err error
+}
+
+// A Supervisor keeps an interface open across the loss of its device:
+// when I/O fails with ErrDeviceGone, because a persistent interface was
+// deleted, the driver reloaded or the system resumed from sleep, it
+// opens and configures the interface again and carries on, instead of
+// failing. It's safe for concurrent use.
+type Supervisor struct {
+	opts SupervisorOptions
+	// Held while reopening the interface, so that only one goroutine
+	// does.
+	reopen sync.Mutex
+	mu     sync.Mutex
+	t      *Interface
+	// The interface being replaced, already closed.
+	lost      *Interface
+	closed    bool
+	closeOnce sync.Once
+	done      chan struct{}
+	// Each reconnection sends a Reconnect here.
+	reconnects chan Reconnect
+}
+
+// NewSupervisor opens the interface with opts.Open and configures it,
+// and returns a Supervisor for it.
+func NewSupervisor(opts SupervisorOptions) (*Supervisor, error) {
+	if opts.Open == nil {
+		return nil, errors.New("Supervisor needs an Open function")
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	s := &Supervisor{
+		opts:       opts,
+		done:       make(chan struct{}),
+		reconnects: make(chan Reconnect, 1),
+	}
+	t, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	s.t = t
+	return s, nil
+}
+
+// open opens and configures the interface once.
+func (s *Supervisor) open() (*Interface, error) {
+	t, err := s.opts.Open()
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.Configure != nil {
+		if err := s.opts.Configure(t); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Interface returns the current interface. It changes after a
+// reconnection.
+func (s *Supervisor) Interface() *Interface {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.t
+}
+
+// Reconnects returns a channel receiving a Reconnect each time the
+// interface is replaced. If the previous one wasn't received yet, it's
+// dropped.
+func (s *Supervisor) Reconnects() <-chan Reconnect {
+	return s.reconnects
+}
+
+// recover replaces old, the interface whose I/O failed with cause, and
+// returns the new one. It blocks until the interface could be opened
+// again, or the Supervisor is closed.
+func (s *Supervisor) recover(old *Interface, cause error) (*Interface, error) {
+	s.reopen.Lock()
+	defer s.reopen.Unlock()
+	s.mu.Lock()
+	t, closed := s.t, s.closed
+	if !closed && t == old {
+		s.lost = old
+	}
+	s.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if t != old {
+		// Another goroutine got there first.
+		return t, nil
+	}
+	old.Close()
+
+	// The lock isn't held while waiting, so that Interface and Close
+	// don't block.
+	delay := s.opts.MinBackoff
+	for {
+		t, err := s.open()
+		if err == nil {
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				t.Close()
+				return nil, ErrClosed
+			}
+			s.t, s.lost = t, nil
+			s.mu.Unlock()
+			r := Reconnect{Interface: t, Err: cause}
+			select {
+			case s.reconnects <- r:
+			default:
+				select {
+				case <-s.reconnects:
+				default:
+				}
+				s.reconnects <- r
+			}
+			return t, nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-s.done:
+			timer.Stop()
+			return nil, ErrClosed
+		}
+		if delay *= 2; delay > s.opts.MaxBackoff {
+			delay = s.opts.MaxBackoff
+		}
+	}
+}
+
+// replaced tells whether t is an interface the Supervisor closed to
+// replace it, so that I/O failing with ErrClosed on it can go on with
+// the new one.
+func (s *Supervisor) replaced(t *Interface) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.closed && (s.t != t || s.lost == t)
+}
+
+// do runs f on the current interface, and again on the new one each
+// time the interface is lost.
+func (s *Supervisor) do(f func(t *Interface) error) error {
+	t := s.Interface()
+	for {
+		err := f(t)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrDeviceGone) && !(errors.Is(err, ErrClosed) && s.replaced(t)) {
+			return err
+		}
+		if t, err = s.recover(t, err); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadPacket reads a packet like Interface.ReadPacket, from the current
+// interface. The packets queued on a lost interface are lost with it.
+func (s *Supervisor) ReadPacket() (*IPPacket, error) {
+	var pkt *IPPacket
+	err := s.do(func(t *Interface) error {
+		var err error
+		pkt, err = t.ReadPacket()
+		return err
+	})
+	return pkt, err
+}
+
+// WritePacket writes a packet like Interface.WritePacket, to the current
+// interface. A packet whose write revealed the loss of the interface
+// is sent again on the new one.
+func (s *Supervisor) WritePacket(pkt *IPPacket) error {
+	return s.do(func(t *Interface) error { return t.WritePacket(pkt) })
+}
+
+// Close closes the Supervisor and its interface, and stops reopening
+// it.
+func (s *Supervisor) Close() error {
+	err := ErrClosed
+	s.closeOnce.Do(func() {
+		// Stop a reconnection waiting to try again.
+		close(s.done)
+		s.mu.Lock()
+		s.closed = true
+		t, lost := s.t, s.lost
+		s.mu.Unlock()
+		err = nil
+		if t != lost {
+			err = t.Close()
+		}
+	})
+	return err
+}
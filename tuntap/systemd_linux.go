@@ -0,0 +1,67 @@
+package tuntap
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// First descriptor passed with the LISTEN_FDS protocol.
+const listenFdsStart = 3
+
+var (
+	systemdMu sync.Mutex
+	// Descriptors already wrapped by NewFromSystemd.
+	systemdUsed = make(map[int]bool)
+)
+
+// NewFromSystemd wraps the tun/tap descriptor named name passed by
+// systemd, with the FileDescriptorName= of a socket unit or through the
+// file descriptor store, or by any supervisor implementing the
+// LISTEN_FDS protocol of sd_listen_fds(3). Whether it's a DevTun or a
+// DevTap interface is queried from the descriptor.
+//
+// Each descriptor can only be wrapped once. The Interface owns it and
+// closes it on Close.
+func NewFromSystemd(name string) (*Interface, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("No descriptors passed by systemd")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, errors.New("No descriptors passed by systemd")
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	fd := -1
+	for i := 0; i < n && i < len(names); i++ {
+		if names[i] == name {
+			fd = listenFdsStart + i
+			break
+		}
+	}
+	if fd < 0 {
+		return nil, errors.New("No descriptor named " + name + " passed by systemd")
+	}
+
+	systemdMu.Lock()
+	defer systemdMu.Unlock()
+	if systemdUsed[fd] {
+		return nil, errors.New("Descriptor " + name + " already in use")
+	}
+	// Even on failure: NewInterfaceFromFd closes fd.
+	systemdUsed[fd] = true
+	syscall.CloseOnExec(fd)
+	t, err := NewInterfaceFromFd(fd, DevTun)
+	if err != nil {
+		return nil, err
+	}
+	if t.kind, err = t.Kind(); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,214 @@
+package tuntap
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	tapWindowsComponentID = "tap0901"
+
+	// Registry keys of the network adapter device class.
+	adapterKey = `SYSTEM\CurrentControlSet\Control\Class\{4D36E972-E325-11CE-BFC1-08002BE10318}`
+	networkKey = `SYSTEM\CurrentControlSet\Control\Network\{4D36E972-E325-11CE-BFC1-08002BE10318}`
+
+	// TAP_WIN_CONTROL_CODE(request, METHOD_BUFFERED) from tap-windows.h.
+	tapWinIoctlGetMac         = 0x220004
+	tapWinIoctlGetMtu         = 0x22000c
+	tapWinIoctlSetMediaStatus = 0x220018
+)
+
+// tapWindowsDevice is an open TAP-Windows6 adapter. Each Read or Write
+// transfers one Ethernet frame.
+type tapWindowsDevice struct {
+	handle syscall.Handle
+	// Overlapped I/O in each direction completes on a single event, so
+	// only one operation per direction may be pending at a time.
+	readMu     sync.Mutex
+	readEvent  syscall.Handle
+	writeMu    sync.Mutex
+	writeEvent syscall.Handle
+}
+
+// openTapWindows opens the TAP-Windows6 adapter identified by ifPattern,
+// which is either the adapter GUID ("{...}") or its connection name.
+// It returns the device and the adapter GUID.
+func openTapWindows(ifPattern string) (*tapWindowsDevice, string, error) {
+	guid := ifPattern
+	if !strings.HasPrefix(guid, "{") {
+		var err error
+		if guid, err = tapWindowsGUID(ifPattern); err != nil {
+			return nil, "", err
+		}
+	}
+
+	path, err := syscall.UTF16PtrFromString(`\\.\Global\` + guid + `.tap`)
+	if err != nil {
+		return nil, "", err
+	}
+	handle, err := syscall.CreateFile(path, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_SYSTEM|syscall.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dev := &tapWindowsDevice{handle: handle}
+	for _, ev := range []*syscall.Handle{&dev.readEvent, &dev.writeEvent} {
+		h, _, err := procCreateEventW.Call(0, 1, 0, 0)
+		if h == 0 {
+			dev.Close()
+			return nil, "", err
+		}
+		*ev = syscall.Handle(h)
+	}
+
+	if err := dev.setMediaStatus(true); err != nil {
+		dev.Close()
+		return nil, "", err
+	}
+	return dev, guid, nil
+}
+
+// tapWindowsGUID looks up the GUID of the TAP-Windows6 adapter whose
+// connection name is name.
+func tapWindowsGUID(name string) (string, error) {
+	key, err := regOpen(syscall.HKEY_LOCAL_MACHINE, adapterKey)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.RegCloseKey(key)
+
+	for i := uint32(0); ; i++ {
+		var buf [256]uint16
+		n := uint32(len(buf))
+		if err := syscall.RegEnumKeyEx(key, i, &buf[0], &n, nil, nil, nil, nil); err != nil {
+			break
+		}
+		sub, err := regOpen(key, syscall.UTF16ToString(buf[:n]))
+		if err != nil {
+			continue
+		}
+		component, _ := regString(sub, "ComponentId")
+		guid, _ := regString(sub, "NetCfgInstanceId")
+		syscall.RegCloseKey(sub)
+
+		if component != tapWindowsComponentID && component != `root\`+tapWindowsComponentID {
+			continue
+		}
+		conn, err := regOpen(syscall.HKEY_LOCAL_MACHINE, networkKey+`\`+guid+`\Connection`)
+		if err != nil {
+			continue
+		}
+		connName, _ := regString(conn, "Name")
+		syscall.RegCloseKey(conn)
+		if connName == name {
+			return guid, nil
+		}
+	}
+	return "", errors.New("No TAP-Windows6 adapter named " + name)
+}
+
+func regOpen(parent syscall.Handle, path string) (syscall.Handle, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var key syscall.Handle
+	err = syscall.RegOpenKeyEx(parent, p, 0, syscall.KEY_READ, &key)
+	return key, err
+}
+
+func regString(key syscall.Handle, name string) (string, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return "", err
+	}
+	var buf [256]uint16
+	var typ uint32
+	n := uint32(len(buf) * 2)
+	if err := syscall.RegQueryValueEx(key, p, nil, &typ, (*byte)(unsafe.Pointer(&buf[0])), &n); err != nil {
+		return "", err
+	}
+	if typ != syscall.REG_SZ {
+		return "", errors.New("Registry value " + name + " is not a string")
+	}
+	return syscall.UTF16ToString(buf[:n/2]), nil
+}
+
+func (d *tapWindowsDevice) ioctl(code uint32, in, out []byte) error {
+	var inp, outp *byte
+	if len(in) > 0 {
+		inp = &in[0]
+	}
+	if len(out) > 0 {
+		outp = &out[0]
+	}
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	var n uint32
+	ov := syscall.Overlapped{HEvent: d.writeEvent}
+	err := syscall.DeviceIoControl(d.handle, code, inp, uint32(len(in)), outp, uint32(len(out)), &n, &ov)
+	if err == syscall.ERROR_IO_PENDING {
+		_, err = d.wait(&ov)
+	}
+	return err
+}
+
+// setMediaStatus plugs (true) or unplugs (false) the virtual cable.
+func (d *tapWindowsDevice) setMediaStatus(connected bool) error {
+	status := make([]byte, 4)
+	if connected {
+		status[0] = 1
+	}
+	return d.ioctl(tapWinIoctlSetMediaStatus, status, status)
+}
+
+// wait blocks until the overlapped operation ov completes.
+func (d *tapWindowsDevice) wait(ov *syscall.Overlapped) (uint32, error) {
+	var n uint32
+	r, _, err := procGetOverlappedResult.Call(uintptr(d.handle), uintptr(unsafe.Pointer(ov)), uintptr(unsafe.Pointer(&n)), 1)
+	if r == 0 {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (d *tapWindowsDevice) Read(b []byte) (int, error) {
+	d.readMu.Lock()
+	defer d.readMu.Unlock()
+	var n uint32
+	ov := syscall.Overlapped{HEvent: d.readEvent}
+	err := syscall.ReadFile(d.handle, b, &n, &ov)
+	if err == syscall.ERROR_IO_PENDING {
+		n, err = d.wait(&ov)
+	}
+	return int(n), err
+}
+
+func (d *tapWindowsDevice) Write(b []byte) (int, error) {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	var n uint32
+	ov := syscall.Overlapped{HEvent: d.writeEvent}
+	err := syscall.WriteFile(d.handle, b, &n, &ov)
+	if err == syscall.ERROR_IO_PENDING {
+		n, err = d.wait(&ov)
+	}
+	return int(n), err
+}
+
+func (d *tapWindowsDevice) Close() error {
+	// Cancel pending I/O first: unplugging waits for the write lock.
+	syscall.CancelIoEx(d.handle, nil)
+	d.setMediaStatus(false)
+	err := syscall.CloseHandle(d.handle)
+	for _, ev := range []syscall.Handle{d.readEvent, d.writeEvent} {
+		if ev != 0 {
+			syscall.CloseHandle(ev)
+		}
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package tuntap
+
+import (
+	"errors"
+)
+
+// ECN codepoints (RFC 3168).
+const (
+	ECNNotECT = 0x0
+	ECNECT1   = 0x1
+	ECNECT0   = 0x2
+	ECNCE     = 0x3
+)
+
+// TrafficClass returns the IPv6 traffic class, or the TOS byte of an
+// IPv4 header: the DSCP in the upper 6 bits and the ECN codepoint in
+// the lower 2.
+func (h IPHeader) TrafficClass() uint8 {
+	switch {
+	case len(h.Data) >= 40 && h.version() == 6:
+		return h.Data[0]<<4 | h.Data[1]>>4
+	case len(h.Data) >= 20 && h.version() == 4:
+		return h.Data[1]
+	}
+	return 0
+}
+
+// SetTrafficClass sets the IPv6 traffic class, or the TOS byte of an
+// IPv4 header, updating the IPv4 header checksum.
+func (h IPHeader) SetTrafficClass(tc uint8) error {
+	switch {
+	case len(h.Data) >= 40 && h.version() == 6:
+		h.Data[0] = h.Data[0]&0xf0 | tc>>4
+		h.Data[1] = tc<<4 | h.Data[1]&0x0f
+		return nil
+	case len(h.Data) >= 20 && h.version() == 4:
+		h.setIPv4Byte(1, tc)
+		return nil
+	}
+	return ErrNotIP
+}
+
+// DSCP returns the differentiated services codepoint.
+func (h IPHeader) DSCP() uint8 {
+	return h.TrafficClass() >> 2
+}
+
+// SetDSCP sets the differentiated services codepoint, a 6-bit value,
+// keeping the ECN codepoint.
+func (h IPHeader) SetDSCP(dscp uint8) error {
+	if dscp > 0x3f {
+		return errors.New("Invalid DSCP")
+	}
+	return h.SetTrafficClass(dscp<<2 | h.TrafficClass()&0x3)
+}
+
+// ECN returns the explicit congestion notification codepoint, such as
+// ECNCE.
+func (h IPHeader) ECN() uint8 {
+	return h.TrafficClass() & 0x3
+}
+
+// SetECN sets the explicit congestion notification codepoint, keeping
+// the DSCP.
+func (h IPHeader) SetECN(ecn uint8) error {
+	if ecn > 0x3 {
+		return errors.New("Invalid ECN codepoint")
+	}
+	return h.SetTrafficClass(h.TrafficClass()&^0x3 | ecn)
+}
@@ -4,7 +4,8 @@
 // Note that while this package lets you create the interface and pass
 // packets to/from it, it does not provide an API to configure the
 // interface. Interface configuration is a very large topic and should
-// be dealt with separately.
+// be dealt with separately; package tuntapcfg covers the basics on
+// Linux.
 package tuntap
 
 import (
@@ -13,7 +14,12 @@ import (
 	_ "fmt"
 	"io"
 	"os"
-	_ "unsafe"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/izqui/tuntap/tuntap/parser"
 )
 
 type DevKind int
@@ -29,20 +35,100 @@ const (
 	DevTap
 )
 
+// ErrClosed is returned by I/O on a closed Interface, including I/O
+// that was blocked when Close was called.
+var ErrClosed = errors.New("Interface is closed")
+
 const (
 	ipHeaderLength = 40
+	afHeaderLength = 4
+	piHeaderLength = 4
+
+	// Size of the buffers allocated by ReadPacket and ReadFrame when
+	// the MTU of the interface is unknown.
+	readBufferSize = 10000
+	// Room for the link and packet information headers around an MTU
+	// sized packet.
+	readOverhead = ethHeaderLength + dot1QTagLength + piHeaderLength
+	// Minimum buffer size with IFF_VNET_HDR, where GSO super-packets can
+	// reach 64 KiB plus headers.
+	gsoBufferSize = 65535 + ethHeaderLength + dot1QTagLength + piHeaderLength + virtioNetHdrLength
 )
 
 type IPPacket struct {
-	// The Ethernet type of the packet. Commonly seen values are
-	// 0x8000 for IPv4 and 0x86dd for IPv6.
+	// The Ethernet type of the packet, 0x0800 for IPv4 and 0x86dd for
+	// IPv6, even on interfaces that don't deliver it. WritePacket also
+	// accepts the IP version, 4 or 6.
 	Protocol int
-	// True if the packet was too large to be read completely.
+	// True if the packet was too large to be read completely: the
+	// packet information header says so, or the read filled the buffer
+	// and the packet is shorter than its IP header says. Reads after a
+	// truncated one use larger buffers.
 	Truncated bool
-	// The raw bytes of the Ethernet payload (for DevTun) or the full
-	// Ethernet frame (for DevTap).
+	// True if the packet doesn't parse as a valid IP packet, which
+	// ReadPacket only delivers in ParseLenient mode. Header and Payload
+	// are then a best effort split of the data.
+	Malformed bool
+	// The IP header and payload.
 	Header  IPHeader
 	Payload []byte
+	// The Ethernet frame the packet was carried in, DevTap only.
+	// WritePacket uses its addresses and VLAN tag to build the frame.
+	Frame *EthernetFrame
+
+	// The virtio-net header of the packet, on interfaces opened with
+	// IFF_VNET_HDR. WritePacket sends an all-zero header if it's nil.
+	VnetHdr *VirtioNetHdr
+
+	// Buffer backing the packet in pooled mode.
+	buf *[]byte
+}
+
+// Release returns the buffer backing a packet read in pooled mode to
+// the pool. The packet, including Header, Payload and Frame, must not
+// be used afterwards. Release is a no-op for packets not read in pooled
+// mode.
+func (p *IPPacket) Release() {
+	if p.buf == nil {
+		return
+	}
+	bufferPool.Put(p.buf)
+	p.buf = nil
+	p.Header.Data = nil
+	p.Payload = nil
+	p.Frame = nil
+}
+
+// UpperLayer returns the upper-layer protocol of the packet, such as
+// ProtoTCP, and its data. Unlike Payload and NextHeader, it skips any
+// IPv6 extension headers.
+func (p *IPPacket) UpperLayer() (IPProtocol, []byte, error) {
+	if len(p.Header.Data) == 0 {
+		return 0, nil, ErrNotIP
+	}
+	ip := parser.Packet{Version: p.Header.version(), Header: p.Header.Data, Payload: p.Payload}
+	proto, data, err := ip.UpperLayer()
+	return IPProtocol(proto), data, err
+}
+
+// RewriteAddrs replaces the source and destination addresses of the
+// packet, leaving the nil ones unchanged. Unlike SetSourceAddr and
+// SetDestAddr, it also updates the TCP, UDP or ICMPv6 checksum covering
+// the addresses, and the IPv4 header checksum, so that the packet stays
+// valid.
+func (p *IPPacket) RewriteAddrs(src, dst []byte) error {
+	if len(p.Header.Data) == 0 {
+		return ErrNotIP
+	}
+	ip := parser.Packet{Version: p.Header.version(), Header: p.Header.Data, Payload: p.Payload}
+	return ip.RewriteAddrs(src, dst)
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, readBufferSize)
+		return &b
+	},
 }
 
 type IPHeader struct {
@@ -56,122 +142,692 @@ func (h IPHeader) version() int {
 	return int(i)
 }
 
+// PayloadLength returns the length of the payload given by the header.
 func (h IPHeader) PayloadLength() int {
+	if len(h.Data) < 6 {
+		return 0
+	}
+	if h.version() == 4 {
+		total := int(binary.BigEndian.Uint16(h.Data[2:4]))
+		return total - int(h.Data[0]&0x0f)*4
+	}
 
 	i := binary.BigEndian.Uint16(h.Data[4:6])
 	return int(i)
 }
 
-func (h IPHeader) SourceAddr() []byte {
+// addrs returns the offset and length of the source address in the
+// header, followed by the destination address.
+func (h IPHeader) addrs() (int, int, error) {
+	switch {
+	case len(h.Data) >= 40 && h.version() == 6:
+		return 8, 16, nil
+	case len(h.Data) >= 20 && h.version() == 4:
+		return 12, 4, nil
+	}
+	return 0, 0, ErrNotIP
+}
 
-	return h.Data[8:24]
+// SourceAddr returns the source address, 4 bytes long for IPv4 and 16
+// for IPv6. It references the header.
+func (h IPHeader) SourceAddr() []byte {
+	off, n, err := h.addrs()
+	if err != nil {
+		return nil
+	}
+	return h.Data[off : off+n]
 }
 
+// DestAddr returns the destination address, like SourceAddr.
 func (h IPHeader) DestAddr() []byte {
-
-	return h.Data[24:40]
+	off, n, err := h.addrs()
+	if err != nil {
+		return nil
+	}
+	return h.Data[off+n : off+2*n]
 }
 
+// SetSourceAddr overwrites the source address in place. a must have
+// the length of the addresses of the header's IP version. Checksums
+// covering the address aren't updated, see IPPacket.RewriteAddrs.
 func (h IPHeader) SetSourceAddr(a []byte) error {
-
-	if len(a) == 16 {
-
-		b := h.Data[24:]
-		h.Data = append(h.Data[:8], a...)
-		h.Data = append(h.Data, b...)
-
-		return nil
+	off, n, err := h.addrs()
+	if err != nil {
+		return err
 	}
-
-	return errors.New("IPv6 headers are required")
+	if len(a) != n {
+		return errors.New("Address length not matching the IP version")
+	}
+	copy(h.Data[off:off+n], a)
+	return nil
 }
 
+// SetDestAddr overwrites the destination address in place, like
+// SetSourceAddr.
 func (h IPHeader) SetDestAddr(a []byte) error {
-
-	if len(a) == 16 {
-
-		h.Data = append(h.Data[:24], a...)
-
-		return nil
+	off, n, err := h.addrs()
+	if err != nil {
+		return err
 	}
-
-	return errors.New("IPv6 headers are required")
+	if len(a) != n {
+		return errors.New("Address length not matching the IP version")
+	}
+	copy(h.Data[off+n:off+2*n], a)
+	return nil
 }
 
+// An Interface is an open TUN/TAP interface.
+//
+// Its methods are safe for concurrent use: any number of goroutines may
+// read and write packets at the same time. Each call transfers one whole
+// packet, so concurrent writes are never interleaved, and Close
+// unblocks reads and writes pending in other goroutines. SetAFHeader,
+// SetPooled and SetParseMode are the exception: they change how packets
+// are framed and parsed, and must be called before starting I/O.
 type Interface struct {
-	name string
-	//file net.Conn
-	file *os.File
+	dev  Device
+	kind DevKind
 	meta bool
+	// Packets are prefixed with a 4-byte address family (utun, BSD tun).
+	afHeader bool
+	// Packets are prefixed with a virtio_net_hdr (IFF_VNET_HDR).
+	vnetHdr bool
+	// ReadPacket takes buffers from bufferPool.
+	pooled bool
+	// How ReadPacket handles malformed packets.
+	parseMode ParseMode
+	// Size of the read buffers, derived from the MTU. 0 until known.
+	bufSize int32
+	// Counters behind Stats.
+	stats counters
+	// Observers of the packets, behind Capture.
+	obs observers
+	// The PacketHook, if any, behind SetHook.
+	hook atomic.Value
+	// The addresses of WithEchoResponder.
+	echo echoAddrs
+	// The *bpf.VM of AttachFilter, when run in userspace.
+	softFilter atomic.Value
+	// All queues of a multiqueue interface, including this one.
+	queues []*Interface
+	// The time.Time deadlines of SetReadDeadline and SetWriteDeadline,
+	// restored by the context methods.
+	readDeadline, writeDeadline atomic.Value
+	// Set to 1 by Close.
+	closed int32
+	// Set once splice failed on the descriptor, Linux only.
+	noSplice atomic.Bool
+	// Set to 1 once I/O failed because the device is gone.
+	gone int32
+	// Backs Packets and Out.
+	ch channels
+	// Goroutines behind Events.
+	watch watchers
 }
 
 // Disconnect from the tun/tap interface.
 //
 // If the interface isn't configured to be persistent, it is
 // immediately destroyed by the kernel.
+//
+// Close unblocks goroutines blocked in ReadPacket, WritePacket and the
+// other I/O methods, which then fail with ErrClosed, as do all later
+// calls.
+//
+// Closing any queue of a multiqueue interface closes all of them.
 func (t *Interface) Close() error {
-	return t.file.Close()
+	if t.queues == nil {
+		return t.close()
+	}
+	var err error
+	for _, q := range t.queues {
+		if e := q.close(); e != nil && q == t {
+			err = e
+		}
+	}
+	return err
+}
+
+func (t *Interface) close() error {
+	if !atomic.CompareAndSwapInt32(&t.closed, 0, 1) {
+		return ErrClosed
+	}
+	t.watch.close()
+	return t.dev.Close()
+}
+
+// ioError turns the error of an I/O interrupted by Close into
+// ErrClosed, and wraps the others in an Error for op.
+func (t *Interface) ioError(op string, err error) error {
+	if errors.Is(err, os.ErrClosed) || atomic.LoadInt32(&t.closed) != 0 {
+		return ErrClosed
+	}
+	if deviceGone(err) {
+		atomic.StoreInt32(&t.gone, 1)
+	} else if atomic.LoadInt32(&t.gone) != 0 {
+		// Once the device is gone, the poller may fail later I/O with
+		// its own errors.
+		err = ErrDeviceGone
+	}
+	return &Error{Op: op, Name: t.Name(), Err: err}
+}
+
+// Queues returns the number of queues of the interface, 1 unless it
+// was opened with OpenMultiQueue.
+func (t *Interface) Queues() int {
+	if t.queues == nil {
+		return 1
+	}
+	return len(t.queues)
+}
+
+// Queue returns queue i of a multiqueue interface, an Interface with
+// its own descriptor that can be read and written independently of the
+// others. Queue 0 is the Interface returned by OpenMultiQueue. It
+// returns nil if i is out of range.
+func (t *Interface) Queue(i int) *Interface {
+	if t.queues == nil {
+		if i == 0 {
+			return t
+		}
+		return nil
+	}
+	if i < 0 || i >= len(t.queues) {
+		return nil
+	}
+	return t.queues[i]
 }
 
 // The name of the interface. May be different from the name given to
 // Open(), if the latter was a pattern.
 func (t *Interface) Name() string {
-	return t.name
+	return t.dev.Name()
+}
+
+// MTU of the interface. Read buffers are sized from it, and calling
+// MTU after changing it makes them follow.
+func (t *Interface) MTU() (int, error) {
+	mtu, err := t.dev.MTU()
+	if err == nil {
+		atomic.StoreInt32(&t.bufSize, int32(t.mtuBufferSize(mtu)))
+	}
+	return mtu, err
+}
+
+// SetAFHeader controls whether ReadPacket strips and WritePacket
+// prepends the 4-byte address family header used by utun and BSD tun
+// devices. It's enabled by default on those platforms for DevTun. Call
+// it before starting I/O.
+func (t *Interface) SetAFHeader(enabled bool) {
+	t.afHeader = enabled
+}
+
+// rawInfo is what the headers preceding a packet on the device tell
+// about it.
+type rawInfo struct {
+	// EtherType of the packet, 0 if unknown.
+	proto     int
+	truncated bool
+	// The read filled the buffer, so the packet may be truncated even
+	// if no header says so.
+	filled  bool
+	vnetHdr *VirtioNetHdr
+}
+
+// readRaw reads a single packet or frame from the device into buf and
+// strips the headers preceding it.
+func (t *Interface) readRaw(buf []byte) ([]byte, rawInfo, error) {
+	return t.readRawWith(buf, t.dev.Read)
+}
+
+// readRawWith is readRaw reading from the device with read. Reads
+// failing with errWouldBlock are not counted.
+func (t *Interface) readRawWith(buf []byte, read func([]byte) (int, error)) ([]byte, rawInfo, error) {
+	for {
+		if atomic.LoadInt32(&t.closed) != 0 {
+			return nil, rawInfo{}, ErrClosed
+		}
+		start := t.stats.start()
+		n, err := retryIO(func() (int, error) { return read(buf) })
+		if err == errWouldBlock {
+			return nil, rawInfo{}, err
+		}
+		if err != nil {
+			err = t.ioError("read", err)
+			t.stats.received(start, 0, err)
+			return nil, rawInfo{}, err
+		}
+		t.stats.received(start, n, nil)
+		data, info, err := t.stripHeader(buf[:n], t.filled(n, len(buf)))
+		if err == nil {
+			// Rejected by the userspace filter.
+			if data = t.softFiltered(data, &info); data == nil {
+				continue
+			}
+			t.obs.observe(false, data)
+		}
+		return data, info, err
+	}
+}
+
+// filled tells whether a read of n bytes filled the buffer of size
+// bytes, in which case the packet may have been truncated. Later reads
+// then use larger buffers: sized from the MTU again in case it grew, or
+// twice as large, up to the largest packet.
+func (t *Interface) filled(n, size int) bool {
+	if n < size {
+		return false
+	}
+	grown := readBufferSize
+	if mtu, err := t.dev.MTU(); err == nil {
+		grown = t.mtuBufferSize(mtu)
+	}
+	if grown <= size {
+		grown = 2 * size
+	}
+	if grown > gsoBufferSize {
+		grown = gsoBufferSize
+	}
+	if int32(grown) > atomic.LoadInt32(&t.bufSize) {
+		atomic.StoreInt32(&t.bufSize, int32(grown))
+	}
+	return true
+}
+
+// stripHeader removes the packet information or address family header,
+// and the virtio header if enabled, from a packet read from the device.
+// filled tells whether the read filled the buffer.
+func (t *Interface) stripHeader(buf []byte, filled bool) ([]byte, rawInfo, error) {
+	info := rawInfo{filled: filled}
+
+	switch {
+	case t.meta:
+		if len(buf) < piHeaderLength {
+			return nil, info, errors.New("Packet information header missing")
+		}
+		info.proto = int(binary.BigEndian.Uint16(buf[2:4]))
+		flags := int(*(*uint16)(unsafe.Pointer(&buf[0])))
+		if flags&flagTruncated != 0 {
+			info.truncated = true
+		}
+		buf = buf[piHeaderLength:]
+	case t.afHeader:
+		if len(buf) < afHeaderLength {
+			return nil, info, errors.New("Address family header missing")
+		}
+		switch binary.BigEndian.Uint32(buf[:afHeaderLength]) {
+		case syscall.AF_INET:
+			info.proto = etherTypeIPv4
+		case syscall.AF_INET6:
+			info.proto = etherTypeIPv6
+		}
+		buf = buf[afHeaderLength:]
+	}
+
+	if t.vnetHdr {
+		if len(buf) < virtioNetHdrLength {
+			return nil, info, errors.New("Virtio header missing")
+		}
+		info.vnetHdr = parseVirtioNetHdr(buf)
+		buf = buf[virtioNetHdrLength:]
+	}
+	return buf, info, nil
+}
+
+// bufferSize returns the size of the read buffers, large enough for an
+// MTU sized packet and its headers.
+func (t *Interface) bufferSize() int {
+	if n := atomic.LoadInt32(&t.bufSize); n > 0 {
+		return int(n)
+	}
+	n := readBufferSize
+	if mtu, err := t.dev.MTU(); err == nil {
+		n = t.mtuBufferSize(mtu)
+	}
+	atomic.StoreInt32(&t.bufSize, int32(n))
+	return n
+}
+
+func (t *Interface) mtuBufferSize(mtu int) int {
+	n := mtu + readOverhead
+	if t.vnetHdr {
+		n += virtioNetHdrLength
+		if n < gsoBufferSize {
+			n = gsoBufferSize
+		}
+	}
+	return n
+}
+
+// getBuffer takes a read buffer from bufferPool.
+func (t *Interface) getBuffer() *[]byte {
+	buf := bufferPool.Get().(*[]byte)
+	if size := t.bufferSize(); len(*buf) < size {
+		*buf = make([]byte, size)
+	}
+	return buf
+}
+
+// SetPooled enables or disables pooled mode. In pooled mode,
+// ReadPacket takes its buffers from a pool shared by all interfaces
+// instead of allocating them, and callers should call Release on each
+// packet once done with it. Call it before starting I/O.
+func (t *Interface) SetPooled(enabled bool) {
+	t.pooled = enabled
+}
+
+// ParseMode tells ReadPacket what to do with malformed packets.
+type ParseMode int
+
+const (
+	// Fail the read, the default.
+	ParseStrict ParseMode = iota
+	// Deliver the packet with Malformed set, for middleboxes that
+	// need to observe all traffic.
+	ParseLenient
+)
+
+// SetParseMode sets how ReadPacket and the other packet reading methods
+// handle packets not parsing as valid IP packets. Call it before
+// starting I/O.
+func (t *Interface) SetParseMode(mode ParseMode) {
+	t.parseMode = mode
 }
 
 // Read a single packet from the kernel.
+//
+// On a DevTap interface, the packet is taken out of its Ethernet frame,
+// which is kept in the Frame field. Frames that don't carry IP fail;
+// use ReadFrame to receive everything.
 func (t *Interface) ReadPacket() (*IPPacket, error) {
-	buf := make([]byte, 10000)
+	return t.readPacket(t.dev.Read)
+}
 
-	n, err := t.file.Read(buf)
+// readPacket is ReadPacket reading from the device with read.
+func (t *Interface) readPacket(read func([]byte) (int, error)) (*IPPacket, error) {
+	if !t.pooled {
+		return t.readPacketInto(make([]byte, t.bufferSize()), read)
+	}
+
+	buf := t.getBuffer()
+	pkt, err := t.readPacketInto(*buf, read)
 	if err != nil {
+		bufferPool.Put(buf)
 		return nil, err
 	}
+	pkt.buf = buf
+	return pkt, nil
+}
 
-	var pkt *IPPacket
-
-	start := 0
-
-	if n < start+ipHeaderLength {
+// ReadPacketInto is like ReadPacket, but reads into buf instead of
+// allocating a new buffer. The returned packet references buf, so buf
+// must not be reused while the packet is in use. buf should be large
+// enough for the interface MTU plus any link and packet information
+// headers, or packets are truncated.
+func (t *Interface) ReadPacketInto(buf []byte) (*IPPacket, error) {
+	return t.readPacketInto(buf, t.dev.Read)
+}
 
-		return nil, errors.New("Not a IPv6 packet")
+func (t *Interface) readPacketInto(buf []byte, read func([]byte) (int, error)) (*IPPacket, error) {
+	for {
+		data, info, err := t.readRawWith(buf, read)
+		if err != nil {
+			return nil, err
+		}
+		pkt, err := t.decodePacket(data, info)
+		if err != nil {
+			return nil, err
+		}
+		if pkt = t.hooked(pkt, false); pkt != nil {
+			return pkt, nil
+		}
 	}
+}
 
-	pkt = &IPPacket{Header: IPHeader{Data: buf[start : start+ipHeaderLength]}, Payload: buf[start+ipHeaderLength : n]}
+// ipTruncated tells whether the IP packet in data is shorter than its
+// header says.
+func ipTruncated(data []byte) bool {
+	switch {
+	case len(data) >= 4 && data[0]>>4 == 4:
+		return int(binary.BigEndian.Uint16(data[2:4])) > len(data)
+	case len(data) >= 6 && data[0]>>4 == 6:
+		return ipHeaderLength+int(binary.BigEndian.Uint16(data[4:6])) > len(data)
+	}
+	return false
+}
 
-	if pkt.Header.PayloadLength() != len(pkt.Payload) {
+// splitHeader splits data into the header and payload of the packet
+// without checking the lengths it gives. If data is shorter than the
+// header, it fails but still leaves all of it in Header.
+func (p *IPPacket) splitHeader(data []byte) error {
+	hlen := ipHeaderLength
+	if len(data) > 0 && data[0]>>4 == 4 {
+		hlen = int(data[0]&0x0f) * 4
+	}
+	if len(data) < hlen || hlen < 20 {
+		p.Header = IPHeader{Data: data}
+		p.Payload = data[len(data):]
+		return ErrNotIP
+	}
+	p.Header = IPHeader{Data: data[:hlen]}
+	p.Payload = data[hlen:]
+	return nil
+}
 
-		return nil, errors.New("Payload length not matching")
+// decodePacket parses the IP packet, or the Ethernet frame carrying it
+// on DevTap, in data.
+func (t *Interface) decodePacket(data []byte, info rawInfo) (*IPPacket, error) {
+	proto := info.proto
+	var err error
+	var frame *EthernetFrame
+	if t.kind == DevTap {
+		frame, err = parser.ParseEthernet(data)
+		if err != nil {
+			return nil, err
+		}
+		if frame.EtherType != etherTypeIPv4 && frame.EtherType != etherTypeIPv6 {
+			return nil, ErrNotIP
+		}
+		data = frame.Payload
+		proto = frame.EtherType
+	}
+	if info.filled && !info.truncated {
+		info.truncated = ipTruncated(data)
 	}
 
-	pkt.Protocol = pkt.Header.version()
+	pkt := &IPPacket{
+		Truncated: info.truncated,
+		Frame:     frame,
+		VnetHdr:   info.vnetHdr,
+	}
+	if pkt.Truncated {
+		// A truncated packet is necessarily shorter than its header
+		// says, so it can't be checked.
+		if err := pkt.splitHeader(data); err != nil {
+			if t.parseMode != ParseLenient || len(data) == 0 {
+				return nil, err
+			}
+			pkt.Malformed = true
+		}
+	} else if ip, err := parser.ParseIP(data); err == nil {
+		pkt.Header = IPHeader{Data: ip.Header}
+		pkt.Payload = ip.Payload
+	} else {
+		if t.parseMode != ParseLenient || len(data) == 0 {
+			return nil, err
+		}
+		pkt.Malformed = true
+		pkt.splitHeader(data)
+	}
 
-	/*pkt.Protocol = int(binary.BigEndian.Uint16(buf[2:4]))
-	flags := int(*(*uint16)(unsafe.Pointer(&buf[0])))
-	if flags&flagTruncated != 0 {
-		pkt.Truncated = true
-	}*/
+	pkt.Protocol = proto
+	if pkt.Protocol == 0 {
+		// No packet information or link header, derive the EtherType
+		// from the IP version.
+		pkt.Protocol = etherTypeIPv6
+		if pkt.Header.version() == 4 {
+			pkt.Protocol = etherTypeIPv4
+		}
+	}
 
 	return pkt, nil
 }
 
-// Send a single packet to the kernel.
-func (t *Interface) WritePacket(packet *IPPacket) error {
+// ReadFrame reads a single Ethernet frame from a DevTap interface.
+func (t *Interface) ReadFrame() (*EthernetFrame, error) {
+	if t.kind != DevTap {
+		return nil, errors.New("ReadFrame needs a DevTap interface")
+	}
+	data, _, err := t.readRaw(make([]byte, t.bufferSize()))
+	if err != nil {
+		return nil, err
+	}
+	return parser.ParseEthernet(data)
+}
 
-	// If only we had writev(), I could do zero-copy here...
+// header returns the packet information or address family header, and
+// the virtio header if enabled, that must precede a packet of the given
+// EtherType on the wire.
+func (t *Interface) header(proto int, vnet *VirtioNetHdr) []byte {
+	var hdr []byte
+	switch {
+	case t.meta:
+		hdr = make([]byte, piHeaderLength)
+		binary.BigEndian.PutUint16(hdr[2:4], uint16(proto))
+	case t.afHeader:
+		hdr = make([]byte, afHeaderLength)
+		family := syscall.AF_INET6
+		if proto == etherTypeIPv4 {
+			family = syscall.AF_INET
+		}
+		binary.BigEndian.PutUint32(hdr, uint32(family))
+	}
 
-	n, err := t.file.Write(append(packet.Header.Data, packet.Payload...))
+	if t.vnetHdr {
+		if vnet == nil {
+			vnet = &VirtioNetHdr{}
+		}
+		hdr = append(hdr, vnet.marshal()...)
+	}
+	return hdr
+}
+
+// vectorWriter is implemented by Devices that can send a packet given
+// in several pieces without concatenating them first.
+type vectorWriter interface {
+	writev(bufs [][]byte) (int, error)
+}
+
+// writeRaw sends the concatenation of parts as a single packet,
+// preceded by the headers given by header(proto, vnet).
+func (t *Interface) writeRaw(proto int, vnet *VirtioNetHdr, parts ...[]byte) error {
+	if atomic.LoadInt32(&t.closed) != 0 {
+		return ErrClosed
+	}
+	bufs := append([][]byte{t.header(proto, vnet)}, parts...)
+
+	start := t.stats.start()
+	var n int
+	var err error
+	if w, ok := t.dev.(vectorWriter); ok {
+		n, err = retryIO(func() (int, error) { return w.writev(bufs) })
+	} else {
+		packet := concat(bufs)
+		n, err = retryIO(func() (int, error) { return t.dev.Write(packet) })
+	}
 
 	if err != nil {
+		err = t.ioError("write", err)
+		t.stats.sent(start, 0, err)
 		return err
 	}
 
-	if n != ipHeaderLength+packet.Header.PayloadLength() {
-		return io.ErrShortWrite
+	total := 0
+	for _, b := range bufs {
+		total += len(b)
+	}
+	if n != total {
+		// Each write is a packet, so the rest can't be sent in another
+		// one: the device truncated the packet.
+		err = t.ioError("write", io.ErrShortWrite)
+		t.stats.sent(start, 0, err)
+		return err
+	}
+	t.stats.sent(start, n, nil)
+	if t.obs.active() {
+		t.obs.observe(true, concat(parts))
 	}
 	return nil
 }
 
+// concat returns the concatenation of bufs in a new slice.
+func concat(bufs [][]byte) []byte {
+	n := 0
+	for _, b := range bufs {
+		n += len(b)
+	}
+	buf := make([]byte, 0, n)
+	for _, b := range bufs {
+		buf = append(buf, b...)
+	}
+	return buf
+}
+
+// Send a single packet to the kernel.
+//
+// On a DevTap interface, packet.Frame supplies the Ethernet addresses
+// and VLAN tag of the frame the packet is sent in.
+func (t *Interface) WritePacket(packet *IPPacket) error {
+	if packet = t.hooked(packet, true); packet == nil {
+		return nil
+	}
+	proto, parts, err := t.packetParts(packet)
+	if err != nil {
+		return err
+	}
+	return t.writeRaw(proto, packet.VnetHdr, parts...)
+}
+
+// packetParts returns the EtherType of packet and the pieces to send
+// it in, after the headers given by header.
+func (t *Interface) packetParts(packet *IPPacket) (int, [][]byte, error) {
+	proto := packet.Protocol
+	if proto < etherTypeMinimum {
+		// Not an EtherType, derive it from the IP version.
+		proto = etherTypeIPv6
+		if packet.Header.version() == 4 {
+			proto = etherTypeIPv4
+		}
+	}
+
+	if t.kind == DevTap {
+		if packet.Frame == nil {
+			return 0, nil, errors.New("DevTap packets need an Ethernet frame")
+		}
+		frame := *packet.Frame
+		frame.EtherType = proto
+		eth, err := frame.Header()
+		if err != nil {
+			return 0, nil, err
+		}
+		return proto, [][]byte{eth, packet.Header.Data, packet.Payload}, nil
+	}
+
+	return proto, [][]byte{packet.Header.Data, packet.Payload}, nil
+}
+
+// WriteFrame sends a single Ethernet frame on a DevTap interface.
+func (t *Interface) WriteFrame(frame *EthernetFrame) error {
+	if t.kind != DevTap {
+		return errors.New("WriteFrame needs a DevTap interface")
+	}
+	eth, err := frame.Header()
+	if err != nil {
+		return err
+	}
+	return t.writeRaw(frame.EtherType, nil, eth, frame.Payload)
+}
+
 // Open connects to the specified tun/tap interface.
 //
 // If the specified device has been configured as persistent, this
@@ -199,6 +855,24 @@ func Open(ifPattern string, kind DevKind, meta bool) (*Interface, error) {
 		file.Close()
 		return nil, err
 	}
+	if file, err = pollFile(file); err != nil {
+		return nil, err
+	}
 
-	return &Interface{ifName, file, meta}, nil
+	return &Interface{
+		dev:      &osDevice{file, ifName},
+		kind:     kind,
+		meta:     meta,
+		afHeader: hasAFHeader && kind == DevTun,
+	}, nil
+}
+
+// clen returns the length of the NUL-terminated string in b.
+func clen(b []byte) int {
+	for i, c := range b {
+		if c == 0 {
+			return i
+		}
+	}
+	return len(b)
 }
@@ -0,0 +1,307 @@
+// Package tun2socks turns a tun interface into a transparent proxy
+// gateway: the TCP connections and UDP flows routed to the interface
+// are terminated in userspace by gVisor's netstack, and relayed through
+// an upstream proxy, such as a SOCKS5 or HTTP CONNECT one.
+//
+//	t, err := tuntap.Open("tun%d", tuntap.DevTun, false)
+//	...
+//	proxy := &tun2socks.SOCKS5{Addr: "127.0.0.1:1080"}
+//	g, err := tun2socks.New(t, tun2socks.Options{Dialer: proxy, PacketDialer: proxy})
+//
+// The addresses and routes sending the traffic to the interface are
+// left to the caller, and so is keeping the traffic of the proxy itself
+// away from it.
+package tun2socks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/tuntapnetstack"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// Defaults of Options.
+	DefaultDialTimeout = 30 * time.Second
+	DefaultUDPTimeout  = time.Minute
+
+	nicID = 1
+	// Connection attempts handled at once; more SYNs are dropped until
+	// some complete.
+	maxInFlight = 1024
+	// Largest UDP datagram.
+	maxDatagram = 65535
+)
+
+// A Dialer opens upstream TCP connections, to the address given as
+// host:port. net.Dialer is one, connecting directly.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// A PacketDialer opens upstream UDP flows: each Read and Write of the
+// returned Conn is a datagram exchanged with address.
+type PacketDialer interface {
+	DialPacket(ctx context.Context, address string) (net.Conn, error)
+}
+
+// Options configure a Gateway.
+type Options struct {
+	// Dialer opens the upstream connection of each TCP connection.
+	Dialer Dialer
+	// PacketDialer opens the upstream flow of each UDP flow. UDP is
+	// dropped if it's nil.
+	PacketDialer PacketDialer
+	// Time allowed to open an upstream connection.
+	DialTimeout time.Duration
+	// Time after which a UDP flow without traffic is closed.
+	UDPTimeout time.Duration
+}
+
+// A Gateway relays the connections routed to an interface through a
+// proxy.
+type Gateway struct {
+	opts   Options
+	ep     *tuntapnetstack.Endpoint
+	stack  *stack.Stack
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	conns map[io.Closer]bool
+	wg    sync.WaitGroup
+}
+
+// New starts relaying the connections routed to t, a DevTun interface.
+// The Gateway owns t: closing it closes t.
+func New(t *tuntap.Interface, opts Options) (*Gateway, error) {
+	if opts.Dialer == nil {
+		return nil, errors.New("Gateway needs a Dialer")
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	if opts.UDPTimeout <= 0 {
+		opts.UDPTimeout = DefaultUDPTimeout
+	}
+	ep, err := tuntapnetstack.NewEndpoint(t)
+	if err != nil {
+		return nil, err
+	}
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	g := &Gateway{opts: opts, ep: ep, stack: s, conns: make(map[io.Closer]bool)}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+
+	if terr := s.CreateNIC(nicID, ep); terr != nil {
+		g.Close()
+		return nil, errors.New("Creating the NIC: " + terr.String())
+	}
+	// Accept connections to any address, and answer from it.
+	if terr := s.SetPromiscuousMode(nicID, true); terr != nil {
+		g.Close()
+		return nil, errors.New("Enabling promiscuous mode: " + terr.String())
+	}
+	if terr := s.SetSpoofing(nicID, true); terr != nil {
+		g.Close()
+		return nil, errors.New("Enabling spoofing: " + terr.String())
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: nicID},
+		{Destination: header.IPv6EmptySubnet, NIC: nicID},
+	})
+
+	s.SetTransportProtocolHandler(tcp.ProtocolNumber, tcp.NewForwarder(s, 0, maxInFlight, g.handleTCP).HandlePacket)
+	s.SetTransportProtocolHandler(udp.ProtocolNumber, udp.NewForwarder(s, g.handleUDP).HandlePacket)
+	return g, nil
+}
+
+// destination returns the address a connection was sent to.
+func destination(id stack.TransportEndpointID) string {
+	addr, _ := netip.AddrFromSlice(id.LocalAddress.AsSlice())
+	return netip.AddrPortFrom(addr, id.LocalPort).String()
+}
+
+// track registers c to be closed with the Gateway, and returns false if
+// it's already closed.
+func (g *Gateway) track(c io.Closer) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ctx.Err() != nil {
+		return false
+	}
+	g.conns[c] = true
+	g.wg.Add(1)
+	return true
+}
+
+func (g *Gateway) untrack(c io.Closer) {
+	g.mu.Lock()
+	delete(g.conns, c)
+	g.mu.Unlock()
+	g.wg.Done()
+}
+
+// handleTCP connects upstream before accepting the connection, so it's
+// reset if the proxy can't reach the destination.
+func (g *Gateway) handleTCP(r *tcp.ForwarderRequest) {
+	ctx, cancel := context.WithTimeout(g.ctx, g.opts.DialTimeout)
+	upstream, err := g.opts.Dialer.DialContext(ctx, "tcp", destination(r.ID()))
+	cancel()
+	if err != nil {
+		r.Complete(true)
+		return
+	}
+
+	var wq waiter.Queue
+	ep, terr := r.CreateEndpoint(&wq)
+	if terr != nil {
+		r.Complete(true)
+		upstream.Close()
+		return
+	}
+	r.Complete(false)
+	conn := gonet.NewTCPConn(&wq, ep)
+	if !g.track(conn) {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	defer g.untrack(conn)
+	relayTCP(conn, upstream)
+}
+
+// relayTCP copies between a and b until both directions are done,
+// passing on half-closes.
+func relayTCP(a, b net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		halfCopy(b, a)
+		close(done)
+	}()
+	halfCopy(a, b)
+	<-done
+	a.Close()
+	b.Close()
+}
+
+func halfCopy(dst, src net.Conn) {
+	if _, err := io.Copy(dst, src); err != nil {
+		// Unblock the other direction.
+		dst.Close()
+		src.Close()
+		return
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+// handleUDP relays the flow of the first datagram of r.
+func (g *Gateway) handleUDP(r *udp.ForwarderRequest) bool {
+	if g.opts.PacketDialer == nil {
+		return false
+	}
+	var wq waiter.Queue
+	ep, terr := r.CreateEndpoint(&wq)
+	if terr != nil {
+		return false
+	}
+	conn := gonet.NewUDPConn(&wq, ep)
+	if !g.track(conn) {
+		conn.Close()
+		return true
+	}
+	dst := destination(r.ID())
+	go func() {
+		defer g.untrack(conn)
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(g.ctx, g.opts.DialTimeout)
+		upstream, err := g.opts.PacketDialer.DialPacket(ctx, dst)
+		cancel()
+		if err != nil {
+			return
+		}
+		defer upstream.Close()
+		g.relayUDP(conn, upstream)
+	}()
+	return true
+}
+
+// relayUDP copies datagrams between a and b until the flow is idle for
+// UDPTimeout or either fails.
+func (g *Gateway) relayUDP(a, b net.Conn) {
+	var mu sync.Mutex
+	last := time.Now()
+	copyDatagrams := func(dst, src net.Conn) {
+		buf := make([]byte, maxDatagram)
+		for {
+			src.SetReadDeadline(time.Now().Add(g.opts.UDPTimeout))
+			n, err := src.Read(buf)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				mu.Lock()
+				idle := time.Since(last) >= g.opts.UDPTimeout
+				mu.Unlock()
+				if idle {
+					break
+				}
+				continue
+			}
+			if err != nil {
+				break
+			}
+			mu.Lock()
+			last = time.Now()
+			mu.Unlock()
+			if _, err := dst.Write(buf[:n]); err != nil {
+				break
+			}
+		}
+		a.Close()
+		b.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		copyDatagrams(b, a)
+		close(done)
+	}()
+	copyDatagrams(a, b)
+	<-done
+}
+
+// Close stops the Gateway, closing the relayed connections and the
+// interface.
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	g.cancel()
+	for c := range g.conns {
+		c.Close()
+	}
+	g.mu.Unlock()
+
+	g.stack.Close()
+	g.ep.Close()
+	g.ep.Wait()
+	g.wg.Wait()
+	return nil
+}
@@ -0,0 +1,106 @@
+package tun2socks
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPConnect is an HTTP proxy relaying TCP connections with the CONNECT
+// method. It can't relay UDP.
+type HTTPConnect struct {
+	// Address of the proxy, as host:port.
+	Addr string
+	// Credentials of the basic authentication, if the proxy requires
+	// it.
+	Username, Password string
+	// Dialer connects to the proxy. A zero net.Dialer if nil.
+	Dialer Dialer
+}
+
+var _ Dialer = (*HTTPConnect)(nil)
+
+// DialContext connects to address through the proxy. Only "tcp"
+// networks are supported.
+func (p *HTTPConnect) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errors.New("HTTP proxy can't dial network " + network)
+	}
+	d := p.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	conn, err := d.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+
+	br, err := p.connect(conn, address)
+	if !stop() || err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// The destination already sent data after the response.
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// connect sends the CONNECT request for address and reads the response.
+func (p *HTTPConnect) connect(conn net.Conn, address string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if p.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(p.Username + ":" + p.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("HTTP proxy: " + resp.Status)
+	}
+	return br, nil
+}
+
+// bufferedConn is a Conn whose first bytes were already read into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// CloseWrite passes on half-closes to the TCP connection.
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,301 @@
+package tun2socks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// From RFC 1928 and RFC 1929.
+const (
+	socksVersion     = 5
+	socksAuthNone    = 0
+	socksAuthUser    = 2
+	socksAuthNoMatch = 0xff
+	socksUserVersion = 1
+
+	socksCmdConnect      = 1
+	socksCmdUDPAssociate = 3
+
+	socksAtypIPv4   = 1
+	socksAtypDomain = 3
+	socksAtypIPv6   = 4
+)
+
+var socksReplies = []string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// SOCKS5 is a SOCKS5 proxy (RFC 1928) relaying TCP connections, and UDP
+// flows with UDP ASSOCIATE.
+type SOCKS5 struct {
+	// Address of the proxy, as host:port.
+	Addr string
+	// Credentials of the username/password authentication (RFC 1929),
+	// if the proxy requires it.
+	Username, Password string
+	// Dialer connects to the proxy. A zero net.Dialer if nil.
+	Dialer Dialer
+}
+
+var (
+	_ Dialer       = (*SOCKS5)(nil)
+	_ PacketDialer = (*SOCKS5)(nil)
+)
+
+// DialContext connects to address through the proxy. Only "tcp"
+// networks are supported.
+func (p *SOCKS5) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, errors.New("SOCKS5 proxy can't dial network " + network)
+	}
+	dst, err := socksAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := p.request(ctx, socksCmdConnect, dst)
+	return conn, err
+}
+
+// DialPacket opens a UDP association with the proxy, whose datagrams are
+// sent to and received from address.
+func (p *SOCKS5) DialPacket(ctx context.Context, address string) (net.Conn, error) {
+	header, err := socksAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	// The association is for datagrams from any address: the one of the
+	// UDP socket isn't known before it's connected to the relay.
+	ctrl, relay, err := p.request(ctx, socksCmdUDPAssociate, []byte{socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		return nil, err
+	}
+	if relay.Addr().IsUnspecified() {
+		// The relay is on the proxy.
+		if ra, ok := ctrl.RemoteAddr().(*net.TCPAddr); ok {
+			ip, _ := netip.AddrFromSlice(ra.IP)
+			relay = netip.AddrPortFrom(ip.Unmap(), relay.Port())
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", relay.String())
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	c := &socksPacketConn{Conn: conn, ctrl: ctrl, header: append([]byte{0, 0, 0}, header...)}
+	// The association ends when the control connection closes.
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		conn.Close()
+	}()
+	return c, nil
+}
+
+// socksAddr encodes the host:port address as in SOCKS requests.
+func socksAddr(address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, errors.New("Invalid port " + portStr)
+	}
+	var b []byte
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Unmap().Is4() {
+			b = append([]byte{socksAtypIPv4}, ip.Unmap().AsSlice()...)
+		} else {
+			b = append([]byte{socksAtypIPv6}, ip.AsSlice()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, errors.New("Host name too long")
+		}
+		b = append([]byte{socksAtypDomain, byte(len(host))}, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// request connects to the proxy, authenticates, and sends the request
+// cmd for the encoded address dst. It returns the connection and the
+// bound address of the reply.
+func (p *SOCKS5) request(ctx context.Context, cmd byte, dst []byte) (net.Conn, netip.AddrPort, error) {
+	d := p.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	conn, err := d.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return nil, netip.AddrPort{}, err
+	}
+	// Abort the handshake with ctx.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+
+	bound, err := p.handshake(conn, cmd, dst)
+	if !stop() || err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, netip.AddrPort{}, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, bound, nil
+}
+
+func (p *SOCKS5) handshake(conn net.Conn, cmd byte, dst []byte) (netip.AddrPort, error) {
+	methods := []byte{socksVersion, 1, socksAuthNone}
+	if p.Username != "" {
+		methods = []byte{socksVersion, 2, socksAuthNone, socksAuthUser}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return netip.AddrPort{}, err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	if reply[0] != socksVersion {
+		return netip.AddrPort{}, errors.New("Not a SOCKS5 proxy")
+	}
+	switch reply[1] {
+	case socksAuthNone:
+	case socksAuthUser:
+		if len(p.Username) > 255 || len(p.Password) > 255 {
+			return netip.AddrPort{}, errors.New("SOCKS5 credentials too long")
+		}
+		auth := []byte{socksUserVersion, byte(len(p.Username))}
+		auth = append(auth, p.Username...)
+		auth = append(auth, byte(len(p.Password)))
+		auth = append(auth, p.Password...)
+		if _, err := conn.Write(auth); err != nil {
+			return netip.AddrPort{}, err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return netip.AddrPort{}, err
+		}
+		if reply[1] != 0 {
+			return netip.AddrPort{}, errors.New("SOCKS5 authentication failed")
+		}
+	case socksAuthNoMatch:
+		return netip.AddrPort{}, errors.New("SOCKS5 proxy accepts none of the authentication methods")
+	default:
+		return netip.AddrPort{}, errors.New("SOCKS5 proxy chose an unknown authentication method")
+	}
+
+	req := append([]byte{socksVersion, cmd, 0}, dst...)
+	if _, err := conn.Write(req); err != nil {
+		return netip.AddrPort{}, err
+	}
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	if hdr[1] != 0 {
+		msg := "unknown error"
+		if int(hdr[1]) < len(socksReplies) {
+			msg = socksReplies[hdr[1]]
+		}
+		return netip.AddrPort{}, errors.New("SOCKS5 proxy: " + msg)
+	}
+	return readSocksAddr(conn, hdr[3])
+}
+
+// readSocksAddr reads the address of type atyp of a reply. Domain names
+// are read but not resolved.
+func readSocksAddr(r io.Reader, atyp byte) (netip.AddrPort, error) {
+	var n int
+	switch atyp {
+	case socksAtypIPv4:
+		n = 4
+	case socksAtypIPv6:
+		n = 16
+	case socksAtypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return netip.AddrPort{}, err
+		}
+		n = int(l[0])
+	default:
+		return netip.AddrPort{}, errors.New("SOCKS5 proxy replied with an unknown address type")
+	}
+	b := make([]byte, n+2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return netip.AddrPort{}, err
+	}
+	port := binary.BigEndian.Uint16(b[n:])
+	if atyp == socksAtypDomain {
+		return netip.AddrPortFrom(netip.IPv4Unspecified(), port), nil
+	}
+	ip, _ := netip.AddrFromSlice(b[:n])
+	return netip.AddrPortFrom(ip, port), nil
+}
+
+// socksPacketConn exchanges the datagrams of a UDP association, each
+// carried after a header with the address of the destination.
+type socksPacketConn struct {
+	net.Conn
+	ctrl   net.Conn
+	header []byte
+}
+
+func (c *socksPacketConn) Write(b []byte) (int, error) {
+	if _, err := c.Conn.Write(append(c.header[:len(c.header):len(c.header)], b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read reads the next datagram. Fragmented ones are dropped.
+func (c *socksPacketConn) Read(b []byte) (int, error) {
+	buf := make([]byte, len(b)+len(c.header)+256)
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		var skip int
+		switch buf[3] {
+		case socksAtypIPv4:
+			skip = 4 + 4 + 2
+		case socksAtypIPv6:
+			skip = 4 + 16 + 2
+		case socksAtypDomain:
+			if n < 5 {
+				continue
+			}
+			skip = 4 + 1 + int(buf[4]) + 2
+		default:
+			continue
+		}
+		if skip > n {
+			continue
+		}
+		return copy(b, buf[skip:n]), nil
+	}
+}
+
+func (c *socksPacketConn) Close() error {
+	c.ctrl.Close()
+	return c.Conn.Close()
+}
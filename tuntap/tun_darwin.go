@@ -1,14 +1,136 @@
 package tuntap
 
 import (
+	"errors"
 	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
 )
 
+// Packets read from and written to a utun socket are prefixed with
+// the 4-byte address family of the payload.
+const hasAFHeader = true
+
+const utunControlName = "com.apple.net.utun_control"
+
 func openDevice(ifPattern string) (*os.File, error) {
-	file, err := os.OpenFile("/dev/" + ifPattern, os.O_RDWR, 0)
-	return file, err
+	fd, err := syscall.Socket(afSystem, syscall.SOCK_DGRAM, sysprotoControl)
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	// Non-blocking descriptors are registered with the runtime poller.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "utun"), nil
+}
+
+// utunUnit converts an interface pattern to a utun control unit. "utun"
+// and "utun%d" let the kernel pick the first free unit (0), "utunN"
+// asks for unit N+1.
+func utunUnit(ifPattern string) (uint32, error) {
+	if !strings.HasPrefix(ifPattern, "utun") {
+		return 0, errors.New("Interface name must be utun[0-9]*")
+	}
+	num := ifPattern[len("utun"):]
+	if num == "" || num == "%d" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(num, 10, 31)
+	if err != nil {
+		return 0, errors.New("Interface name must be utun[0-9]*")
+	}
+	return uint32(n) + 1, nil
 }
 
 func createInterface(file *os.File, ifPattern string, kind DevKind, meta bool) (string, error) {
-	return "ok", nil
+	if kind != DevTun {
+		return "", errors.New("Only DevTun is supported on darwin")
+	}
+	unit, err := utunUnit(ifPattern)
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	err = control(file, func(fd uintptr) error {
+		var info ctlInfo
+		for i, c := range utunControlName {
+			info.Name[i] = int8(c)
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(ctlIocGInfo), uintptr(unsafe.Pointer(&info)))
+		if errno != 0 {
+			return errno
+		}
+
+		addr := sockaddrCtl{
+			Len:     uint8(unsafe.Sizeof(sockaddrCtl{})),
+			Family:  afSystem,
+			Sysaddr: afSysControl,
+			Id:      info.Id,
+			Unit:    unit,
+		}
+		_, _, errno = syscall.Syscall(syscall.SYS_CONNECT, fd, uintptr(unsafe.Pointer(&addr)), uintptr(addr.Len))
+		if errno == syscall.EPERM {
+			return &PermissionError{
+				Op:   "connect",
+				Path: ifPattern,
+				UID:  os.Geteuid(),
+				Hint: "Creating utun interfaces needs root, or a network extension entitlement",
+				Err:  errno,
+			}
+		}
+		if errno != 0 {
+			return errno
+		}
+
+		var err error
+		name, err = utunName(fd)
+		return err
+	})
+	return name, err
+}
+
+// utunName returns the interface name of a connected utun socket.
+func utunName(fd uintptr) (string, error) {
+	var name [syscall.IFNAMSIZ]byte
+	nameLen := uintptr(len(name))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, sysprotoControl, utunOptIfname,
+		uintptr(unsafe.Pointer(&name[0])), uintptr(unsafe.Pointer(&nameLen)), 0)
+	if errno != 0 {
+		return "", errno
+	}
+	return string(name[:clen(name[:nameLen])]), nil
+}
+
+func fileInterface(file *os.File) (string, bool, error) {
+	var name string
+	err := control(file, func(fd uintptr) error {
+		var err error
+		name, err = utunName(fd)
+		return err
+	})
+	return name, false, err
+}
+
+// NewInterfaceFromPacketFlowFd wraps the utun descriptor backing an
+// iOS or macOS NEPacketTunnelProvider's packetFlow. The provider keeps
+// its own descriptor, so fd should be a dup() of it; the Interface owns
+// fd and closes it on Close.
+//
+// Packets on the descriptor carry the 4-byte address family header,
+// which is stripped and prepended transparently.
+func NewInterfaceFromPacketFlowFd(fd int) (*Interface, error) {
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "utun")
+	// The sandbox of a network extension may refuse the name lookup,
+	// that's not fatal.
+	name, _, _ := fileInterface(file)
+	return &Interface{dev: &osDevice{file, name}, kind: DevTun, afHeader: true}, nil
 }
@@ -0,0 +1,34 @@
+// +build linux darwin freebsd openbsd
+
+package tuntap
+
+import (
+	"os"
+	"syscall"
+)
+
+// NewInterfaceFromFd wraps an already open tun/tap file descriptor,
+// for example the one returned by Android's VpnService.establish(), so
+// it can be used with ReadPacket and WritePacket without calling Open.
+//
+// The Interface takes ownership of fd and closes it on Close. fd is
+// also closed if an error is returned.
+func NewInterfaceFromFd(fd int, kind DevKind) (*Interface, error) {
+	// Non-blocking descriptors are registered with the runtime poller.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "tun")
+	name, meta, err := fileInterface(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Interface{
+		dev:      &osDevice{file, name},
+		kind:     kind,
+		meta:     meta,
+		afHeader: hasAFHeader && kind == DevTun,
+	}, nil
+}
@@ -0,0 +1,97 @@
+package tuntap
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// With TUNSIFHEAD set, tun(4) packets are prefixed with the 4-byte
+// address family of the payload. tap(4) frames have no such header.
+const hasAFHeader = true
+
+// There's no packet information header, so no truncation flag either.
+const flagTruncated = 0
+
+// struct fiodgname_arg from <sys/filio.h>.
+type fiodgnameArg struct {
+	Len int32
+	Buf *byte
+}
+
+const (
+	// _IOW('t', 96, int)
+	tunSIfHead = 0x80047460
+	// _IOW('f', 120, struct fiodgname_arg)
+	fiodgName = 0x80006678 | uintptr(unsafe.Sizeof(fiodgnameArg{}))<<16
+)
+
+// openDevice opens /dev/tunN or /dev/tapN for an exact name, or the
+// /dev/tun and /dev/tap cloning devices for "tun", "tun%d", "tap" and
+// "tap%d".
+func openDevice(ifPattern string) (*os.File, error) {
+	dev := strings.TrimSuffix(ifPattern, "%d")
+	if !strings.HasPrefix(dev, "tun") && !strings.HasPrefix(dev, "tap") {
+		return nil, errors.New("Interface name must be tun[0-9]* or tap[0-9]*")
+	}
+	file, err := os.OpenFile("/dev/"+dev, os.O_RDWR, 0)
+	if err != nil {
+		return nil, openPermissionError("/dev/"+dev, err)
+	}
+	return file, nil
+}
+
+func createInterface(file *os.File, ifPattern string, kind DevKind, meta bool) (string, error) {
+	name, _, err := fileInterface(file)
+	if err != nil {
+		return "", err
+	}
+
+	switch kind {
+	case DevTun:
+		if !strings.HasPrefix(name, "tun") {
+			return "", errors.New("Device " + name + " is not a tun device")
+		}
+		head := 1
+		err := control(file, func(fd uintptr) error {
+			_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, tunSIfHead, uintptr(unsafe.Pointer(&head)))
+			if errno != 0 {
+				return errno
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	case DevTap:
+		if !strings.HasPrefix(name, "tap") {
+			return "", errors.New("Device " + name + " is not a tap device")
+		}
+	default:
+		panic("Unknown interface type")
+	}
+	return name, nil
+}
+
+// devName returns the name of the tun or tap device open on fd.
+func devName(fd uintptr) (string, error) {
+	var buf [syscall.IFNAMSIZ]byte
+	arg := fiodgnameArg{Len: int32(len(buf)), Buf: &buf[0]}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, fiodgName, uintptr(unsafe.Pointer(&arg)))
+	if errno != 0 {
+		return "", errno
+	}
+	return string(buf[:clen(buf[:])]), nil
+}
+
+func fileInterface(file *os.File) (string, bool, error) {
+	var name string
+	err := control(file, func(fd uintptr) error {
+		var err error
+		name, err = devName(fd)
+		return err
+	})
+	return name, false, err
+}
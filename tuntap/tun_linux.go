@@ -1,20 +1,57 @@
 package tuntap
 
 import (
+	"errors"
+	"net"
 	"os"
+	"os/user"
+	"strconv"
 	"unsafe"
 	"syscall"
 )
 
+const hasAFHeader = false
+
 func openDevice(ifPattern string) (*os.File, error) {
 	file, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
-	return file, err
+	if err != nil {
+		return nil, openPermissionError("/dev/net/tun", err)
+	}
+	return file, nil
+}
+
+// pollFile returns a descriptor for file registered with the runtime
+// poller anew, and closes file. The tun driver only wakes up the pollers
+// registered once the descriptor is attached to an interface, while
+// os.OpenFile registers it right away: without this, reads blocked in
+// the poller never return.
+func pollFile(file *os.File) (*os.File, error) {
+	var fd int
+	err := control(file, func(f uintptr) error {
+		r, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f, syscall.F_DUPFD_CLOEXEC, 0)
+		if errno != 0 {
+			return errno
+		}
+		fd = int(r)
+		return nil
+	})
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), file.Name()), nil
 }
 
 func createInterface(file *os.File, ifPattern string, kind DevKind, meta bool) (string, error) {
+	return setIff(file, ifPattern, kind, meta, 0)
+}
+
+// setIff attaches file to the interface with TUNSETIFF, adding flags to
+// the ones derived from kind and meta.
+func setIff(file *os.File, ifPattern string, kind DevKind, meta bool, flags uint16) (string, error) {
 	var req ifReq
 	//req.Flags = iffOneQueue
-	req.Flags = 0
+	req.Flags = flags
 	copy(req.Name[:15], ifPattern)
 	switch kind {
 	case DevTun:
@@ -27,9 +64,381 @@ func createInterface(file *os.File, ifPattern string, kind DevKind, meta bool) (
 	if !meta {
 		req.Flags |= iffnopi
 	}
-	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), uintptr(syscall.TUNSETIFF), uintptr(unsafe.Pointer(&req)))
-	if err != 0 {
+	if err := fileIoctl(file, syscall.TUNSETIFF, unsafe.Pointer(&req)); err != nil {
+		return "", setIffPermissionError(ifPattern, err)
+	}
+	return string(req.Name[:clen(req.Name[:])]), nil
+}
+
+// fileInterface queries the name and packet information setting of
+// an attached tun/tap descriptor.
+func fileInterface(file *os.File) (string, bool, error) {
+	var req ifReq
+	if err := fileIoctl(file, syscall.TUNGETIFF, unsafe.Pointer(&req)); err != nil {
+		return "", false, err
+	}
+	return string(req.Name[:clen(req.Name[:])]), req.Flags&iffnopi == 0, nil
+}
+
+// OpenMultiQueue is like Open, but creates a multiqueue interface
+// (IFF_MULTI_QUEUE) with the given number of queues. Each queue is read
+// and written through its own descriptor, see Interface.Queue.
+//
+// All queues are attached to the same exact interface name: when
+// ifPattern is a pattern, the name picked by the kernel for the first
+// queue is used for the others.
+func OpenMultiQueue(ifPattern string, kind DevKind, meta bool, queues int) (*Interface, error) {
+	return openQueues(ifPattern, kind, meta, queues, iffMultiQueue)
+}
+
+// OpenVnetHdr is like Open, but enables IFF_VNET_HDR: every packet is
+// preceded by a virtio-net header, available as IPPacket.VnetHdr, and
+// the kernel may deliver and accept GSO super-packets of up to 64 KiB.
+func OpenVnetHdr(ifPattern string, kind DevKind, meta bool) (*Interface, error) {
+	return openQueues(ifPattern, kind, meta, 1, iffVnetHdr)
+}
+
+// openQueues opens queues descriptors on the same interface with the
+// extra TUNSETIFF flags.
+func openQueues(ifPattern string, kind DevKind, meta bool, queues int, flags uint16) (*Interface, error) {
+	if queues < 1 {
+		return nil, errors.New("An interface needs at least one queue")
+	}
+
+	var ifs []*Interface
+	name := ifPattern
+	for i := 0; i < queues; i++ {
+		file, err := openDevice(name)
+		if err != nil {
+			closeAll(ifs)
+			return nil, err
+		}
+		name, err = setIff(file, name, kind, meta, flags)
+		if err != nil {
+			file.Close()
+			closeAll(ifs)
+			return nil, err
+		}
+		if file, err = pollFile(file); err != nil {
+			closeAll(ifs)
+			return nil, err
+		}
+		ifs = append(ifs, &Interface{
+			dev:     &osDevice{file, name},
+			kind:    kind,
+			meta:    meta,
+			vnetHdr: flags&iffVnetHdr != 0,
+		})
+	}
+
+	if flags&iffMultiQueue != 0 {
+		for _, q := range ifs {
+			q.queues = ifs
+		}
+	}
+	return ifs[0], nil
+}
+
+func closeAll(ifs []*Interface) {
+	for _, t := range ifs {
+		t.dev.Close()
+	}
+}
+
+// fileIoctl issues req with a pointer argument on file.
+func fileIoctl(file *os.File, req uintptr, arg unsafe.Pointer) error {
+	return control(file, func(fd uintptr) error {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// ioctl issues req with a pointer argument on the interface descriptor.
+func (t *Interface) ioctl(req uintptr, arg unsafe.Pointer) error {
+	file, err := t.file()
+	if err != nil {
+		return err
+	}
+	return fileIoctl(file, req, arg)
+}
+
+// ioctlValue issues req with an integer argument on the interface
+// descriptor.
+func (t *Interface) ioctlValue(req, arg uintptr) error {
+	file, err := t.file()
+	if err != nil {
+		return err
+	}
+	return control(file, func(fd uintptr) error {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// AttachQueue re-enables a queue of a multiqueue interface previously
+// disabled with DetachQueue, so the kernel delivers packets to it again.
+func (t *Interface) AttachQueue() error {
+	return t.setQueue(iffAttachQueue)
+}
+
+// DetachQueue disables a queue of a multiqueue interface: the kernel
+// stops steering packets to it, while packets already queued can still
+// be read. Useful to drain a queue before stopping or moving its worker.
+func (t *Interface) DetachQueue() error {
+	return t.setQueue(iffDetachQueue)
+}
+
+func (t *Interface) setQueue(flags uint16) error {
+	var req ifReq
+	req.Flags = flags
+	return t.ioctl(tunSetQueue, unsafe.Pointer(&req))
+}
+
+// OffloadFlags select the offloads enabled with SetOffloads.
+type OffloadFlags uint
+
+const (
+	// The kernel may hand over packets with partial checksums
+	// (VirtioNetHdrFNeedsCsum), and accepts them from userspace.
+	OffloadCsum OffloadFlags = 0x01
+	// TCP segmentation offload for IPv4 and IPv6.
+	OffloadTSO4 OffloadFlags = 0x02
+	OffloadTSO6 OffloadFlags = 0x04
+	// TSO of packets with the ECN bit (VirtioNetHdrGSOECN).
+	OffloadTSOECN OffloadFlags = 0x08
+	// UDP fragmentation offload, mostly removed from recent kernels.
+	OffloadUFO OffloadFlags = 0x10
+	// UDP segmentation offload for IPv4 and IPv6.
+	OffloadUSO4 OffloadFlags = 0x20
+	OffloadUSO6 OffloadFlags = 0x40
+)
+
+// SetOffloads tells the kernel which offloads userspace can handle
+// (TUNSETOFFLOAD), so it delivers GSO super-packets and partially
+// checksummed packets instead of segmenting and checksumming them
+// first. The segmentation offloads require OffloadCsum too, and all of
+// them need an interface opened with OpenVnetHdr.
+func (t *Interface) SetOffloads(flags OffloadFlags) error {
+	return t.ioctlValue(syscall.TUNSETOFFLOAD, uintptr(flags))
+}
+
+func openWithOptions(ifPattern string, kind DevKind, o *openOptions) (*Interface, error) {
+	var flags uint16
+	queues := 1
+	if o.queues > 0 {
+		flags |= iffMultiQueue
+		queues = o.queues
+	}
+	if o.vnetHdr {
+		flags |= iffVnetHdr
+	}
+	t, err := openQueues(ifPattern, kind, o.meta, queues, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.applyOptions(o); err != nil {
+		t.Close()
+		return nil, err
+	}
+	if o.ioUring {
+		for i := 0; i < t.Queues(); i++ {
+			if err := t.Queue(i).useIOUring(); err != nil {
+				t.Close()
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// applyOptions sets the ownership and persistence requested in o.
+func (t *Interface) applyOptions(o *openOptions) error {
+	if o.owner >= 0 {
+		if err := t.SetOwner(o.owner); err != nil {
+			return err
+		}
+	}
+	if o.group >= 0 {
+		if err := t.SetGroup(o.group); err != nil {
+			return err
+		}
+	}
+	if o.persist {
+		return t.SetPersistent(true)
+	}
+	return nil
+}
+
+// SetPersistent sets whether the interface outlives its descriptors
+// (TUNSETPERSIST). A persistent interface stays around when closed, and
+// can be attached to again with Open and its exact name.
+func (t *Interface) SetPersistent(persist bool) error {
+	var arg uintptr
+	if persist {
+		arg = 1
+	}
+	return t.ioctlValue(syscall.TUNSETPERSIST, arg)
+}
+
+// CreatePersistent creates a persistent interface and returns its name,
+// like tunctl. It's meant for a privileged setup step: combined with
+// WithOwner or WithGroup, an unprivileged process can then attach to the
+// interface with Open.
+func CreatePersistent(ifPattern string, kind DevKind, opts ...Option) (string, error) {
+	t, err := OpenWithOptions(ifPattern, kind, append(opts, WithPersist())...)
+	if err != nil {
 		return "", err
 	}
-	return string(req.Name[:]), nil
+	name := t.Name()
+	return name, t.Close()
+}
+
+// RemovePersistent deletes the persistent interface name created with
+// CreatePersistent. The interface goes away once every other process
+// attached to it closes its descriptor.
+func RemovePersistent(name string, kind DevKind) error {
+	t, err := Open(name, kind, false)
+	if err != nil {
+		return err
+	}
+	if err := t.SetPersistent(false); err != nil {
+		t.Close()
+		return err
+	}
+	return t.Close()
+}
+
+// SetOwner lets the user uid attach to the interface without
+// CAP_NET_ADMIN (TUNSETOWNER). It's mostly useful on persistent
+// interfaces, see CreatePersistent.
+func (t *Interface) SetOwner(uid int) error {
+	return t.ioctlValue(syscall.TUNSETOWNER, uintptr(uid))
+}
+
+// SetGroup lets members of the group gid attach to the interface
+// without CAP_NET_ADMIN (TUNSETGROUP).
+func (t *Interface) SetGroup(gid int) error {
+	return t.ioctlValue(syscall.TUNSETGROUP, uintptr(gid))
+}
+
+// SetOwnerName is like SetOwner, with the user given by name.
+func (t *Interface) SetOwnerName(username string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	return t.SetOwner(uid)
+}
+
+// SetGroupName is like SetGroup, with the group given by name.
+func (t *Interface) SetGroupName(name string) error {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return err
+	}
+	return t.SetGroup(gid)
+}
+
+// Flags are the flags of a Linux tun/tap interface, as reported by
+// Interface.Flags.
+type Flags uint16
+
+const (
+	FlagTun        Flags = iffTun
+	FlagTap        Flags = iffTap
+	FlagNoPI       Flags = iffnopi
+	FlagOneQueue   Flags = iffOneQueue
+	FlagMultiQueue Flags = iffMultiQueue
+	FlagVnetHdr    Flags = iffVnetHdr
+	FlagPersist    Flags = iffPersist
+	FlagTunExcl    Flags = iffTunExcl
+	FlagNapi       Flags = iffNapi
+	FlagNapiFrags  Flags = iffNapiFrags
+)
+
+// Flags queries the current flags of the interface with TUNGETIFF. It
+// tells how a pre-existing interface, like a persistent one, was
+// configured.
+func (t *Interface) Flags() (Flags, error) {
+	var req ifReq
+	if err := t.ioctl(syscall.TUNGETIFF, unsafe.Pointer(&req)); err != nil {
+		return 0, err
+	}
+	return Flags(req.Flags), nil
+}
+
+// Kind queries whether the interface is a DevTun or a DevTap one, see
+// Flags.
+func (t *Interface) Kind() (DevKind, error) {
+	flags, err := t.Flags()
+	if err != nil {
+		return 0, err
+	}
+	if flags&FlagTap != 0 {
+		return DevTap, nil
+	}
+	return DevTun, nil
+}
+
+// ifreqHwaddr is struct ifreq with the ifr_hwaddr member.
+type ifreqHwaddr struct {
+	Name   [syscall.IFNAMSIZ]byte
+	Family uint16
+	Data   [14]byte
+	_      [8]byte
+}
+
+// SetHardwareAddr sets the MAC address of a DevTap interface
+// (SIOCSIFHWADDR).
+func (t *Interface) SetHardwareAddr(mac net.HardwareAddr) error {
+	if t.kind != DevTap {
+		return errNotTap
+	}
+	if len(mac) != 6 {
+		return errors.New("MAC addresses must be 6 bytes long")
+	}
+	var req ifreqHwaddr
+	copy(req.Name[:syscall.IFNAMSIZ-1], t.Name())
+	req.Family = syscall.ARPHRD_ETHER
+	copy(req.Data[:], mac)
+	return t.ioctl(syscall.SIOCSIFHWADDR, unsafe.Pointer(&req))
+}
+
+// SetCarrier sets the carrier state of the interface (TUNSETCARRIER).
+// Without carrier the link is reported down, as if its cable was
+// unplugged, so routing daemons can react when the transport behind a
+// tunnel is lost.
+func (t *Interface) SetCarrier(up bool) error {
+	var carrier int32
+	if up {
+		carrier = 1
+	}
+	return t.ioctl(tunSetCarrier, unsafe.Pointer(&carrier))
+}
+
+// SetSendBuffer sets the size in bytes of the send buffer of the
+// interface (TUNSETSNDBUF), which bounds the memory held by packets
+// written to it that the network stack hasn't consumed yet. Writes
+// fail while it's full, so a larger buffer absorbs bursts of writes.
+func (t *Interface) SetSendBuffer(bytes int) error {
+	if bytes <= 0 {
+		return errors.New("Send buffer size must be positive")
+	}
+	size := int32(bytes)
+	return t.ioctl(syscall.TUNSETSNDBUF, unsafe.Pointer(&size))
 }
@@ -0,0 +1,67 @@
+package tuntap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// tun(4) packets always carry a 4-byte address family header. tap(4)
+// frames don't.
+const hasAFHeader = true
+
+// There's no packet information header, so no truncation flag either.
+const flagTruncated = 0
+
+// OpenBSD has no cloning device, /dev/tunN opens (and creates) unit N.
+// A "%d" pattern tries units in order until a free one is found.
+const maxUnit = 256
+
+func openDevice(ifPattern string) (*os.File, error) {
+	if !strings.HasPrefix(ifPattern, "tun") && !strings.HasPrefix(ifPattern, "tap") {
+		return nil, errors.New("Interface name must be tun[0-9]* or tap[0-9]*")
+	}
+	if !strings.Contains(ifPattern, "%d") {
+		file, err := os.OpenFile("/dev/"+ifPattern, os.O_RDWR, 0)
+		if err != nil {
+			return nil, openPermissionError("/dev/"+ifPattern, err)
+		}
+		return file, nil
+	}
+	for i := 0; i < maxUnit; i++ {
+		path := "/dev/" + fmt.Sprintf(ifPattern, i)
+		file, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			return nil, openPermissionError(path, err)
+		}
+	}
+	return nil, errors.New("No free device for " + ifPattern)
+}
+
+func createInterface(file *os.File, ifPattern string, kind DevKind, meta bool) (string, error) {
+	name := strings.TrimPrefix(file.Name(), "/dev/")
+	switch kind {
+	case DevTun:
+		if !strings.HasPrefix(name, "tun") {
+			return "", errors.New("Device " + name + " is not a tun device")
+		}
+	case DevTap:
+		if !strings.HasPrefix(name, "tap") {
+			return "", errors.New("Device " + name + " is not a tap device")
+		}
+	default:
+		panic("Unknown interface type")
+	}
+	return name, nil
+}
+
+// There's no ioctl to recover the unit of an inherited descriptor, the
+// name is left empty.
+func fileInterface(file *os.File) (string, bool, error) {
+	return "", false, nil
+}
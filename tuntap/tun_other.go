@@ -1,4 +1,4 @@
-// +build !linux,!darwin
+// +build !linux,!darwin,!windows,!freebsd,!openbsd,!solaris
 
 package tuntap
 
@@ -8,6 +8,12 @@ import (
 
 var flagTruncated = 0
 
-func createInterface(f *os.File, ifPattern string, kind DevKind) (string, error) {
+const hasAFHeader = false
+
+func openDevice(ifPattern string) (*os.File, error) {
+	panic("Not implemented on this platform")
+}
+
+func createInterface(f *os.File, ifPattern string, kind DevKind, meta bool) (string, error) {
 	panic("Not implemented on this platform")
 }
@@ -0,0 +1,157 @@
+package tuntap
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// The universal TUN/TAP STREAMS driver delivers bare IP packets.
+const hasAFHeader = false
+
+// There's no packet information header, so no truncation flag either.
+const flagTruncated = 0
+
+const (
+	sysIoctl = 54
+
+	// <sys/stropts.h>
+	iStr     = 0x5308
+	iPush    = 0x5302
+	iPlink   = 0x5316
+	iPunlink = 0x5317
+	iSrdopt  = 0x5306
+	rmsgd    = 0x0001
+
+	// _IOW('i', 54, int) from <sys/sockio.h>
+	ifUnitSel = 0x80046936
+	// From the driver's if_tun.h.
+	tunNewPPA = 'T'<<16 | 0x0001
+)
+
+// struct strioctl from <sys/stropts.h>.
+type strIoctl struct {
+	Cmd    int32
+	Timout int32
+	Len    int32
+	Dp     *byte
+}
+
+// solarisDevice is the data stream of a tun device plus the IP stream
+// it's linked under. Closing it unlinks the interface again.
+type solarisDevice struct {
+	*os.File
+	ip    *os.File
+	muxid int
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) (int, error) {
+	r, _, errno := syscall.Syscall(sysIoctl, fd, req, uintptr(arg))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+func ioctlValue(fd uintptr, req uintptr, arg uintptr) (int, error) {
+	r, _, errno := syscall.Syscall(sysIoctl, fd, req, arg)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+// tunPPA converts an interface pattern to a physical point of
+// attachment: -1 lets the driver pick one for "tun" and "tun%d".
+func tunPPA(ifPattern string) (int32, error) {
+	if !strings.HasPrefix(ifPattern, "tun") {
+		return 0, errors.New("Interface name must be tun[0-9]*")
+	}
+	num := ifPattern[len("tun"):]
+	if num == "" || num == "%d" {
+		return -1, nil
+	}
+	n, err := strconv.ParseInt(num, 10, 32)
+	if err != nil {
+		return 0, errors.New("Interface name must be tun[0-9]*")
+	}
+	return int32(n), nil
+}
+
+func openDevice(ifPattern string) (*solarisDevice, error) {
+	file, err := os.OpenFile("/dev/tun", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &solarisDevice{File: file}, nil
+}
+
+func pollFile(dev *solarisDevice) (*solarisDevice, error) {
+	return dev, nil
+}
+
+func createInterface(dev *solarisDevice, ifPattern string, kind DevKind, meta bool) (string, error) {
+	if kind != DevTun {
+		return "", errors.New("Only DevTun is supported on solaris")
+	}
+	ppa, err := tunPPA(ifPattern)
+	if err != nil {
+		return "", err
+	}
+
+	// Allocate the PPA on the data stream.
+	req := strIoctl{Cmd: tunNewPPA, Len: 4, Dp: (*byte)(unsafe.Pointer(&ppa))}
+	n, err := ioctl(dev.Fd(), iStr, unsafe.Pointer(&req))
+	if err != nil {
+		return "", err
+	}
+	ppa = int32(n)
+
+	// Read one packet per read(2) instead of a byte stream.
+	if _, err := ioctlValue(dev.Fd(), iSrdopt, rmsgd); err != nil {
+		return "", err
+	}
+
+	// Open a second stream on the PPA, push IP on it and link it under
+	// the IP multiplexor to make the interface visible to the stack.
+	ipFile, err := os.OpenFile("/dev/ip", os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	ifFile, err := os.OpenFile("/dev/tun", os.O_RDWR, 0)
+	if err != nil {
+		ipFile.Close()
+		return "", err
+	}
+	defer ifFile.Close()
+
+	module := []byte("ip\x00")
+	if _, err := ioctl(ifFile.Fd(), iPush, unsafe.Pointer(&module[0])); err != nil {
+		ipFile.Close()
+		return "", err
+	}
+	if _, err := ioctl(ifFile.Fd(), ifUnitSel, unsafe.Pointer(&ppa)); err != nil {
+		ipFile.Close()
+		return "", err
+	}
+	muxid, err := ioctlValue(ipFile.Fd(), iPlink, ifFile.Fd())
+	if err != nil {
+		ipFile.Close()
+		return "", err
+	}
+
+	dev.ip = ipFile
+	dev.muxid = muxid
+	return "tun" + strconv.Itoa(int(ppa)), nil
+}
+
+func (d *solarisDevice) Close() error {
+	if d.ip != nil {
+		ioctlValue(d.ip.Fd(), iPunlink, uintptr(d.muxid))
+		d.ip.Close()
+	}
+	return d.File.Close()
+}
@@ -0,0 +1,70 @@
+package tuntap
+
+import (
+	"io"
+	"syscall"
+)
+
+// Neither Wintun nor TAP-Windows6 add a packet information or address
+// family header.
+const hasAFHeader = false
+
+// There's no packet information header, so no truncation flag either.
+const flagTruncated = 0
+
+const (
+	waitObject0 = 0
+	infinite    = 0xffffffff
+)
+
+var (
+	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procCreateEventW           = modkernel32.NewProc("CreateEventW")
+	procSetEvent               = modkernel32.NewProc("SetEvent")
+	procWaitForMultipleObjects = modkernel32.NewProc("WaitForMultipleObjects")
+	procGetOverlappedResult    = modkernel32.NewProc("GetOverlappedResult")
+)
+
+// windowsDevice is filled in by createInterface with the driver
+// matching the requested kind: Wintun for DevTun, TAP-Windows6 for
+// DevTap.
+type windowsDevice struct {
+	io.ReadWriteCloser
+}
+
+func openDevice(ifPattern string) (*windowsDevice, error) {
+	return &windowsDevice{}, nil
+}
+
+func pollFile(dev *windowsDevice) (*windowsDevice, error) {
+	return dev, nil
+}
+
+func createInterface(dev *windowsDevice, ifPattern string, kind DevKind, meta bool) (string, error) {
+	switch kind {
+	case DevTun:
+		w, err := openWintun(ifPattern)
+		if err != nil {
+			return "", err
+		}
+		dev.ReadWriteCloser = w
+		return ifPattern, nil
+	case DevTap:
+		t, name, err := openTapWindows(ifPattern)
+		if err != nil {
+			return "", err
+		}
+		dev.ReadWriteCloser = t
+		return name, nil
+	default:
+		panic("Unknown interface type")
+	}
+}
+
+func (d *windowsDevice) Close() error {
+	if d.ReadWriteCloser == nil {
+		return nil
+	}
+	return d.ReadWriteCloser.Close()
+}
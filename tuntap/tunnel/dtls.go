@@ -0,0 +1,85 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/pion/dtls/v2"
+)
+
+// DTLSConfig returns a configuration for DialDTLS, or for AcceptDTLS if
+// server is set, authenticating both ends with certificates, like
+// TLSConfig.
+func DTLSConfig(cert tls.Certificate, peers *x509.CertPool, server bool) *dtls.Config {
+	config := &dtls.Config{
+		Certificates:         []tls.Certificate{cert},
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}
+	if server {
+		config.ClientCAs = peers
+		config.ClientAuth = dtls.RequireAndVerifyClientCert
+	} else {
+		config.RootCAs = peers
+	}
+	return config
+}
+
+// DialDTLS connects to the peer listening at the UDP address with
+// AcceptDTLS, and returns a Tunnel between t and the peer over DTLS,
+// with a packet in each record. The server name verified is the host of
+// address, unless config.ServerName is set.
+func DialDTLS(ctx context.Context, t *tuntap.Interface, address string, config *dtls.Config, opts Options) (*Tunnel, error) {
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(address)
+		c := *config
+		c.ServerName = host
+		config = &c
+	}
+	conn, err := dtls.DialWithContext(ctx, "udp", raddr, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(t, conn, opts), nil
+}
+
+// AcceptDTLS listens on the UDP address, and returns a Tunnel between t
+// and the first peer to complete a DTLS handshake, like AcceptTLS.
+func AcceptDTLS(ctx context.Context, t *tuntap.Interface, address string, config *dtls.Config, opts Options) (*Tunnel, error) {
+	laddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := dtls.Listen("udp", laddr, config)
+	if err != nil {
+		return nil, err
+	}
+	// The socket stays open for the accepted connection.
+	defer ln.Close()
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	for {
+		conn, err := ln.Accept()
+		switch {
+		case ctx.Err() != nil:
+			if conn != nil {
+				conn.Close()
+			}
+			return nil, ctx.Err()
+		case errors.Is(err, net.ErrClosed):
+			return nil, err
+		case err != nil:
+			// A failed handshake.
+			continue
+		}
+		return NewConn(t, conn, opts), nil
+	}
+}
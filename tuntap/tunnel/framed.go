@@ -0,0 +1,75 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Length of the frame header of Framed streams.
+const frameHeaderLength = 2
+
+// Framed returns a Conn sending each Write on the stream conn, like a
+// TCP or TLS connection, as a frame: its length as a 16-bit big endian
+// integer followed by the data. Each Read returns the data of a frame,
+// so it can carry a Tunnel with NewConn.
+func Framed(conn net.Conn) net.Conn {
+	return &framedConn{Conn: conn}
+}
+
+type framedConn struct {
+	net.Conn
+	writeMu sync.Mutex
+	readMu  sync.Mutex
+	buf     []byte
+	hdr     [frameHeaderLength]byte
+}
+
+func (c *framedConn) Write(b []byte) (int, error) {
+	if len(b) > 0xffff {
+		return 0, errors.New("Frame too large")
+	}
+	frame := make([]byte, frameHeaderLength+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[frameHeaderLength:], b)
+
+	// A frame is written at once, to keep Writes from several
+	// goroutines whole.
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.Conn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read reads the next frame into b. A frame larger than b is truncated
+// to its first len(b) bytes.
+func (c *framedConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if _, err := io.ReadFull(c.Conn, c.hdr[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(c.hdr[:]))
+	if n <= len(b) {
+		return io.ReadFull(c.Conn, b[:n])
+	}
+	if len(c.buf) < n {
+		c.buf = make([]byte, n)
+	}
+	if _, err := io.ReadFull(c.Conn, c.buf[:n]); err != nil {
+		return 0, err
+	}
+	return copy(b, c.buf[:n]), nil
+}
+
+// CloseWrite passes on half-closes to the stream.
+func (c *framedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,138 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// Longest GRE header, with checksum, key and sequence number.
+const greMaxHeaderLength = 16
+
+// GREOptions configure the GRE headers of a Tunnel made with NewGRE,
+// like the key, csum and seq settings of Linux gre and gretap devices.
+// Both ends must agree on them.
+type GREOptions struct {
+	// Key, if KeyPresent, is sent with each packet, and only packets
+	// with the same key are accepted; otherwise only packets without a
+	// key are.
+	Key        uint32
+	KeyPresent bool
+	// Checksum adds checksums to the packets sent. Received checksums
+	// are verified whenever present.
+	Checksum bool
+	// Sequence numbers the packets sent, and drops the packets received
+	// without a sequence number or out of order.
+	Sequence bool
+}
+
+// NewGRE returns a Tunnel between t and the peer reached through conn,
+// a raw socket for GRE packets opened with net.ListenIP("ip4:gre", ...)
+// or "ip6:gre", carrying the packets of a DevTun interface as IPv4 or
+// IPv6 payloads, or the frames of a DevTap interface as Ethernet
+// payloads. opts.Peer is the address of the peer with port 0. Keepalive
+// sends GRE packets with no payload, which Linux devices ignore. The
+// Tunnel owns t and conn: closing it closes them.
+func NewGRE(t *tuntap.Interface, conn *net.IPConn, opts Options, gre GREOptions) *Tunnel {
+	peer := netip.AddrPortFrom(opts.Peer.Addr().Unmap(), 0)
+	tr := &greTransport{
+		packetTransport: &packetTransport{conn: conn, roaming: opts.Roaming, addr: peer},
+		opts:            gre,
+		tap:             t.LocalAddr().(*tuntap.Addr).Kind == tuntap.DevTap,
+		buf:             make([]byte, maxDatagram),
+	}
+	return newTunnel(t, tr, opts)
+}
+
+// greTransport carries datagrams in GRE packets.
+type greTransport struct {
+	*packetTransport
+	opts GREOptions
+	tap  bool
+	// Sequence number of the next packet sent.
+	seq atomic.Uint32
+	// Sequence number expected next, and whether one was received.
+	nextSeq uint32
+	seqInit bool
+	buf     []byte
+}
+
+func (g *greTransport) read(b []byte) (int, error) {
+	for {
+		n, err := g.packetTransport.read(g.buf)
+		if err != nil {
+			return 0, err
+		}
+		if payload, ok := g.accept(g.buf[:n]); ok {
+			return copy(b, payload), nil
+		}
+	}
+}
+
+// accept returns the payload of the GRE packet b, if the tunnel takes
+// it.
+func (g *greTransport) accept(b []byte) ([]byte, bool) {
+	var h parser.GREHeader
+	if err := h.Unmarshal(b); err != nil {
+		return nil, false
+	}
+	if h.KeyPresent != g.opts.KeyPresent || h.Key != g.opts.Key {
+		return nil, false
+	}
+	if h.ChecksumPresent && !parser.VerifyGREChecksum(b) {
+		return nil, false
+	}
+	if g.opts.Sequence {
+		// Like Linux, with serial number arithmetic.
+		if !h.SequencePresent || g.seqInit && int32(h.Sequence-g.nextSeq) < 0 {
+			return nil, false
+		}
+		g.nextSeq = h.Sequence + 1
+		g.seqInit = true
+	}
+	payload := b[h.Length():]
+	if len(payload) == 0 {
+		// Keepalive.
+		return payload, true
+	}
+	switch h.Protocol {
+	case parser.EtherTypeTransparentEthernet:
+		return payload, g.tap
+	case parser.EtherTypeIPv4, parser.EtherTypeIPv6:
+		return payload, !g.tap
+	}
+	return nil, false
+}
+
+func (g *greTransport) write(b []byte) error {
+	h := parser.GREHeader{
+		ChecksumPresent: g.opts.Checksum,
+		KeyPresent:      g.opts.KeyPresent,
+		Key:             g.opts.Key,
+		SequencePresent: g.opts.Sequence,
+	}
+	switch {
+	case len(b) == 0:
+		// A keepalive with no protocol.
+	case g.tap:
+		h.Protocol = parser.EtherTypeTransparentEthernet
+	case b[0]>>4 == 4:
+		h.Protocol = parser.EtherTypeIPv4
+	default:
+		h.Protocol = parser.EtherTypeIPv6
+	}
+	if h.SequencePresent {
+		h.Sequence = g.seq.Add(1) - 1
+	}
+	pkt := make([]byte, 0, greMaxHeaderLength+len(b))
+	pkt = append(pkt, h.Marshal()...)
+	pkt = append(pkt, b...)
+	if h.ChecksumPresent {
+		binary.BigEndian.PutUint16(pkt[4:6], parser.GREChecksum(pkt))
+	}
+	return g.packetTransport.write(pkt)
+}
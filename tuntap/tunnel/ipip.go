@@ -0,0 +1,63 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/izqui/tuntap/tuntap"
+)
+
+// NewIPIP returns a Tunnel between t, a DevTun interface, and the peer
+// reached through conn, a raw socket opened for proto: either
+// tuntap.ProtoIPv6, with net.ListenIP("ip4:41", ...), carrying IPv6
+// packets in IPv4 like the 6in4 tunnels of tunnel brokers and Linux sit
+// devices, or tuntap.ProtoIPIP, with "ip4:4", carrying IPv4 packets in
+// IPv4 like Linux ipip devices. Packets of the other IP version read
+// from t are dropped. opts.Peer is the IPv4 address of the peer with
+// port 0. Keepalive sends empty packets, which Linux devices ignore.
+// The Tunnel owns t and conn: closing it closes them.
+func NewIPIP(t *tuntap.Interface, conn *net.IPConn, proto tuntap.IPProtocol, opts Options) (*Tunnel, error) {
+	if t.LocalAddr().(*tuntap.Addr).Kind != tuntap.DevTun {
+		return nil, errors.New("IP in IP needs a DevTun interface")
+	}
+	var version byte
+	switch proto {
+	case tuntap.ProtoIPIP:
+		version = 4
+	case tuntap.ProtoIPv6:
+		version = 6
+	default:
+		return nil, errors.New("IP in IP carries IPv4 or IPv6 packets")
+	}
+	peer := netip.AddrPortFrom(opts.Peer.Addr().Unmap(), 0)
+	tr := &ipipTransport{
+		packetTransport: &packetTransport{conn: conn, roaming: opts.Roaming, addr: peer},
+		version:         version,
+	}
+	return newTunnel(t, tr, opts), nil
+}
+
+// ipipTransport carries the packets of one IP version as the payload of
+// raw IPv4 packets.
+type ipipTransport struct {
+	*packetTransport
+	version byte
+}
+
+func (p *ipipTransport) read(b []byte) (int, error) {
+	for {
+		// The socket strips the outer header.
+		n, err := p.packetTransport.read(b)
+		if err != nil || n == 0 || b[0]>>4 == p.version {
+			return n, err
+		}
+	}
+}
+
+func (p *ipipTransport) write(b []byte) error {
+	if len(b) > 0 && b[0]>>4 != p.version {
+		return nil
+	}
+	return p.packetTransport.write(b)
+}
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap"
+)
+
+// Time allowed to a connecting peer to complete the handshake, after
+// which AcceptTLS moves on to the next one.
+const handshakeTimeout = 10 * time.Second
+
+// TLSConfig returns a configuration for DialTLS, or for AcceptTLS if
+// server is set, authenticating both ends with certificates: cert is
+// presented to the peer, whose certificate must be signed by one of
+// peers.
+func TLSConfig(cert tls.Certificate, peers *x509.CertPool, server bool) *tls.Config {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+	}
+	if server {
+		config.ClientCAs = peers
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		config.RootCAs = peers
+	}
+	return config
+}
+
+// DialTLS connects to the peer listening at address with AcceptTLS, and
+// returns a Tunnel between t and the peer over TLS over TCP, with the
+// packets Framed. The server name verified is the host of address,
+// unless config.ServerName is set.
+func DialTLS(ctx context.Context, t *tuntap.Interface, address string, config *tls.Config, opts Options) (*Tunnel, error) {
+	d := tls.Dialer{Config: config}
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(t, Framed(conn), opts), nil
+}
+
+// AcceptTLS listens on the TCP address, and returns a Tunnel between t
+// and the first peer to complete a TLS handshake, the peers presenting
+// no certificate or one config doesn't accept being turned away. It
+// stops listening once the Tunnel is established.
+func AcceptTLS(ctx context.Context, t *tuntap.Interface, address string, config *tls.Config, opts Options) (*Tunnel, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		conn := tls.Server(c, config)
+		hctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+		err = conn.HandshakeContext(hctx)
+		cancel()
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		return NewConn(t, Framed(conn), opts), nil
+	}
+}
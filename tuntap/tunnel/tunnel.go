@@ -0,0 +1,305 @@
+// Package tunnel builds overlays out of tuntap Interfaces, carrying
+// their packets to a remote peer over another network:
+//
+//	t, err := tuntap.Open("tun%d", tuntap.DevTun, false)
+//	...
+//	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: 4789})
+//	...
+//	tn := tunnel.New(t, conn, tunnel.Options{
+//		Peer:      netip.MustParseAddrPort("192.0.2.1:4789"),
+//		Keepalive: 25 * time.Second,
+//	})
+//	err = tn.Run()
+//
+// Over plain UDP, or TCP with Framed, the packets travel as they are,
+// unauthenticated and unencrypted. DialTLS and AcceptTLS, or DialDTLS
+// and AcceptDTLS for datagrams, establish tunnels encrypted with TLS,
+// whose ends authenticate each other with certificates. NewGRE,
+// NewVXLAN and NewIPIP interoperate with the GRE, VXLAN and IP in IP
+// tunnels of routers and Linux devices.
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap"
+)
+
+// Largest datagram, which fits any packet read from an interface.
+const maxDatagram = 65535
+
+// Options configure a Tunnel.
+type Options struct {
+	// Address of the remote peer. If unset, the tunnel waits for a
+	// datagram and answers its sender, as the passive end of a tunnel.
+	Peer netip.AddrPort
+	// Roaming makes the tunnel follow the peer to the source address
+	// of its latest datagram, when its address changes. It lets anyone
+	// able to send datagrams to the tunnel take it over.
+	Roaming bool
+	// Keepalive, if set, sends an empty datagram to the peer after that
+	// long without sending anything, to keep NAT and firewall state
+	// alive. Empty datagrams are not delivered to the interface.
+	Keepalive time.Duration
+}
+
+// A Tunnel encapsulates the packets read from an Interface, or the
+// frames of a DevTap one, in the datagrams of a transport to a remote
+// peer, and writes the packets it receives from the peer to the
+// Interface.
+type Tunnel struct {
+	t    *tuntap.Interface
+	tr   transport
+	opts Options
+	// Time of the last datagram sent, in nanoseconds.
+	lastSend atomic.Int64
+
+	closed    atomic.Bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// transport carries the datagrams of a Tunnel.
+type transport interface {
+	// read reads the next datagram from the peer.
+	read(b []byte) (int, error)
+	// write sends b to the peer, if it's known.
+	write(b []byte) error
+	peer() netip.AddrPort
+	Close() error
+}
+
+// New returns a Tunnel between t and the peer reached through conn,
+// usually a *net.UDPConn, with each packet in a datagram. The Tunnel
+// owns both: closing it closes them.
+func New(t *tuntap.Interface, conn net.PacketConn, opts Options) *Tunnel {
+	peer := netip.AddrPortFrom(opts.Peer.Addr().Unmap(), opts.Peer.Port())
+	return newTunnel(t, &packetTransport{conn: conn, roaming: opts.Roaming, addr: peer}, opts)
+}
+
+// NewConn returns a Tunnel between t and the peer at the other end of
+// conn, which must keep the boundaries of the packets written, like a
+// DTLS connection, a connected UDP socket or a Framed stream. Peer and
+// Roaming are ignored. The Tunnel owns both: closing it closes them.
+func NewConn(t *tuntap.Interface, conn net.Conn, opts Options) *Tunnel {
+	return newTunnel(t, connTransport{conn}, opts)
+}
+
+func newTunnel(t *tuntap.Interface, tr transport, opts Options) *Tunnel {
+	return &Tunnel{t: t, tr: tr, opts: opts, done: make(chan struct{})}
+}
+
+// Peer returns the address of the peer, invalid until it's known.
+func (tn *Tunnel) Peer() netip.AddrPort {
+	return tn.tr.peer()
+}
+
+// Run carries the packets both ways until the Tunnel is closed, then
+// returns nil, or until the interface or transport fails. Datagrams
+// that can't be sent or written to the interface are dropped.
+func (tn *Tunnel) Run() error {
+	errc := make(chan error, 2)
+	go func() { errc <- tn.send() }()
+	go func() { errc <- tn.receive() }()
+	if tn.opts.Keepalive > 0 {
+		go tn.keepalive()
+	}
+	err := <-errc
+	closed := tn.closed.Load()
+	tn.Close()
+	<-errc
+	if closed {
+		return nil
+	}
+	return err
+}
+
+// send sends the packets read from the interface to the peer.
+func (tn *Tunnel) send() error {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, _, err := tn.t.ReadFrom(buf)
+		if err != nil {
+			var ioErr *tuntap.Error
+			if errors.Is(err, tuntap.ErrClosed) || errors.As(err, &ioErr) {
+				return err
+			}
+			continue
+		}
+		if err := tn.writePeer(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+// writePeer sends b to the peer. Only errors ending the transport are
+// returned.
+func (tn *Tunnel) writePeer(b []byte) error {
+	tn.lastSend.Store(time.Now().UnixNano())
+	err := tn.tr.write(b)
+	var opErr *net.OpError
+	if err == nil || errors.As(err, &opErr) && !errors.Is(err, net.ErrClosed) {
+		// Like an unreachable peer.
+		return nil
+	}
+	return err
+}
+
+// receive writes the packets received from the peer to the interface.
+func (tn *Tunnel) receive() error {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, err := tn.tr.read(buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// Keepalive.
+			continue
+		}
+		if _, err := tn.t.WriteTo(buf[:n], nil); errors.Is(err, tuntap.ErrClosed) || errors.Is(err, tuntap.ErrDeviceGone) {
+			return err
+		}
+	}
+}
+
+// packetTransport sends datagrams on a PacketConn to a peer whose
+// address is given or learned.
+type packetTransport struct {
+	conn    net.PacketConn
+	roaming bool
+	// port, if set, is the port of the peer whatever the source port of
+	// its datagrams, for protocols like VXLAN whose source ports vary.
+	port uint16
+	mu   sync.Mutex
+	addr netip.AddrPort
+}
+
+func (p *packetTransport) read(b []byte) (int, error) {
+	for {
+		n, addr, err := p.conn.ReadFrom(b)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() || errors.Is(err, net.ErrClosed) {
+				return 0, err
+			}
+			// Like ICMP errors reported on the socket.
+			continue
+		}
+		if p.accept(addr) {
+			return n, nil
+		}
+	}
+}
+
+// accept tells whether a datagram from addr comes from the peer, and
+// learns the peer from it as configured.
+func (p *packetTransport) accept(addr net.Addr) bool {
+	var from netip.AddrPort
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		from = a.AddrPort()
+	case *net.IPAddr:
+		// Raw IP sockets, without ports.
+		ip, _ := netip.AddrFromSlice(a.IP)
+		from = netip.AddrPortFrom(ip, 0)
+	default:
+		return false
+	}
+	from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+	if p.port != 0 {
+		from = netip.AddrPortFrom(from.Addr(), p.port)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.addr == from:
+		return true
+	case !p.addr.IsValid() || p.roaming:
+		p.addr = from
+		return true
+	}
+	return false
+}
+
+func (p *packetTransport) write(b []byte) error {
+	peer := p.peer()
+	if !peer.IsValid() {
+		return nil
+	}
+	var addr net.Addr = net.UDPAddrFromAddrPort(peer)
+	if _, ok := p.conn.(*net.IPConn); ok {
+		addr = &net.IPAddr{IP: peer.Addr().AsSlice()}
+	}
+	_, err := p.conn.WriteTo(b, addr)
+	return err
+}
+
+func (p *packetTransport) peer() netip.AddrPort {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addr
+}
+
+func (p *packetTransport) Close() error {
+	return p.conn.Close()
+}
+
+// connTransport sends datagrams on a connection.
+type connTransport struct {
+	net.Conn
+}
+
+func (c connTransport) read(b []byte) (int, error) {
+	return c.Read(b)
+}
+
+func (c connTransport) write(b []byte) error {
+	_, err := c.Write(b)
+	return err
+}
+
+func (c connTransport) peer() netip.AddrPort {
+	switch a := c.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return a.AddrPort()
+	case *net.TCPAddr:
+		return a.AddrPort()
+	}
+	return netip.AddrPort{}
+}
+
+// keepalive sends empty datagrams while nothing else is sent.
+func (tn *Tunnel) keepalive() {
+	tick := time.NewTicker(tn.opts.Keepalive / 2)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tn.done:
+			return
+		case <-tick.C:
+		}
+		if time.Since(time.Unix(0, tn.lastSend.Load())) >= tn.opts.Keepalive {
+			tn.writePeer(nil)
+		}
+	}
+}
+
+// Close stops the Tunnel, and closes its interface and transport.
+func (tn *Tunnel) Close() error {
+	var err error
+	tn.closeOnce.Do(func() {
+		tn.closed.Store(true)
+		close(tn.done)
+		err = tn.tr.Close()
+		if terr := tn.t.Close(); err == nil {
+			err = terr
+		}
+	})
+	return err
+}
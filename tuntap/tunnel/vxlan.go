@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+// NewVXLAN returns a Tunnel bridging t, a DevTap interface, to the peer
+// reached through conn, a UDP socket usually bound to
+// parser.VXLANPort: the frames of t travel in VXLAN packets (RFC 7348)
+// of the network vni, and only the packets of that network are
+// accepted. The peer may be a Linux vxlan device with the same id.
+// Datagrams are sent to the port of opts.Peer, or of conn if opts.Peer
+// has none, whatever the source port of those received. The Tunnel owns
+// t and conn: closing it closes them.
+func NewVXLAN(t *tuntap.Interface, conn net.PacketConn, opts Options, vni uint32) (*Tunnel, error) {
+	if t.LocalAddr().(*tuntap.Addr).Kind != tuntap.DevTap {
+		return nil, errors.New("VXLAN needs a DevTap interface")
+	}
+	h := parser.VXLANHeader{VNI: vni}
+	hdr, err := h.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	peer := netip.AddrPortFrom(opts.Peer.Addr().Unmap(), opts.Peer.Port())
+	// The peer sends from ports depending on the flow, but receives on
+	// its VXLAN port, by default ours.
+	port := peer.Port()
+	if port == 0 {
+		port = parser.VXLANPort
+		if ua, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			port = uint16(ua.Port)
+		}
+	}
+	tr := &vxlanTransport{
+		packetTransport: &packetTransport{conn: conn, roaming: opts.Roaming, port: port, addr: peer},
+		vni:             vni,
+		hdr:             hdr,
+		buf:             make([]byte, maxDatagram),
+	}
+	return newTunnel(t, tr, opts), nil
+}
+
+// vxlanTransport carries datagrams in VXLAN packets.
+type vxlanTransport struct {
+	*packetTransport
+	vni uint32
+	hdr []byte
+	buf []byte
+}
+
+func (v *vxlanTransport) read(b []byte) (int, error) {
+	for {
+		n, err := v.packetTransport.read(v.buf)
+		if err != nil {
+			return 0, err
+		}
+		var h parser.VXLANHeader
+		if h.Unmarshal(v.buf[:n]) != nil || h.VNI != v.vni {
+			continue
+		}
+		return copy(b, v.buf[len(v.hdr):n]), nil
+	}
+}
+
+func (v *vxlanTransport) write(b []byte) error {
+	pkt := make([]byte, 0, len(v.hdr)+len(b))
+	pkt = append(pkt, v.hdr...)
+	return v.packetTransport.write(append(pkt, b...))
+}
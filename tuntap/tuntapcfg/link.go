@@ -0,0 +1,44 @@
+// Package tuntapcfg configures the network interfaces created with
+// package tuntap: MTU, link state, addresses and routes. On Linux it
+// talks rtnetlink directly, so there's no need to run ip(8).
+package tuntapcfg
+
+import (
+	"net"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/internal/mtu"
+)
+
+// Link is a network interface to configure, identified by its index so
+// it's not confused with another one if it's renamed.
+type Link struct {
+	Index int
+}
+
+// For returns the Link of t.
+func For(t *tuntap.Interface) (*Link, error) {
+	index, err := t.Index()
+	if err != nil {
+		return nil, err
+	}
+	return &Link{Index: index}, nil
+}
+
+// ByName returns the Link of the interface name.
+func ByName(name string) (*Link, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Link{Index: ifi.Index}, nil
+}
+
+// SetMTU sets the MTU of the interface.
+func (l *Link) SetMTU(n int) error {
+	ifi, err := net.InterfaceByIndex(l.Index)
+	if err != nil {
+		return err
+	}
+	return mtu.Set(ifi.Name, n)
+}
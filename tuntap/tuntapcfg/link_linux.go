@@ -0,0 +1,91 @@
+package tuntapcfg
+
+import (
+	"net/netip"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/izqui/tuntap/tuntap/internal/rtnl"
+)
+
+// SetUp brings the interface up.
+func (l *Link) SetUp() error {
+	var b rtnl.Message
+	b.IfInfo(l.Index, unix.IFF_UP, unix.IFF_UP)
+	return rtnl.Request(unix.RTM_NEWLINK, 0, b)
+}
+
+// SetDown brings the interface down.
+func (l *Link) SetDown() error {
+	var b rtnl.Message
+	b.IfInfo(l.Index, 0, unix.IFF_UP)
+	return rtnl.Request(unix.RTM_NEWLINK, 0, b)
+}
+
+// AddAddress assigns an address to the interface, with the network
+// in prefix being reachable through it.
+func (l *Link) AddAddress(prefix netip.Prefix) error {
+	return rtnl.Request(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_EXCL, l.addrMsg(prefix))
+}
+
+// DelAddress removes an address added with AddAddress.
+func (l *Link) DelAddress(prefix netip.Prefix) error {
+	return rtnl.Request(unix.RTM_DELADDR, 0, l.addrMsg(prefix))
+}
+
+func (l *Link) addrMsg(prefix netip.Prefix) rtnl.Message {
+	addr := prefix.Addr().Unmap()
+	ifa := unix.IfAddrmsg{
+		Family:    family(addr),
+		Prefixlen: uint8(prefix.Bits()),
+		Index:     uint32(l.Index),
+	}
+	var b rtnl.Message
+	b = append(b, (*[unix.SizeofIfAddrmsg]byte)(unsafe.Pointer(&ifa))[:]...)
+	b.Attr(unix.IFA_LOCAL, addr.AsSlice())
+	b.Attr(unix.IFA_ADDRESS, addr.AsSlice())
+	return b
+}
+
+// AddRoute routes dst through the interface. gw is the next hop, or the
+// zero Addr for a route to directly connected hosts, the usual case for
+// a tun interface.
+func (l *Link) AddRoute(dst netip.Prefix, gw netip.Addr) error {
+	return rtnl.Request(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, l.routeMsg(dst, gw))
+}
+
+// DelRoute removes a route added with AddRoute.
+func (l *Link) DelRoute(dst netip.Prefix, gw netip.Addr) error {
+	return rtnl.Request(unix.RTM_DELROUTE, 0, l.routeMsg(dst, gw))
+}
+
+func (l *Link) routeMsg(dst netip.Prefix, gw netip.Addr) rtnl.Message {
+	dst = dst.Masked()
+	rt := unix.RtMsg{
+		Family:   family(dst.Addr().Unmap()),
+		Dst_len:  uint8(dst.Bits()),
+		Table:    unix.RT_TABLE_MAIN,
+		Protocol: unix.RTPROT_BOOT,
+		Scope:    unix.RT_SCOPE_LINK,
+		Type:     unix.RTN_UNICAST,
+	}
+	if gw.IsValid() {
+		rt.Scope = unix.RT_SCOPE_UNIVERSE
+	}
+	var b rtnl.Message
+	b = append(b, (*[unix.SizeofRtMsg]byte)(unsafe.Pointer(&rt))[:]...)
+	b.Attr(unix.RTA_DST, dst.Addr().Unmap().AsSlice())
+	b.Attr(unix.RTA_OIF, rtnl.Uint32(uint32(l.Index)))
+	if gw.IsValid() {
+		b.Attr(unix.RTA_GATEWAY, gw.Unmap().AsSlice())
+	}
+	return b
+}
+
+func family(addr netip.Addr) uint8 {
+	if addr.Is4() {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
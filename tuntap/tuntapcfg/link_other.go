@@ -0,0 +1,34 @@
+// +build !linux
+
+package tuntapcfg
+
+import (
+	"errors"
+	"net/netip"
+)
+
+var errUnsupported = errors.New("Interface configuration is not supported on this platform")
+
+func (l *Link) SetUp() error {
+	return errUnsupported
+}
+
+func (l *Link) SetDown() error {
+	return errUnsupported
+}
+
+func (l *Link) AddAddress(prefix netip.Prefix) error {
+	return errUnsupported
+}
+
+func (l *Link) DelAddress(prefix netip.Prefix) error {
+	return errUnsupported
+}
+
+func (l *Link) AddRoute(dst netip.Prefix, gw netip.Addr) error {
+	return errUnsupported
+}
+
+func (l *Link) DelRoute(dst netip.Prefix, gw netip.Addr) error {
+	return errUnsupported
+}
@@ -0,0 +1,142 @@
+// Package tuntapgopacket connects tuntap Interfaces to gopacket, to
+// decode the packets they carry with gopacket's layers, or feed them
+// to code built around a gopacket.PacketSource.
+package tuntapgopacket
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/izqui/tuntap/tuntap"
+)
+
+// Source is a gopacket.PacketDataSource reading the packets of an
+// Interface: IP packets on DevTun, Ethernet frames on DevTap.
+type Source struct {
+	t    *tuntap.Interface
+	kind tuntap.DevKind
+	size int
+}
+
+var _ gopacket.PacketDataSource = (*Source)(nil)
+
+// Room for the link, packet information and virtio headers read along
+// with each MTU sized packet.
+const headerRoom = 64
+
+// NewSource returns a Source reading from t. Nothing else should read
+// from t while it's used.
+func NewSource(t *tuntap.Interface) *Source {
+	size := 65535
+	if mtu, err := t.MTU(); err == nil {
+		size = mtu
+	}
+	return &Source{t: t, kind: t.LocalAddr().(*tuntap.Addr).Kind, size: size + headerRoom}
+}
+
+// ReadPacketData reads the next packet. It allocates a new buffer for
+// each packet, so the data can be retained.
+func (s *Source) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	buf := make([]byte, s.size)
+	n, _, err := s.t.ReadFrom(buf)
+	if err != nil {
+		return nil, gopacket.CaptureInfo{}, err
+	}
+	ci := gopacket.CaptureInfo{
+		Timestamp:      time.Now(),
+		CaptureLength:  n,
+		Length:         n,
+		InterfaceIndex: -1,
+	}
+	if index, err := s.t.Index(); err == nil {
+		ci.InterfaceIndex = index
+	}
+	return buf[:n], ci, nil
+}
+
+// LinkType returns the link type of the packets: LinkTypeRaw on DevTun,
+// LinkTypeEthernet on DevTap. It's also their Decoder.
+func (s *Source) LinkType() layers.LinkType {
+	if s.kind == tuntap.DevTap {
+		return layers.LinkTypeEthernet
+	}
+	return layers.LinkTypeRaw
+}
+
+// PacketSource returns a gopacket.PacketSource decoding the packets of
+// s.
+func (s *Source) PacketSource() *gopacket.PacketSource {
+	return gopacket.NewPacketSource(s, s.LinkType())
+}
+
+// Decode decodes pkt with gopacket, starting from its Ethernet frame if
+// it has one, or its IP header otherwise.
+func Decode(pkt *tuntap.IPPacket) (gopacket.Packet, error) {
+	if pkt.Frame != nil {
+		data, err := pkt.Frame.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		return gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default), nil
+	}
+	data := make([]byte, 0, len(pkt.Header.Data)+len(pkt.Payload))
+	data = append(append(data, pkt.Header.Data...), pkt.Payload...)
+	return gopacket.NewPacket(data, layers.LinkTypeRaw, gopacket.Default), nil
+}
+
+// FromPacket converts a decoded gopacket packet to an IPPacket, to be
+// written with WritePacket. The Ethernet layer, if any, becomes the
+// Frame of the IPPacket. It returns tuntap.ErrNotIP if p has no IPv4 or
+// IPv6 network layer.
+func FromPacket(p gopacket.Packet) (*tuntap.IPPacket, error) {
+	pkt := &tuntap.IPPacket{}
+	switch p.NetworkLayer().(type) {
+	case *layers.IPv4:
+		pkt.Protocol = int(layers.EthernetTypeIPv4)
+	case *layers.IPv6:
+		pkt.Protocol = int(layers.EthernetTypeIPv6)
+	default:
+		return nil, tuntap.ErrNotIP
+	}
+	network := p.NetworkLayer()
+	pkt.Header = tuntap.IPHeader{Data: network.LayerContents()}
+	pkt.Payload = network.LayerPayload()
+
+	if eth, ok := p.LinkLayer().(*layers.Ethernet); ok {
+		pkt.Frame = &tuntap.EthernetFrame{
+			DstMAC:    eth.DstMAC,
+			SrcMAC:    eth.SrcMAC,
+			EtherType: pkt.Protocol,
+		}
+		if l := p.Layer(layers.LayerTypeDot1Q); l != nil {
+			tag := l.(*layers.Dot1Q)
+			pkt.Frame.VLAN = &tuntap.VLANTag{
+				Priority:     tag.Priority,
+				DropEligible: tag.DropEligible,
+				ID:           tag.VLANIdentifier,
+			}
+		}
+	}
+	return pkt, nil
+}
+
+// FromLayers serializes ls, fixing lengths and computing checksums, and
+// converts the result to an IPPacket. The first layer must be an
+// Ethernet or IP layer.
+func FromLayers(ls ...gopacket.SerializableLayer) (*tuntap.IPPacket, error) {
+	if len(ls) == 0 {
+		return nil, errors.New("No layers")
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		return nil, err
+	}
+	first := ls[0].LayerType()
+	if first == layers.LayerTypeIPv4 || first == layers.LayerTypeIPv6 {
+		return FromPacket(gopacket.NewPacket(buf.Bytes(), layers.LinkTypeRaw, gopacket.Default))
+	}
+	return FromPacket(gopacket.NewPacket(buf.Bytes(), first, gopacket.Default))
+}
@@ -0,0 +1,19 @@
+// Package tuntaphelper lets a small privileged helper process open a
+// tun/tap interface and hand its descriptor to an unprivileged process
+// over a unix socket (SCM_RIGHTS), so that the main process of a tunnel
+// daemon never needs CAP_NET_ADMIN.
+//
+// The main process starts the helper with Run, which passes it one end
+// of a socket pair as descriptor HelperFd. The helper calls Conn to get
+// it, then Serve to open the interface and send it back:
+//
+//	conn, err := tuntaphelper.Conn()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if err := tuntaphelper.Serve(conn, "tun%d", tuntap.DevTun); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// It's only supported on Linux, macOS, FreeBSD and OpenBSD.
+package tuntaphelper
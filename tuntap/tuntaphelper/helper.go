@@ -0,0 +1,167 @@
+// +build linux darwin freebsd openbsd
+
+package tuntaphelper
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/izqui/tuntap/tuntap"
+)
+
+// HelperFd is the descriptor of the socket Run passes to the helper.
+const HelperFd = 3
+
+var errHelperClosed = errors.New("Helper closed the connection")
+
+// Message status, the first byte of each message.
+const (
+	statusOK = iota
+	statusError
+)
+
+// Conn returns the socket to the main process in a helper started by
+// Run.
+func Conn() (*net.UnixConn, error) {
+	file := os.NewFile(HelperFd, "tuntaphelper")
+	defer file.Close()
+	c, err := net.FileConn(file)
+	if err != nil {
+		return nil, err
+	}
+	conn, ok := c.(*net.UnixConn)
+	if !ok {
+		c.Close()
+		return nil, errors.New("Helper descriptor is not a unix socket")
+	}
+	return conn, nil
+}
+
+// Serve opens the interface like tuntap.OpenWithOptions and sends it to
+// the other end of conn, which gets it with ReceiveInterface. If opening
+// it fails, the error is sent instead, and returned.
+func Serve(conn *net.UnixConn, ifPattern string, kind tuntap.DevKind, opts ...tuntap.Option) error {
+	t, err := tuntap.OpenWithOptions(ifPattern, kind, opts...)
+	if err != nil {
+		SendError(conn, err)
+		return err
+	}
+	defer t.Close()
+	return SendInterface(conn, t, kind)
+}
+
+// SendInterface sends the descriptor of t, an interface of the given
+// kind, to the other end of conn. t stays open in the caller, which can
+// then close it: the receiver gets its own descriptor. Only the first
+// queue of a multiqueue interface is sent.
+func SendInterface(conn *net.UnixConn, t *tuntap.Interface, kind tuntap.DevKind) error {
+	rc, err := t.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		msg := []byte{statusOK, byte(kind)}
+		_, _, serr = conn.WriteMsgUnix(msg, syscall.UnixRights(int(fd)), nil)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// SendError reports err to the other end of conn, where ReceiveInterface
+// returns it.
+func SendError(conn *net.UnixConn, err error) error {
+	_, _, werr := conn.WriteMsgUnix(append([]byte{statusError}, err.Error()...), nil, nil)
+	return werr
+}
+
+// ReceiveInterface receives an interface sent with SendInterface or
+// Serve on conn.
+func ReceiveInterface(conn *net.UnixConn) (*tuntap.Interface, error) {
+	msg := make([]byte, 1024)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(msg, oob)
+	if err == io.EOF {
+		return nil, errHelperClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []int
+	if oobn > 0 {
+		cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, err
+		}
+		for _, cmsg := range cmsgs {
+			rights, err := syscall.ParseUnixRights(&cmsg)
+			if err == nil {
+				fds = append(fds, rights...)
+			}
+		}
+	}
+	for _, fd := range fds {
+		syscall.CloseOnExec(fd)
+	}
+
+	switch {
+	case n == 0:
+		err = errHelperClosed
+	case msg[0] == statusError:
+		err = errors.New(string(msg[1:n]))
+	case msg[0] != statusOK || n != 2 || len(fds) != 1:
+		err = errors.New("Malformed helper message")
+	}
+	if err != nil {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return nil, err
+	}
+	return tuntap.NewInterfaceFromFd(fds[0], tuntap.DevKind(msg[1]))
+}
+
+// Run starts cmd, a helper calling Conn and Serve, with one end of a
+// socket pair as descriptor HelperFd, and returns the interface it
+// sends. It waits for cmd to exit. cmd.ExtraFiles must be empty.
+func Run(cmd *exec.Cmd) (*tuntap.Interface, error) {
+	if len(cmd.ExtraFiles) != 0 {
+		return nil, errors.New("Helper command has extra files")
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socketpair", err)
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	local := os.NewFile(uintptr(fds[0]), "tuntaphelper")
+	remote := os.NewFile(uintptr(fds[1]), "tuntaphelper")
+	defer local.Close()
+
+	cmd.ExtraFiles = []*os.File{remote}
+	err = cmd.Start()
+	remote.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := net.FileConn(local)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	defer c.Close()
+	// An error of the helper after sending the interface doesn't
+	// matter: the interface is still usable.
+	t, err := ReceiveInterface(c.(*net.UnixConn))
+	cmd.Wait()
+	return t, err
+}
@@ -0,0 +1,191 @@
+// Package tuntapnetstack connects tuntap Interfaces to gVisor's
+// netstack, to terminate the TCP and UDP connections carried by an
+// interface in userspace, like tun2socks does:
+//
+//	t, err := tuntap.Open("tun%d", tuntap.DevTun, false)
+//	...
+//	ep, err := tuntapnetstack.NewEndpoint(t)
+//	...
+//	s := stack.New(stack.Options{...})
+//	s.CreateNIC(1, ep)
+package tuntapnetstack
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// Packets sent by the stack queued before they're written, and
+	// packets read or written at once.
+	queueLen = 512
+	batch    = 64
+)
+
+// Endpoint is a stack.LinkEndpoint delivering the packets read from an
+// Interface to the stack, and writing the packets the stack sends to
+// the Interface. Everything else comes from a channel.Endpoint.
+//
+// The GSO super-packets read from an interface opened with
+// IFF_VNET_HDR are split before delivery.
+type Endpoint struct {
+	*channel.Endpoint
+	t *tuntap.Interface
+
+	once      sync.Once
+	closeOnce sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+
+// NewEndpoint returns an Endpoint on t, a DevTun interface, with the
+// MTU of t. The Endpoint owns t: closing it closes t.
+func NewEndpoint(t *tuntap.Interface) (*Endpoint, error) {
+	if t.LocalAddr().(*tuntap.Addr).Kind != tuntap.DevTun {
+		return nil, errors.New("Netstack needs a DevTun interface")
+	}
+	mtu, err := t.MTU()
+	if err != nil {
+		return nil, err
+	}
+	e := &Endpoint{Endpoint: channel.New(queueLen, uint32(mtu), ""), t: t}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	return e, nil
+}
+
+// Attach attaches the Endpoint to the stack, and starts moving packets
+// between them the first time.
+func (e *Endpoint) Attach(d stack.NetworkDispatcher) {
+	e.Endpoint.Attach(d)
+	if d == nil {
+		return
+	}
+	e.once.Do(func() {
+		e.wg.Add(2)
+		go e.inbound()
+		go e.outbound()
+	})
+}
+
+// inbound delivers the packets read from the interface until it's
+// closed or a read fails.
+func (e *Endpoint) inbound() {
+	defer e.wg.Done()
+	pkts := make([]*tuntap.IPPacket, batch)
+	for {
+		n, err := e.t.ReadPackets(pkts)
+		if err != nil {
+			// Only packets failing to parse are worth reading past.
+			var ioErr *tuntap.Error
+			if errors.Is(err, tuntap.ErrClosed) || errors.Is(err, tuntap.ErrDeviceGone) || errors.As(err, &ioErr) {
+				return
+			}
+			continue
+		}
+		for i, pkt := range pkts[:n] {
+			pkts[i] = nil
+			segs, err := pkt.SplitGSO()
+			if err != nil {
+				pkt.Release()
+				continue
+			}
+			for _, seg := range segs {
+				e.deliver(seg)
+			}
+			pkt.Release()
+		}
+	}
+}
+
+// deliver hands a copy of pkt to the stack.
+func (e *Endpoint) deliver(pkt *tuntap.IPPacket) {
+	proto := header.IPv6ProtocolNumber
+	if pkt.Protocol == parser.EtherTypeIPv4 {
+		proto = header.IPv4ProtocolNumber
+	}
+	data := make([]byte, 0, len(pkt.Header.Data)+len(pkt.Payload))
+	data = append(append(data, pkt.Header.Data...), pkt.Payload...)
+	pkb := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(data)})
+	e.InjectInbound(proto, pkb)
+	pkb.DecRef()
+}
+
+// outbound writes the packets sent by the stack until the Endpoint is
+// closed.
+func (e *Endpoint) outbound() {
+	defer e.wg.Done()
+	pkts := make([]*tuntap.IPPacket, 0, batch)
+	views := make([]*buffer.View, 0, batch)
+	for {
+		pkb := e.ReadContext(e.ctx)
+		if pkb == nil {
+			return
+		}
+		// Write what else is queued along with it.
+		for pkb != nil {
+			view := pkb.ToView()
+			pkb.DecRef()
+			views = append(views, view)
+			if pkt := packet(view.AsSlice()); pkt != nil {
+				pkts = append(pkts, pkt)
+			}
+			if len(views) == batch {
+				break
+			}
+			pkb = e.Read()
+		}
+
+		_, err := e.t.WritePackets(pkts)
+		for i, v := range views {
+			v.Release()
+			views[i] = nil
+		}
+		for i := range pkts {
+			pkts[i] = nil
+		}
+		pkts, views = pkts[:0], views[:0]
+		if errors.Is(err, tuntap.ErrClosed) {
+			return
+		}
+	}
+}
+
+// packet returns the IP packet in b, or nil if it's not one.
+func packet(b []byte) *tuntap.IPPacket {
+	ip, err := parser.ParseIP(b)
+	if err != nil {
+		return nil
+	}
+	return &tuntap.IPPacket{
+		Protocol: ip.Version,
+		Header:   tuntap.IPHeader{Data: ip.Header},
+		Payload:  ip.Payload,
+	}
+}
+
+// Close closes the Endpoint and its interface. The stack may close it
+// too, when its NIC is removed.
+func (e *Endpoint) Close() {
+	e.closeOnce.Do(func() {
+		e.t.Close()
+		e.cancel()
+		e.Endpoint.Close()
+	})
+}
+
+// Wait waits for the goroutines moving the packets to stop, after
+// Close.
+func (e *Endpoint) Wait() {
+	e.wg.Wait()
+}
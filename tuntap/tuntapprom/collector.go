@@ -0,0 +1,123 @@
+// Package tuntapprom exports the statistics of tuntap Interfaces as
+// Prometheus metrics.
+package tuntapprom
+
+import (
+	"sync"
+	"time"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	labels = []string{"interface"}
+
+	rxPackets = prometheus.NewDesc("tuntap_rx_packets_total", "Packets read from the interface.", labels, nil)
+	rxBytes   = prometheus.NewDesc("tuntap_rx_bytes_total", "Bytes read from the interface.", labels, nil)
+	rxErrors  = prometheus.NewDesc("tuntap_rx_errors_total", "Failed reads.", labels, nil)
+	rxDropped = prometheus.NewDesc("tuntap_rx_dropped_total", "Packets read but discarded.", labels, nil)
+	txPackets = prometheus.NewDesc("tuntap_tx_packets_total", "Packets written to the interface.", labels, nil)
+	txBytes   = prometheus.NewDesc("tuntap_tx_bytes_total", "Bytes written to the interface.", labels, nil)
+	txErrors  = prometheus.NewDesc("tuntap_tx_errors_total", "Failed writes.", labels, nil)
+	depth     = prometheus.NewDesc("tuntap_channel_depth", "Packets buffered in the Packets (in) and Out (out) channels.", []string{"interface", "direction"}, nil)
+)
+
+// Collector is a prometheus.Collector for a set of Interfaces. Besides
+// the counters of Interface.Stats and the depth of the channels behind
+// Packets and Out, it records histograms of the duration of reads and
+// writes, through the IOHook of each Interface.
+type Collector struct {
+	mu  sync.Mutex
+	ifs map[string]*tuntap.Interface
+
+	readDuration  *prometheus.HistogramVec
+	writeDuration *prometheus.HistogramVec
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector returns a Collector for ifs. More interfaces can be added
+// with Add.
+func NewCollector(ifs ...*tuntap.Interface) *Collector {
+	c := &Collector{
+		ifs: make(map[string]*tuntap.Interface),
+		readDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tuntap_read_duration_seconds",
+			Help:    "Time spent in reads, including waiting for a packet.",
+			Buckets: prometheus.ExponentialBuckets(1e-6, 4, 12),
+		}, labels),
+		writeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tuntap_write_duration_seconds",
+			Help:    "Time spent in writes.",
+			Buckets: prometheus.ExponentialBuckets(1e-6, 4, 12),
+		}, labels),
+	}
+	for _, t := range ifs {
+		c.Add(t)
+	}
+	return c
+}
+
+// Add starts collecting metrics for t, labeled with its name. It
+// replaces the IOHook of t.
+func (c *Collector) Add(t *tuntap.Interface) {
+	name := t.Name()
+	read := c.readDuration.WithLabelValues(name)
+	write := c.writeDuration.WithLabelValues(name)
+	t.SetIOHook(func(isWrite bool, d time.Duration, err error) {
+		if isWrite {
+			write.Observe(d.Seconds())
+		} else {
+			read.Observe(d.Seconds())
+		}
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ifs[name] = t
+}
+
+// Remove stops collecting metrics for t and removes its IOHook.
+func (c *Collector) Remove(t *tuntap.Interface) {
+	name := t.Name()
+	t.SetIOHook(nil)
+	c.readDuration.DeleteLabelValues(name)
+	c.writeDuration.DeleteLabelValues(name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ifs, name)
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{rxPackets, rxBytes, rxErrors, rxDropped, txPackets, txBytes, txErrors, depth} {
+		ch <- d
+	}
+	c.readDuration.Describe(ch)
+	c.writeDuration.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, t := range c.ifs {
+		s := t.Stats()
+		counter := func(d *prometheus.Desc, v uint64) {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), name)
+		}
+		counter(rxPackets, s.RxPackets)
+		counter(rxBytes, s.RxBytes)
+		counter(rxErrors, s.RxErrors)
+		counter(rxDropped, s.RxDropped)
+		counter(txPackets, s.TxPackets)
+		counter(txBytes, s.TxBytes)
+		counter(txErrors, s.TxErrors)
+
+		in, out := t.ChannelLen()
+		ch <- prometheus.MustNewConstMetric(depth, prometheus.GaugeValue, float64(in), name, "in")
+		ch <- prometheus.MustNewConstMetric(depth, prometheus.GaugeValue, float64(out), name, "out")
+	}
+	c.readDuration.Collect(ch)
+	c.writeDuration.Collect(ch)
+}
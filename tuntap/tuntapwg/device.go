@@ -0,0 +1,223 @@
+// Package tuntapwg adapts tuntap Interfaces to the tun.Device of
+// wireguard-go, so they can be the device layer under a WireGuard
+// device:
+//
+//	t, err := tuntap.OpenVnetHdr("wg%d", tuntap.DevTun, false)
+//	...
+//	dev, err := tuntapwg.NewDevice(t)
+//	...
+//	wg := device.NewDevice(dev, conn.NewDefaultBind(), logger)
+package tuntapwg
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/izqui/tuntap/tuntap"
+	"github.com/izqui/tuntap/tuntap/parser"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// BatchSize is the number of packets read at once, the batch size
+// wireguard-go is tuned for.
+const BatchSize = 128
+
+// Device is a tun.Device reading and writing the packets of an
+// Interface.
+//
+// On an interface opened with IFF_VNET_HDR, whose offloads were enabled
+// with SetOffloads, the GSO super-packets read are split into segments
+// handed to wireguard-go across reads, and checksums left to userspace
+// are completed. Packets are written without offloads.
+type Device struct {
+	t      *tuntap.Interface
+	readMu sync.Mutex
+	// Packets read but not yet returned by Read.
+	batch   []*tuntap.IPPacket
+	pending []*tuntap.IPPacket
+
+	events    chan tun.Event
+	watching  bool
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+var _ tun.Device = (*Device)(nil)
+
+// NewDevice returns a Device on t, a DevTun interface. The Device owns
+// t: closing it closes t.
+func NewDevice(t *tuntap.Interface) (*Device, error) {
+	if t.LocalAddr().(*tuntap.Addr).Kind != tuntap.DevTun {
+		return nil, errors.New("WireGuard needs a DevTun interface")
+	}
+	d := &Device{
+		t:      t,
+		batch:  make([]*tuntap.IPPacket, BatchSize),
+		events: make(chan tun.Event, 4),
+		done:   make(chan struct{}),
+	}
+
+	// wireguard-go brings the device up on the first EventUp.
+	if ifi, err := net.InterfaceByName(t.Name()); err != nil || ifi.Flags&net.FlagUp != 0 {
+		d.events <- tun.EventUp
+	}
+	if ch, err := t.Events(); err == nil {
+		d.watching = true
+		go d.forwardEvents(ch)
+	}
+	return d, nil
+}
+
+// forwardEvents translates the events of the interface until it's
+// closed.
+func (d *Device) forwardEvents(ch <-chan tuntap.Event) {
+	defer close(d.events)
+	for e := range ch {
+		var ev tun.Event
+		switch e.Type {
+		case tuntap.EventLinkUp:
+			ev = tun.EventUp
+		case tuntap.EventLinkDown, tuntap.EventDeleted:
+			ev = tun.EventDown
+		case tuntap.EventMTU:
+			ev = tun.EventMTUUpdate
+		default:
+			continue
+		}
+		select {
+		case d.events <- ev:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// File returns nil: the descriptor stays owned by the Interface.
+func (d *Device) File() *os.File {
+	return nil
+}
+
+// Read reads up to len(bufs) packets, each into bufs[i][offset:], and
+// their sizes into sizes. Packets that don't fit in their buffer are
+// dropped.
+func (d *Device) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	d.readMu.Lock()
+	defer d.readMu.Unlock()
+
+	if len(d.pending) == 0 {
+		if err := d.fill(len(bufs)); err != nil {
+			return 0, err
+		}
+	}
+	count := 0
+	for count < len(bufs) && len(d.pending) > 0 {
+		pkt := d.pending[0]
+		d.pending[0] = nil
+		d.pending = d.pending[1:]
+
+		buf := bufs[count][offset:]
+		if len(pkt.Header.Data)+len(pkt.Payload) <= len(buf) {
+			n := copy(buf, pkt.Header.Data)
+			sizes[count] = n + copy(buf[n:], pkt.Payload)
+			count++
+		}
+		pkt.Release()
+	}
+	return count, nil
+}
+
+// fill reads up to n packets into d.pending, splitting the GSO ones.
+// Packets failing to split are dropped; the error of the first of them
+// is only returned if there's nothing else to return.
+func (d *Device) fill(n int) error {
+	if n > len(d.batch) {
+		n = len(d.batch)
+	}
+	n, err := d.t.ReadPackets(d.batch[:n])
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for i, pkt := range d.batch[:n] {
+		d.batch[i] = nil
+		segs, err := pkt.SplitGSO()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			pkt.Release()
+			continue
+		}
+		if segs[0] != pkt {
+			// The segments have their own buffers.
+			pkt.Release()
+		}
+		d.pending = append(d.pending, segs...)
+	}
+	if len(d.pending) == 0 {
+		return firstErr
+	}
+	return nil
+}
+
+// Write writes the packets in bufs[i][offset:], in order. It returns
+// the number of packets written and the error that stopped it, if any.
+func (d *Device) Write(bufs [][]byte, offset int) (int, error) {
+	pkts := make([]*tuntap.IPPacket, 0, len(bufs))
+	var perr error
+	for _, b := range bufs {
+		ip, err := parser.ParseIP(b[offset:])
+		if err != nil {
+			perr = err
+			break
+		}
+		pkts = append(pkts, &tuntap.IPPacket{
+			Protocol: ip.Version,
+			Header:   tuntap.IPHeader{Data: ip.Header},
+			Payload:  ip.Payload,
+		})
+	}
+	n, err := d.t.WritePackets(pkts)
+	if err != nil {
+		return n, err
+	}
+	return n, perr
+}
+
+// MTU returns the MTU of the interface.
+func (d *Device) MTU() (int, error) {
+	return d.t.MTU()
+}
+
+// Name returns the name of the interface.
+func (d *Device) Name() (string, error) {
+	return d.t.Name(), nil
+}
+
+// Events returns the channel of tun events: EventUp and EventDown as
+// the link goes up and down, and EventMTUUpdate. It's closed with the
+// Device. Where Interface.Events isn't supported, only the first
+// EventUp is sent.
+func (d *Device) Events() <-chan tun.Event {
+	return d.events
+}
+
+// Close closes the Device and its interface.
+func (d *Device) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+		d.closeErr = d.t.Close()
+		if !d.watching {
+			close(d.events)
+		}
+	})
+	return d.closeErr
+}
+
+// BatchSize returns BatchSize.
+func (d *Device) BatchSize() int {
+	return BatchSize
+}
@@ -0,0 +1,26 @@
+// +build ignore
+
+package tuntap
+
+/*
+#include <sys/ioctl.h>
+#include <sys/socket.h>
+#include <sys/kern_control.h>
+#include <sys/sys_domain.h>
+#include <net/if_utun.h>
+*/
+import "C"
+
+const (
+	flagTruncated = 0x1
+
+	afSystem        = C.AF_SYSTEM
+	afSysControl    = C.AF_SYS_CONTROL
+	sysprotoControl = C.SYSPROTO_CONTROL
+	ctlIocGInfo     = C.CTLIOCGINFO
+	utunOptIfname   = C.UTUN_OPT_IFNAME
+)
+
+type ctlInfo C.struct_ctl_info
+
+type sockaddrCtl C.struct_sockaddr_ctl
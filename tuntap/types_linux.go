@@ -19,6 +19,18 @@ const (
 	iffTap = C.IFF_TAP
 	iffnopi = C.IFF_NO_PI
 	iffOneQueue = C.IFF_ONE_QUEUE
+	iffMultiQueue = C.IFF_MULTI_QUEUE
+	iffAttachQueue = C.IFF_ATTACH_QUEUE
+	iffDetachQueue = C.IFF_DETACH_QUEUE
+	iffVnetHdr = C.IFF_VNET_HDR
+	iffPersist = C.IFF_PERSIST
+	iffTunExcl = C.IFF_TUN_EXCL
+	iffNapi = C.IFF_NAPI
+	iffNapiFrags = C.IFF_NAPI_FRAGS
+
+	tunSetQueue = C.TUNSETQUEUE
+	tunSetCarrier = C.TUNSETCARRIER
+	tunSetSteeringEBPF = C.TUNSETSTEERINGEBPF
 )
 
 type ifReq struct {
@@ -0,0 +1,29 @@
+package tuntap
+
+import (
+	"errors"
+
+	"github.com/izqui/tuntap/tuntap/parser"
+)
+
+const udpHeaderLength = 8
+
+// UDPHeader is a decoded UDP header, see parser.UDPHeader.
+type UDPHeader = parser.UDPHeader
+
+// UDP decodes the UDP header of the packet and returns it with the
+// datagram payload. It fails if the upper-layer protocol isn't UDP.
+func (p *IPPacket) UDP() (*UDPHeader, []byte, error) {
+	proto, data, err := p.UpperLayer()
+	if err != nil {
+		return nil, nil, err
+	}
+	if proto != parser.ProtoUDP {
+		return nil, nil, errors.New("Not a UDP packet")
+	}
+	h := new(UDPHeader)
+	if err := h.Unmarshal(data); err != nil {
+		return nil, nil, err
+	}
+	return h, data[udpHeaderLength:h.Length], nil
+}
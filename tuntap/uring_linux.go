@@ -0,0 +1,377 @@
+package tuntap
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// From linux/io_uring.h. The system call numbers are in the
+// uring_sysnum files.
+const (
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringEnterGetEvents = 1 << 0
+	ioringOpReadv        = 1
+	ioringOpWritev       = 2
+	iosqeIOLink          = 1 << 2
+
+	rwfNowait = 0x8
+)
+
+// Entries in the rings of an interface opened with WithIOUring. Larger
+// batches are split.
+const uringEntries = 256
+
+type uringSQOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCQOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSQOffsets
+	cqOff                                                                  uringCQOffsets
+}
+
+type uringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type uringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// uring is an io_uring instance. Each run submits a batch of operations
+// and waits for all of them, so the rings are empty between runs.
+type uring struct {
+	mu     sync.Mutex
+	fd     int
+	closed bool
+
+	sqMem, cqMem, sqeMem []byte
+	sqTail, sqMask       *uint32
+	sqArray              []uint32
+	sqes                 []uringSQE
+	cqHead, cqTail       *uint32
+	cqMask               *uint32
+	cqes                 []uringCQE
+	entries              int
+}
+
+func newUring(entries int) (*uring, error) {
+	var p uringParams
+	fd, _, errno := syscall.Syscall(sysIOUringSetup, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("io_uring_setup", errno)
+	}
+	r := &uring{fd: int(fd), entries: int(p.sqEntries)}
+	syscall.CloseOnExec(r.fd)
+
+	mmap := func(off int64, size uint32) ([]byte, error) {
+		b, err := syscall.Mmap(r.fd, off, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+		return b, os.NewSyscallError("mmap", err)
+	}
+	var err error
+	if r.sqMem, err = mmap(ioringOffSQRing, p.sqOff.array+p.sqEntries*4); err != nil {
+		r.close()
+		return nil, err
+	}
+	if r.cqMem, err = mmap(ioringOffCQRing, p.cqOff.cqes+p.cqEntries*uint32(unsafe.Sizeof(uringCQE{}))); err != nil {
+		r.close()
+		return nil, err
+	}
+	if r.sqeMem, err = mmap(ioringOffSQEs, p.sqEntries*uint32(unsafe.Sizeof(uringSQE{}))); err != nil {
+		r.close()
+		return nil, err
+	}
+
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqMem[p.sqOff.tail]))
+	r.sqMask = (*uint32)(unsafe.Pointer(&r.sqMem[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqMem[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*uringSQE)(unsafe.Pointer(&r.sqeMem[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqMem[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqMem[p.cqOff.tail]))
+	r.cqMask = (*uint32)(unsafe.Pointer(&r.cqMem[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*uringCQE)(unsafe.Pointer(&r.cqMem[p.cqOff.cqes])), p.cqEntries)
+	return r, nil
+}
+
+func (r *uring) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, b := range [][]byte{r.sqMem, r.cqMem, r.sqeMem} {
+		if b != nil {
+			syscall.Munmap(b)
+		}
+	}
+	syscall.Close(r.fd)
+	r.closed = true
+}
+
+// run submits n operations, at most r.entries, the ith one prepared by
+// prep, and returns their results once they all completed.
+func (r *uring) run(n int, prep func(i int, sqe *uringSQE)) ([]int32, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, os.ErrClosed
+	}
+
+	tail := *r.sqTail
+	mask := *r.sqMask
+	for i := 0; i < n; i++ {
+		idx := (tail + uint32(i)) & mask
+		sqe := &r.sqes[idx]
+		*sqe = uringSQE{userData: uint64(i)}
+		prep(i, sqe)
+		r.sqArray[idx] = idx
+	}
+	// Nothing links past the batch.
+	r.sqes[(tail+uint32(n-1))&mask].flags &^= iosqeIOLink
+	atomic.StoreUint32(r.sqTail, tail+uint32(n))
+
+	res := make([]int32, n)
+	submitted, completed := 0, 0
+	for completed < n {
+		m, _, errno := syscall.Syscall6(sysIOUringEnter, uintptr(r.fd), uintptr(n-submitted), uintptr(n-completed), ioringEnterGetEvents, 0, 0)
+		switch {
+		case errno == 0:
+			submitted += int(m)
+		case errno == syscall.EINTR:
+		case submitted == completed:
+			// Nothing in flight: drop what wasn't submitted.
+			atomic.StoreUint32(r.sqTail, tail+uint32(submitted))
+			return nil, os.NewSyscallError("io_uring_enter", errno)
+		}
+
+		head := atomic.LoadUint32(r.cqHead)
+		for ctail := atomic.LoadUint32(r.cqTail); head != ctail; head++ {
+			cqe := &r.cqes[head&*r.cqMask]
+			res[cqe.userData] = cqe.res
+			completed++
+		}
+		atomic.StoreUint32(r.cqHead, head)
+	}
+	return res, nil
+}
+
+// uringDevice is a descriptor backed Device doing the I/O of ReadPackets
+// and WritePackets with io_uring: the reads or writes of a batch are
+// submitted at once instead of taking a system call each.
+//
+// The descriptor stays non-blocking and registered with the runtime
+// poller, which still does the waiting: the operations of a batch
+// complete right away, and a batch of reads only finding the queue
+// empty parks the goroutine like a plain read would. Deadlines and
+// Close work as without io_uring.
+type uringDevice struct {
+	*osDevice
+	file *os.File
+	// Separate rings let a reader and a writer proceed together.
+	rd, wr *uring
+}
+
+// useIOUring switches the I/O of t to io_uring.
+func (t *Interface) useIOUring() error {
+	d, ok := t.dev.(*osDevice)
+	if !ok {
+		return errors.New("Device has no file descriptor")
+	}
+	file, ok := d.ReadWriteCloser.(*os.File)
+	if !ok {
+		return errors.New("Device has no file descriptor")
+	}
+	rd, err := newUring(uringEntries)
+	if err != nil {
+		return err
+	}
+	wr, err := newUring(uringEntries)
+	if err != nil {
+		rd.close()
+		return err
+	}
+	t.dev = &uringDevice{osDevice: d, file: file, rd: rd, wr: wr}
+	return nil
+}
+
+func (d *uringDevice) unwrap() Device {
+	return d.osDevice
+}
+
+func (d *uringDevice) Close() error {
+	// Closing the descriptor first unblocks the goroutines waiting in
+	// the poller; the rings wait for the batches in progress.
+	err := d.osDevice.Close()
+	d.rd.close()
+	d.wr.close()
+	return err
+}
+
+func (d *uringDevice) readBatch(bufs [][]byte, sizes []int) (int, error) {
+	rc, err := d.file.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	if len(bufs) > d.rd.entries {
+		bufs = bufs[:d.rd.entries]
+	}
+
+	iovs := make([]syscall.Iovec, len(bufs))
+	var pin runtime.Pinner
+	defer pin.Unpin()
+	for i, b := range bufs {
+		if len(b) == 0 {
+			return 0, io.ErrShortBuffer
+		}
+		pin.Pin(&b[0])
+		iovs[i].Base = &b[0]
+		iovs[i].SetLen(len(b))
+	}
+	pin.Pin(&iovs[0])
+
+	count := 0
+	var rerr error
+	err = rc.Read(func(fd uintptr) bool {
+		res, err := d.rd.run(len(bufs), func(i int, sqe *uringSQE) {
+			sqe.opcode = ioringOpReadv
+			// io_uring would otherwise wait for packets itself, despite
+			// O_NONBLOCK.
+			sqe.rwFlags = rwfNowait
+			sqe.fd = int32(fd)
+			sqe.addr = uint64(uintptr(unsafe.Pointer(&iovs[i])))
+			sqe.len = 1
+		})
+		if err != nil {
+			rerr = err
+			return true
+		}
+		for i, n := range res {
+			switch {
+			case n >= 0:
+				// A packet may arrive after a read found the queue empty:
+				// keep the packets first.
+				if i != count {
+					copy(bufs[count], bufs[i][:n])
+				}
+				sizes[count] = int(n)
+				count++
+			case syscall.Errno(-n) != syscall.EAGAIN && count == 0 && rerr == nil:
+				rerr = syscall.Errno(-n)
+			}
+		}
+		// Wait for the poller if nothing was read yet.
+		return count > 0 || rerr != nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return count, nil
+	}
+	return 0, rerr
+}
+
+func (d *uringDevice) writeBatch(pkts [][][]byte) (int, error) {
+	rc, err := d.file.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var pin runtime.Pinner
+	defer pin.Unpin()
+	iovs := make([][]syscall.Iovec, len(pkts))
+	sizes := make([]int32, len(pkts))
+	for i, bufs := range pkts {
+		for _, b := range bufs {
+			if len(b) == 0 {
+				continue
+			}
+			pin.Pin(&b[0])
+			iov := syscall.Iovec{Base: &b[0]}
+			iov.SetLen(len(b))
+			iovs[i] = append(iovs[i], iov)
+			sizes[i] += int32(len(b))
+		}
+		if len(iovs[i]) > 0 {
+			pin.Pin(&iovs[i][0])
+		}
+	}
+
+	count := 0
+	var werr error
+	err = rc.Write(func(fd uintptr) bool {
+		for count < len(pkts) {
+			batch := len(pkts) - count
+			if batch > d.wr.entries {
+				batch = d.wr.entries
+			}
+			first := count
+			res, err := d.wr.run(batch, func(i int, sqe *uringSQE) {
+				v := iovs[first+i]
+				sqe.opcode = ioringOpWritev
+				// Keep the packets in order: a failed write cancels the
+				// ones after it.
+				sqe.flags = iosqeIOLink
+				sqe.fd = int32(fd)
+				if len(v) > 0 {
+					sqe.addr = uint64(uintptr(unsafe.Pointer(&v[0])))
+				}
+				sqe.len = uint32(len(v))
+			})
+			if err != nil {
+				werr = err
+				return true
+			}
+			for _, n := range res {
+				if n == sizes[count] {
+					count++
+					continue
+				}
+				switch {
+				case n >= 0:
+					werr = io.ErrShortWrite
+				case syscall.Errno(-n) == syscall.EAGAIN:
+					// Wait for the poller and send the rest.
+					return false
+				default:
+					werr = syscall.Errno(-n)
+				}
+				return true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, werr
+}
@@ -0,0 +1,10 @@
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package tuntap
+
+// System call numbers of io_uring, shared by the architectures using
+// the generic table.
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+)
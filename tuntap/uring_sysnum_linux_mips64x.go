@@ -0,0 +1,9 @@
+// +build linux,mips64 linux,mips64le
+
+package tuntap
+
+// System call numbers of io_uring on the MIPS n64 ABI.
+const (
+	sysIOUringSetup = 5425
+	sysIOUringEnter = 5426
+)
@@ -0,0 +1,9 @@
+// +build linux,mips linux,mipsle
+
+package tuntap
+
+// System call numbers of io_uring on the MIPS o32 ABI.
+const (
+	sysIOUringSetup = 4425
+	sysIOUringEnter = 4426
+)
@@ -0,0 +1,77 @@
+package tuntap
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// Size of struct virtio_net_hdr.
+const virtioNetHdrLength = 10
+
+// Flags of VirtioNetHdr.
+const (
+	// The checksum starting at CsumStart must be filled in.
+	VirtioNetHdrFNeedsCsum = 1
+	// The checksum has been validated.
+	VirtioNetHdrFDataValid = 2
+)
+
+// GSO types of VirtioNetHdr.
+const (
+	VirtioNetHdrGSONone  = 0
+	VirtioNetHdrGSOTCPv4 = 1
+	VirtioNetHdrGSOUDP   = 3
+	VirtioNetHdrGSOTCPv6 = 4
+	VirtioNetHdrGSOUDPL4 = 5
+	// Set in GSOType if the packet has the ECN bit set.
+	VirtioNetHdrGSOECN = 0x80
+)
+
+// VirtioNetHdr is the virtio-net header exchanged with interfaces
+// opened with IFF_VNET_HDR. It describes checksum offload and, for GSO
+// super-packets larger than the MTU, how to segment them.
+type VirtioNetHdr struct {
+	Flags   uint8
+	GSOType uint8
+	// Length of the headers to replicate in each segment.
+	HdrLen uint16
+	// Payload size of each segment.
+	GSOSize uint16
+	// Where checksumming starts and where the result is stored,
+	// relative to CsumStart, for VirtioNetHdrFNeedsCsum.
+	CsumStart  uint16
+	CsumOffset uint16
+}
+
+// nativeEndian is the byte order of the host. The tun driver uses it
+// for the virtio header unless told otherwise with TUNSETVNETLE/BE.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+func parseVirtioNetHdr(b []byte) *VirtioNetHdr {
+	return &VirtioNetHdr{
+		Flags:      b[0],
+		GSOType:    b[1],
+		HdrLen:     nativeEndian.Uint16(b[2:4]),
+		GSOSize:    nativeEndian.Uint16(b[4:6]),
+		CsumStart:  nativeEndian.Uint16(b[6:8]),
+		CsumOffset: nativeEndian.Uint16(b[8:10]),
+	}
+}
+
+func (h *VirtioNetHdr) marshal() []byte {
+	b := make([]byte, virtioNetHdrLength)
+	b[0] = h.Flags
+	b[1] = h.GSOType
+	nativeEndian.PutUint16(b[2:4], h.HdrLen)
+	nativeEndian.PutUint16(b[4:6], h.GSOSize)
+	nativeEndian.PutUint16(b[6:8], h.CsumStart)
+	nativeEndian.PutUint16(b[8:10], h.CsumOffset)
+	return b
+}
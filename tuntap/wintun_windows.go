@@ -0,0 +1,162 @@
+package tuntap
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	wintunTunnelType = "tuntap"
+	// Ring capacity of a Wintun session, must be a power of two between
+	// 128 KiB and 64 MiB.
+	wintunRingCapacity = 0x800000
+
+	errorHandleEOF   = syscall.Errno(38)
+	errorNoMoreItems = syscall.Errno(259)
+)
+
+var (
+	modwintun = syscall.NewLazyDLL("wintun.dll")
+
+	procWintunCreateAdapter        = modwintun.NewProc("WintunCreateAdapter")
+	procWintunOpenAdapter          = modwintun.NewProc("WintunOpenAdapter")
+	procWintunCloseAdapter         = modwintun.NewProc("WintunCloseAdapter")
+	procWintunStartSession         = modwintun.NewProc("WintunStartSession")
+	procWintunEndSession           = modwintun.NewProc("WintunEndSession")
+	procWintunGetReadWaitEvent     = modwintun.NewProc("WintunGetReadWaitEvent")
+	procWintunReceivePacket        = modwintun.NewProc("WintunReceivePacket")
+	procWintunReleaseReceivePacket = modwintun.NewProc("WintunReleaseReceivePacket")
+	procWintunAllocateSendPacket   = modwintun.NewProc("WintunAllocateSendPacket")
+	procWintunSendPacket           = modwintun.NewProc("WintunSendPacket")
+)
+
+// wintunDevice is a Wintun adapter and its packet session. It satisfies
+// io.ReadWriteCloser, one packet per Read or Write.
+type wintunDevice struct {
+	mu       sync.RWMutex
+	closed   bool
+	adapter  uintptr
+	session  uintptr
+	readWait uintptr
+	quit     uintptr
+}
+
+// ptr turns a pointer returned by a Wintun call back into a byte slice
+// of the given size.
+func ptr(p uintptr, size int) []byte {
+	return unsafe.Slice((*byte)(*(*unsafe.Pointer)(unsafe.Pointer(&p))), size)
+}
+
+// openWintun attaches to the Wintun adapter named ifPattern, creating
+// it if needed, and starts a packet session on it.
+func openWintun(ifPattern string) (*wintunDevice, error) {
+	if strings.Contains(ifPattern, "%d") {
+		return nil, errors.New("Wintun adapters need an exact interface name")
+	}
+	if err := modwintun.Load(); err != nil {
+		return nil, err
+	}
+	name, err := syscall.UTF16PtrFromString(ifPattern)
+	if err != nil {
+		return nil, err
+	}
+	tunnelType, err := syscall.UTF16PtrFromString(wintunTunnelType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Attach to an existing adapter of that name, or create a new one.
+	adapter, _, _ := procWintunOpenAdapter.Call(uintptr(unsafe.Pointer(name)))
+	if adapter == 0 {
+		adapter, _, err = procWintunCreateAdapter.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(tunnelType)), 0)
+		if adapter == 0 {
+			return nil, err
+		}
+	}
+
+	session, _, err := procWintunStartSession.Call(adapter, wintunRingCapacity)
+	if session == 0 {
+		procWintunCloseAdapter.Call(adapter)
+		return nil, err
+	}
+	readWait, _, _ := procWintunGetReadWaitEvent.Call(session)
+
+	quit, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if quit == 0 {
+		procWintunEndSession.Call(session)
+		procWintunCloseAdapter.Call(adapter)
+		return nil, err
+	}
+
+	return &wintunDevice{
+		adapter:  adapter,
+		session:  session,
+		readWait: readWait,
+		quit:     quit,
+	}, nil
+}
+
+func (d *wintunDevice) Read(b []byte) (int, error) {
+	for {
+		d.mu.RLock()
+		if d.closed {
+			d.mu.RUnlock()
+			return 0, os.ErrClosed
+		}
+		var size uint32
+		p, _, err := procWintunReceivePacket.Call(d.session, uintptr(unsafe.Pointer(&size)))
+		if p != 0 {
+			n := copy(b, ptr(p, int(size)))
+			procWintunReleaseReceivePacket.Call(d.session, p)
+			d.mu.RUnlock()
+			return n, nil
+		}
+		d.mu.RUnlock()
+
+		switch err {
+		case errorNoMoreItems:
+			handles := [2]uintptr{d.readWait, d.quit}
+			r, _, err := procWaitForMultipleObjects.Call(2, uintptr(unsafe.Pointer(&handles[0])), 0, infinite)
+			if r != waitObject0 && r != waitObject0+1 {
+				return 0, err
+			}
+		case errorHandleEOF:
+			return 0, os.ErrClosed
+		default:
+			return 0, err
+		}
+	}
+}
+
+func (d *wintunDevice) Write(b []byte) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return 0, os.ErrClosed
+	}
+	p, _, err := procWintunAllocateSendPacket.Call(d.session, uintptr(len(b)))
+	if p == 0 {
+		return 0, err
+	}
+	copy(ptr(p, len(b)), b)
+	procWintunSendPacket.Call(d.session, p)
+	return len(b), nil
+}
+
+func (d *wintunDevice) Close() error {
+	procSetEvent.Call(d.quit)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return os.ErrClosed
+	}
+	d.closed = true
+	procWintunEndSession.Call(d.session)
+	procWintunCloseAdapter.Call(d.adapter)
+	return syscall.CloseHandle(syscall.Handle(d.quit))
+}
@@ -0,0 +1,53 @@
+// +build linux darwin freebsd openbsd
+
+package tuntap
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// writev sends bufs as a single packet with one writev(2), without
+// copying them into a contiguous buffer.
+func (d *osDevice) writev(bufs [][]byte) (int, error) {
+	f, ok := d.ReadWriteCloser.(*os.File)
+	if !ok {
+		return d.Write(concat(bufs))
+	}
+
+	iovs := make([]syscall.Iovec, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+		iov := syscall.Iovec{Base: &b[0]}
+		iov.SetLen(len(b))
+		iovs = append(iovs, iov)
+	}
+	if len(iovs) == 0 {
+		return d.Write(nil)
+	}
+
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var n uintptr
+	var errno syscall.Errno
+	err = rc.Write(func(fd uintptr) bool {
+		for {
+			n, _, errno = syscall.Syscall(syscall.SYS_WRITEV, fd, uintptr(unsafe.Pointer(&iovs[0])), uintptr(len(iovs)))
+			if errno != syscall.EINTR {
+				return errno != syscall.EAGAIN
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
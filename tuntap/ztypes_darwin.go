@@ -1,17 +1,28 @@
-
+// Created by cgo -godefs - DO NOT EDIT
+// cgo -godefs=true types_darwin.go
 
 package tuntap
 
 const (
 	flagTruncated	= 0x1
 
-	iffTun		= 0x1
-	iffTap		= 0x2
-	iffOneQueue	= 0x2000
+	afSystem	= 0x20
+	afSysControl	= 0x2
+	sysprotoControl	= 0x2
+	ctlIocGInfo	= 0xc0644e03
+	utunOptIfname	= 0x2
 )
 
-type ifReq struct {
-	Name	[0x10]byte
-	Flags	uint16
-	pad	[0x28 - 0x10 - 2]byte
+type ctlInfo struct {
+	Id	uint32
+	Name	[96]int8
+}
+
+type sockaddrCtl struct {
+	Len		uint8
+	Family		uint8
+	Sysaddr		uint16
+	Id		uint32
+	Unit		uint32
+	Reserved	[5]uint32
 }
@@ -10,6 +10,18 @@ const (
 	iffTap		= 0x2
 	iffOneQueue	= 0x2000
 	iffnopi =  0x1000
+	iffMultiQueue	= 0x100
+	iffAttachQueue	= 0x200
+	iffDetachQueue	= 0x400
+	iffVnetHdr	= 0x4000
+	iffPersist	= 0x800
+	iffTunExcl	= 0x8000
+	iffNapi		= 0x10
+	iffNapiFrags	= 0x20
+
+	tunSetQueue	= 0x400454d9
+	tunSetCarrier	= 0x400454e2
+	tunSetSteeringEBPF	= 0x800454e0
 )
 
 type ifReq struct {